@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// restrictedFile lists the series --check-access found the credentials cannot access,
+// below metadata/
+const restrictedFile = "restricted-series.csv"
+
+// accessProbes is the number of series of a collection probed before its access is
+// judged; an empty answer may also mean a single withdrawn series
+const accessProbes = 3
+
+// collectionAccess is the outcome of probing a collection
+type collectionAccess struct {
+	Collection string
+	Series     []*FileInfo
+	Reason     string // why the collection cannot be accessed, empty when it can
+}
+
+// probeSeriesAccess asks for the metadata of a series with the current credentials.
+// It returns why the series cannot be accessed, or an empty string when it can.
+func probeSeriesAccess(httpClient *http.Client, authToken *Token, seriesUID string) (string, error) {
+	url_, err := makeURL(endpoints.Meta, map[string]interface{}{"SeriesInstanceUID": seriesUID})
+	if err != nil {
+		return "", err
+	}
+	content, err := fetchNBIAResponse(httpClient, authToken, url_)
+	switch {
+	case errors.Is(err, ErrAuthFailed):
+		return "credentials rejected", nil
+	case errors.Is(err, ErrSeriesNotFound):
+		return "series not found", nil
+	case err != nil:
+		return "", err
+	}
+	// NBIA answers requests for series the credentials cannot see with an empty body
+	if files, err := parseSeriesMetadata(content); err != nil || len(files) == 0 {
+		return "no metadata returned", nil
+	}
+	return "", nil
+}
+
+// checkAccess probes each collection of the NBIA items with the current
+// credentials, prints which collections cannot be accessed and returns the items
+// of those that can. The series left out are written to metadata/restricted-series.csv.
+func checkAccess(files []*FileInfo, httpClient *http.Client, authToken *Token, options *Options) ([]*FileInfo, error) {
+	var order []string
+	collections := make(map[string]*collectionAccess)
+	for _, info := range files {
+		if info.DownloadURL != "" || info.DRSURI != "" || info.S5cmdManifestPath != "" {
+			continue
+		}
+		access, ok := collections[info.Collection]
+		if !ok {
+			access = &collectionAccess{Collection: info.Collection}
+			collections[info.Collection] = access
+			order = append(order, info.Collection)
+		}
+		access.Series = append(access.Series, info)
+	}
+	if len(collections) == 0 {
+		return files, nil
+	}
+
+	fmt.Printf("Checking access to %d collections...\n", len(collections))
+	denied := make(map[string]string)
+	var restricted []*collectionAccess
+	for _, name := range order {
+		access := collections[name]
+		for i := 0; i < len(access.Series) && i < accessProbes; i++ {
+			reason, err := probeSeriesAccess(httpClient, authToken, access.Series[i].SeriesUID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check access to %s: %w", collectionLabel(name), err)
+			}
+			if access.Reason = reason; reason == "" {
+				break
+			}
+		}
+		if access.Reason == "" {
+			continue
+		}
+		restricted = append(restricted, access)
+		for _, info := range access.Series {
+			denied[info.SeriesUID] = access.Reason
+		}
+	}
+	path := filepath.Join(options.Output, "metadata", restrictedFile)
+	if len(restricted) == 0 {
+		fmt.Println("All collections are accessible")
+		// The list of an earlier run is outdated
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Warnf("Failed to remove %s: %v", path, err)
+		}
+		return files, nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "COLLECTION\tSERIES\tREASON\n")
+	for _, access := range restricted {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", collectionLabel(access.Collection), len(access.Series), access.Reason)
+	}
+	_ = w.Flush()
+
+	if err := writeRestrictedSeries(path, restricted); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	fmt.Printf("%d series cannot be accessed with the current credentials and are left out, listed in %s\n", len(denied), path)
+
+	allowed := files[:0]
+	for _, info := range files {
+		if _, ok := denied[info.SeriesUID]; !ok {
+			allowed = append(allowed, info)
+		}
+	}
+	return allowed, nil
+}
+
+// collectionLabel names a collection in the access report
+func collectionLabel(collection string) string {
+	if strings.TrimSpace(collection) == "" {
+		return "(unknown)"
+	}
+	return collection
+}
+
+// writeRestrictedSeries writes the series of the restricted collections as CSV
+func writeRestrictedSeries(path string, restricted []*collectionAccess) error {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	_ = w.Write([]string{"series_uid", "collection", "reason"})
+	for _, access := range restricted {
+		for _, info := range access.Series {
+			_ = w.Write([]string{info.SeriesUID, access.Collection, access.Reason})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(b.String()), 0644)
+}
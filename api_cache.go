@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// apiCacheDirName is the directory under metadata/ holding raw API responses
+const apiCacheDirName = ".api-cache"
+
+// APIResponseCache stores raw metadata API responses keyed by a hash of the request.
+// It is kept separate from the per-series JSON cache so that repeated planning runs
+// over the same manifest can be answered without touching the API at all.
+type APIResponseCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// cachedAPIResponse is the on-disk representation of a cached response, with the
+// validators the server sent for revalidation
+type cachedAPIResponse struct {
+	URL          string          `json:"url"`
+	FetchedAt    time.Time       `json:"fetched_at"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	Body         json.RawMessage `json:"body"`
+}
+
+// revalidatable reports whether the server can confirm the entry is unchanged
+func (e *cachedAPIResponse) revalidatable() bool {
+	return e != nil && (e.ETag != "" || e.LastModified != "")
+}
+
+// NewAPIResponseCache creates a response cache under the output metadata directory.
+// A ttl of zero or less disables the cache.
+func NewAPIResponseCache(output string, ttl time.Duration) *APIResponseCache {
+	return &APIResponseCache{
+		dir: filepath.Join(output, "metadata", apiCacheDirName),
+		ttl: ttl,
+	}
+}
+
+// Enabled reports whether the cache is active
+func (c *APIResponseCache) Enabled() bool {
+	return c != nil && c.ttl > 0
+}
+
+// requestKey returns the content address for a request URL
+func requestKey(method, requestURL string) string {
+	sum := sha256.Sum256([]byte(method + " " + requestURL))
+	return hex.EncodeToString(sum[:])
+}
+
+// path returns the cache file path for a request URL
+func (c *APIResponseCache) path(requestURL string) string {
+	return filepath.Join(c.dir, requestKey("GET", requestURL)+".json")
+}
+
+// Lookup returns the cache entry for a request URL, expired or not, and whether it
+// is still fresh. Expired entries keep their validators for revalidation.
+func (c *APIResponseCache) Lookup(requestURL string) (*cachedAPIResponse, bool) {
+	if !c.Enabled() {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.path(requestURL))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cachedAPIResponse
+	if err := json.Unmarshal(data, &entry); err != nil {
+		logger.Debugf("Ignoring unreadable API cache entry for %s: %v", requestURL, err)
+		return nil, false
+	}
+	if entry.URL != requestURL {
+		return nil, false
+	}
+
+	return &entry, time.Since(entry.FetchedAt) <= c.ttl
+}
+
+// fetchAPIResponse requests a URL from the API, conditionally when cached holds
+// validators. A 304 answer returns cached with revalidated set; other answers
+// return a new entry. Neither is stored; Put does that once the body is accepted.
+func fetchAPIResponse(httpClient *http.Client, authToken *Token, requestURL string, cached *cachedAPIResponse) (entry *cachedAPIResponse, revalidated bool, err error) {
+	if !cached.revalidatable() {
+		cached = nil
+	}
+	content, header, err := fetchNBIAConditional(httpClient, authToken, requestURL, cached)
+	if err == errNotModified {
+		return cached, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &cachedAPIResponse{
+		URL:          requestURL,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Body:         content,
+	}, false, nil
+}
+
+// Put stores a response, stamped with the current time
+func (c *APIResponseCache) Put(entry *cachedAPIResponse) error {
+	if !c.Enabled() {
+		return nil
+	}
+	if !json.Valid(entry.Body) {
+		return fmt.Errorf("refusing to cache non-JSON response for %s", entry.URL)
+	}
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+
+	entry.FetchedAt = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// Write to temp file first for atomic operation
+	return writeFileAtomic(c.path(entry.URL), data, 0644)
+}
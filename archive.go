@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveFormats maps the --archive-format values to the extension of the series
+// archives written with --no-decompress
+var archiveFormats = map[string]string{
+	"zip":     ".zip",
+	"tar.gz":  ".tar.gz",
+	"tar.zst": ".tar.zst",
+}
+
+// seriesArchiveExt is the extension of the series archives of --no-decompress
+var seriesArchiveExt = ".zip"
+
+// tarSink writes ZIP entries into a tar stream, below a directory named prefix
+type tarSink struct {
+	tw     *tar.Writer
+	prefix string
+}
+
+func (s *tarSink) name(file *zip.File) (string, error) {
+	name := path.Clean(strings.TrimSuffix(file.Name, "/"))
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return "", fmt.Errorf("invalid file path in zip: %s", file.Name)
+	}
+	return s.prefix + "/" + name, nil
+}
+
+func (s *tarSink) Dir(file *zip.File) error {
+	name, err := s.name(file)
+	if err != nil {
+		return err
+	}
+	return s.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  file.Modified,
+	})
+}
+
+func (s *tarSink) Create(file *zip.File) (io.WriteCloser, error) {
+	name, err := s.name(file)
+	if err != nil {
+		return nil, err
+	}
+	err = s.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(file.Mode().Perm() | 0644),
+		Size:     int64(file.UncompressedSize64),
+		ModTime:  file.Modified,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nopWriteCloser{s.tw}, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newCompressor returns the compressing writer of an archive format. zstd uses the
+// default level of the zstd tool and compresses on all CPUs, like zstd -T0.
+func newCompressor(w io.Writer, format string) (io.WriteCloser, error) {
+	if format == "tar.zst" {
+		return zstd.NewWriter(w)
+	}
+	return gzip.NewWriter(w), nil
+}
+
+// convertSeriesZip converts the downloaded ZIP of a series into a tar archive
+// compressed as options.ArchiveFormat, verifying the size and MD5 hashes of its
+// files in the same pass. Entries start with the series directory name, like
+// those of --repack.
+func (info *FileInfo) convertSeriesZip(zipPath, finalPath string, options *Options) (err error) {
+	expectedSize := int64(0)
+	if info.FileSize != "" {
+		expectedSize, _ = strconv.ParseInt(info.FileSize, 10, 64)
+	}
+	var md5Map map[string]string
+	if !options.NoMD5 {
+		if md5Map, err = parseMD5HashesCSV(zipPath); err != nil {
+			logger.Warnf("Failed to parse MD5 hashes: %v", err)
+			md5Map = nil
+		}
+	}
+
+	tempPath := transferTempPath(finalPath, ".tmp")
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(tempPath)
+		}
+	}()
+	var sha256Hasher hash.Hash
+	var w io.Writer = f
+	if checksums != nil {
+		sha256Hasher = newSHA256Hash()
+		w = io.MultiWriter(f, sha256Hasher)
+	}
+
+	compressor, err := newCompressor(w, options.ArchiveFormat)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(compressor)
+	err = extractZipEntries(zipPath, &tarSink{tw: tw, prefix: info.SeriesUID}, expectedSize, md5Map, nil)
+	if closeErr := tw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := compressor.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		events.Record(Event{Action: "verify", SeriesUID: info.SeriesUID, Error: err.Error()})
+		return fmt.Errorf("failed to convert ZIP to %s: %w", options.ArchiveFormat, err)
+	}
+	if len(md5Map) > 0 {
+		events.Record(Event{Action: "verify", SeriesUID: info.SeriesUID, Detail: fmt.Sprintf("MD5 of %d files verified", len(md5Map))})
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to close archive: %w", err)
+	}
+
+	if removeErr := os.Remove(finalPath); removeErr != nil && !os.IsNotExist(removeErr) {
+		return fmt.Errorf("failed to remove existing file: %w", removeErr)
+	}
+	if err = renameFile(tempPath, finalPath); err != nil {
+		return fmt.Errorf("failed to move archive: %w", err)
+	}
+	if sha256Hasher != nil {
+		checksums.Add(finalPath, hex.EncodeToString(sha256Hasher.Sum(nil)))
+	}
+	if err := os.Remove(zipPath); err != nil {
+		logger.Warnf("Failed to remove temporary ZIP file %s: %v", zipPath, err)
+	}
+	logger.Debugf("Successfully converted %s to %s", info.SeriesUID, finalPath)
+	return nil
+}
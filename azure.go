@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Credentials of Azure Blob Storage, named as in the Azure CLI: a SAS token, or the
+// account key signing requests with Shared Key. Without either, blobs are read
+// anonymously, as from public containers.
+const (
+	azureSASEnv = "AZURE_STORAGE_SAS_TOKEN"
+	azureKeyEnv = "AZURE_STORAGE_KEY"
+)
+
+// azureBlobSuffix is the host suffix of Azure Blob Storage accounts
+const azureBlobSuffix = ".blob.core.windows.net"
+
+// azureAPIVersion is the Blob service version of signed requests
+const azureAPIVersion = "2021-08-06"
+
+// azureBlob is a blob, or a prefix of blobs, in an Azure storage account
+type azureBlob struct {
+	Account   string
+	Container string
+	Name      string
+	SAS       string // SAS token of the URL, without "?"
+}
+
+// isAzureURL reports whether a source is in Azure Blob Storage: az://account/container/blob
+// or https://account.blob.core.windows.net/container/blob
+func isAzureURL(uri string) bool {
+	if strings.HasPrefix(uri, "az://") {
+		return true
+	}
+	u, err := url.Parse(uri)
+	return err == nil && u.Scheme == "https" && strings.HasSuffix(strings.ToLower(u.Hostname()), azureBlobSuffix)
+}
+
+// parseAzureURL splits an Azure source into its account, container and blob
+func parseAzureURL(uri string) (*azureBlob, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	blob := &azureBlob{Account: u.Host, SAS: u.RawQuery}
+	if u.Scheme != "az" {
+		blob.Account = strings.TrimSuffix(strings.ToLower(u.Hostname()), azureBlobSuffix)
+	}
+	blob.Container, blob.Name, _ = strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if blob.Account == "" || blob.Container == "" {
+		return nil, fmt.Errorf("invalid Azure URL %s, expected az://account/container/blob", uri)
+	}
+	if blob.SAS == "" {
+		blob.SAS = strings.TrimPrefix(os.Getenv(azureSASEnv), "?")
+	}
+	return blob, nil
+}
+
+// requestURL returns the HTTPS URL of a blob, or of its container with the query
+func (b *azureBlob) requestURL(name string, query url.Values) string {
+	u := url.URL{Scheme: "https", Host: b.Account + azureBlobSuffix, Path: "/" + b.Container}
+	if name != "" {
+		u.Path += "/" + name
+	}
+	u.RawQuery = query.Encode()
+	if b.SAS != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += b.SAS
+	}
+	return u.String()
+}
+
+// authorize signs a request with the account key from AZURE_STORAGE_KEY. Requests
+// carrying a SAS token, or sent without a key, are left as they are.
+func (b *azureBlob) authorize(req *http.Request) error {
+	key := os.Getenv(azureKeyEnv)
+	if b.SAS != "" || key == "" {
+		return nil
+	}
+	secret, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", azureKeyEnv, err)
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+
+	var headers []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			headers = append(headers, lower+":"+strings.TrimSpace(req.Header.Get(name)))
+		}
+	}
+	sort.Strings(headers)
+	resource := "/" + b.Account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	var params []string
+	for name, values := range query {
+		sort.Strings(values)
+		params = append(params, strings.ToLower(name)+":"+strings.Join(values, ","))
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		resource += "\n" + param
+	}
+	// Shared Key string to sign of the Blob service: the verb, eleven standard
+	// headers, of which GET requests only send Range, then the x-ms- headers
+	stringToSign := strings.Join([]string{
+		req.Method, "", "", "", "", "", "", "", "", "", "", req.Header.Get("Range"),
+		strings.Join(headers, "\n"), resource,
+	}, "\n")
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", "SharedKey "+b.Account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// azureListing is a page of the List Blobs response
+type azureListing struct {
+	Blobs []struct {
+		Name       string `xml:"Name"`
+		Properties struct {
+			ContentLength int64  `xml:"Content-Length"`
+			ContentMD5    string `xml:"Content-MD5"`
+		} `xml:"Properties"`
+	} `xml:"Blobs>Blob"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// listAzureBlobs returns the blobs below a prefix as items named by their path
+// relative to it, with the size and MD5 Azure keeps for them
+func listAzureBlobs(httpClient *http.Client, prefix *azureBlob) ([]*FileInfo, error) {
+	dir := strings.TrimSuffix(prefix.Name, "*")
+	var files []*FileInfo
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {dir}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		req, err := http.NewRequest("GET", prefix.requestURL("", query), nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := prefix.authorize(req); err != nil {
+			return nil, err
+		}
+		resp, err := doRequest(httpClient, req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("listing %s failed: HTTP %d: %s", redactURL(req.URL.String()), resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		var listing azureListing
+		if err := xml.Unmarshal(body, &listing); err != nil {
+			return nil, fmt.Errorf("failed to parse blob listing: %v", err)
+		}
+		for _, blob := range listing.Blobs {
+			name := strings.TrimPrefix(blob.Name, dir)
+			if name == "" || strings.HasSuffix(name, "/") {
+				continue
+			}
+			info := &FileInfo{
+				DownloadURL: prefix.requestURL(blob.Name, nil),
+				SeriesUID:   name,
+				FileName:    filepath.FromSlash(path.Clean("/" + name))[1:],
+			}
+			if blob.Properties.ContentLength > 0 {
+				info.FileSize = fmt.Sprint(blob.Properties.ContentLength)
+			}
+			if sum, err := base64.StdEncoding.DecodeString(blob.Properties.ContentMD5); err == nil && len(sum) > 0 {
+				info.MD5Hash = hex.EncodeToString(sum)
+			}
+			files = append(files, info)
+		}
+		if marker = listing.NextMarker; marker == "" {
+			return files, nil
+		}
+	}
+}
+
+// downloadFromAzure downloads the blobs below a prefix (az://account/container/dir/*)
+// into the directory of the item
+func (info *FileInfo) downloadFromAzure(output string, httpClient *http.Client, options *Options) error {
+	prefix, err := parseAzureURL(info.DownloadURL)
+	if err != nil {
+		return err
+	}
+	blobs, err := listAzureBlobs(httpClient, prefix)
+	if err != nil {
+		return err
+	}
+	return info.downloadObjects(output, blobs, httpClient, options)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bagFile is a payload file and its path below the bag's data/ directory
+type bagFile struct {
+	src string
+	rel string
+}
+
+// bagUnsafeChars are replaced when a collection name is used as a directory name
+var bagUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// loadCachedSeriesMetadata returns the cached metadata of every TCIA series stored in output
+func loadCachedSeriesMetadata(output string) []*FileInfo {
+	paths, _ := filepath.Glob(filepath.Join(output, "metadata", "*.json"))
+	var infos []*FileInfo
+	for _, path := range paths {
+		info, err := loadMetadataFromCache(path)
+		if err != nil || info.SeriesUID == "" || info.SubjectID == "" {
+			continue
+		}
+		if _, err := os.Stat(info.seriesPath(output)); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].SeriesUID < infos[j].SeriesUID })
+	return infos
+}
+
+// collectBagFiles walks root and maps each file to rel below prefix, skipping
+// hidden files, temporary files and entries rejected by skip
+func collectBagFiles(root, prefix string, skip func(rel string) bool) ([]bagFile, error) {
+	var files []bagFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if rel == "." {
+			return nil
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") || (skip != nil && skip(filepath.ToSlash(rel))) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			files = append(files, bagFile{src: path, rel: filepath.ToSlash(filepath.Join(prefix, rel))})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// bagInfo builds bag-info.txt fields from the series metadata of the payload
+func bagInfo(series []*FileInfo, oxum string) [][2]string {
+	agent := "nbia-data-retriever-cli"
+	if version != "" {
+		agent += " " + version
+	}
+	fields := [][2]string{
+		{"Source-Organization", "The Cancer Imaging Archive"},
+		{"Bagging-Date", time.Now().Format("2006-01-02")},
+		{"Bag-Software-Agent", agent},
+		{"Payload-Oxum", oxum},
+	}
+
+	seen := make(map[string]bool)
+	add := func(key, value string) {
+		if value != "" && !seen[key+"\x00"+value] {
+			seen[key+"\x00"+value] = true
+			fields = append(fields, [2]string{key, value})
+		}
+	}
+	for _, info := range series {
+		add("Collection", info.Collection)
+	}
+	for _, info := range series {
+		add("License-Name", info.LicenseName)
+		add("License-URL", info.LicenseURL)
+		add("Data-Description-URI", info.DataDescriptionURI)
+	}
+	add("External-Description", fmt.Sprintf("%d series downloaded from TCIA", len(series)))
+	return fields
+}
+
+// writeBag creates a BagIt 1.0 bag at bagDir. Payload files are hard-linked when possible
+// and copied otherwise; sums already known from --sha256sums are reused.
+func writeBag(bagDir string, payload []bagFile, series []*FileInfo) error {
+	tempDir := bagDir + ".tmp"
+	if err := os.RemoveAll(tempDir); err != nil {
+		return err
+	}
+
+	var totalBytes int64
+	var manifest strings.Builder
+	sort.Slice(payload, func(i, j int) bool { return payload[i].rel < payload[j].rel })
+	for _, file := range payload {
+		dst := filepath.Join(tempDir, "data", filepath.FromSlash(file.rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Link(file.src, dst); err != nil {
+			if err := copyFile(file.src, dst); err != nil {
+				return fmt.Errorf("failed to add %s to bag: %v", file.src, err)
+			}
+		}
+
+		stat, err := os.Stat(dst)
+		if err != nil {
+			return err
+		}
+		totalBytes += stat.Size()
+
+		sum, ok := checksums.Lookup(file.src)
+		if !ok {
+			if sum, err = sha256File(dst); err != nil {
+				return fmt.Errorf("failed to hash %s: %v", dst, err)
+			}
+		}
+		fmt.Fprintf(&manifest, "%s  data/%s\n", sum, file.rel)
+	}
+
+	var info strings.Builder
+	for _, field := range bagInfo(series, fmt.Sprintf("%d.%d", totalBytes, len(payload))) {
+		fmt.Fprintf(&info, "%s: %s\n", field[0], field[1])
+	}
+
+	tagFiles := []struct{ name, content string }{
+		{"bagit.txt", "BagIt-Version: 1.0\nTag-File-Character-Encoding: UTF-8\n"},
+		{"bag-info.txt", info.String()},
+		{"manifest-sha256.txt", manifest.String()},
+	}
+	var tagManifest strings.Builder
+	for _, tag := range tagFiles {
+		path := filepath.Join(tempDir, tag.name)
+		if err := os.WriteFile(path, []byte(tag.content), 0644); err != nil {
+			return err
+		}
+		sum, err := sha256File(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&tagManifest, "%s  %s\n", sum, tag.name)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "tagmanifest-sha256.txt"), []byte(tagManifest.String()), 0644); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(bagDir); err != nil {
+		return err
+	}
+	return renameFile(tempDir, bagDir)
+}
+
+// buildBags packages the output directory as one BagIt bag next to it ({output}.bag),
+// or with perCollection one bag per collection below {output}.bags/
+func buildBags(options *Options, perCollection bool) ([]string, error) {
+	output := filepath.Clean(options.Output)
+	series := loadCachedSeriesMetadata(output)
+
+	if !perCollection {
+		payload, err := collectBagFiles(output, "", func(rel string) bool {
+			return rel == checksumManifestFile || strings.HasPrefix(rel, "s5cmd-tmp-")
+		})
+		if err != nil {
+			return nil, err
+		}
+		bagDir := output + ".bag"
+		return []string{bagDir}, writeBag(bagDir, payload, series)
+	}
+
+	byCollection := make(map[string][]*FileInfo)
+	for _, info := range series {
+		collection := info.Collection
+		if collection == "" {
+			collection = "unknown"
+		}
+		byCollection[collection] = append(byCollection[collection], info)
+	}
+
+	var bags []string
+	for _, collection := range sortedKeys(byCollection) {
+		var payload []bagFile
+		for _, info := range byCollection[collection] {
+			files, err := collectBagFiles(info.seriesPath(output), filepath.Join(info.SubjectID, info.StudyUID, info.SeriesUID), nil)
+			if err != nil {
+				return bags, err
+			}
+			payload = append(payload, files...)
+			payload = append(payload, bagFile{
+				src: info.MetaFile(output),
+				rel: "metadata/" + filepath.Base(info.MetaFile(output)),
+			})
+		}
+		bagDir := filepath.Join(output+".bags", bagUnsafeChars.ReplaceAllString(collection, "_"))
+		if err := os.MkdirAll(filepath.Dir(bagDir), 0755); err != nil {
+			return bags, err
+		}
+		if err := writeBag(bagDir, payload, byCollection[collection]); err != nil {
+			return bags, fmt.Errorf("collection %s: %v", collection, err)
+		}
+		bags = append(bags, bagDir)
+	}
+	return bags, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// StudySummary is a single study as returned by getPatientStudy
+type StudySummary struct {
+	StudyUID         string `json:"StudyInstanceUID"`
+	StudyDate        string `json:"StudyDate"`
+	StudyDescription string `json:"StudyDescription"`
+	PatientID        string `json:"PatientID"`
+	Collection       string `json:"Collection"`
+	SeriesCount      int    `json:"SeriesCount"`
+}
+
+// SeriesSummary is a single series as returned by getSeries
+type SeriesSummary struct {
+	SeriesUID         string  `json:"SeriesInstanceUID"`
+	StudyUID          string  `json:"StudyInstanceUID"`
+	Modality          string  `json:"Modality"`
+	SeriesDescription string  `json:"SeriesDescription"`
+	SeriesNumber      float64 `json:"SeriesNumber"`
+	BodyPartExamined  string  `json:"BodyPartExamined"`
+	PatientID         string  `json:"PatientID"`
+	Collection        string  `json:"Collection"`
+	ImageCount        int     `json:"ImageCount"`
+	FileSize          float64 `json:"FileSize"`
+}
+
+// BrowseResult is the JSON document printed by `browse --json`
+type BrowseResult struct {
+	Studies []*StudySummary  `json:"studies"`
+	Series  []*SeriesSummary `json:"series"`
+}
+
+// browseQuery builds the query parameters shared by the study and series endpoints
+func browseQuery(options *Options) map[string]interface{} {
+	query := map[string]interface{}{}
+	if options.Collection != "" {
+		query["Collection"] = options.Collection
+	}
+	if options.PatientID != "" {
+		query["PatientID"] = options.PatientID
+	}
+	if options.StudyUID != "" {
+		query["StudyInstanceUID"] = options.StudyUID
+	}
+	return query
+}
+
+// queryNBIA fetches an NBIA listing endpoint and decodes its JSON array into out
+func queryNBIA(httpClient *http.Client, authToken *Token, endpoint string, query map[string]interface{}, out interface{}) error {
+	url_, err := makeURL(endpoint, query)
+	if err != nil {
+		return err
+	}
+	content, err := fetchNBIAResponse(httpClient, authToken, url_)
+	if err != nil {
+		return err
+	}
+	// NBIA answers queries without matches with an empty body
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(content, out); err != nil {
+		return fmt.Errorf("failed to parse response from %s: %v", endpoint, err)
+	}
+	return nil
+}
+
+// browseSeries lists the studies and series matching the browse filters
+func browseSeries(httpClient *http.Client, authToken *Token, options *Options) (*BrowseResult, error) {
+	result := &BrowseResult{}
+	query := browseQuery(options)
+
+	if err := queryNBIA(httpClient, authToken, endpoints.Study, query, &result.Studies); err != nil {
+		return nil, fmt.Errorf("failed to list studies: %w", err)
+	}
+	if options.StudyUID != "" {
+		// getPatientStudy ignores StudyInstanceUID, so filter locally
+		filtered := result.Studies[:0]
+		for _, study := range result.Studies {
+			if study.StudyUID == options.StudyUID {
+				filtered = append(filtered, study)
+			}
+		}
+		result.Studies = filtered
+	}
+	for i := range result.Studies {
+		result.Studies[i].StudyDate = normalizeDate(result.Studies[i].StudyDate)
+	}
+
+	if err := queryNBIA(httpClient, authToken, endpoints.Series, query, &result.Series); err != nil {
+		return nil, fmt.Errorf("failed to list series: %w", err)
+	}
+
+	sort.Slice(result.Series, func(i, j int) bool {
+		a, b := result.Series[i], result.Series[j]
+		if a.PatientID != b.PatientID {
+			return a.PatientID < b.PatientID
+		}
+		if a.StudyUID != b.StudyUID {
+			return a.StudyUID < b.StudyUID
+		}
+		return a.SeriesNumber < b.SeriesNumber
+	})
+
+	return result, nil
+}
+
+// printBrowseResult prints the studies and a numbered series table
+func printBrowseResult(result *BrowseResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "STUDY\tPATIENT\tDATE\tSERIES\tDESCRIPTION\n")
+	for _, study := range result.Studies {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", study.StudyUID, study.PatientID, study.StudyDate, study.SeriesCount, study.StudyDescription)
+	}
+	fmt.Fprintf(w, "\n#\tSERIES\tPATIENT\tMODALITY\tIMAGES\tSIZE (MB)\tDESCRIPTION\n")
+	for i, series := range result.Series {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%.1f\t%s\n", i+1, series.SeriesUID, series.PatientID,
+			series.Modality, series.ImageCount, series.FileSize/(1024*1024), series.SeriesDescription)
+	}
+	_ = w.Flush()
+}
+
+// parseSelection parses a selection like "1,3-5" or "all" into zero-based indices
+func parseSelection(input string, count int) ([]int, error) {
+	input = strings.TrimSpace(input)
+	if strings.EqualFold(input, "all") {
+		indices := make([]int, count)
+		for i := range indices {
+			indices[i] = i
+		}
+		return indices, nil
+	}
+
+	seen := make(map[int]bool)
+	var indices []int
+	for _, part := range strings.Split(input, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi := part, part
+		if before, after, found := strings.Cut(part, "-"); found {
+			lo, hi = before, after
+		}
+		start, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", part)
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", part)
+		}
+		if start < 1 || end > count || start > end {
+			return nil, fmt.Errorf("selection %q is out of range 1-%d", part, count)
+		}
+		for i := start - 1; i < end; i++ {
+			if !seen[i] {
+				seen[i] = true
+				indices = append(indices, i)
+			}
+		}
+	}
+	return indices, nil
+}
+
+// writeTCIAManifest writes series UIDs in the NBIA .tcia manifest format
+func writeTCIAManifest(path string, seriesUIDs []string) error {
+	var b strings.Builder
+	b.WriteString("downloadServerUrl=https://public.cancerimagingarchive.net/nbia-download/servlet/DownloadServlet\n")
+	b.WriteString("includeAnnotation=true\n")
+	b.WriteString("noOfrRetry=4\n")
+	b.WriteString("databasketId=nbia-data-retriever-cli.tcia\n")
+	b.WriteString("manifestVersion=3.0\n")
+	b.WriteString("ListOfSeriesToDownload=\n")
+	for _, uid := range seriesUIDs {
+		b.WriteString(uid)
+		b.WriteString("\n")
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// stdinIsTerminal reports whether stdin is attached to an interactive terminal
+func stdinIsTerminal() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// runBrowse implements the `browse` command
+func runBrowse(httpClient *http.Client, authToken *Token, options *Options) error {
+	if options.Collection == "" && options.PatientID == "" {
+		return fmt.Errorf("browse requires --collection and/or --patient")
+	}
+
+	result, err := browseSeries(httpClient, authToken, options)
+	if err != nil {
+		return err
+	}
+
+	if options.JSON {
+		content, err := json.MarshalIndent(result, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+	} else {
+		printBrowseResult(result)
+	}
+
+	if options.SaveManifest == "" {
+		return nil
+	}
+
+	// Interactive selection; non-interactive runs take every listed series
+	indices, _ := parseSelection("all", len(result.Series))
+	if stdinIsTerminal() && !options.JSON {
+		fmt.Fprintf(os.Stderr, "\nSelect series for %s (e.g. 1,3-5 or all): ", options.SaveManifest)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read selection: %v", err)
+		}
+		if indices, err = parseSelection(line, len(result.Series)); err != nil {
+			return err
+		}
+	}
+
+	uids := make([]string, 0, len(indices))
+	for _, i := range indices {
+		uids = append(uids, result.Series[i].SeriesUID)
+	}
+	if err := writeTCIAManifest(options.SaveManifest, uids); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d series to %s\n", len(uids), options.SaveManifest)
+	return nil
+}
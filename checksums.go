@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// checksumManifestFile is the sha256sum-compatible manifest written to the output directory
+const checksumManifestFile = "sha256sums.txt"
+
+// checksums collects SHA-256 sums of downloaded files when --sha256sums is set
+var checksums *ChecksumManifest
+
+// ChecksumManifest holds the SHA-256 sum of every file in the output directory, keyed by
+// slash-separated path relative to the output directory. Entries from previous runs are
+// kept so the manifest covers the whole archive, not just the last run.
+type ChecksumManifest struct {
+	output  string
+	mu      sync.Mutex
+	sums    map[string]string
+	removed map[string]bool // entries dropped by ReplaceDir, not restored when merging
+}
+
+// NewChecksumManifest loads the existing manifest of the output directory, if any
+func NewChecksumManifest(output string) (*ChecksumManifest, error) {
+	m := &ChecksumManifest{output: output, removed: make(map[string]bool)}
+	sums, err := readChecksumFile(m.Path())
+	if err != nil {
+		return nil, err
+	}
+	m.sums = sums
+	return m, nil
+}
+
+// readChecksumFile reads a sha256sum-style file into a map keyed by path
+func readChecksumFile(path string) (map[string]string, error) {
+	sums := make(map[string]string)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return sums, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// sha256sum format: "<hex>  <path>" (a '*' instead of the second space marks binary mode)
+		line := scanner.Text()
+		if len(line) < 67 || line[64] != ' ' {
+			continue
+		}
+		sums[line[66:]] = line[:64]
+	}
+	return sums, scanner.Err()
+}
+
+// Path returns the location of the manifest file
+func (m *ChecksumManifest) Path() string {
+	return filepath.Join(m.output, checksumManifestFile)
+}
+
+// relPath converts a path inside the output directory to a manifest key
+func (m *ChecksumManifest) relPath(path string) string {
+	rel, err := filepath.Rel(m.output, path)
+	if err != nil {
+		rel = path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Add records the sum of a single file
+func (m *ChecksumManifest) Add(path, sum string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sums[m.relPath(path)] = sum
+}
+
+// ReplaceDir drops every entry below dir and records sums, keyed by path relative to dir.
+// A re-downloaded series thereby does not leave stale entries for removed files.
+func (m *ChecksumManifest) ReplaceDir(dir string, sums map[string]string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := m.relPath(dir) + "/"
+	for key := range m.sums {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.sums, key)
+			m.removed[key] = true
+		}
+	}
+	for name, sum := range sums {
+		m.sums[prefix+filepath.ToSlash(name)] = sum
+	}
+}
+
+// RemoveDir drops every entry below dir, for a directory packed into an archive
+func (m *ChecksumManifest) RemoveDir(dir string) {
+	m.ReplaceDir(dir, nil)
+}
+
+// HashDir hashes every file below dir and replaces its entries. This is a second read
+// pass, used only for transfers that are not streamed through the retriever (s5cmd).
+func (m *ChecksumManifest) HashDir(dir string) error {
+	if m == nil {
+		return nil
+	}
+	sums := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		sum, err := sha256File(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		sums[rel] = sum
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %v", dir, err)
+	}
+	m.ReplaceDir(dir, sums)
+	return nil
+}
+
+// Save atomically writes the manifest, sorted by path. With --shared the entries
+// other invocations saved in the meantime are merged in first.
+func (m *ChecksumManifest) Save() error {
+	if m == nil {
+		return nil
+	}
+	return outputLock.WithState(func() error {
+		if outputLock.Shared() {
+			if err := m.mergeSaved(); err != nil {
+				return err
+			}
+		}
+		return m.save()
+	})
+}
+
+// mergeSaved adds the entries saved by other invocations since the manifest was loaded
+func (m *ChecksumManifest) mergeSaved() error {
+	saved, err := readChecksumFile(m.Path())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, sum := range saved {
+		if _, ok := m.sums[key]; !ok && !m.removed[key] {
+			m.sums[key] = sum
+		}
+	}
+	return nil
+}
+
+func (m *ChecksumManifest) save() error {
+	m.mu.Lock()
+	paths := make([]string, 0, len(m.sums))
+	for path := range m.sums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%s  %s\n", m.sums[path], path)
+	}
+	m.mu.Unlock()
+
+	return writeFileAtomic(m.Path(), []byte(b.String()), 0644)
+}
+
+// Lookup returns the recorded sum of a file, if any
+func (m *ChecksumManifest) Lookup(path string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sum, ok := m.sums[m.relPath(path)]
+	return sum, ok
+}
+
+// Len returns the number of files in the manifest
+func (m *ChecksumManifest) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sums)
+}
+
+// sha256File returns the hex SHA-256 of a file on disk
+func sha256File(path string) (string, error) {
+	var sum string
+	err := withRecallWait(path, func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		hasher := newSHA256Hash()
+		if _, err := io.Copy(hasher, f); err != nil {
+			return err
+		}
+		sum = hex.EncodeToString(hasher.Sum(nil))
+		return nil
+	})
+	return sum, err
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// citationsDir holds the citation files of the collections in the output
+const citationsDir = "citations"
+
+// doiPattern finds the DOI in a Data Description URI such as
+// https://doi.org/10.7937/K9/TCIA.2015.LO9QL9SX
+var doiPattern = regexp.MustCompile(`10\.[0-9]{4,9}/[^\s?#]+`)
+
+// dataCiteRecord holds the attributes of a DataCite DOI used in citations
+type dataCiteRecord struct {
+	Data struct {
+		Attributes struct {
+			DOI      string `json:"doi"`
+			URL      string `json:"url"`
+			Version  string `json:"version"`
+			Creators []struct {
+				Name       string `json:"name"`
+				NameType   string `json:"nameType"`
+				GivenName  string `json:"givenName"`
+				FamilyName string `json:"familyName"`
+			} `json:"creators"`
+			Titles []struct {
+				Title string `json:"title"`
+			} `json:"titles"`
+			Dates []struct {
+				Date     string `json:"date"`
+				DateType string `json:"dateType"`
+			} `json:"dates"`
+			RightsList []struct {
+				RightsIdentifier string `json:"rightsIdentifier"`
+			} `json:"rightsList"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// writeCitations writes a CITATION.cff and the DataCite record of every DOI of the
+// collections in the output to citations/, named after the collection. DOIs with
+// files from an earlier run are not resolved again.
+func writeCitations(httpClient *http.Client, options *Options) {
+	dir := filepath.Join(options.Output, citationsDir)
+	written := 0
+	for _, collection := range provenanceCollections(loadCachedSeriesMetadata(options.Output)) {
+		for i, uri := range collection.DOIs {
+			doi := doiPattern.FindString(uri)
+			if doi == "" {
+				continue
+			}
+			name := bagUnsafeChars.ReplaceAllString(collection.Name, "_")
+			if i > 0 {
+				name += fmt.Sprintf("-%d", i+1)
+			}
+			cffPath := filepath.Join(dir, name+".cff")
+			if _, err := os.Stat(cffPath); err == nil {
+				continue
+			}
+			if err := writeCitation(httpClient, doi, dir, name); err != nil {
+				logger.Warnf("Failed to write the citation of %s (%s): %v", collection.Name, doi, err)
+				continue
+			}
+			written++
+		}
+	}
+	if written > 0 {
+		fmt.Printf("Citations of %d datasets saved to %s\n", written, dir)
+	}
+}
+
+// writeCitation resolves a DOI with the DataCite API and saves the record as
+// <name>.datacite.json and as <name>.cff
+func writeCitation(httpClient *http.Client, doi, dir, name string) error {
+	req, err := http.NewRequest("GET", endpoints.DataCite+doi, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.api+json")
+	resp, err := doRequest(httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("DataCite: HTTP %d", resp.StatusCode)
+	}
+	var record dataCiteRecord
+	if err := json.Unmarshal(body, &record); err != nil {
+		return fmt.Errorf("failed to parse the DataCite record: %v", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, name+".datacite.json"), body, 0644); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, name+".cff"), []byte(citationCFF(&record, doi)), 0644)
+}
+
+// citationCFF renders a DataCite record in the Citation File Format 1.2.0
+func citationCFF(record *dataCiteRecord, doi string) string {
+	attributes := record.Data.Attributes
+	if attributes.DOI != "" {
+		doi = attributes.DOI
+	}
+	// JSON strings are valid YAML double-quoted scalars
+	quote := func(s string) string {
+		quoted, _ := json.Marshal(s)
+		return string(quoted)
+	}
+
+	var b strings.Builder
+	b.WriteString("cff-version: 1.2.0\n")
+	b.WriteString("message: \"If you use this dataset, please cite it as below.\"\n")
+	b.WriteString("type: dataset\n")
+	if len(attributes.Titles) > 0 {
+		fmt.Fprintf(&b, "title: %s\n", quote(attributes.Titles[0].Title))
+	}
+	b.WriteString("authors:\n")
+	for _, creator := range attributes.Creators {
+		if creator.NameType == "Organizational" || creator.FamilyName == "" {
+			fmt.Fprintf(&b, "  - name: %s\n", quote(creator.Name))
+			continue
+		}
+		fmt.Fprintf(&b, "  - family-names: %s\n", quote(creator.FamilyName))
+		if creator.GivenName != "" {
+			fmt.Fprintf(&b, "    given-names: %s\n", quote(creator.GivenName))
+		}
+	}
+	if len(attributes.Creators) == 0 {
+		b.WriteString("  - name: \"The Cancer Imaging Archive\"\n")
+	}
+	fmt.Fprintf(&b, "doi: %s\n", quote(doi))
+	url := attributes.URL
+	if url == "" {
+		url = "https://doi.org/" + doi
+	}
+	fmt.Fprintf(&b, "url: %s\n", quote(url))
+	if attributes.Version != "" {
+		fmt.Fprintf(&b, "version: %s\n", quote(attributes.Version))
+	}
+	for _, date := range attributes.Dates {
+		// date-released takes a full date; DataCite often has only the year
+		if date.DateType == "Issued" && len(date.Date) == len("2006-01-02") {
+			fmt.Fprintf(&b, "date-released: %s\n", quote(date.Date))
+			break
+		}
+	}
+	for _, rights := range attributes.RightsList {
+		if rights.RightsIdentifier != "" {
+			fmt.Fprintf(&b, "license: %s\n", quote(strings.ToUpper(rights.RightsIdentifier)))
+			break
+		}
+	}
+	return b.String()
+}
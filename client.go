@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"crypto/tls"
 	"net"
@@ -9,33 +10,33 @@ import (
 	"time"
 )
 
-func newClient(proxy string, maxConnsPerHost int) *http.Client {
-	logger.Debugf("initializing http request client with max %d connections per host", maxConnsPerHost)
+func newClient(proxy string, profile NetProfile, timeout time.Duration) *http.Client {
+	logger.Debugf("initializing http request client with max %d connections per host, keep-alive %v, HTTP/2 %v",
+		profile.MaxConnsPerHost, profile.KeepAlive, profile.HTTP2)
 	if proxy != "" {
 		logger.Debugf("using proxy %s", proxy)
 	}
+	if profile.IPVersion != "" || profile.NoHappyEyeballs || profile.DNSServer != "" {
+		logger.Debugf("dialing IPv%s, happy eyeballs %v, DNS server %q", cmp.Or(profile.IPVersion, "4/6"),
+			!profile.NoHappyEyeballs, profile.DNSServer)
+	}
 
-	// Configure transport for parallel downloads (server-friendly settings)
+	// Configure transport for parallel downloads (see --net-profile)
 	transport := &http.Transport{
-		MaxIdleConns:          maxConnsPerHost * 2, // Server-friendly: reduced multiplier
-		MaxIdleConnsPerHost:   maxConnsPerHost,
-		MaxConnsPerHost:       maxConnsPerHost,
-		IdleConnTimeout:       30 * time.Second, // Server-friendly: reduced from 90s
+		MaxIdleConns:          profile.MaxConnsPerHost * 2, // Server-friendly: reduced multiplier
+		MaxIdleConnsPerHost:   profile.MaxConnsPerHost,
+		MaxConnsPerHost:       profile.MaxConnsPerHost,
+		IdleConnTimeout:       profile.IdleConnTimeout,
 		TLSHandshakeTimeout:   20 * time.Second, // Server-friendly: increased timeout
-		DisableKeepAlives:     false,            // Enable HTTP/1.1 keep-alive
+		DisableKeepAlives:     !profile.KeepAlive,
 		DisableCompression:    true,             // Disable compression to avoid issues
-		ForceAttemptHTTP2:     false,            // NBIA server doesn't support HTTP/2
+		ForceAttemptHTTP2:     profile.HTTP2,    // NBIA servers answer HTTP/1.1 only; ALPN falls back
 		ResponseHeaderTimeout: 30 * time.Second, // Timeout for server response headers
 		ExpectContinueTimeout: 1 * time.Second,  // Timeout for HTTP/1.1 100-continue
+		ReadBufferSize:        profile.ReadBufferSize,
+		WriteBufferSize:       profile.WriteBufferSize,
 		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
-		// Custom dialer with connection timeout
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			dialer := &net.Dialer{
-				Timeout:   30 * time.Second, // Connection timeout
-				KeepAlive: 30 * time.Second, // TCP keep-alive
-			}
-			return dialer.DialContext(ctx, network, addr)
-		},
+		DialContext:           profile.dialContext,
 	}
 
 	// Add proxy if configured
@@ -47,10 +48,67 @@ func newClient(proxy string, maxConnsPerHost int) *http.Client {
 		transport.Proxy = http.ProxyURL(p)
 	}
 
+	// Requests carrying credentials only go to servers with a valid certificate
+	verified := transport.Clone()
+	verified.TLSClientConfig = &tls.Config{}
+
 	client := &http.Client{
-		Transport: transport,
-		Timeout:   10 * time.Minute, // Global timeout for requests
+		Transport: &credentialTransport{lenient: transport, verified: verified},
+		Timeout:   timeout, // Global timeout for requests
 	}
 
 	return client
 }
+
+// credentialsKey marks the context of requests whose body carries credentials
+type credentialsKey struct{}
+
+// withCredentials marks a request whose body carries credentials, such as a password
+// or an API key, so that it is sent like those with an Authorization header
+func withCredentials(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), credentialsKey{}, true))
+}
+
+// credentialTransport sends the requests carrying credentials, in an Authorization
+// header or marked by withCredentials, over a transport that verifies the server
+// certificate, and the others over the lenient one
+type credentialTransport struct {
+	lenient  http.RoundTripper
+	verified http.RoundTripper
+}
+
+func (t *credentialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" || req.Context().Value(credentialsKey{}) != nil {
+		return t.verified.RoundTrip(req)
+	}
+	return t.lenient.RoundTrip(req)
+}
+
+// dialContext connects with the dialing settings of the profile (--ip-version,
+// --happy-eyeballs and --dns-server)
+func (profile NetProfile) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second, // Connection timeout
+		KeepAlive: 30 * time.Second, // TCP keep-alive
+	}
+	if profile.NoHappyEyeballs {
+		// The addresses are tried in turn, IPv6 first where the resolver prefers it
+		dialer.FallbackDelay = -1
+	}
+	if profile.DNSServer != "" {
+		server := profile.DNSServer
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	// Restricting the network also restricts the name lookup to A or AAAA records,
+	// so a broken IPv6 path is never tried
+	if profile.IPVersion != "" && network == "tcp" {
+		network += profile.IPVersion
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/DavidGamba/go-getoptions"
+)
+
+// completionShells are the shells the completion command writes scripts for
+var completionShells = map[string]func(w io.Writer, program string, flags []completionFlag){
+	"bash":       writeBashCompletion,
+	"zsh":        writeZshCompletion,
+	"fish":       writeFishCompletion,
+	"powershell": writePowerShellCompletion,
+}
+
+// completionInputExts are the --input formats offered by the completion scripts
+var completionInputExts = []string{"tcia", "s5cmd", "csv", "tsv", "xlsx", "json", "txt"}
+
+// completionFileFlags are the options whose value is a file; directories are
+// offered for those of completionDirFlags
+var (
+	completionFileFlags = map[string]bool{"auth": true, "allowed-collections": true, "series-list": true, "subjects": true,
+		"priority-input": true, "save-manifest": true, "stats-file": true, "recall-list": true, "deidentify": true, "s5cmd-path": true,
+		"usage-file": true}
+	completionDirFlags = map[string]bool{"output": true, "temp-dir": true, "stamp-dir": true, "token-cache": true}
+)
+
+// completionFileArgs are the commands taking files as arguments
+var completionFileArgs = []string{"apply", "plan", "merge-reports", "encrypt"}
+
+// completionOptionLine is an option of the help text: its names, the type of its
+// value and its description, followed by the default
+var completionOptionLine = regexp.MustCompile(`^    (-[^ ]+)(?: <(\w+)>)?\s+(.*)$`)
+
+// completionFlag is an option as the completion scripts offer it
+type completionFlag struct {
+	Names       []string // --name first, then the aliases
+	Kind        string   // "" for switches, value, choices, file, dir or input
+	Description string
+	Choices     []string
+	Repeatable  bool
+}
+
+// choices restricts an option to values, which the completion scripts offer
+func (opt *Options) choices(name string, values ...string) getoptions.ModifyFn {
+	if opt.flagValues == nil {
+		opt.flagValues = make(map[string][]string)
+	}
+	opt.flagValues[name] = values
+	return opt.opt.ValidValues(values...)
+}
+
+// completionFlags lists the options of the help text, so the scripts always match
+// the options of the build
+func (opt *Options) completionFlags() []completionFlag {
+	var flags []completionFlag
+	for _, line := range strings.Split(opt.opt.Help(getoptions.HelpOptionList), "\n") {
+		match := completionOptionLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		flag := completionFlag{Names: strings.Split(match[1], "|"), Description: match[3]}
+		if i := strings.LastIndex(flag.Description, "(default: "); i >= 0 {
+			flag.Repeatable = strings.HasPrefix(flag.Description[i:], "(default: [")
+			flag.Description = strings.TrimSpace(flag.Description[:i])
+		}
+		name := strings.TrimLeft(flag.Names[0], "-")
+		switch {
+		case match[2] == "":
+		case name == "input":
+			flag.Kind = "input"
+		case completionFileFlags[name]:
+			flag.Kind = "file"
+		case completionDirFlags[name]:
+			flag.Kind = "dir"
+		case opt.flagValues[name] != nil:
+			flag.Kind, flag.Choices = "choices", opt.flagValues[name]
+		default:
+			flag.Kind = "value"
+		}
+		flags = append(flags, flag)
+	}
+	return flags
+}
+
+// runCompletion writes the completion script of a shell to standard output
+func runCompletion(args []string, opt *Options) error {
+	if len(args) != 1 || completionShells[strings.ToLower(args[0])] == nil {
+		return fmt.Errorf("completion takes the shell as its argument: %s", strings.Join(sortedKeys(completionShells), ", "))
+	}
+	program := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	completionShells[strings.ToLower(args[0])](os.Stdout, program, opt.completionFlags())
+	return nil
+}
+
+// completionFunc returns the shell function name of a program
+func completionFunc(program string) string {
+	return "_" + regexp.MustCompile(`[^A-Za-z0-9_]`).ReplaceAllString(program, "_")
+}
+
+// flagNames returns the names of the flags of a kind, or of all flags
+func flagNames(flags []completionFlag, kind string) []string {
+	var names []string
+	for _, flag := range flags {
+		if kind == "*" || flag.Kind == kind {
+			names = append(names, flag.Names...)
+		}
+	}
+	return names
+}
+
+func writeBashCompletion(w io.Writer, program string, flags []completionFlag) {
+	fn := completionFunc(program)
+	fmt.Fprintf(w, "# bash completion for %s\n# source <(%s completion bash)\n\n", program, program)
+	fmt.Fprintf(w, "%s_files() {\n", fn)
+	fmt.Fprintf(w, "    local IFS=$'\\n' ext\n    compopt -o filenames 2>/dev/null\n")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -d -- \"$cur\"))\n")
+	fmt.Fprintf(w, "    for ext in \"$@\"; do\n        COMPREPLY+=($(compgen -f -X \"!*.$ext\" -- \"$cur\"))\n    done\n}\n\n")
+
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}\n")
+	fmt.Fprintf(w, "    # --option=value is split at the =\n")
+	fmt.Fprintf(w, "    if [[ $cur == = ]]; then\n        cur=\n    elif [[ $prev == = && $COMP_CWORD -gt 1 ]]; then\n        prev=${COMP_WORDS[COMP_CWORD-2]}\n    fi\n")
+	fmt.Fprintf(w, "    COMPREPLY=()\n    case $prev in\n")
+	fmt.Fprintf(w, "        %s)\n            %s_files %s\n            return ;;\n",
+		strings.Join(flagNames(flags, "input"), "|"), fn, strings.Join(completionInputExts, " "))
+	fmt.Fprintf(w, "        %s)\n            local IFS=$'\\n'\n            compopt -o filenames 2>/dev/null\n            COMPREPLY=($(compgen -f -- \"$cur\"))\n            return ;;\n",
+		strings.Join(flagNames(flags, "file"), "|"))
+	fmt.Fprintf(w, "        %s)\n            local IFS=$'\\n'\n            compopt -o filenames 2>/dev/null\n            COMPREPLY=($(compgen -d -- \"$cur\"))\n            return ;;\n",
+		strings.Join(flagNames(flags, "dir"), "|"))
+	for _, flag := range flags {
+		if flag.Kind == "choices" {
+			fmt.Fprintf(w, "        %s)\n            COMPREPLY=($(compgen -W %q -- \"$cur\"))\n            return ;;\n",
+				strings.Join(flag.Names, "|"), strings.Join(flag.Choices, " "))
+		}
+	}
+	fmt.Fprintf(w, "        %s)\n            return ;;\n", strings.Join(flagNames(flags, "value"), "|"))
+	fmt.Fprintf(w, "    esac\n")
+	fmt.Fprintf(w, "    if [[ $cur == -* ]]; then\n        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(flagNames(flags, "*"), " "))
+	fmt.Fprintf(w, "    elif [[ $COMP_CWORD -eq 1 ]]; then\n        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(sortedKeys(commands), " "))
+	fmt.Fprintf(w, "    elif [[ \" %s \" == *\" ${COMP_WORDS[1]} \"* ]]; then\n", strings.Join(completionFileArgs, " "))
+	fmt.Fprintf(w, "        local IFS=$'\\n'\n        compopt -o filenames 2>/dev/null\n        COMPREPLY=($(compgen -f -- \"$cur\"))\n    fi\n}\n\n")
+	fmt.Fprintf(w, "complete -F %s %s\n", fn, program)
+}
+
+// zshQuote quotes s for the explanation of a single-quoted _arguments spec
+func zshQuote(s string) string {
+	return strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+func writeZshCompletion(w io.Writer, program string, flags []completionFlag) {
+	fn := completionFunc(program)
+	fmt.Fprintf(w, "#compdef %s\n# source <(%s completion zsh), or save as %s in a directory of $fpath\n\n", program, program, fn)
+	fmt.Fprintf(w, "%s() {\n    local context state state_descr line\n    typeset -A opt_args\n    local -a commands\n    commands=(\n", fn)
+	for _, name := range sortedKeys(commands) {
+		fmt.Fprintf(w, "        '%s:%s'\n", name, strings.NewReplacer(`'`, `'\''`, `:`, `\:`).Replace(commands[name]))
+	}
+	fmt.Fprintf(w, "    )\n    _arguments -s -S \\\n")
+	for _, flag := range flags {
+		var names []string
+		for _, name := range flag.Names {
+			switch {
+			case flag.Kind == "":
+			case strings.HasPrefix(name, "--"):
+				name += "="
+			default:
+				name += "+"
+			}
+			names = append(names, name)
+		}
+		exclusive := "(" + strings.Join(flag.Names, " ") + ")"
+		if flag.Repeatable {
+			exclusive = "*"
+		}
+		spec := "'" + exclusive + "'"
+		if len(names) == 1 {
+			spec += "'" + names[0] + "'"
+		} else {
+			spec += "{" + strings.Join(names, ",") + "}"
+		}
+		spec += "'[" + zshQuote(flag.Description) + "]"
+		arg := strings.TrimLeft(flag.Names[0], "-")
+		switch flag.Kind {
+		case "input":
+			spec += ":" + arg + `:_files -g "*.(` + strings.Join(completionInputExts, "|") + `)"`
+		case "file":
+			spec += ":" + arg + ":_files"
+		case "dir":
+			spec += ":" + arg + ":_files -/"
+		case "choices":
+			spec += ":" + arg + ":(" + strings.Join(flag.Choices, " ") + ")"
+		case "value":
+			spec += ":" + arg + ": "
+		}
+		fmt.Fprintf(w, "        %s' \\\n", spec)
+	}
+	fmt.Fprintf(w, "        '1: :->command' \\\n        '*:file:_files' && return\n")
+	fmt.Fprintf(w, "    case $state in\n        command)\n            _describe -t commands command commands ;;\n    esac\n}\n\n")
+	fmt.Fprintf(w, "if [ \"$funcstack[1]\" = \"%s\" ]; then\n    %s \"$@\"\nelse\n    compdef %s %s\nfi\n", fn, fn, fn, program)
+}
+
+// fishQuote quotes s for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func writeFishCompletion(w io.Writer, program string, flags []completionFlag) {
+	fmt.Fprintf(w, "# fish completion for %s\n# %s completion fish | source, or save as ~/.config/fish/completions/%s.fish\n\n", program, program, program)
+	fmt.Fprintf(w, "complete -c %s -f\n", program)
+	for _, name := range sortedKeys(commands) {
+		fmt.Fprintf(w, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n", program, name, fishQuote(commands[name]))
+	}
+	fmt.Fprintf(w, "complete -c %s -n '__fish_seen_subcommand_from %s' -F\n", program, strings.Join(completionFileArgs, " "))
+	for _, flag := range flags {
+		line := "complete -c " + program
+		for _, name := range flag.Names {
+			if strings.HasPrefix(name, "--") {
+				line += " -l " + strings.TrimPrefix(name, "--")
+			} else {
+				line += " -s " + strings.TrimPrefix(name, "-")
+			}
+		}
+		switch flag.Kind {
+		case "input":
+			var suffixes []string
+			for _, ext := range completionInputExts {
+				suffixes = append(suffixes, "."+ext)
+			}
+			line += " -r -a '(__fish_complete_suffix " + strings.Join(suffixes, " ") + ")'"
+		case "file":
+			line += " -r -F"
+		case "dir":
+			line += " -r -a '(__fish_complete_directories)'"
+		case "choices":
+			line += " -x -a " + fishQuote(strings.Join(flag.Choices, " "))
+		case "value":
+			line += " -x"
+		}
+		fmt.Fprintf(w, "%s -d %s\n", line, fishQuote(flag.Description))
+	}
+}
+
+// powerShellQuote quotes s for PowerShell
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func writePowerShellCompletion(w io.Writer, program string, flags []completionFlag) {
+	quoteAll := func(values []string) string {
+		quoted := make([]string, len(values))
+		for i, value := range values {
+			quoted[i] = powerShellQuote(value)
+		}
+		return "@(" + strings.Join(quoted, ", ") + ")"
+	}
+
+	fmt.Fprintf(w, "# PowerShell completion for %s\n# %s completion powershell | Out-String | Invoke-Expression\n\n", program, program)
+	fmt.Fprintf(w, "Register-ArgumentCompleter -Native -CommandName %s, %s -ScriptBlock {\n", powerShellQuote(program), powerShellQuote(program+".exe"))
+	fmt.Fprintf(w, "    param($wordToComplete, $commandAst, $cursorPosition)\n\n    $commands = [ordered]@{\n")
+	for _, name := range sortedKeys(commands) {
+		fmt.Fprintf(w, "        %s = %s\n", powerShellQuote(name), powerShellQuote(commands[name]))
+	}
+	fmt.Fprintf(w, "    }\n    $flags = @(\n")
+	for i, flag := range flags {
+		separator := ","
+		if i == len(flags)-1 {
+			separator = ""
+		}
+		fmt.Fprintf(w, "        @{ Names = %s; Kind = %s; Choices = %s; Description = %s }%s\n", quoteAll(flag.Names),
+			powerShellQuote(flag.Kind), quoteAll(flag.Choices), powerShellQuote(flag.Description), separator)
+	}
+	var exts []string
+	for _, ext := range completionInputExts {
+		exts = append(exts, "."+ext)
+	}
+	fmt.Fprintf(w, "    )\n    $inputExtensions = %s\n    $fileArgs = %s\n\n", quoteAll(exts), quoteAll(completionFileArgs))
+	fmt.Fprint(w, `    # The words before the one completed; the first is the program
+    $words = @($commandAst.CommandElements | Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.Extent.Text })
+    if ($wordToComplete -ne '' -and $words.Count -gt 0 -and $words[-1] -eq $wordToComplete) {
+        $words = @($words | Select-Object -SkipLast 1)
+    }
+    $previous = if ($words.Count -gt 1) { $words[-1] } else { '' }
+    $flag = $flags | Where-Object { $_.Names -contains $previous } | Select-Object -First 1
+    $files = [System.Management.Automation.CompletionCompleters]::CompleteFilename($wordToComplete)
+
+    if ($flag -and $flag.Kind) {
+        switch ($flag.Kind) {
+            'input' {
+                $files | Where-Object {
+                    $_.ResultType -eq 'ProviderContainer' -or
+                    $inputExtensions -contains [System.IO.Path]::GetExtension($_.CompletionText.Trim("'")).ToLower()
+                }
+            }
+            'file' { $files }
+            'dir' { $files | Where-Object { $_.ResultType -eq 'ProviderContainer' } }
+            'choices' {
+                $flag.Choices | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+                    [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+                }
+            }
+        }
+        return
+    }
+    if ($wordToComplete -like '-*') {
+        foreach ($f in $flags) {
+            foreach ($name in $f.Names) {
+                if ($name -like "$wordToComplete*") {
+                    [System.Management.Automation.CompletionResult]::new($name, $name, 'ParameterName', $f.Description)
+                }
+            }
+        }
+    } elseif ($words.Count -le 1) {
+        foreach ($name in $commands.Keys) {
+            if ($name -like "$wordToComplete*") {
+                [System.Management.Automation.CompletionResult]::new($name, $name, 'Command', $commands[$name])
+            }
+        }
+    } elseif ($fileArgs -contains $words[1]) {
+        $files
+    }
+}
+`)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// missingDateValues are placeholders NBIA and DICOM files use for unknown dates
+var missingDateValues = map[string]bool{"": true, "null": true, "none": true, "n/a": true, "unknown": true}
+
+// metadataDateLayouts are the date and date-time formats found in NBIA responses,
+// manifests and DICOM files, with whether they carry a time of day. MM-DD-YYYY is
+// that of NBIA's metadata.csv; day-first dates are not recognized because they
+// cannot be told apart from it.
+var metadataDateLayouts = []struct {
+	layout  string
+	hasTime bool
+}{
+	{"2006-01-02 15:04:05.0", true},
+	{"2006-01-02 15:04:05", true},
+	{"2006-01-02T15:04:05Z07:00", true},
+	{"2006-01-02T15:04:05", true},
+	{"2006-01-02", false},
+	{"01-02-2006", false},
+	{"01/02/2006", false},
+	{"2006.01.02", false}, // DA of ACR-NEMA 2.0 files
+}
+
+// normalizeDate returns a date as ISO-8601 YYYY-MM-DD, or with a time of day as
+// YYYY-MM-DDThh:mm:ss[.f][±hh:mm]. DICOM DA (YYYYMMDD) and DT values and the
+// formats of metadataDateLayouts are recognized. Times are not converted between
+// time zones: DICOM dates and times are local to the acquiring site, and only keep
+// an offset when the value has one. Missing values become empty; values that are
+// not recognized are returned unchanged.
+func normalizeDate(value string) string {
+	value = strings.TrimSpace(value)
+	if missingDateValues[strings.ToLower(value)] {
+		return ""
+	}
+	if len(value) >= 8 && isDigits(value[:8]) {
+		return normalizeDICOMDateTime(value)
+	}
+	for _, format := range metadataDateLayouts {
+		t, err := time.Parse(format.layout, value)
+		if err != nil {
+			continue
+		}
+		zoned := strings.HasSuffix(format.layout, "Z07:00")
+		midnight := t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+		switch {
+		case !format.hasTime || (midnight && !zoned):
+			// NBIA reports dates as midnight of the day
+			return t.Format("2006-01-02")
+		case zoned:
+			return t.Format("2006-01-02T15:04:05.999999Z07:00")
+		}
+		return t.Format("2006-01-02T15:04:05.999999")
+	}
+	return value
+}
+
+// normalizeDICOMDateTime converts a DICOM DA or DT value, YYYYMMDD[hh[mm[ss[.f]]]][&ZZXX]
+func normalizeDICOMDateTime(value string) string {
+	datePart, offset := value, ""
+	if i := strings.IndexAny(value, "+-"); i >= 8 {
+		datePart, offset = value[:i], value[i:]
+	}
+	date, err := time.Parse("20060102", datePart[:8])
+	if err != nil {
+		return value
+	}
+	normalized := date.Format("2006-01-02")
+	if len(datePart) > 8 {
+		clock := normalizeTime(datePart[8:])
+		if clock == datePart[8:] {
+			return value
+		}
+		normalized += "T" + clock
+	}
+	// ISO-8601 has no offsets for dates without a time of day
+	if offset != "" && len(datePart) > 8 {
+		if len(offset) != 5 || !isDigits(offset[1:]) {
+			return value
+		}
+		normalized += offset[:3] + ":" + offset[3:]
+	}
+	return normalized
+}
+
+// normalizeTime converts a DICOM TM value, hh[mm[ss[.f]]] or the older hh:mm:ss,
+// to ISO-8601 hh:mm[:ss[.f]]. Missing values become empty; values that are not
+// recognized are returned unchanged.
+func normalizeTime(value string) string {
+	value = strings.TrimSpace(value)
+	if missingDateValues[strings.ToLower(value)] {
+		return ""
+	}
+	clock, fraction, _ := strings.Cut(strings.ReplaceAll(value, ":", ""), ".")
+	if !isDigits(clock) || !isDigits(fraction) || len(clock)%2 != 0 || len(clock) < 2 || len(clock) > 6 {
+		return value
+	}
+	var parts []string
+	limits := []int{23, 59, 60} // 60 is a leap second
+	for i := 0; i < len(clock); i += 2 {
+		n := int(clock[i]-'0')*10 + int(clock[i+1]-'0')
+		if n > limits[i/2] {
+			return value
+		}
+		parts = append(parts, clock[i:i+2])
+	}
+	if len(parts) == 1 {
+		parts = append(parts, "00")
+	}
+	normalized := strings.Join(parts, ":")
+	if fraction != "" && len(parts) == 3 {
+		normalized += "." + fraction
+	}
+	return normalized
+}
+
+// isDigits reports whether s consists of ASCII digits only
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeDescription trims a description and collapses runs of white space,
+// including the line breaks some sites put in them
+func normalizeDescription(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// normalizeDates brings the dates and descriptions of a series to their normal
+// form, before it is cached or written to a CSV
+func (info *FileInfo) normalizeDates() {
+	info.StudyDate = normalizeDate(info.StudyDate)
+	info.StudyDescription = normalizeDescription(info.StudyDescription)
+	info.SeriesDescription = normalizeDescription(info.SeriesDescription)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// dedupObjectsDir holds the files of --dedup once per content, below the output
+// directory as .objects/<first 2 hex digits>/<SHA-256>
+const dedupObjectsDir = ".objects"
+
+// Deduplicator stores the files of extracted series once per SHA-256 and links them
+// back into the series directories, for collections whose series share
+// byte-identical instances, e.g. derived data next to its source series
+type Deduplicator struct {
+	Mode   string // hardlink or symlink
+	output string
+
+	files atomic.Int64 // files linked to an object stored before
+	saved atomic.Int64 // bytes of those files
+}
+
+// NewDeduplicator returns the deduplicator of an output directory
+func NewDeduplicator(output, mode string) *Deduplicator {
+	return &Deduplicator{Mode: mode, output: output}
+}
+
+// objectPath returns the object of a content hash
+func (d *Deduplicator) objectPath(sum string) string {
+	return filepath.Join(d.output, dedupObjectsDir, sum[:2], sum)
+}
+
+// link creates the link of mode at path to object
+func (d *Deduplicator) link(object, path string) error {
+	if d.Mode == "symlink" {
+		// Relative, so the output directory can be moved as a whole
+		target, err := filepath.Rel(filepath.Dir(path), object)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, path)
+	}
+	return os.Link(object, path)
+}
+
+// store makes a new object of the file at path. A hard link keeps the file in
+// place; with symlinks the file moves into the store and is linked back.
+func (d *Deduplicator) store(path, object string) error {
+	if err := os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+		return err
+	}
+	if d.Mode == "hardlink" {
+		return os.Link(path, object)
+	}
+	if _, err := os.Lstat(object); err == nil {
+		return fs.ErrExist
+	}
+	if err := renameFile(path, object); err != nil {
+		return err
+	}
+	if err := d.link(object, path); err != nil {
+		// Put the file back rather than lose it
+		if restoreErr := renameFile(object, path); restoreErr != nil {
+			return fmt.Errorf("%v; %s was moved to %s: %v", err, path, object, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// File replaces the file at path by a link to the object of its content, storing
+// the content first if it is new. Links of earlier runs are left alone.
+func (d *Deduplicator) File(path string) error {
+	stat, err := os.Lstat(path)
+	if err != nil || !stat.Mode().IsRegular() {
+		return err
+	}
+	sum, err := sha256File(path)
+	if err != nil {
+		return err
+	}
+	object := d.objectPath(sum)
+
+	existing, err := os.Stat(object)
+	if os.IsNotExist(err) {
+		err = d.store(path, object)
+		if !os.IsExist(err) {
+			return err
+		}
+		// Another worker stored the same content meanwhile
+		existing, err = os.Stat(object)
+	}
+	if err != nil {
+		return err
+	}
+	if os.SameFile(stat, existing) {
+		return nil
+	}
+	if existing.Size() != stat.Size() {
+		return fmt.Errorf("object %s has %d bytes, not the %d of %s", object, existing.Size(), stat.Size(), path)
+	}
+
+	// The link replaces the file in one rename, so the series is never incomplete
+	tempPath := path + ".dedup.tmp"
+	os.Remove(tempPath)
+	if err := d.link(object, tempPath); err != nil {
+		return err
+	}
+	if err := renameFile(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	d.files.Add(1)
+	d.saved.Add(stat.Size())
+	return nil
+}
+
+// Series deduplicates the files of an extracted series directory
+func (d *Deduplicator) Series(info *FileInfo) error {
+	return filepath.WalkDir(info.seriesPath(d.output), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		return d.File(path)
+	})
+}
+
+// runDedup links the files of every complete series of the run to the content store
+func runDedup(files []*FileInfo, options *Options) {
+	series := extractedSeries(files, options.Output)
+	if len(series) == 0 {
+		return
+	}
+
+	fmt.Printf("\nDeduplicating the files of %d series with %ss...\n", len(series), options.Dedup)
+	d := NewDeduplicator(options.Output, options.Dedup)
+	var failed int32
+	forEachSeries(series, options.Concurrent, func(info *FileInfo) {
+		if err := d.Series(info); err != nil {
+			logger.Warnf("Failed to deduplicate %s: %v", info.SeriesUID, err)
+			atomic.AddInt32(&failed, 1)
+			events.Record(Event{Action: "dedup", SeriesUID: info.SeriesUID, Error: err.Error(), Code: failureCode(err)})
+			return
+		}
+		events.Record(Event{Action: "dedup", SeriesUID: info.SeriesUID, Path: info.seriesPath(options.Output)})
+	})
+	fmt.Printf("%d duplicate files linked to %s, %s saved", d.files.Load(),
+		filepath.Join(options.Output, dedupObjectsDir), formatBytes(d.saved.Load()))
+	if failed > 0 {
+		fmt.Printf(", %d series failed (their remaining files are kept as they are)", failed)
+	}
+	fmt.Println()
+}
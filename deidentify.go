@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// Actions a de-identification profile applies to an attribute, after the action
+// codes of DICOM PS3.15 Annex E
+const (
+	deidRemove  = "remove"  // X: remove the attribute
+	deidEmpty   = "empty"   // Z: keep the attribute with an empty value
+	deidKeep    = "keep"    // K: keep unchanged
+	deidUID     = "uid"     // U: replace with a consistent new UID
+	deidShift   = "shift"   // shift a date by the profile's date_shift_days
+	deidReplace = "replace" // D: replace with a dummy value, written as replace:VALUE
+)
+
+// deidMethod is written to (0012,0063) De-identification Method (LO, max. 64 chars)
+const deidMethod = "PS3.15 Basic Profile (NBIA Data Retriever)"
+
+// deidRecordDir holds one record per de-identified series below metadata/
+const deidRecordDir = "deidentified"
+
+// basicProfile is a subset of the DICOM PS3.15 Basic Application Level
+// Confidentiality Profile covering identifying patient, study, institution and
+// device attributes, dates and times, and instance UIDs
+var basicProfile = map[tag.Tag]string{
+	// Patient
+	{Group: 0x0010, Element: 0x0010}: deidEmpty,  // Patient's Name
+	{Group: 0x0010, Element: 0x0020}: deidEmpty,  // Patient ID
+	{Group: 0x0010, Element: 0x0030}: deidEmpty,  // Patient's Birth Date
+	{Group: 0x0010, Element: 0x0032}: deidRemove, // Patient's Birth Time
+	{Group: 0x0010, Element: 0x0040}: deidEmpty,  // Patient's Sex
+	{Group: 0x0010, Element: 0x1000}: deidRemove, // Other Patient IDs
+	{Group: 0x0010, Element: 0x1001}: deidRemove, // Other Patient Names
+	{Group: 0x0010, Element: 0x1002}: deidRemove, // Other Patient IDs Sequence
+	{Group: 0x0010, Element: 0x1005}: deidRemove, // Patient's Birth Name
+	{Group: 0x0010, Element: 0x1010}: deidRemove, // Patient's Age
+	{Group: 0x0010, Element: 0x1020}: deidRemove, // Patient's Size
+	{Group: 0x0010, Element: 0x1030}: deidRemove, // Patient's Weight
+	{Group: 0x0010, Element: 0x1040}: deidRemove, // Patient's Address
+	{Group: 0x0010, Element: 0x1060}: deidRemove, // Patient's Mother's Birth Name
+	{Group: 0x0010, Element: 0x1090}: deidRemove, // Medical Record Locator
+	{Group: 0x0010, Element: 0x2154}: deidRemove, // Patient's Telephone Numbers
+	{Group: 0x0010, Element: 0x2160}: deidRemove, // Ethnic Group
+	{Group: 0x0010, Element: 0x2180}: deidRemove, // Occupation
+	{Group: 0x0010, Element: 0x21B0}: deidRemove, // Additional Patient History
+	{Group: 0x0010, Element: 0x4000}: deidRemove, // Patient Comments
+	{Group: 0x0038, Element: 0x0010}: deidRemove, // Admission ID
+	{Group: 0x0038, Element: 0x0300}: deidRemove, // Current Patient Location
+	{Group: 0x0038, Element: 0x0400}: deidRemove, // Patient's Institution Residence
+
+	// Study and procedure
+	{Group: 0x0008, Element: 0x0050}: deidEmpty,  // Accession Number
+	{Group: 0x0020, Element: 0x0010}: deidEmpty,  // Study ID
+	{Group: 0x0008, Element: 0x0090}: deidEmpty,  // Referring Physician's Name
+	{Group: 0x0008, Element: 0x0092}: deidRemove, // Referring Physician's Address
+	{Group: 0x0008, Element: 0x0094}: deidRemove, // Referring Physician's Telephone Numbers
+	{Group: 0x0008, Element: 0x1030}: deidRemove, // Study Description
+	{Group: 0x0008, Element: 0x103E}: deidRemove, // Series Description
+	{Group: 0x0008, Element: 0x1048}: deidRemove, // Physician(s) of Record
+	{Group: 0x0008, Element: 0x1050}: deidRemove, // Performing Physician's Name
+	{Group: 0x0008, Element: 0x1060}: deidRemove, // Name of Physician(s) Reading Study
+	{Group: 0x0008, Element: 0x1070}: deidRemove, // Operators' Name
+	{Group: 0x0008, Element: 0x4000}: deidRemove, // Identifying Comments
+	{Group: 0x0018, Element: 0x1030}: deidRemove, // Protocol Name
+	{Group: 0x0020, Element: 0x4000}: deidRemove, // Image Comments
+	{Group: 0x0032, Element: 0x1032}: deidRemove, // Requesting Physician
+	{Group: 0x0032, Element: 0x1060}: deidRemove, // Requested Procedure Description
+	{Group: 0x0040, Element: 0x0244}: deidRemove, // Performed Procedure Step Start Date
+	{Group: 0x0040, Element: 0x0245}: deidRemove, // Performed Procedure Step Start Time
+	{Group: 0x0040, Element: 0x0253}: deidRemove, // Performed Procedure Step ID
+	{Group: 0x0040, Element: 0x0254}: deidRemove, // Performed Procedure Step Description
+
+	// Dates and times
+	{Group: 0x0008, Element: 0x0012}: deidRemove, // Instance Creation Date
+	{Group: 0x0008, Element: 0x0013}: deidRemove, // Instance Creation Time
+	{Group: 0x0008, Element: 0x0020}: deidEmpty,  // Study Date
+	{Group: 0x0008, Element: 0x0021}: deidRemove, // Series Date
+	{Group: 0x0008, Element: 0x0022}: deidRemove, // Acquisition Date
+	{Group: 0x0008, Element: 0x0023}: deidEmpty,  // Content Date
+	{Group: 0x0008, Element: 0x002A}: deidRemove, // Acquisition DateTime
+	{Group: 0x0008, Element: 0x0030}: deidEmpty,  // Study Time
+	{Group: 0x0008, Element: 0x0031}: deidRemove, // Series Time
+	{Group: 0x0008, Element: 0x0032}: deidRemove, // Acquisition Time
+	{Group: 0x0008, Element: 0x0033}: deidEmpty,  // Content Time
+
+	// Institution and device
+	{Group: 0x0008, Element: 0x0080}: deidRemove, // Institution Name
+	{Group: 0x0008, Element: 0x0081}: deidRemove, // Institution Address
+	{Group: 0x0008, Element: 0x1010}: deidRemove, // Station Name
+	{Group: 0x0008, Element: 0x1040}: deidRemove, // Institutional Department Name
+	{Group: 0x0018, Element: 0x1000}: deidRemove, // Device Serial Number
+	{Group: 0x0018, Element: 0x1002}: deidUID,    // Device UID
+
+	// UIDs
+	{Group: 0x0002, Element: 0x0003}: deidUID, // Media Storage SOP Instance UID
+	{Group: 0x0008, Element: 0x0014}: deidUID, // Instance Creator UID
+	{Group: 0x0008, Element: 0x0018}: deidUID, // SOP Instance UID
+	{Group: 0x0008, Element: 0x1155}: deidUID, // Referenced SOP Instance UID
+	{Group: 0x0008, Element: 0x3010}: deidUID, // Irradiation Event UID
+	{Group: 0x0020, Element: 0x000D}: deidUID, // Study Instance UID
+	{Group: 0x0020, Element: 0x000E}: deidUID, // Series Instance UID
+	{Group: 0x0020, Element: 0x0052}: deidUID, // Frame of Reference UID
+	{Group: 0x0020, Element: 0x0200}: deidUID, // Synchronization Frame of Reference UID
+	{Group: 0x0020, Element: 0x9161}: deidUID, // Concatenation UID
+	{Group: 0x0028, Element: 0x1199}: deidUID, // Palette Color Lookup Table UID
+	{Group: 0x0040, Element: 0xA124}: deidUID, // UID
+	{Group: 0x0088, Element: 0x0140}: deidUID, // Storage Media File-set UID
+	{Group: 0x3006, Element: 0x0024}: deidUID, // Referenced Frame of Reference UID
+}
+
+// deidProfileFile is the JSON form of a custom profile. Actions are keyed by DICOM
+// keyword (PatientName) or tag ((0010,0010) or 00100010) and override the basic profile.
+type deidProfileFile struct {
+	Actions         map[string]string `json:"actions"`
+	KeepPrivateTags bool              `json:"keep_private_tags"`
+	DateShiftDays   int               `json:"date_shift_days"`
+	UIDSalt         string            `json:"uid_salt"`
+}
+
+// DeidProfile is a resolved de-identification profile
+type DeidProfile struct {
+	Name            string
+	Actions         map[tag.Tag]string
+	KeepPrivateTags bool
+	DateShiftDays   int
+	uidSalt         string
+}
+
+// deidRecord is written for every de-identified series; its size lets the local
+// state check accept a directory that no longer matches the metadata size
+type deidRecord struct {
+	Profile string    `json:"profile"`
+	Files   int       `json:"files"`
+	Size    int64     `json:"size"`
+	Time    time.Time `json:"time"`
+}
+
+// LoadDeidProfile returns the basic profile, or the basic profile overridden by the
+// JSON profile at path. Without a uid_salt, a salt kept in the metadata directory is
+// used so that remapped UIDs stay consistent across runs without being reversible.
+func LoadDeidProfile(path, output string) (*DeidProfile, error) {
+	profile := &DeidProfile{Name: "basic", Actions: make(map[tag.Tag]string, len(basicProfile))}
+	for t, action := range basicProfile {
+		profile.Actions[t] = action
+	}
+
+	if path != "basic" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read profile: %v", err)
+		}
+		var custom deidProfileFile
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return nil, fmt.Errorf("failed to parse profile %s: %v", path, err)
+		}
+		for key, action := range custom.Actions {
+			t, err := parseTagKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %v", path, err)
+			}
+			if err := validateDeidAction(action); err != nil {
+				return nil, fmt.Errorf("profile %s: %s: %v", path, key, err)
+			}
+			profile.Actions[t] = action
+		}
+		profile.Name = filepath.Base(path)
+		profile.KeepPrivateTags = custom.KeepPrivateTags
+		profile.DateShiftDays = custom.DateShiftDays
+		profile.uidSalt = custom.UIDSalt
+	}
+
+	if profile.uidSalt == "" {
+		salt, err := loadDeidSalt(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load UID salt: %v", err)
+		}
+		profile.uidSalt = salt
+	}
+	return profile, nil
+}
+
+// parseTagKey accepts a DICOM keyword, (gggg,eeee) or ggggeeee
+func parseTagKey(key string) (tag.Tag, error) {
+	hexKey := strings.NewReplacer("(", "", ")", "", ",", "").Replace(key)
+	if len(hexKey) == 8 {
+		if n, err := strconv.ParseUint(hexKey, 16, 32); err == nil {
+			return tag.Tag{Group: uint16(n >> 16), Element: uint16(n)}, nil
+		}
+	}
+	info, err := tag.FindByKeyword(key)
+	if err != nil {
+		return tag.Tag{}, fmt.Errorf("unknown attribute %q", key)
+	}
+	return info.Tag, nil
+}
+
+func validateDeidAction(action string) error {
+	switch action {
+	case deidRemove, deidEmpty, deidKeep, deidUID, deidShift:
+		return nil
+	}
+	if strings.HasPrefix(action, deidReplace+":") {
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", action)
+}
+
+// loadDeidSalt returns the salt stored in metadata/.deid-salt, creating it if needed
+func loadDeidSalt(output string) (string, error) {
+	path := filepath.Join(output, "metadata", ".deid-salt")
+	if data, err := os.ReadFile(path); err == nil && len(bytes.TrimSpace(data)) > 0 {
+		return string(bytes.TrimSpace(data)), nil
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	salt := hex.EncodeToString(buf)
+	if err := os.WriteFile(path, []byte(salt+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return salt, nil
+}
+
+// remapUID derives a new UID under the 2.25 root from a salted hash of the original,
+// so references between instances and series stay intact
+func (p *DeidProfile) remapUID(original string) string {
+	sum := sha256.Sum256([]byte(p.uidSalt + "\x00" + strings.TrimRight(original, "\x00 ")))
+	return "2.25." + new(big.Int).SetBytes(sum[:16]).String()
+}
+
+// shiftDate moves a DA (YYYYMMDD) or the date part of a DT value by the profile offset
+func (p *DeidProfile) shiftDate(value string) string {
+	if len(value) < 8 {
+		return value
+	}
+	date, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return value
+	}
+	return date.AddDate(0, 0, p.DateShiftDays).Format("20060102") + value[8:]
+}
+
+// apply de-identifies a list of elements in place, recursing into sequences
+func (p *DeidProfile) apply(elements []*dicom.Element) ([]*dicom.Element, error) {
+	kept := elements[:0]
+	for _, elem := range elements {
+		if tag.IsPrivate(elem.Tag.Group) && !p.KeepPrivateTags {
+			continue
+		}
+
+		action, ok := p.Actions[elem.Tag]
+		if !ok {
+			action = deidKeep
+		}
+		if action == deidRemove {
+			continue
+		}
+
+		if elem.Value != nil && elem.Value.ValueType() == dicom.Sequences {
+			items := [][]*dicom.Element{}
+			if action != deidEmpty {
+				for _, item := range elem.Value.GetValue().([]*dicom.SequenceItemValue) {
+					itemElements, err := p.apply(item.GetValue().([]*dicom.Element))
+					if err != nil {
+						return nil, err
+					}
+					items = append(items, itemElements)
+				}
+			}
+			value, err := dicom.NewValue(items)
+			if err != nil {
+				return nil, err
+			}
+			elem.Value = value
+			kept = append(kept, elem)
+			continue
+		}
+
+		if action != deidKeep {
+			if elem.Value == nil || elem.Value.ValueType() != dicom.Strings {
+				// Only textual values can be replaced; drop anything else that must change
+				continue
+			}
+			values := elem.Value.GetValue().([]string)
+			var replaced []string
+			switch {
+			case action == deidEmpty:
+				replaced = []string{}
+			case action == deidUID:
+				for _, v := range values {
+					replaced = append(replaced, p.remapUID(v))
+				}
+			case action == deidShift:
+				for _, v := range values {
+					replaced = append(replaced, p.shiftDate(v))
+				}
+			default:
+				replaced = []string{strings.TrimPrefix(action, deidReplace+":")}
+			}
+			value, err := dicom.NewValue(replaced)
+			if err != nil {
+				return nil, err
+			}
+			elem.Value = value
+		}
+		kept = append(kept, elem)
+	}
+	return kept, nil
+}
+
+// isDicomFile checks for the DICM marker after the 128 byte preamble
+func isDicomFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 132)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return string(header[128:]) == "DICM"
+}
+
+// DeidentifyFile rewrites a DICOM file in place, atomically
+func (p *DeidProfile) DeidentifyFile(path string) error {
+	dataset, err := dicom.ParseFile(path, nil, dicom.SkipProcessingPixelDataValue())
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	if dataset.Elements, err = p.apply(dataset.Elements); err != nil {
+		return fmt.Errorf("failed to de-identify %s: %v", path, err)
+	}
+	for t, value := range map[tag.Tag]string{tag.PatientIdentityRemoved: "YES", tag.DeidentificationMethod: deidMethod} {
+		elem, err := dicom.NewElement(t, []string{value})
+		if err != nil {
+			return err
+		}
+		if existing, err := dataset.FindElementByTag(t); err == nil {
+			existing.Value = elem.Value
+		} else {
+			dataset.Elements = append(dataset.Elements, elem)
+		}
+	}
+	sort.SliceStable(dataset.Elements, func(i, j int) bool {
+		return dataset.Elements[i].Tag.Compare(dataset.Elements[j].Tag) < 0
+	})
+
+	tempPath := path + ".deid.tmp"
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	if err := dicom.Write(f, dataset, dicom.SkipVRVerification()); err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return renameFile(tempPath, path)
+}
+
+// deidRecordPath returns where the de-identification record of a series is kept
+func deidRecordPath(output, seriesUID string) string {
+	return filepath.Join(output, "metadata", deidRecordDir, seriesUID+".json")
+}
+
+// deidentifiedSize returns the directory size recorded after de-identification
+func deidentifiedSize(output, seriesUID string) (int64, bool) {
+	data, err := os.ReadFile(deidRecordPath(output, seriesUID))
+	if err != nil {
+		return 0, false
+	}
+	var record deidRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return 0, false
+	}
+	return record.Size, true
+}
+
+// DeidentifySeries rewrites every DICOM file of a series directory and records the
+// result. Other files (license texts, checksum lists) are left untouched.
+func (p *DeidProfile) DeidentifySeries(output string, info *FileInfo) (int, error) {
+	dir := info.seriesPath(output)
+	count := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isDicomFile(path) {
+			return err
+		}
+		count++
+		return p.DeidentifyFile(path)
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if err := checksums.HashDir(dir); err != nil {
+		logger.Warnf("Failed to update checksums for %s: %v", dir, err)
+	}
+
+	size, err := getDirectorySize(dir)
+	if err != nil {
+		return count, err
+	}
+	record, _ := json.MarshalIndent(deidRecord{Profile: p.Name, Files: count, Size: size, Time: time.Now().UTC()}, "", "  ")
+	recordPath := deidRecordPath(output, info.SeriesUID)
+	if err := os.MkdirAll(filepath.Dir(recordPath), 0755); err != nil {
+		return count, err
+	}
+	return count, os.WriteFile(recordPath, record, 0644)
+}
+
+// runDeidentification de-identifies every complete TCIA series of the run that has
+// not been processed before, with the given number of workers, and returns the
+// number of series that failed and may still hold identifiers
+func runDeidentification(profile *DeidProfile, files []*FileInfo, options *Options) int {
+	var pending []*FileInfo
+	for _, info := range extractedSeries(files, options.Output) {
+		if _, done := deidentifiedSize(options.Output, info.SeriesUID); !done {
+			pending = append(pending, info)
+		}
+	}
+	if len(pending) == 0 {
+		return 0
+	}
+
+	fmt.Printf("\nDe-identifying %d series with the %s profile...\n", len(pending), profile.Name)
+	var done, failed int32
+	forEachSeries(pending, options.Concurrent, func(info *FileInfo) {
+		count, err := profile.DeidentifySeries(options.Output, info)
+		if err != nil {
+			logger.Errorf("De-identification of %s failed: %v", info.SeriesUID, err)
+			atomic.AddInt32(&failed, 1)
+			events.Record(Event{Action: "deidentify", SeriesUID: info.SeriesUID, Error: err.Error()})
+			return
+		}
+		atomic.AddInt32(&done, 1)
+		events.Record(Event{Action: "deidentify", SeriesUID: info.SeriesUID,
+			Detail: fmt.Sprintf("%d files, profile %s", count, profile.Name)})
+	})
+
+	fmt.Printf("De-identified: %d series", done)
+	if failed > 0 {
+		fmt.Printf(", failed: %d", failed)
+	}
+	fmt.Println()
+	return int(failed)
+}
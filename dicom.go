@@ -2,49 +2,136 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/suyashkumar/dicom"
 	"github.com/suyashkumar/dicom/pkg/tag"
 )
 
 type DicomFile struct {
-	Path             string
-	SeriesUID        string
+	Path              string
+	SeriesUID         string
+	SOPInstanceUID    string
 	AcquisitionNumber int
-	InstanceNumber   int
+	InstanceNumber    int
+	// HasInstanceNumber is false for instances without a usable InstanceNumber, as
+	// some secondary captures and NM or US multi-frame objects are
+	HasInstanceNumber bool
+	NumberOfFrames    int
 }
 
+// ProcessDicomFile reads the attributes of a DICOM file that series are organized
+// and ordered by, without its pixel data, so multi-frame objects are read as
+// quickly as single frames. Only the SeriesInstanceUID is required.
 func ProcessDicomFile(filePath string) (*DicomFile, error) {
-	dataset, err := dicom.ParseFile(filePath, nil)
+	dataset, err := dicom.ParseFile(filePath, nil, dicom.SkipPixelData())
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse DICOM file %s: %v", filePath, err)
 	}
 
 	seriesUID, err := getElementValue(dataset, tag.SeriesInstanceUID)
-	if err != nil {
-		return nil, err
+	if err != nil || seriesUID == "" {
+		return nil, fmt.Errorf("no SeriesInstanceUID in %s", filePath)
 	}
-	acquisitionNumberStr, err := getElementValue(dataset, tag.AcquisitionNumber)
-	if err != nil {
-		// AcquisitionNumber is optional, so we can default to 0
-		acquisitionNumberStr = "0"
+	file := &DicomFile{Path: filePath, SeriesUID: seriesUID, NumberOfFrames: 1}
+	file.SOPInstanceUID, _ = getElementValue(dataset, tag.SOPInstanceUID)
+	// AcquisitionNumber is optional, so we can default to 0
+	file.AcquisitionNumber, _ = getIntValue(dataset, tag.AcquisitionNumber)
+	file.InstanceNumber, file.HasInstanceNumber = getIntValue(dataset, tag.InstanceNumber)
+	if frames, ok := getIntValue(dataset, tag.NumberOfFrames); ok && frames > 1 {
+		file.NumberOfFrames = frames
 	}
-	instanceNumberStr, err := getElementValue(dataset, tag.InstanceNumber)
+	return file, nil
+}
+
+// getIntValue returns an integer string (IS) element, and false when it is missing,
+// empty or not a number
+func getIntValue(dataset dicom.Dataset, tag tag.Tag) (int, bool) {
+	value, err := getElementValue(dataset, tag)
 	if err != nil {
-		return nil, err
+		return 0, false
 	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	return n, err == nil
+}
 
-	acquisitionNumber, _ := strconv.Atoi(acquisitionNumberStr)
-	instanceNumber, _ := strconv.Atoi(instanceNumberStr)
+// sortInstances orders the instances of a series by AcquisitionNumber and
+// InstanceNumber. Instances without an InstanceNumber follow, ordered by their
+// SOPInstanceUID, which most systems generate in ascending order.
+func sortInstances(instances []*DicomFile) {
+	sort.SliceStable(instances, func(i, j int) bool {
+		a, b := instances[i], instances[j]
+		if a.HasInstanceNumber != b.HasInstanceNumber {
+			return a.HasInstanceNumber
+		}
+		if a.HasInstanceNumber {
+			if a.AcquisitionNumber != b.AcquisitionNumber {
+				return a.AcquisitionNumber < b.AcquisitionNumber
+			}
+			if a.InstanceNumber != b.InstanceNumber {
+				return a.InstanceNumber < b.InstanceNumber
+			}
+		}
+		if c := compareUIDs(a.SOPInstanceUID, b.SOPInstanceUID); c != 0 {
+			return c < 0
+		}
+		return a.Path < b.Path
+	})
+}
 
-	return &DicomFile{
-		Path:             filePath,
-		SeriesUID:        seriesUID,
-		AcquisitionNumber: acquisitionNumber,
-		InstanceNumber:   instanceNumber,
-	}, nil
+// compareUIDs compares DICOM UIDs component by component as numbers, so that
+// 1.2.10 follows 1.2.9
+func compareUIDs(a, b string) int {
+	partsA, partsB := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		x, y := partsA[i], partsB[i]
+		if len(x) != len(y) {
+			return len(x) - len(y)
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return len(partsA) - len(partsB)
+}
+
+// dirSeriesUID returns the SeriesInstanceUID of the first DICOM file of a directory
+// that has one, skipping other files
+func dirSeriesUID(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var lastErr error
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		file, err := ProcessDicomFile(filepath.Join(dir, entry.Name()))
+		if err == nil {
+			return file.SeriesUID, nil
+		}
+		logger.Debugf("No SeriesInstanceUID from %s: %v", entry.Name(), err)
+		lastErr = err
+	}
+	if lastErr == nil {
+		return "", fmt.Errorf("no files in %s", dir)
+	}
+	return "", fmt.Errorf("no DICOM file with a SeriesInstanceUID in %s: %w", dir, lastErr)
+}
+
+// sopInstanceUID reads the SOPInstanceUID of a DICOM file, without its pixel data
+func sopInstanceUID(filePath string) (string, error) {
+	dataset, err := dicom.ParseFile(filePath, nil, dicom.SkipPixelData())
+	if err != nil {
+		return "", fmt.Errorf("failed to parse DICOM file %s: %v", filePath, err)
+	}
+	return getElementValue(dataset, tag.SOPInstanceUID)
 }
 
 func getElementValue(dataset dicom.Dataset, tag tag.Tag) (string, error) {
@@ -55,3 +142,42 @@ func getElementValue(dataset dicom.Dataset, tag tag.Tag) (string, error) {
 	// Trim leading/trailing brackets and spaces
 	return strings.Trim(element.Value.String(), "[] "), nil
 }
+
+// extractedSeries returns the TCIA series among files that are complete and
+// extracted in the output directory, for post-processing stages
+func extractedSeries(files []*FileInfo, output string) []*FileInfo {
+	var series []*FileInfo
+	for _, info := range files {
+		if info.DownloadURL != "" || info.DRSURI != "" || info.S5cmdManifestPath != "" || info.SubjectID == "" {
+			continue
+		}
+		if stat, err := os.Stat(info.seriesPath(output)); err != nil || !stat.IsDir() {
+			continue
+		}
+		if state, _ := info.LocalState(output, false); state == StateComplete {
+			series = append(series, info)
+		}
+	}
+	return series
+}
+
+// forEachSeries runs fn for every series with the given number of workers
+func forEachSeries(series []*FileInfo, workers int, fn func(info *FileInfo)) {
+	queue := make(chan *FileInfo, len(series))
+	for _, info := range series {
+		queue <- info
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, workers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for info := range queue {
+				fn(info)
+			}
+		}()
+	}
+	wg.Wait()
+}
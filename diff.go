@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// SeriesDiff is a series that differs between the reference and the output directory
+type SeriesDiff struct {
+	SeriesUID string `json:"series_uid"`
+	Status    string `json:"status"` // missing, mismatch or extra
+	Reason    string `json:"reason"`
+}
+
+// DiffResult is the result of the diff command, printed as JSON with --json
+type DiffResult struct {
+	Matching    int          `json:"matching"`
+	Differences []SeriesDiff `json:"differences"`
+}
+
+// diffReference returns the series the output directory is compared against: those
+// of --input, or of the NBIA series listing for the browse filters
+func diffReference(httpClient *http.Client, authToken *Token, options *Options) ([]*FileInfo, error) {
+	if options.Input != "" {
+		// Nothing is transferred: s5cmd manifests are read without preparing their copies
+		options.WhatIf = true
+		files, _, err := decodeInputFile(options.Input, httpClient, authToken, options, nil)
+		return files, err
+	}
+	if options.Collection == "" && options.PatientID == "" {
+		return nil, fmt.Errorf("diff requires --collection and/or --patient, or --input")
+	}
+	var series []*SeriesSummary
+	if err := queryNBIA(httpClient, authToken, endpoints.Series, browseQuery(options), &series); err != nil {
+		return nil, fmt.Errorf("failed to list series: %w", err)
+	}
+	return listedFiles(series), nil
+}
+
+// listedFiles turns an NBIA series listing into items carrying what the listing
+// knows of each series: enough to locate and check it on disk
+func listedFiles(series []*SeriesSummary) []*FileInfo {
+	files := make([]*FileInfo, 0, len(series))
+	for _, s := range series {
+		info := &FileInfo{
+			SeriesUID:  s.SeriesUID,
+			StudyUID:   s.StudyUID,
+			SubjectID:  s.PatientID,
+			Collection: s.Collection,
+			Modality:   s.Modality,
+		}
+		if s.FileSize > 0 {
+			info.FileSize = strconv.FormatInt(int64(s.FileSize), 10)
+		}
+		if s.ImageCount > 0 {
+			info.NumberOfImages = strconv.Itoa(s.ImageCount)
+		}
+		files = append(files, info)
+	}
+	return files
+}
+
+// inDiffScope reports whether a local series not in the reference belongs to the
+// collection, patient or study the reference was listed for
+func inDiffScope(output, seriesUID, location string, options *Options) bool {
+	if options.Input != "" {
+		return true
+	}
+	subject, study := filepath.Split(location)
+	if options.PatientID != "" && filepath.Clean(subject) != options.PatientID {
+		return false
+	}
+	if options.StudyUID != "" && study != options.StudyUID {
+		return false
+	}
+	if options.Collection != "" {
+		// Series without cached metadata cannot be attributed and are reported
+		if info, err := loadMetadataFromCache(getMetadataCachePath(output, seriesUID)); err == nil && info.Collection != "" {
+			return info.Collection == options.Collection
+		}
+	}
+	return true
+}
+
+// diffSeries compares the reference series with the output directory: series
+// missing locally, present with the wrong size or kind, and local series the
+// reference does not list
+func diffSeries(files []*FileInfo, options *Options) (*DiffResult, error) {
+	result := &DiffResult{}
+	listed := make(map[string]bool, len(files))
+	for _, info := range files {
+		listed[info.SeriesUID] = true
+		isTCIA := info.DownloadURL == "" && info.DRSURI == "" && info.S5cmdManifestPath == ""
+		if isTCIA && info.SubjectID == "" && info.StudyUID == "" {
+			result.Differences = append(result.Differences, SeriesDiff{info.SeriesUID, "missing", "no metadata, local copy cannot be located"})
+			continue
+		}
+		state, reason := info.LocalState(options.Output, options.NoDecompress)
+		switch state {
+		case StateMissing:
+			result.Differences = append(result.Differences, SeriesDiff{info.SeriesUID, "missing", reason})
+		case StateInvalid:
+			result.Differences = append(result.Differences, SeriesDiff{info.SeriesUID, "mismatch", reason})
+		default:
+			result.Matching++
+		}
+	}
+
+	locations, err := discoverSeries(options.Output)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to scan %s: %v", options.Output, err)
+	}
+	for _, seriesUID := range sortedKeys(locations) {
+		if !listed[seriesUID] && inDiffScope(options.Output, seriesUID, locations[seriesUID], options) {
+			result.Differences = append(result.Differences, SeriesDiff{seriesUID, "extra", "not in the reference, stored in " + locations[seriesUID]})
+		}
+	}
+
+	order := map[string]int{"missing": 0, "mismatch": 1, "extra": 2}
+	sort.SliceStable(result.Differences, func(i, j int) bool {
+		return order[result.Differences[i].Status] < order[result.Differences[j].Status]
+	})
+	return result, nil
+}
+
+// runDiff compares a manifest or collection with the output directory and prints
+// the differences. With --save-manifest the missing and mismatched series are
+// written to a manifest that brings the output directory up to date.
+func runDiff(httpClient *http.Client, authToken *Token, options *Options) error {
+	files, err := diffReference(httpClient, authToken, options)
+	if err != nil {
+		return err
+	}
+	result, err := diffSeries(files, options)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	var todo []string
+	for _, d := range result.Differences {
+		counts[d.Status]++
+		if d.Status != "extra" {
+			todo = append(todo, d.SeriesUID)
+		}
+	}
+
+	if options.JSON {
+		content, err := json.MarshalIndent(result, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+	} else {
+		if len(result.Differences) > 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "STATUS\tSERIES\tREASON\n")
+			for _, d := range result.Differences {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", d.Status, d.SeriesUID, d.Reason)
+			}
+			_ = w.Flush()
+			fmt.Println()
+		}
+		fmt.Printf("%d matching, %d missing, %d mismatched, %d extra\n",
+			result.Matching, counts["missing"], counts["mismatch"], counts["extra"])
+	}
+
+	if options.SaveManifest == "" {
+		return nil
+	}
+	if err := writeTCIAManifest(options.SaveManifest, todo); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d series to %s\n", len(todo), options.SaveManifest)
+	return nil
+}
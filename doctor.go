@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// doctorTimeout bounds every network check of doctor
+const doctorTimeout = 20 * time.Second
+
+// Results of a doctor check
+const (
+	checkOK   = "OK"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+	checkSkip = "SKIP"
+)
+
+// DoctorCheck is the result of a check, with what to do about a failure
+type DoctorCheck struct {
+	Name   string
+	Status string
+	Detail string
+	Hint   string
+}
+
+// runDoctor checks the environment of a run, the output directory, proxy, NBIA
+// API, credentials, Gen3 keys and s5cmd, and prints what to fix. It returns an
+// error when a check failed.
+func runDoctor(options *Options) error {
+	var checks []DoctorCheck
+	report := func(check DoctorCheck) {
+		checks = append(checks, check)
+		fmt.Printf("[%-4s] %-22s %s\n", check.Status, check.Name, check.Detail)
+		if check.Hint != "" && (check.Status == checkFail || check.Status == checkWarn) {
+			fmt.Printf("       %-22s → %s\n", "", check.Hint)
+		}
+	}
+
+	report(checkOutputDir(options.Output))
+	report(checkProxy(options.Proxy))
+	token, login := checkLogin(options)
+	report(login)
+	report(checkNBIA(token))
+	for _, check := range checkGen3(options) {
+		report(check)
+	}
+	report(checkS5cmdTool(options))
+
+	failed := 0
+	for _, check := range checks {
+		if check.Status == checkFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	fmt.Println("\nAll checks passed")
+	return nil
+}
+
+// checkOutputDir checks that the output directory can be created and written to
+func checkOutputDir(output string) DoctorCheck {
+	check := DoctorCheck{Name: "Output directory"}
+	if err := os.MkdirAll(output, 0755); err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		check.Hint = "choose another --output or fix the permissions of its parent"
+		return check
+	}
+	f, err := os.CreateTemp(output, ".doctor-*")
+	if err == nil {
+		_, err = f.WriteString("ok")
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		check.Hint = "make " + output + " writable or choose another --output"
+		return check
+	}
+	abs, _ := filepath.Abs(output)
+	check.Status, check.Detail = checkOK, abs+" is writable"
+	return check
+}
+
+// checkProxy checks that the proxy accepts connections
+func checkProxy(proxy string) DoctorCheck {
+	check := DoctorCheck{Name: "Proxy"}
+	if proxy == "" {
+		check.Status, check.Detail = checkSkip, "no --proxy"
+		return check
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		check.Status, check.Detail = checkFail, fmt.Sprintf("invalid proxy URL %q", proxy)
+		check.Hint = "use --proxy http://host:port or socks5://host:port"
+		return check
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443", "socks5": "1080"}[u.Scheme]
+	}
+	host := net.JoinHostPort(u.Hostname(), port)
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", host, doctorTimeout)
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		check.Hint = "check that the proxy is running and reachable from this machine"
+		return check
+	}
+	conn.Close()
+	check.Status, check.Detail = checkOK, fmt.Sprintf("%s accepts connections (%s)", host, time.Since(start).Round(time.Millisecond))
+	return check
+}
+
+// checkLogin requests a new NBIA token with the credentials of the run, leaving any
+// saved token alone
+func checkLogin(options *Options) (*Token, DoctorCheck) {
+	check := DoctorCheck{Name: "NBIA credentials"}
+	who := options.Username
+	if options.GrantType == grantClientCredentials {
+		who = "client " + options.ClientID
+	}
+	start := time.Now()
+	token, err := createNewToken(options.Username, options.Password, "")
+	if err != nil {
+		check.Status, check.Detail = checkFail, fmt.Sprintf("login of %s failed: %v", who, err)
+		check.Hint = "check the network, DNS and firewall, or set --proxy"
+		if strings.Contains(err.Error(), "token request failed") {
+			// The server answered and rejected the credentials
+			check.Hint = "check --user and --passwd (or --client-id and --client-secret); restricted collections need an account with access"
+		}
+		return nil, check
+	}
+	token.username, token.password = options.Username, options.Password
+	check.Status = checkOK
+	check.Detail = fmt.Sprintf("token issued to %s (%s), valid for %s", who,
+		time.Since(start).Round(time.Millisecond), time.Duration(token.ExpiresIn)*time.Second)
+	return token, check
+}
+
+// checkNBIA checks that the NBIA API answers, with the token when there is one
+func checkNBIA(token *Token) DoctorCheck {
+	check := DoctorCheck{Name: "NBIA API"}
+	u, err := url.Parse(endpoints.Series)
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+	// The list of collections is small and needs no parameters
+	u.Path = path.Join(path.Dir(u.Path), "getCollectionValues")
+	u.RawQuery = ""
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		return check
+	}
+	httpClient := *client
+	httpClient.Timeout = doctorTimeout
+	start := time.Now()
+	var resp *http.Response
+	if token != nil {
+		resp, err = doAuthorizedRequest(&httpClient, req, token)
+	} else {
+		resp, err = doRequest(&httpClient, req)
+	}
+	if err != nil {
+		check.Status, check.Detail = checkFail, err.Error()
+		check.Hint = "check the network, DNS and firewall, or set --proxy"
+		return check
+	}
+	resp.Body.Close()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		check.Status, check.Detail = checkOK, fmt.Sprintf("%s answered in %s", u.Host, elapsed)
+	case token == nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden):
+		// Reachable; the credentials check reports why there is no token
+		check.Status, check.Detail = checkWarn, fmt.Sprintf("%s is reachable but requires a token (%s)", u.Host, resp.Status)
+	default:
+		check.Status, check.Detail = checkFail, fmt.Sprintf("%s answered %s", u.Host, resp.Status)
+		check.Hint = "the service may be down; see https://www.cancerimagingarchive.net for announcements"
+	}
+	return check
+}
+
+// checkGen3 checks the API key of --auth with every commons it is used for: the
+// commons that issued it and --drs-host
+func checkGen3(options *Options) []DoctorCheck {
+	if options.Auth == "" {
+		return []DoctorCheck{{Name: "Gen3 API key", Status: checkSkip, Detail: "no --auth"}}
+	}
+	manager, err := NewGen3AuthManager(client, options.Auth)
+	if err != nil {
+		return []DoctorCheck{{Name: "Gen3 API key", Status: checkFail, Detail: err.Error(),
+			Hint: "download a new credentials.json from the profile page of the commons"}}
+	}
+	claims := parseGen3Key(manager.apiKey)
+	var checks []DoctorCheck
+	if claims.Expires > 0 {
+		expires := time.Unix(claims.Expires, 0)
+		check := DoctorCheck{Name: "Gen3 API key", Status: checkOK, Detail: "expires " + expires.Format("2006-01-02")}
+		switch remaining := time.Until(expires); {
+		case remaining <= 0:
+			check.Status, check.Detail = checkFail, "expired "+expires.Format("2006-01-02")
+			check.Hint = "create a new API key on the profile page of the commons"
+		case remaining < 7*24*time.Hour:
+			check.Status = checkWarn
+			check.Hint = "create a new API key before it expires during a long run"
+		}
+		checks = append(checks, check)
+	}
+
+	var hosts []string
+	if u, err := url.Parse(claims.Issuer); err == nil && u.Host != "" {
+		hosts = appendUnique(hosts, u.Host)
+	}
+	hosts = appendUnique(hosts, options.DRSHost)
+	if len(hosts) == 0 {
+		checks = append(checks, DoctorCheck{Name: "Gen3 commons", Status: checkWarn,
+			Detail: "the key names no commons", Hint: "set --drs-host to check it with a commons"})
+	}
+	for _, host := range hosts {
+		check := DoctorCheck{Name: "Gen3 " + host}
+		start := time.Now()
+		if _, err := manager.GetAccessToken(host); err != nil {
+			check.Status, check.Detail = checkFail, err.Error()
+			check.Hint = "the key is not valid for this commons; create one on its profile page"
+		} else {
+			check.Status, check.Detail = checkOK, fmt.Sprintf("access token issued (%s)", time.Since(start).Round(time.Millisecond))
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// gen3KeyClaims are the claims of a Gen3 API key used by doctor
+type gen3KeyClaims struct {
+	Issuer  string `json:"iss"`
+	Expires int64  `json:"exp"`
+}
+
+// parseGen3Key decodes the claims of a Gen3 API key, a JWT, without verifying it
+func parseGen3Key(apiKey string) gen3KeyClaims {
+	var claims gen3KeyClaims
+	parts := strings.Split(apiKey, ".")
+	if len(parts) != 3 {
+		return claims
+	}
+	if payload, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+		json.Unmarshal(payload, &claims)
+	}
+	return claims
+}
+
+// checkS5cmdTool checks that s5cmd is installed and recent enough, which only s3://
+// items need
+func checkS5cmdTool(options *Options) DoctorCheck {
+	check := DoctorCheck{Name: "s5cmd"}
+	if err := checkS5cmd(options); err != nil {
+		check.Status, check.Detail = checkWarn, err.Error()
+		check.Hint = "only needed for .s5cmd manifests and s3:// sources"
+		return check
+	}
+	check.Status, check.Detail = checkOK, options.S5cmdPath+" is usable"
+	return check
+}
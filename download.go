@@ -5,10 +5,11 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/md5"
+	"crypto/sha256"
 	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
@@ -20,6 +21,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,6 +31,8 @@ type MetadataStats struct {
 	Fetched       int32
 	Cached        int32
 	Failed        int32
+	Unavailable   int32
+	Revalidated   int32 // served from the response cache after a 304
 	StartTime     time.Time
 	LastUpdate    time.Time
 	CurrentSeries string
@@ -48,11 +52,15 @@ func (m *MetadataStats) updateProgress(action string, seriesID string) {
 		m.Fetched++
 	case "cached":
 		m.Cached++
-	case "failed":
+	case "failed", "uncached":
 		m.Failed++
+	case "unavailable":
+		m.Unavailable++
+	case "revalidated":
+		m.Revalidated++
 	}
 
-	completed := int(m.Fetched + m.Cached + m.Failed)
+	completed := int(m.Fetched + m.Cached + m.Failed + m.Unavailable + m.Revalidated)
 	now := time.Now()
 
 	// Update display at most once per 100ms or when complete
@@ -69,7 +77,7 @@ func (m *MetadataStats) updateProgress(action string, seriesID string) {
 		var eta string
 		if m.Fetched > 0 && elapsed > 0 {
 			rate := float64(m.Fetched) / elapsed.Seconds()
-			remainingToFetch := float64(m.Total - int(m.Cached) - int(m.Fetched) - int(m.Failed))
+			remainingToFetch := float64(m.Total - completed)
 			if remainingToFetch > 0 && rate > 0 {
 				remainingTime := remainingToFetch / rate
 				etaDuration := time.Duration(remainingTime * float64(time.Second))
@@ -83,10 +91,18 @@ func (m *MetadataStats) updateProgress(action string, seriesID string) {
 			displayID = displayID[:30] + "..."
 		}
 
+		var extraCounts string
+		if m.Revalidated > 0 {
+			extraCounts = fmt.Sprintf(" | Revalidated: %d", m.Revalidated)
+		}
+		if m.Unavailable > 0 {
+			extraCounts += fmt.Sprintf(" | Unavailable: %d", m.Unavailable)
+		}
+
 		// Clear line and print progress - identical format to download progress
-		fmt.Fprintf(os.Stderr, "\r\033[K[%d/%d] %.1f%% | Fetched: %d | Cached: %d | Failed: %d%s | Current: %s",
+		fmt.Fprintf(os.Stderr, "\r\033[K[%d/%d] %.1f%% | Fetched: %d | Cached: %d | Failed: %d%s%s | Current: %s",
 			completed, m.Total, percentage,
-			m.Fetched, m.Cached, m.Failed,
+			m.Fetched, m.Cached, m.Failed, extraCounts,
 			eta, displayID)
 
 		if completed == m.Total {
@@ -116,16 +132,35 @@ func createMetadataDir(output string) error {
 	return nil
 }
 
-// loadMetadataFromCache loads metadata from cache file
+// metadataSumExt is the extension of the sidecar holding the SHA-256 of a metadata
+// cache file, written after it so that a crash in between reads as corruption
+const metadataSumExt = ".sha256"
+
+// errCorruptMetadata marks a metadata cache file that is truncated, altered or does
+// not describe its series; callers treat it like a missing file and fetch again
+var errCorruptMetadata = errors.New("corrupt metadata cache")
+
+// loadMetadataFromCache loads metadata from cache file, checking it against its
+// sidecar checksum when there is one (files written by older versions have none)
 func loadMetadataFromCache(cachePath string) (*FileInfo, error) {
 	data, err := os.ReadFile(cachePath)
 	if err != nil {
 		return nil, err
 	}
 
+	if sum, err := os.ReadFile(cachePath + metadataSumExt); err == nil {
+		actual := sha256.Sum256(data)
+		if strings.TrimSpace(string(sum)) != hex.EncodeToString(actual[:]) {
+			return nil, fmt.Errorf("%w: %s does not match its checksum", errCorruptMetadata, cachePath)
+		}
+	}
+
 	var info FileInfo
 	if err := json.Unmarshal(data, &info); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s: %v", errCorruptMetadata, cachePath, err)
+	}
+	if name := strings.TrimSuffix(filepath.Base(cachePath), ".json"); info.SeriesUID != "" && info.SeriesUID != name {
+		return nil, fmt.Errorf("%w: %s holds series %s", errCorruptMetadata, cachePath, info.SeriesUID)
 	}
 
 	return &info, nil
@@ -142,23 +177,33 @@ func saveMetadataToCache(info *FileInfo, cachePath string) error {
 		return err
 	}
 
-	// Write to temp file first for atomic operation
-	tempFile := cachePath + ".tmp"
+	info.setViewerURLs()
+	info.normalizeDates()
 	data, err := json.MarshalIndent(info, "", "\t")
 	if err != nil {
 		return err
 	}
 
-	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+	// Write to temp file first for atomic operation
+	if err := writeFileAtomic(cachePath, data, 0644); err != nil {
 		return err
 	}
-
-	// Atomic rename
-	return os.Rename(tempFile, cachePath)
+	sum := sha256.Sum256(data)
+	return writeFileAtomic(cachePath+metadataSumExt, []byte(hex.EncodeToString(sum[:])+"\n"), 0644)
 }
 
 // FetchMetadataForSeriesUIDs fetches metadata for a list of series UIDs in parallel
 func FetchMetadataForSeriesUIDs(seriesIDs []string, httpClient *http.Client, authToken *Token, options *Options) ([]*FileInfo, error) {
+	if activeShard != nil || activeSeriesList != nil {
+		// Only the shard's or listed series are looked up; the items are selected again later
+		var selected []string
+		for _, id := range seriesIDs {
+			if activeShard.Contains(id) && activeSeriesList.Contains(id) {
+				selected = append(selected, id)
+			}
+		}
+		seriesIDs = selected
+	}
 	fmt.Printf("Found %d series to fetch metadata for\n", len(seriesIDs))
 
 	// Initialize metadata stats
@@ -166,12 +211,23 @@ func FetchMetadataForSeriesUIDs(seriesIDs []string, httpClient *http.Client, aut
 		Total:     len(seriesIDs),
 		StartTime: time.Now(),
 	}
+	// Before the downloads start, --stats-file reports the metadata phase
+	if activeStats == nil {
+		lastMetadataStats = metaStats
+		stopStatsWriter := startSnapshotWriter(options.StatsFile, func() StatsSnapshot {
+			return metadataStatsSnapshot(metaStats)
+		}, time.Second, false)
+		defer stopStatsWriter()
+	}
+
+	apiCache := NewAPIResponseCache(options.Output, options.APICacheTTL)
 
 	// Use parallel workers to fetch metadata
 	metadataWorkers := options.MetadataWorkers
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	results := make([]*FileInfo, 0)
+	var uncached []string // series without cached metadata with --offline
 
 	// Create a channel for series IDs
 	idChan := make(chan string, len(seriesIDs))
@@ -187,121 +243,206 @@ func FetchMetadataForSeriesUIDs(seriesIDs []string, httpClient *http.Client, aut
 			defer wg.Done()
 
 			for seriesID := range idChan {
-				// Check cache first unless refresh is requested
-				cachePath := getMetadataCachePath(options.Output, seriesID)
-
-				if !options.RefreshMetadata {
-					// Try to load from cache
-					if cachedInfo, err := loadMetadataFromCache(cachePath); err == nil {
-						logger.Debugf("[Meta Worker %d] Loaded metadata from cache for: %s", workerID, seriesID)
-						mu.Lock()
-						results = append(results, cachedInfo)
-						mu.Unlock()
-						metaStats.updateProgress("cached", seriesID)
-						continue
-					}
-					// Cache miss or error, fetch from API
-					logger.Debugf("[Meta Worker %d] Cache miss, fetching metadata for: %s", workerID, seriesID)
-				} else {
-					logger.Debugf("[Meta Worker %d] Force refresh, fetching metadata for: %s", workerID, seriesID)
+				files, action := fetchSeriesMetadata(seriesID, httpClient, authToken, options, apiCache, workerID)
+				mu.Lock()
+				results = append(results, files...)
+				if action == "uncached" {
+					uncached = append(uncached, seriesID)
 				}
+				mu.Unlock()
+				metaStats.updateProgress(action, seriesID)
+			}
+		}(i + 1)
+	}
 
-				url_, err := makeURL(MetaUrl, map[string]interface{}{"SeriesInstanceUID": seriesID})
-				if err != nil {
-					logger.Errorf("[Meta Worker %d] Failed to make URL: %v", workerID, err)
-					metaStats.updateProgress("failed", seriesID)
-					continue
-				}
+	// Wait for all workers to finish
+	wg.Wait()
+	if len(uncached) > 0 {
+		return nil, offlineMissingError(options.Output, uncached)
+	}
 
-				req, err := http.NewRequest("GET", url_, nil)
-				if err != nil {
-					logger.Errorf("[Meta Worker %d] Failed to create request: %v", workerID, err)
-					metaStats.updateProgress("failed", seriesID)
-					continue
-				}
+	fmt.Printf("Successfully fetched metadata for %d files\n", len(results))
+	return results, nil
+}
 
-				// Get current access token
-				accessToken, err := authToken.GetAccessToken()
-				if err != nil {
-					logger.Errorf("[Meta Worker %d] Failed to get access token: %v", workerID, err)
-					metaStats.updateProgress("failed", seriesID)
-					continue
-				}
-				req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+// fetchSeriesMetadata returns the metadata of a series from the per-series cache, the
+// raw API response cache or the API, with the progress action to report for it
+func fetchSeriesMetadata(seriesID string, httpClient *http.Client, authToken *Token, options *Options, apiCache *APIResponseCache, workerID int) ([]*FileInfo, string) {
+	// Check cache first unless refresh is requested
+	cachePath := getMetadataCachePath(options.Output, seriesID)
 
-				// Set timeout for metadata request
-				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-				req = req.WithContext(ctx)
+	if !options.RefreshMetadata && !options.RevalidateMeta {
+		// Try to load from cache
+		cachedInfo, err := loadMetadataFromCache(cachePath)
+		if err == nil {
+			logger.Debugf("[Meta Worker %d] Loaded metadata from cache for: %s", workerID, seriesID)
+			return []*FileInfo{cachedInfo}, "cached"
+		}
+		if errors.Is(err, errCorruptMetadata) {
+			logger.Warnf("[Meta Worker %d] %v; fetching it again", workerID, err)
+		}
+		// Cache miss or error, fetch from API
+		logger.Debugf("[Meta Worker %d] Cache miss, fetching metadata for: %s", workerID, seriesID)
+	} else if options.RevalidateMeta {
+		logger.Debugf("[Meta Worker %d] Revalidating metadata for: %s", workerID, seriesID)
+	} else {
+		logger.Debugf("[Meta Worker %d] Force refresh, fetching metadata for: %s", workerID, seriesID)
+	}
 
-				resp, err := doRequest(httpClient, req)
-				cancel() // Cancel context after request
-				if err != nil {
-					logger.Errorf("[Meta Worker %d] Failed to do request: %v", workerID, err)
-					metaStats.updateProgress("failed", seriesID)
-					continue
-				}
+	url_, err := makeURL(endpoints.Meta, map[string]interface{}{"SeriesInstanceUID": seriesID})
+	if err != nil {
+		logger.Errorf("[Meta Worker %d] Failed to make URL: %v", workerID, err)
+		return nil, "failed"
+	}
+
+	// Raw API responses are content-addressed by request, so repeated
+	// runs over the same manifest can skip the network entirely. Expired
+	// entries, and all entries with --revalidate-metadata, are confirmed with
+	// a conditional request that costs no body when nothing changed.
+	action := "fetched"
+	var entry *cachedAPIResponse
+	var fresh, fromCache bool
+	if !options.RefreshMetadata {
+		entry, fresh = apiCache.Lookup(url_)
+	}
+	// Offline, expired responses are as good as it gets
+	if (fresh || options.Offline && entry != nil) && !options.RevalidateMeta {
+		logger.Debugf("[Meta Worker %d] Loaded API response from cache for: %s", workerID, seriesID)
+		action, fromCache = "cached", true
+	} else if options.Offline {
+		logger.Debugf("[Meta Worker %d] No cached metadata for: %s", workerID, seriesID)
+		return nil, "uncached"
+	} else if options.WhatIf {
+		// Dry runs never touch the network; the series is planned without metadata
+		logger.Debugf("[Meta Worker %d] No cached metadata for: %s", workerID, seriesID)
+		return []*FileInfo{{SeriesUID: seriesID}}, "failed"
+	} else {
+		var revalidated bool
+		entry, revalidated, err = fetchAPIResponse(httpClient, authToken, url_, entry)
+		if revalidated {
+			logger.Debugf("[Meta Worker %d] Cached API response still current for: %s", workerID, seriesID)
+			action = "revalidated"
+		}
+		if err == ErrSeriesNotFound {
+			unavailableSeries.Add(options.Output, seriesID)
+			return nil, "unavailable"
+		}
+		if err != nil {
+			logger.Errorf("[Meta Worker %d] Failed to fetch metadata for series %s: %v", workerID, seriesID, err)
+			return nil, "failed"
+		}
+	}
 
-				// Check for authentication errors
-				if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-					logger.Errorf("[Meta Worker %d] Authentication failed for series %s (status: %s). Please check your credentials and ensure you have access to this restricted series.", workerID, seriesID, resp.Status)
-					_ = resp.Body.Close()
-					metaStats.updateProgress("failed", seriesID)
-					continue
-				}
+	files, err := parseSeriesMetadata(entry.Body)
+	if err != nil {
+		logger.Errorf("[Meta Worker %d] Failed to parse response data: %v", workerID, err)
+		logger.Debugf("%s", string(entry.Body))
+		return nil, "failed"
+	}
+	if len(files) == 0 {
+		// An empty answer means the server does not know the series (withdrawn or
+		// retired); it is not cached so a later restoration is picked up
+		logger.Debugf("[Meta Worker %d] No metadata on server for: %s", workerID, seriesID)
+		unavailableSeries.Add(options.Output, seriesID)
+		return nil, "unavailable"
+	}
 
-				content, err := io.ReadAll(resp.Body)
-				_ = resp.Body.Close()
-				if err != nil {
-					logger.Errorf("[Meta Worker %d] Failed to read response data: %v", workerID, err)
-					metaStats.updateProgress("failed", seriesID)
-					continue
-				}
+	if !fromCache {
+		if err := apiCache.Put(entry); err != nil {
+			logger.Warnf("[Meta Worker %d] Failed to cache API response for %s: %v", workerID, seriesID, err)
+		}
+	}
 
-				var files []*FileInfo
-				// The API sometimes returns a single object instead of an array for a single series.
-				// We need to handle both cases.
-				if len(content) > 0 && content[0] == '[' {
-					err = json.Unmarshal(content, &files)
-				} else if len(content) > 0 {
-					var file FileInfo
-					err = json.Unmarshal(content, &file)
-					if err == nil {
-						files = []*FileInfo{&file}
-					}
-				}
+	// Save to cache - usually one file per series
+	for _, file := range files {
+		if file.SeriesUID != "" {
+			if err := saveMetadataToCache(file, getMetadataCachePath(options.Output, file.SeriesUID)); err != nil {
+				logger.Warnf("[Meta Worker %d] Failed to cache metadata for %s: %v", workerID, file.SeriesUID, err)
+			}
+		}
+	}
 
-				if err != nil {
-					logger.Errorf("[Meta Worker %d] Failed to parse response data: %v", workerID, err)
-					logger.Debugf("%s", string(content))
-					metaStats.updateProgress("failed", seriesID)
-					continue
-				}
+	// Successfully fetched (or served from the response cache)
+	return files, action
+}
 
-				// Save to cache - usually one file per series
-				for _, file := range files {
-					if file.SeriesUID != "" {
-						if err := saveMetadataToCache(file, getMetadataCachePath(options.Output, file.SeriesUID)); err != nil {
-							logger.Warnf("[Meta Worker %d] Failed to cache metadata for %s: %v", workerID, file.SeriesUID, err)
-						}
-					}
-				}
+// errNotModified is returned by fetchNBIAConditional when the cached response is current
+var errNotModified = errors.New("not modified")
 
-				// Thread-safe append to results
-				mu.Lock()
-				results = append(results, files...)
-				mu.Unlock()
+// fetchNBIAResponse performs an authenticated NBIA API GET request and returns the raw body
+func fetchNBIAResponse(httpClient *http.Client, authToken *Token, url_ string) ([]byte, error) {
+	content, _, err := fetchNBIAConditional(httpClient, authToken, url_, nil)
+	return content, err
+}
 
-				// Mark as successfully fetched
-				metaStats.updateProgress("fetched", seriesID)
-			}
-		}(i + 1)
+// fetchNBIAConditional performs an authenticated NBIA API GET request, conditional on
+// the validators of a cached response when one is given, and returns the raw body
+// with the response headers
+func fetchNBIAConditional(httpClient *http.Client, authToken *Token, url_ string, cached *cachedAPIResponse) ([]byte, http.Header, error) {
+	if metadataOffline {
+		return nil, nil, fmt.Errorf("%w: %s", ErrOffline, redactURL(url_))
+	}
+	req, err := http.NewRequest("GET", url_, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	// Wait for all workers to finish
-	wg.Wait()
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
 
-	fmt.Printf("Successfully fetched metadata for %d files\n", len(results))
-	return results, nil
+	// Set timeout for metadata request
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	resp, err := doAuthorizedRequest(httpClient, req, authToken)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check for authentication errors
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, nil, fmt.Errorf("%w (status: %s). Please check your credentials and ensure you have access to this restricted series", ErrAuthFailed, responseStatus(resp))
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, nil, ErrSeriesNotFound
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return nil, resp.Header, errNotModified
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response data: %v", err)
+	}
+	rawResponses.Save(url_, resp, content)
+	return content, resp.Header, nil
+}
+
+// parseSeriesMetadata decodes a metadata response body into FileInfo records
+func parseSeriesMetadata(content []byte) ([]*FileInfo, error) {
+	var files []*FileInfo
+	var err error
+	content = bytes.TrimSpace(content)
+	// The API sometimes returns a single object instead of an array for a single series.
+	// We need to handle both cases.
+	if len(content) > 0 && content[0] == '[' {
+		err = json.Unmarshal(content, &files)
+	} else if len(content) > 0 {
+		var file FileInfo
+		err = json.Unmarshal(content, &file)
+		if err == nil {
+			files = []*FileInfo{&file}
+		}
+	}
+	return files, err
 }
 
 // decodeTCIA is used to decode the tcia file with parallel metadata fetching
@@ -350,12 +491,30 @@ type FileInfo struct {
 	SubjectID          string `json:"Subject ID"`
 	SeriesNumber       string `json:"Series Number"`
 	MD5Hash            string `json:"MD5 Hash,omitempty"`
+	SHA256Hash         string `json:"SHA256 Hash,omitempty"`
 	DownloadURL        string `json:"downloadUrl,omitempty"`
 	DRSURI             string `json:"drs_uri,omitempty"`
 	S5cmdManifestPath  string `json:"s5cmd_manifest_path,omitempty"`
 	FileName           string `json:"file_name,omitempty"`
 	OriginalS5cmdURI   string `json:"original_s5cmd_uri,omitempty"`
 	IsSyncJob          bool   `json:"is_sync_job,omitempty"`
+	NBIAViewerURL      string `json:"NBIA Viewer URL,omitempty"`
+	IDCViewerURL       string `json:"IDC Viewer URL,omitempty"`
+	Priority           int    `json:"-"` // dispatch priority from --priority-input, 0 for --input
+	// SOPInstanceUIDs selects single instances of the series; empty for the whole series
+	SOPInstanceUIDs []string `json:"-"`
+	// Alternates are further sources of the item, tried in order once the retries of
+	// the source before are exhausted; Source is the one the item was downloaded from
+	Alternates []string `json:"-"`
+	Source     string   `json:"-"`
+
+	// pendingExtract finishes a download on the extraction pool; inlineExtract makes
+	// the download extract itself, when downloading again after the pool failed
+	pendingExtract func() error
+	inlineExtract  bool
+	// s5cmdResume syncs an s5cmd copy into the temporary directory an interrupted
+	// run left, fetching only the objects missing there
+	s5cmdResume bool
 }
 
 // GetOutput construct the output directory (thread-safe)
@@ -385,202 +544,268 @@ func (info *FileInfo) MetaFile(output string) string {
 	return getMetadataCachePath(output, info.SeriesUID)
 }
 
+// setViewerURLs links the series to the browser viewers of NBIA and IDC
+func (info *FileInfo) setViewerURLs() {
+	info.NBIAViewerURL = nbiaViewerURL(info.SeriesUID)
+	info.IDCViewerURL = idcViewerURL(info.StudyUID, info.SeriesUID)
+}
+
 func (info *FileInfo) DcimFiles(output string) string {
 	return filepath.Join(info.getOutput(output), info.SeriesUID)
 }
 
-// NeedsDownload checks if files need to be downloaded
-func (info *FileInfo) NeedsDownload(output string, force bool, noDecompress bool) bool {
-	if force {
-		logger.Debugf("Force flag set, will re-download %s", info.SeriesUID)
-		return true
+// directPath returns where a direct or DRS download is stored: under the name from
+// its manifest, the name taken from the server on its first download, or its SeriesUID
+func (info *FileInfo) directPath(output string) string {
+	if info.FileName != "" {
+		return filepath.Join(output, info.FileName)
 	}
+	if name, ok := fileNames.Lookup(info.source()); ok {
+		return filepath.Join(output, name)
+	}
+	return filepath.Join(output, info.SeriesUID)
+}
 
-	var targetPath string
+// seriesPath returns where a TCIA series is stored, without creating any directory
+func (info *FileInfo) seriesPath(output string) string {
+	return filepath.Join(output, info.SubjectID, info.StudyUID, info.SeriesUID)
+}
+
+// LocalState describes what is on disk for an item compared to its metadata
+type LocalState int
+
+const (
+	StateMissing  LocalState = iota // nothing on disk
+	StateInvalid                    // present but incomplete or of the wrong kind
+	StateComplete                   // present and matching the metadata
+	StateUnknown                    // cannot be checked locally (s5cmd transfers)
+)
+
+// LocalState inspects the output directory for this item and explains the verdict.
+// It only reads the file system, so it is safe for dry runs.
+func (info *FileInfo) LocalState(output string, noDecompress bool) (LocalState, string) {
 	if info.S5cmdManifestPath != "" {
 		// s5cmd downloads files to the output directory, so we can't check for a specific file
 		// and we assume the file needs to be downloaded.
-		return true
+		return StateUnknown, "s5cmd transfers are always run"
 	}
-	if info.DownloadURL != "" {
-		targetPath = filepath.Join(output, info.SeriesUID)
-		_, err := os.Stat(targetPath)
+
+	var targetPath string
+	if info.DownloadURL != "" || info.DRSURI != "" {
+		targetPath = info.directPath(output)
+		stat, err := os.Stat(targetPath)
 		if os.IsNotExist(err) {
-			logger.Debugf("Target %s does not exist, need to download", targetPath)
-			return true
+			return StateMissing, fmt.Sprintf("%s does not exist", targetPath)
+		}
+		// Checksums are verified while downloading; only manifests with sizes allow a check here
+		if expected, err := strconv.ParseInt(info.FileSize, 10, 64); err == nil && stat != nil && stat.Size() != expected {
+			return StateInvalid, fmt.Sprintf("size mismatch in %s: expected %d, got %d", targetPath, expected, stat.Size())
+		}
+		if changed, detail := urlHeads.Changed(info.DownloadURL); changed && info.DRSURI == "" {
+			return StateInvalid, fmt.Sprintf("%s changed on the server: %s", info.DownloadURL, detail)
+		}
+		return StateComplete, fmt.Sprintf("direct download file %s exists", targetPath)
+	}
+
+	if len(info.SOPInstanceUIDs) > 0 {
+		// Selected instances are stored as loose files whatever the decompression mode;
+		// once packed or repacked the directory is gone and the checks below apply
+		if _, err := os.Stat(info.seriesPath(output)); err == nil || noDecompress {
+			if missing := info.missingInstances(output); missing > 0 {
+				return StateMissing, fmt.Sprintf("%d of %d selected instances missing in %s", missing, len(info.SOPInstanceUIDs), info.seriesPath(output))
+			}
+			return StateComplete, fmt.Sprintf("all %d selected instances exist in %s", len(info.SOPInstanceUIDs), info.seriesPath(output))
 		}
-		// If it exists, we assume it's downloaded. We don't have size/checksum info for these.
-		logger.Debugf("Direct download file %s exists, skipping", targetPath)
-		return false
 	}
 
 	if noDecompress {
-		// Check for ZIP file
-		targetPath = info.DcimFiles(output) + ".zip"
+		// Check for the series archive
+		targetPath = info.seriesPath(output) + seriesArchiveExt
 	} else {
 		// Check for extracted directory
-		targetPath = info.DcimFiles(output)
+		targetPath = info.seriesPath(output)
 	}
 
-	stat, err := os.Stat(targetPath)
+	var stat os.FileInfo
+	err := withRecallWait(targetPath, func() (err error) {
+		stat, err = os.Stat(targetPath)
+		return err
+	})
 	if err != nil {
 		if os.IsNotExist(err) {
-			logger.Debugf("Target %s does not exist, need to download", targetPath)
-			return true
+			if stored, ok := contentStore.Lookup(info.SeriesUID); ok && !noDecompress {
+				return StateComplete, fmt.Sprintf("series packed into %s", stored.Container)
+			}
+			if archive, ok := repackedArchive(output, info); ok && !noDecompress {
+				return StateComplete, fmt.Sprintf("series repacked into %s", archive)
+			}
+			return StateMissing, fmt.Sprintf("%s does not exist", targetPath)
 		}
 		logger.Warnf("Error checking target %s: %v", targetPath, err)
-		return true
+		return StateInvalid, fmt.Sprintf("cannot check %s: %v", targetPath, err)
 	}
 
 	if noDecompress {
-		// For ZIP files, check if it's a regular file
+		// For archives, check if it's a regular file
 		if stat.IsDir() {
-			logger.Debugf("%s exists but is a directory, need to re-download", targetPath)
-			return true
+			return StateInvalid, fmt.Sprintf("%s exists but is a directory", targetPath)
 		}
-		// For ZIP files, we can't easily verify the size as it's compressed
+		// For archives, we can't easily verify the size as it's compressed
 		// Just check existence for now
-		logger.Debugf("ZIP file %s exists, skipping", targetPath)
-		return false
-	} else {
-		// For extracted files, check if it's a directory
-		if !stat.IsDir() {
-			logger.Debugf("%s exists but is not a directory, need to re-download", targetPath)
-			return true
-		}
+		return StateComplete, fmt.Sprintf("archive %s exists", targetPath)
+	}
 
-		// Check total size of extracted files
-		if info.FileSize != "" {
-			expectedSize, err := strconv.ParseInt(info.FileSize, 10, 64)
-			if err == nil {
-				actualSize, err := getDirectorySize(targetPath)
-				if err != nil {
-					logger.Warnf("Error calculating directory size for %s: %v", targetPath, err)
-					return true
-				}
-				if actualSize != expectedSize {
-					logger.Debugf("Directory %s size mismatch: expected %d, got %d", targetPath, expectedSize, actualSize)
-					return true
-				}
+	// For extracted files, check if it's a directory
+	if !stat.IsDir() {
+		return StateInvalid, fmt.Sprintf("%s exists but is not a directory", targetPath)
+	}
+
+	// Check total size of extracted files
+	if info.FileSize != "" {
+		expectedSize, err := strconv.ParseInt(info.FileSize, 10, 64)
+		if err == nil {
+			var actualSize int64
+			err := withRecallWait(targetPath, func() (err error) {
+				actualSize, err = getDirectorySize(targetPath)
+				return err
+			})
+			if err != nil {
+				logger.Warnf("Error calculating directory size for %s: %v", targetPath, err)
+				return StateInvalid, fmt.Sprintf("cannot calculate size of %s: %v", targetPath, err)
+			}
+			if deidSize, ok := deidentifiedSize(output, info.SeriesUID); ok && actualSize == deidSize {
+				return StateComplete, fmt.Sprintf("directory %s exists, de-identified", targetPath)
+			}
+			if actualSize != expectedSize {
+				return StateInvalid, fmt.Sprintf("size mismatch in %s: expected %d, got %d", targetPath, expectedSize, actualSize)
 			}
 		}
+	}
+
+	return StateComplete, fmt.Sprintf("directory %s exists with correct size", targetPath)
+}
 
-		logger.Debugf("Directory %s exists with correct size, skipping", targetPath)
-		return false
+// extractAndVerifyZip extracts a ZIP file and verifies the total uncompressed size and optional MD5 hashes.
+// When sha256Sums is non-nil, the SHA-256 of each extracted file is computed in the same pass and stored by name.
+func extractAndVerifyZip(zipPath string, destDir string, expectedSize int64, md5Map map[string]string, sha256Sums map[string]string) error {
+	// Create destination directory
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
 	}
+	return extractZipEntries(zipPath, dirSink(destDir), expectedSize, md5Map, sha256Sums)
 }
 
-// extractAndVerifyZip extracts a ZIP file and verifies the total uncompressed size and optional MD5 hashes
-func extractAndVerifyZip(zipPath string, destDir string, expectedSize int64, md5Map map[string]string) error {
-	reader, err := zip.OpenReader(zipPath)
+// zipSink receives the entries of a series ZIP while it is verified
+type zipSink interface {
+	// Dir creates a directory entry
+	Dir(file *zip.File) error
+	// Create opens the destination of a file entry
+	Create(file *zip.File) (io.WriteCloser, error)
+}
+
+// dirSink extracts ZIP entries below a directory
+type dirSink string
+
+func (destDir dirSink) path(file *zip.File) (string, error) {
+	path := filepath.Join(string(destDir), file.Name)
+	// Ensure the file path is within destDir (security check)
+	if !strings.HasPrefix(path, filepath.Clean(string(destDir))+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid file path in zip: %s", file.Name)
+	}
+	return path, nil
+}
+
+func (destDir dirSink) Dir(file *zip.File) error {
+	path, err := destDir.path(file)
 	if err != nil {
-		return fmt.Errorf("failed to open zip: %v", err)
+		return err
 	}
-	defer reader.Close()
+	if err := os.MkdirAll(path, file.Mode()); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return nil
+}
 
-	// Create destination directory
-	if err := os.MkdirAll(destDir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
+func (destDir dirSink) Create(file *zip.File) (io.WriteCloser, error) {
+	path, err := destDir.path(file)
+	if err != nil {
+		return nil, err
+	}
+	// Create the directory for the file
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create file directory: %w", err)
 	}
+	targetFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	return targetFile, nil
+}
 
-	var totalSize int64
-	var md5Errors []string
+// extractZipEntries writes the entries of a ZIP file to sink, verifying the total
+// uncompressed size and optional MD5 hashes like extractAndVerifyZip. Entries
+// extracted to a directory are decompressed, written and hashed on the goroutines
+// of the hash pool, one file each; other sinks take them in order.
+func extractZipEntries(zipPath string, sink zipSink, expectedSize int64, md5Map map[string]string, sha256Sums map[string]string) error {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("failed to open zip: %w", err)
+	}
+	defer reader.Close()
 
 	// Check if we're in MD5 validation mode
 	md5Mode := len(md5Map) > 0
 
-	// Extract files
+	var files []*zip.File
 	for _, file := range reader.File {
 		// Skip md5hashes.csv if present
 		if file.Name == "md5hashes.csv" {
 			continue
 		}
-
-		path := filepath.Join(destDir, file.Name)
-
-		// Ensure the file path is within destDir (security check)
-		if !strings.HasPrefix(path, filepath.Clean(destDir)+string(os.PathSeparator)) {
-			return fmt.Errorf("invalid file path in zip: %s", file.Name)
-		}
-
 		if file.FileInfo().IsDir() {
-			if err := os.MkdirAll(path, file.Mode()); err != nil {
-				return fmt.Errorf("failed to create directory: %v", err)
+			if err := sink.Dir(file); err != nil {
+				return err
 			}
 			continue
 		}
+		files = append(files, file)
+	}
 
-		// Create the directory for the file
-		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-			return fmt.Errorf("failed to create file directory: %v", err)
-		}
-
-		// Extract file
-		fileReader, err := file.Open()
-		if err != nil {
-			return fmt.Errorf("failed to open file in zip: %v", err)
-		}
-
-		targetFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			fileReader.Close()
-			return fmt.Errorf("failed to create file: %v", err)
-		}
-
-		// Check if this file is in the MD5 map (i.e., it's an imaging file)
-		isImagingFile := false
-		expectedMD5 := ""
-		if md5Hash, ok := md5Map[file.Name]; ok {
-			isImagingFile = true
-			expectedMD5 = md5Hash
-		}
-
-		// If MD5 validation is needed, use a multi-writer
-		var writer io.Writer = targetFile
-		var hasher hash.Hash
-		if isImagingFile && expectedMD5 != "" {
-			hasher = md5.New()
-			writer = io.MultiWriter(targetFile, hasher)
-		}
-
-		written, err := io.Copy(writer, fileReader)
-		fileReader.Close()
-		targetFile.Close()
-
-		if err != nil {
-			return fmt.Errorf("failed to extract file %s: %v", file.Name, err)
-		}
+	var entries []*zipEntry
+	if dest, ok := sink.(dirSink); ok && hashPool != nil && len(files) > 1 {
+		entries, err = extractParallel(files, dest, md5Map, sha256Sums != nil, hashPool.workers)
+	} else {
+		entries, err = extractSerial(files, sink, md5Map, sha256Sums != nil)
+	}
+	if err != nil {
+		return err
+	}
 
-		// Verify MD5 if available
-		if hasher != nil && expectedMD5 != "" {
-			actualMD5 := hex.EncodeToString(hasher.Sum(nil))
-			if actualMD5 != expectedMD5 {
-				md5Errors = append(md5Errors, fmt.Sprintf("%s: expected %s, got %s", file.Name, expectedMD5, actualMD5))
-			} else {
-				logger.Debugf("MD5 verified for %s", file.Name)
-			}
+	var totalSize int64
+	var md5Errors []string
+	for _, entry := range entries {
+		result := entry.result
+		if entry.pending != nil {
+			// Collect digests computed by the hash pool
+			result = <-entry.pending
 		}
-
+		md5Errors = recordHashResult(entry.name, entry.expectedMD5, result, sha256Sums, md5Errors)
 		// Only count size for imaging files in MD5 mode, or all files in non-MD5 mode
-		if md5Mode {
-			if isImagingFile {
-				totalSize += written
-			}
-		} else {
-			totalSize += written
+		if !md5Mode || entry.imaging {
+			totalSize += entry.written
 		}
 	}
 
 	// Report MD5 errors if any
 	if len(md5Errors) > 0 {
-		return fmt.Errorf("MD5 validation failed for %d files:\n%s", len(md5Errors), strings.Join(md5Errors, "\n"))
+		return fmt.Errorf("%w: MD5 validation failed for %d files:\n%s", ErrChecksumMismatch, len(md5Errors), strings.Join(md5Errors, "\n"))
 	}
 
 	// Verify total size if expected size is provided
 	if expectedSize > 0 && totalSize != expectedSize {
 		if md5Mode {
 			// In MD5 mode, we know exactly which files are imaging files, so size should match
-			return fmt.Errorf("size mismatch: expected %d bytes, extracted %d bytes", expectedSize, totalSize)
+			return fmt.Errorf("%w: expected %d bytes, extracted %d bytes", ErrChecksumMismatch, expectedSize, totalSize)
 		} else {
 			// In non-MD5 mode, we counted all files including non-imaging files, so just warn
 			logger.Warnf("Size mismatch (this may be due to non-imaging files in the archive): expected %d bytes, extracted %d bytes", expectedSize, totalSize)
@@ -590,6 +815,172 @@ func extractAndVerifyZip(zipPath string, destDir string, expectedSize int64, md5
 	return nil
 }
 
+// zipEntry is an extracted file of a ZIP with its digests, or the pending result of
+// the hash pool computing them
+type zipEntry struct {
+	name        string
+	expectedMD5 string
+	imaging     bool // listed in md5hashes.csv
+	written     int64
+	result      HashResult
+	pending     <-chan HashResult
+}
+
+// newZipEntry prepares the entry of a file, looking up its expected MD5
+func newZipEntry(file *zip.File, md5Map map[string]string) *zipEntry {
+	entry := &zipEntry{name: file.Name}
+	// Check if this file is in the MD5 map (i.e., it's an imaging file)
+	entry.expectedMD5, entry.imaging = md5Map[file.Name]
+	return entry
+}
+
+// extractSerial extracts the files one after the other, as sinks writing a single
+// stream need. Small files are hashed on the hash pool while extraction continues.
+func extractSerial(files []*zip.File, sink zipSink, md5Map map[string]string, wantSHA256 bool) ([]*zipEntry, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	entries := make([]*zipEntry, 0, len(files))
+	for _, file := range files {
+		entry := newZipEntry(file, md5Map)
+		wantMD5 := entry.expectedMD5 != ""
+		if hashPool != nil && (wantMD5 || wantSHA256) && file.UncompressedSize64 <= hashPoolMaxFileSize {
+			data, err := readZipFile(file)
+			if err == nil {
+				entry.written, err = writeZipEntry(sink, file, bytes.NewReader(data), nil, nil, *buf)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to extract file %s: %w", file.Name, err)
+			}
+			entry.pending = hashPool.Submit(data, wantMD5, wantSHA256)
+		} else if err := entry.extract(file, sink, wantSHA256, *buf); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// extractParallel extracts the files on the given number of goroutines, each
+// decompressing, writing and hashing whole files in a single pass. The first
+// failure stops the remaining files.
+func extractParallel(files []*zip.File, dest dirSink, md5Map map[string]string, wantSHA256 bool, workers int) ([]*zipEntry, error) {
+	if workers > len(files) {
+		workers = len(files)
+	}
+	entries := make([]*zipEntry, len(files))
+	next := make(chan int)
+	var failed atomic.Bool
+	var firstErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	bufSize := copyBufferSize(string(dest))
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, bufSize)
+			for i := range next {
+				entry := newZipEntry(files[i], md5Map)
+				if err := entry.extract(files[i], dest, wantSHA256, buf); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					failed.Store(true)
+					continue
+				}
+				entries[i] = entry
+			}
+		}()
+	}
+	for i := range files {
+		if failed.Load() {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return entries, nil
+}
+
+// extract writes a file to the sink, hashing it while it streams
+func (entry *zipEntry) extract(file *zip.File, sink zipSink, wantSHA256 bool, buf []byte) error {
+	var md5Hasher, sha256Hasher hash.Hash
+	if entry.expectedMD5 != "" {
+		md5Hasher = newMD5Hash()
+	}
+	if wantSHA256 {
+		sha256Hasher = newSHA256Hash()
+	}
+	fileReader, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open file in zip: %v", err)
+	}
+	entry.written, err = writeZipEntry(sink, file, fileReader, md5Hasher, sha256Hasher, buf)
+	fileReader.Close()
+	if err != nil {
+		return fmt.Errorf("failed to extract file %s: %w", file.Name, err)
+	}
+	if md5Hasher != nil {
+		entry.result.MD5 = hex.EncodeToString(md5Hasher.Sum(nil))
+	}
+	if sha256Hasher != nil {
+		entry.result.SHA256 = hex.EncodeToString(sha256Hasher.Sum(nil))
+	}
+	return nil
+}
+
+// writeZipEntry copies the content of a file to its destination in the sink through
+// the given hashes, in chunks of the size of buf, and returns the bytes written
+func writeZipEntry(sink zipSink, file *zip.File, content io.Reader, md5Hasher, sha256Hasher hash.Hash, buf []byte) (int64, error) {
+	targetFile, err := sink.Create(file)
+	if err != nil {
+		return 0, err
+	}
+	var writer io.Writer = targetFile
+	if md5Hasher != nil {
+		writer = io.MultiWriter(writer, md5Hasher)
+	}
+	if sha256Hasher != nil {
+		writer = io.MultiWriter(writer, sha256Hasher)
+	}
+	// Wrapped in a plain writer so the copy goes through buf rather than ReadFrom
+	written, err := io.CopyBuffer(struct{ io.Writer }{writer}, content, buf)
+	if closeErr := targetFile.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// readZipFile returns the uncompressed content of a file
+func readZipFile(file *zip.File) ([]byte, error) {
+	fileReader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file in zip: %v", err)
+	}
+	defer fileReader.Close()
+	return io.ReadAll(fileReader)
+}
+
+// recordHashResult verifies the MD5 of an extracted file and stores its SHA-256,
+// returning md5Errors with any mismatch appended
+func recordHashResult(name, expectedMD5 string, result HashResult, sha256Sums map[string]string, md5Errors []string) []string {
+	// Verify MD5 if available
+	if result.MD5 != "" && expectedMD5 != "" {
+		if result.MD5 != expectedMD5 {
+			md5Errors = append(md5Errors, fmt.Sprintf("%s: expected %s, got %s", name, expectedMD5, result.MD5))
+		} else {
+			logger.Debugf("MD5 verified for %s", name)
+		}
+	}
+	if result.SHA256 != "" && sha256Sums != nil {
+		sha256Sums[name] = result.SHA256
+	}
+	return md5Errors
+}
+
 // getDirectorySize calculates the total size of all files in a directory
 func getDirectorySize(dirPath string) (int64, error) {
 	var size int64
@@ -597,6 +988,12 @@ func getDirectorySize(dirPath string) (int64, error) {
 		if err != nil {
 			return err
 		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			// Files of --dedup symlink count with the size of their object
+			if info, err = os.Stat(path); err != nil {
+				return err
+			}
+		}
 		if !info.IsDir() {
 			size += info.Size()
 		}
@@ -649,43 +1046,84 @@ func parseMD5HashesCSV(zipPath string) (map[string]string, error) {
 
 func (info *FileInfo) GetMeta(output string) error {
 	logger.Debugf("getting meta information and save to %s", output)
-	f, err := os.OpenFile(info.MetaFile(output), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("failed to open meta file %s: %v", info.MetaFile(output), err)
-	}
+	info.setViewerURLs()
+	info.normalizeDates()
 	content, err := json.MarshalIndent(info, "", "\t")
 	if err != nil {
 		return fmt.Errorf("failed to marshall meta: %v", err)
 	}
-	_, err = f.Write(content)
-	if err != nil {
-		return err
+	// A new file rather than rewriting the old one in place, which may be hard-linked
+	if err := writeFileAtomic(info.MetaFile(output), content, 0644); err != nil {
+		return fmt.Errorf("failed to write meta file %s: %v", info.MetaFile(output), err)
 	}
-	return f.Close()
+	return nil
 }
 
 // Download is real function to download file with retry logic
 func (info *FileInfo) Download(output string, httpClient *http.Client, authToken *Token, gen3Auth *Gen3AuthManager, options *Options) error {
-	// Add rate limiting delay between requests
-	if options.RequestDelay > 0 {
-		time.Sleep(options.RequestDelay)
+	// Courtesy mode lets only a few workers transfer at once
+	defer throttle.Admit()()
+	// Add rate limiting delay between requests, jittered so workers drift apart
+	delay := options.RequestDelay
+	if throttle.Courtesy() {
+		delay = max(delay, courtesyRequestDelay)
+	}
+	if delay > 0 {
+		time.Sleep(jitter(delay, options.RequestJitter))
+	}
+	err := info.DownloadWithRetry(output, httpClient, authToken, gen3Auth, options)
+	if len(info.Alternates) == 0 {
+		return err
 	}
-	return info.DownloadWithRetry(output, httpClient, authToken, gen3Auth, options)
+
+	source := info.source()
+	for _, alternate := range info.Alternates {
+		if err == nil || errors.Is(err, ErrCircuitOpen) {
+			break
+		}
+		logger.Warnf("Download %s from %s failed, falling back to %s: %v", info.SeriesUID, source, alternate, err)
+		source = alternate
+		if err = info.withSource(alternate).DownloadWithRetry(output, httpClient, authToken, gen3Auth, options); err == nil {
+			activeStats.recordFallback()
+		}
+	}
+	switch {
+	case err == nil:
+		info.Source = source
+		return nil
+	case errors.Is(err, ErrCircuitOpen):
+		return err
+	}
+	return fmt.Errorf("all %d sources failed, last %s: %w", len(info.Alternates)+1, source, err)
 }
 
-// DownloadWithRetry downloads file with retry logic and exponential backoff
+// DownloadWithRetry downloads file with retry logic, jittered exponential backoff and a
+// per-series retry budget
 func (info *FileInfo) DownloadWithRetry(output string, httpClient *http.Client, authToken *Token, gen3Auth *Gen3AuthManager, options *Options) error {
 	var lastErr error
-	delay := options.RetryDelay
+	started := time.Now()
 
 	for attempt := 0; attempt <= options.MaxRetries; attempt++ {
 		if attempt > 0 {
-			logger.Infof("Retrying download %s (attempt %d/%d) after %v delay", info.SeriesUID, attempt, options.MaxRetries, delay)
+			delay := backoffDelay(attempt, options.RetryDelay, options.RetryMaxDelay)
+			if options.RetryBudget > 0 && time.Since(started)+delay > options.RetryBudget {
+				return fmt.Errorf("retry budget of %v exhausted after %d attempts: %w", options.RetryBudget, attempt, lastErr)
+			}
+			logger.Infof("Retrying download %s (attempt %d/%d) after %v delay", info.SeriesUID, attempt, options.MaxRetries, delay.Round(time.Millisecond))
+			event := itemEvent(ProgressRetrying, info, 0)
+			event.Attempt, event.Error, event.Code = attempt+1, lastErr.Error(), failureCode(lastErr)
+			progress.Emit(event)
 			time.Sleep(delay)
-			delay *= 2 // Exponential backoff
+		}
+		if circuitBreaker.Tripped() {
+			if lastErr == nil {
+				return ErrCircuitOpen
+			}
+			return fmt.Errorf("%w (last error: %v)", ErrCircuitOpen, lastErr)
 		}
 
 		err := info.doDownload(output, httpClient, authToken, gen3Auth, options)
+		circuitBreaker.Record(err)
 		if err == nil {
 			return nil
 		}
@@ -700,31 +1138,7 @@ func (info *FileInfo) DownloadWithRetry(output string, httpClient *http.Client,
 		}
 	}
 
-	return fmt.Errorf("download failed after %d attempts: %v", options.MaxRetries+1, lastErr)
-}
-
-// isRetryableError checks if an error is retryable
-func isRetryableError(err error) bool {
-	// Check for network errors, timeouts, and certain HTTP status codes
-	errStr := err.Error()
-
-	// s5cmd errors are generally not retryable
-	if strings.Contains(errStr, "s5cmd command failed") {
-		return false
-	}
-
-	return strings.Contains(errStr, "timeout") ||
-		strings.Contains(errStr, "connection refused") ||
-		strings.Contains(errStr, "connection reset") ||
-		strings.Contains(errStr, "EOF") ||
-		strings.Contains(errStr, "incomplete download") || // Truncated downloads
-		strings.Contains(errStr, "closed") || // Connection closed
-		strings.Contains(errStr, "broken pipe") || // Broken connection
-		strings.Contains(errStr, "429") || // Rate limiting
-		strings.Contains(errStr, "500") || // Server error
-		strings.Contains(errStr, "502") || // Bad gateway
-		strings.Contains(errStr, "503") || // Service unavailable
-		strings.Contains(errStr, "504") // Gateway timeout
+	return fmt.Errorf("download failed after %d attempts: %w", options.MaxRetries+1, lastErr)
 }
 
 // doDownload is a dispatcher for different download types
@@ -740,12 +1154,56 @@ func (info *FileInfo) doDownload(output string, httpClient *http.Client, authTok
 	if info.DRSURI != "" {
 		return info.downloadFromGen3(output, httpClient, gen3Auth, options)
 	}
+	if strings.HasSuffix(info.DownloadURL, "/*") {
+		// Prefixes of object stores downloaded without external tools
+		switch {
+		case isAzureURL(info.DownloadURL):
+			return info.downloadFromAzure(output, httpClient, options)
+		case isGCSURL(info.DownloadURL):
+			return info.downloadFromGCS(output, httpClient, options)
+		case isFTPURL(info.DownloadURL):
+			return info.downloadFromFTP(output, options)
+		}
+	}
 	if info.DownloadURL != "" {
-		return info.downloadDirect(output, httpClient)
+		return info.downloadDirect(output, httpClient, options)
+	}
+	if len(info.SOPInstanceUIDs) > 0 {
+		return info.downloadInstances(output, httpClient, authToken)
 	}
 	return info.downloadFromTCIA(output, httpClient, authToken, options)
 }
 
+// s5cmdGlobalFlags returns the s5cmd flags selecting the endpoint and credentials,
+// followed by those of --s5cmd-args.
+// Requests are anonymous unless a profile, signing or requester pays is asked for;
+// signed requests use the AWS credential chain (environment, shared files, roles).
+func s5cmdGlobalFlags(options *Options) []string {
+	flags := []string{"--endpoint-url", endpoints.S3}
+	switch {
+	case options.S3Profile != "":
+		flags = append(flags, "--profile", options.S3Profile)
+	case !options.S3Sign && !options.S3RequesterPays:
+		flags = append(flags, "--no-sign-request")
+	}
+	if options.S3RequesterPays {
+		flags = append(flags, "--request-payer", "requester")
+	}
+	return append(flags, options.S5cmdArgs...)
+}
+
+// s5cmdTransferFlags returns the flags of cp and sync tuning multipart downloads
+func s5cmdTransferFlags(options *Options) []string {
+	var flags []string
+	if options.S5cmdConcurrent > 0 {
+		flags = append(flags, "--concurrency", strconv.Itoa(options.S5cmdConcurrent))
+	}
+	if options.S5cmdPartSize > 0 {
+		flags = append(flags, "--part-size", strconv.Itoa(options.S5cmdPartSize))
+	}
+	return flags
+}
+
 // downloadFromS3 downloads a file (or files, using a wildcard) from S3 using the s5cmd command-line tool.
 func (info *FileInfo) downloadFromS3(targetDir string, options *Options) error {
 	// Ensure the target directory exists, especially for sync jobs where the dir might have been deleted.
@@ -753,34 +1211,54 @@ func (info *FileInfo) downloadFromS3(targetDir string, options *Options) error {
 		return fmt.Errorf("could not create target directory %s: %w", targetDir, err)
 	}
 
-	var cmd *exec.Cmd
+	err := s5cmdBatch.Run(info, targetDir, options)
+	// Objects in Glacier or Deep Archive can only be fetched once restored
+	var s5cmdErr *S5cmdError
+	if errors.As(err, &s5cmdErr) {
+		if uris := archivedObjects(s5cmdErr.Output); len(uris) > 0 {
+			if err = info.restoreArchived(uris, options); err == nil {
+				err = s5cmdBatch.Run(info, targetDir, options)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	clearRestores(options.Output, info.DownloadURL)
+
+	// Copies land in a temporary directory and are hashed once organized into their series folder
 	if info.IsSyncJob {
+		if err := checksums.HashDir(targetDir); err != nil {
+			logger.Warnf("Failed to compute checksums for %s: %v", targetDir, err)
+		}
+	}
+	return nil
+}
+
+// runS5cmd copies or syncs the objects of an item into targetDir with s5cmd
+func (info *FileInfo) runS5cmd(targetDir string, options *Options) error {
+	args := s5cmdGlobalFlags(options)
+	if info.IsSyncJob || info.s5cmdResume {
 		logger.Debugf("Syncing from S3: %s to %s", info.DownloadURL, targetDir)
-		cmd = exec.Command("s5cmd",
-			"--no-sign-request",
-			"--endpoint-url", "https://s3.amazonaws.com",
-			"sync",
-			"--size-only",
-			info.DownloadURL,
-			".",
-		)
+		args = append(args, "sync", "--size-only")
 	} else {
 		logger.Debugf("Copying from S3: %s to %s", info.DownloadURL, targetDir)
-		cmd = exec.Command("s5cmd",
-			"--no-sign-request",
-			"--endpoint-url", "https://s3.amazonaws.com",
-			"cp",
-			info.DownloadURL,
-			".",
-		)
+		args = append(args, "cp")
 	}
+	args = append(append(args, s5cmdTransferFlags(options)...), info.DownloadURL, ".")
+	cmd := exec.Command(options.S5cmdPath, args...)
 
 	cmd.Dir = targetDir // Run the command in the specified target directory
 
+	// s5cmd does not report sizes; the egress is what it wrote into the directory
+	before := dirFileStates(targetDir)
+
 	// Execute the command
 	stdout, err := cmd.CombinedOutput()
+	objects, bytes := writtenSince(targetDir, before)
+	recordEgress(info.DownloadURL, objects, bytes)
 	if err != nil {
-		return fmt.Errorf("s5cmd command failed for %s: %s\nOutput: %s", info.DownloadURL, err, string(stdout))
+		return &S5cmdError{URL: info.DownloadURL, Err: err, Output: string(stdout)}
 	}
 
 	logger.Debugf("s5cmd output for %s:\n%s", info.DownloadURL, string(stdout))
@@ -799,16 +1277,34 @@ func (info *FileInfo) downloadFromGen3(output string, httpClient *http.Client, g
 	commonsURL := parsedURI.Host
 	objectID := strings.TrimPrefix(parsedURI.Path, "/")
 
-	// Get download URL from Gen3
+	// Reuse a presigned URL resolved earlier if it stays valid long enough for this transfer
 	objectID = url.PathEscape(objectID)
-	downloadURL, err := getGen3DownloadURL(httpClient, commonsURL, objectID, gen3Auth)
-	if err != nil {
-		return fmt.Errorf("failed to get download URL from Gen3: %v", err)
+	downloadURL, cached := presignedURLs.Get(info.DRSURI, presignedURLNeed(info, options.Concurrent))
+	if !cached {
+		downloadURL, err = getGen3DownloadURL(httpClient, commonsURL, objectID, gen3Auth)
+		if err != nil {
+			return fmt.Errorf("failed to get download URL from Gen3: %w", err)
+		}
+		presignedURLs.Put(info.DRSURI, downloadURL)
 	}
 
 	// Download the file
 	info.DownloadURL = downloadURL
-	return info.downloadDirect(output, httpClient)
+	err = info.downloadDirect(output, httpClient, options)
+
+	// A cached URL may have been revoked; resolve it again once
+	var statusErr *HTTPStatusError
+	if cached && errors.As(err, &statusErr) &&
+		(statusErr.StatusCode == http.StatusForbidden || statusErr.StatusCode == http.StatusBadRequest) {
+		logger.Debugf("Cached download URL for %s rejected (%s), resolving again", info.DRSURI, statusErr.Status)
+		presignedURLs.Invalidate(info.DRSURI)
+		if info.DownloadURL, err = getGen3DownloadURL(httpClient, commonsURL, objectID, gen3Auth); err != nil {
+			return fmt.Errorf("failed to get download URL from Gen3: %w", err)
+		}
+		presignedURLs.Put(info.DRSURI, info.DownloadURL)
+		err = info.downloadDirect(output, httpClient, options)
+	}
+	return err
 }
 
 type AccessMethod struct {
@@ -835,6 +1331,9 @@ func NewGen3AuthManager(client *http.Client, authFile string) (*Gen3AuthManager,
 	if err != nil {
 		return nil, fmt.Errorf("failed to read API key file: %v", err)
 	}
+	if keyData, err = openSecret(secretKey, keyData); err != nil {
+		return nil, fmt.Errorf("failed to read API key file %s: %w", authFile, err)
+	}
 
 	var apiKeyData struct {
 		APIKey string `json:"api_key"`
@@ -857,7 +1356,7 @@ func NewGen3AuthManager(client *http.Client, authFile string) (*Gen3AuthManager,
 // GetAccessToken retrieves a token for a given Gen3 host, using the cache if possible.
 func (m *Gen3AuthManager) GetAccessToken(commonsURL string) (string, error) {
 	if m.apiKey == "" {
-		return "", fmt.Errorf("Gen3 authentication requires an API key, but none was provided")
+		return "", fmt.Errorf("%w: Gen3 authentication requires an API key, but none was provided", ErrAuthFailed)
 	}
 
 	m.mu.Lock()
@@ -895,14 +1394,14 @@ func getGen3AccessToken(client *http.Client, commonsURL, apiKey string) (string,
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := client.Do(req)
+	resp, err := client.Do(withCredentials(req))
 	if err != nil {
 		return "", fmt.Errorf("failed to make request for access token: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Gen3 access token endpoint returned status %s", resp.Status)
+		return "", fmt.Errorf("%w: Gen3 access token endpoint returned status %s", ErrAuthFailed, responseStatus(resp))
 	}
 
 	var result map[string]string
@@ -915,7 +1414,7 @@ func getGen3AccessToken(client *http.Client, commonsURL, apiKey string) (string,
 		return "", fmt.Errorf("no 'access_token' found in Gen3 response")
 	}
 
-	logger.Infof("Successfully retrieved Gen3 access token: %s", accessToken)
+	logger.Debugf("Retrieved Gen3 access token for %s", commonsURL)
 	return accessToken, nil
 }
 
@@ -932,18 +1431,13 @@ func getGen3DownloadURL(client *http.Client, commonsURL, objectID string, gen3Au
 	if gen3Auth != nil && gen3Auth.apiKey != "" {
 		accessToken, err := gen3Auth.GetAccessToken(commonsURL)
 		if err != nil {
-			return "", fmt.Errorf("failed to get access token for %s: %v", commonsURL, err)
+			return "", fmt.Errorf("failed to get access token for %s: %w", commonsURL, err)
 		}
 		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 	}
 
-	// Log the request for debugging
-	logger.Warnf("Gen3 API Request URL: %s", req.URL.String())
-	for name, headers := range req.Header {
-		for _, h := range headers {
-			logger.Warnf("Gen3 API Request Header: %s: %s", name, h)
-		}
-	}
+	// The headers are not logged, they carry the access token
+	logger.Debugf("Gen3 API Request URL: %s", req.URL.String())
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -952,7 +1446,7 @@ func getGen3DownloadURL(client *http.Client, commonsURL, objectID string, gen3Au
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Gen3 API returned status %s", resp.Status)
+		return "", fmt.Errorf("Gen3 API returned status %s", responseStatus(resp))
 	}
 
 	var result map[string]interface{}
@@ -974,68 +1468,151 @@ func getGen3DownloadURL(client *http.Client, commonsURL, objectID string, gen3Au
 	return accessURL, nil
 }
 
+// objectRequest returns the HTTPS URL a direct download is fetched from and how its
+// requests are authorized, nil when they are sent as they are. Azure blobs and GCS
+// objects are read over HTTPS with the credentials of their store.
+func objectRequest(uri string, options *Options) (string, func(*http.Request) error, error) {
+	switch {
+	case isAzureURL(uri):
+		blob, err := parseAzureURL(uri)
+		if err != nil {
+			return "", nil, err
+		}
+		return blob.requestURL(blob.Name, nil), blob.authorize, nil
+	case isGCSURL(uri):
+		object, err := parseGCSURL(uri)
+		if err != nil {
+			return "", nil, err
+		}
+		return object.mediaURL(object.Name), gcsAuthorizer(options), nil
+	}
+	return uri, nil, nil
+}
+
+// downloadObjects downloads the objects listed below a prefix of an object store
+// into the directory of the item. They are collected in a temporary directory,
+// which takes the place of the item once all are there, so that a run interrupted
+// halfway resumes with the missing ones.
+func (info *FileInfo) downloadObjects(output string, objects []*FileInfo, httpClient *http.Client, options *Options) error {
+	if len(objects) == 0 {
+		return fmt.Errorf("%w: no objects below %s", ErrSeriesNotFound, info.DownloadURL)
+	}
+	finalPath := info.directPath(output)
+	tempDir := transferTempPath(finalPath, ".partial")
+	for _, object := range objects {
+		if state, _ := object.LocalState(tempDir, false); state == StateComplete {
+			continue
+		}
+		if err := object.downloadDirect(tempDir, httpClient, options); err != nil {
+			return fmt.Errorf("%s: %w", object.SeriesUID, err)
+		}
+	}
+	if err := replaceDir(tempDir, finalPath); err != nil {
+		return fmt.Errorf("failed to move %s: %w", tempDir, err)
+	}
+	logger.Debugf("Downloaded %d objects of %s to %s", len(objects), info.DownloadURL, finalPath)
+	return nil
+}
+
 // downloadDirect downloads a file from a direct URL without decompression
-func (info *FileInfo) downloadDirect(output string, httpClient *http.Client) error {
+func (info *FileInfo) downloadDirect(output string, httpClient *http.Client, options *Options) (err error) {
+	if isFTPURL(info.DownloadURL) {
+		return info.downloadFTPFile(output, options)
+	}
 	logger.Debugf("Downloading direct from URL: %s", info.DownloadURL)
 
-	fileName := info.SeriesUID
-	if info.FileName != "" {
-		fileName = info.FileName
+	finalPath := info.directPath(output)
+	tempPath := transferTempPath(finalPath, ".tmp")
+	// Files of folder listings keep the subfolder they are in
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
 	}
-	finalPath := filepath.Join(output, fileName)
-	tempPath := finalPath + ".tmp"
 
-	// Clean up any previous temporary files
-	if _, err := os.Stat(tempPath); err == nil {
-		logger.Debugf("Removing incomplete download: %s", tempPath)
-		os.Remove(tempPath)
+	requestURL, authorize, err := objectRequest(info.DownloadURL, options)
+	if err != nil {
+		return err
 	}
-
-	req, err := http.NewRequest("GET", info.DownloadURL, nil)
+	req, err := http.NewRequest("GET", requestURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
+	// Drop or continue the partial file of an earlier attempt
+	offset := resumeOffset(req, tempPath, options)
+	if offset > 0 && info.DRSURI == "" {
+		urlHeads.SetIfRange(req, info.DownloadURL)
+	}
 
-	// Use a reasonable timeout for direct downloads
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	// Deadline of the whole transfer; stalls are caught much earlier with --stall-timeout
+	ctx, cancel := context.WithTimeout(context.Background(), info.transferTimeout(options))
 	defer cancel()
 	req = req.WithContext(ctx)
+	if authorize != nil {
+		if err := authorize(req); err != nil {
+			return err
+		}
+	}
 
 	resp, err := doRequest(httpClient, req)
 	if err != nil {
-		return fmt.Errorf("failed to do request: %v", err)
+		return fmt.Errorf("failed to do request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP error %d: %s", resp.StatusCode, resp.Status)
+	if err := transferStatus(resp, tempPath, offset); err != nil {
+		return err
+	}
+	// Presigned URLs of DRS objects change with every resolution
+	if info.DRSURI == "" {
+		urlHeads.SetDownloaded(info.DownloadURL, headOf(resp))
+	}
+	if info.FileName == "" {
+		finalPath = info.namedPath(output, resp)
 	}
 
-	f, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	md5Hasher, sha256Hasher := info.directHashers()
+	f, offset, err := openTransferFile(tempPath, resp, offset, md5Hasher, sha256Hasher)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %v", err)
+		return fmt.Errorf("failed to open file: %w", err)
 	}
+	keepPartial := false
 	defer func() {
 		f.Close()
-		if err != nil {
+		if err != nil && !keepPartial {
 			os.Remove(tempPath)
 		}
 	}()
 
-	written, err := io.Copy(f, resp.Body)
+	body := newStallReader(resp.Body, options.StallTimeout, cancel)
+	defer body.Stop()
+	written, err := io.Copy(io.MultiWriter(f, hashWriter(md5Hasher, sha256Hasher)), &countingReader{r: body, info: info})
+	// Failed attempts count too, the bytes were transferred
+	if err != nil {
+		recordEgress(info.DownloadURL, 0, written)
+	} else {
+		recordEgress(info.DownloadURL, 1, written)
+	}
+	written += offset
 	if err != nil {
-		return fmt.Errorf("failed to write data after %d bytes: %v", written, err)
+		keepPartial = options.IfExists == ifExistsResume
+		return fmt.Errorf("failed to write data after %d bytes: %w", written, err)
 	}
 
 	logger.Debugf("Downloaded %d bytes for %s", written, info.SeriesUID)
 
 	if err := f.Close(); err != nil {
-		return fmt.Errorf("failed to close file: %v", err)
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	// Checksums from the manifest, e.g. of a DRS manifest
+	if err = info.verifyDirect(written, md5Hasher, sha256Hasher); err != nil {
+		return err
 	}
 
 	// Atomic rename to final location
-	if err := os.Rename(tempPath, finalPath); err != nil {
-		return fmt.Errorf("failed to move file: %v", err)
+	if err := renameFile(tempPath, finalPath); err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	if sha256Hasher != nil && checksums != nil {
+		checksums.Add(finalPath, hex.EncodeToString(sha256Hasher.Sum(nil)))
 	}
 
 	logger.Debugf("Successfully saved %s as %s", info.SeriesUID, finalPath)
@@ -1043,10 +1620,10 @@ func (info *FileInfo) downloadDirect(output string, httpClient *http.Client) err
 }
 
 // downloadFromTCIA performs the actual download from TCIA, with decompression
-func (info *FileInfo) downloadFromTCIA(output string, httpClient *http.Client, authToken *Token, options *Options) error {
+func (info *FileInfo) downloadFromTCIA(output string, httpClient *http.Client, authToken *Token, options *Options) (err error) {
 	logger.Debugf("getting image file to %s", output)
 
-	url_, err := makeURL(ImageUrl, map[string]interface{}{"SeriesInstanceUID": info.SeriesUID})
+	url_, err := makeURL(endpoints.Image, imageQuery(info.SeriesUID, options))
 	if err != nil {
 		return fmt.Errorf("failed to make URL: %v", err)
 	}
@@ -1055,25 +1632,23 @@ func (info *FileInfo) downloadFromTCIA(output string, httpClient *http.Client, a
 	var finalPath string
 	var tempZipPath string
 
-	if options.NoDecompress {
+	if options.NoDecompress && options.ArchiveFormat != "zip" {
+		// Convert the ZIP into a tar archive
+		finalPath = info.DcimFiles(output) + seriesArchiveExt
+		tempZipPath = transferTempPath(finalPath, ".zip.tmp")
+	} else if options.NoDecompress {
 		// Keep as ZIP file
 		finalPath = info.DcimFiles(output) + ".zip"
-		tempZipPath = finalPath + ".tmp"
+		tempZipPath = transferTempPath(finalPath, ".tmp")
 	} else {
 		// Extract to directory
 		finalPath = info.DcimFiles(output)
-		tempZipPath = finalPath + ".zip.tmp"
-	}
-
-	// Clean up any previous temporary files
-	if _, err := os.Stat(tempZipPath); err == nil {
-		logger.Debugf("Removing incomplete download: %s", tempZipPath)
-		os.Remove(tempZipPath)
+		tempZipPath = transferTempPath(finalPath, ".zip.tmp")
 	}
 
 	// For extraction mode, also clean up temporary extraction directory
 	if !options.NoDecompress {
-		tempExtractDir := finalPath + ".uncompressed.tmp"
+		tempExtractDir := transferTempPath(finalPath, ".uncompressed.tmp")
 		if _, err := os.Stat(tempExtractDir); err == nil {
 			logger.Debugf("Removing incomplete extraction: %s", tempExtractDir)
 			os.RemoveAll(tempExtractDir)
@@ -1085,35 +1660,19 @@ func (info *FileInfo) downloadFromTCIA(output string, httpClient *http.Client, a
 		return fmt.Errorf("failed to create request: %v", err)
 	}
 
-	// Get current access token
-	accessToken, err := authToken.GetAccessToken()
-	if err != nil {
-		return fmt.Errorf("failed to get access token: %v", err)
-	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	// Drop or continue the partial ZIP of an earlier attempt
+	offset := resumeOffset(req, tempZipPath, options)
 
 	// Set timeout based on file size (if known)
-	var timeout time.Duration
-	if info.FileSize != "" {
-		fileSize, _ := strconv.ParseInt(info.FileSize, 10, 64)
-		// Calculate timeout: base 5 minutes + 1 minute per 100MB
-		timeout = 5*time.Minute + time.Duration(fileSize/(100*1024*1024))*time.Minute
-		// Cap at 60 minutes for very large files
-		if timeout > 60*time.Minute {
-			timeout = 60 * time.Minute
-		}
-	} else {
-		// Default timeout for unknown size
-		timeout = 30 * time.Minute
-	}
+	timeout := info.transferTimeout(options)
 	logger.Debugf("Setting download timeout to %v for %s", timeout, info.SeriesUID)
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	req = req.WithContext(ctx)
 
-	resp, err := doRequest(httpClient, req)
+	resp, err := doAuthorizedRequest(httpClient, req, authToken)
 	if err != nil {
-		return fmt.Errorf("failed to do request: %v", err)
+		return fmt.Errorf("failed to do request: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -1122,39 +1681,57 @@ func (info *FileInfo) downloadFromTCIA(output string, httpClient *http.Client, a
 		info.SeriesUID, resp.Status, resp.ContentLength, resp.Header.Get("Transfer-Encoding"))
 
 	// Check HTTP status
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP error %d: %s", resp.StatusCode, resp.Status)
+	if err := transferStatus(resp, tempZipPath, offset); err != nil {
+		return err
+	}
+
+	// Hash the ZIP while writing when it is kept as-is; extracted files are hashed during extraction
+	var sha256Hasher hash.Hash
+	if checksums != nil && options.NoDecompress && options.ArchiveFormat == "zip" {
+		sha256Hasher = newSHA256Hash()
 	}
 
-	// Create new temp ZIP file
-	f, err := os.OpenFile(tempZipPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	// Create new temp ZIP file, or continue a partial one
+	f, offset, err := openTransferFile(tempZipPath, resp, offset, sha256Hasher)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %v", err)
+		return fmt.Errorf("failed to open file: %w", err)
 	}
+	keepPartial := false
 	defer func() {
 		f.Close()
 		// Clean up temp files on error
 		if err != nil {
-			os.Remove(tempZipPath)
+			if !keepPartial {
+				os.Remove(tempZipPath)
+			}
 			if !options.NoDecompress {
-				tempExtractDir := finalPath + ".uncompressed.tmp"
+				tempExtractDir := transferTempPath(finalPath, ".uncompressed.tmp")
 				os.RemoveAll(tempExtractDir)
 			}
 		}
 	}()
 
 	// Log download start
-	if resp.ContentLength > 0 {
+	if offset > 0 {
+		logger.Debugf("Resuming %s after %d bytes", info.SeriesUID, offset)
+	} else if resp.ContentLength > 0 {
 		logger.Debugf("Downloading %s (size: %d bytes)", info.SeriesUID, resp.ContentLength)
 	} else {
 		logger.Debugf("Downloading %s (size: unknown)", info.SeriesUID)
 	}
 
 	// Buffer the response body for better handling of chunked transfers
-	bufferedReader := bufio.NewReaderSize(resp.Body, 64*1024) // 64KB buffer
+	body := newStallReader(resp.Body, options.StallTimeout, cancel)
+	defer body.Stop()
+	bufferedReader := bufio.NewReaderSize(&countingReader{r: body, info: info}, 64*1024) // 64KB buffer
+
+	var writer io.Writer = f
+	if sha256Hasher != nil {
+		writer = io.MultiWriter(f, sha256Hasher)
+	}
 
 	// Download without progress bar
-	written, err := io.Copy(f, bufferedReader)
+	written, err := io.Copy(writer, bufferedReader)
 	if err != nil {
 		// Log detailed error information
 		logger.Errorf("Download error for %s: %v (written=%d bytes)", info.SeriesUID, err, written)
@@ -1162,8 +1739,10 @@ func (info *FileInfo) downloadFromTCIA(output string, httpClient *http.Client, a
 		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			logger.Errorf("Connection closed prematurely by server for %s", info.SeriesUID)
 		}
-		return fmt.Errorf("failed to write data after %d bytes: %v", written, err)
+		keepPartial = options.IfExists == ifExistsResume
+		return fmt.Errorf("failed to write data after %d bytes: %w", offset+written, err)
 	}
+	written += offset
 
 	logger.Debugf("Downloaded %d bytes for %s", written, info.SeriesUID)
 
@@ -1179,88 +1758,137 @@ func (info *FileInfo) downloadFromTCIA(output string, httpClient *http.Client, a
 
 	// Close ZIP file before extraction
 	if err := f.Close(); err != nil {
-		return fmt.Errorf("failed to close file: %v", err)
+		return fmt.Errorf("failed to close file: %w", err)
 	}
 
-	if options.NoDecompress {
+	if options.NoDecompress && options.ArchiveFormat == "zip" {
 		// No decompression mode: just move the ZIP file to final location
 
 		// Remove any existing file
 		if _, err := os.Stat(finalPath); err == nil {
 			logger.Debugf("Removing existing file: %s", finalPath)
 			if err := os.Remove(finalPath); err != nil {
-				return fmt.Errorf("failed to remove existing file: %v", err)
+				return fmt.Errorf("failed to remove existing file: %w", err)
 			}
 		}
 
 		// Atomic rename from temp to final location
-		if err := os.Rename(tempZipPath, finalPath); err != nil {
-			return fmt.Errorf("failed to move ZIP file: %v", err)
+		if err := renameFile(tempZipPath, finalPath); err != nil {
+			return fmt.Errorf("failed to move ZIP file: %w", err)
+		}
+		if sha256Hasher != nil {
+			checksums.Add(finalPath, hex.EncodeToString(sha256Hasher.Sum(nil)))
 		}
 
 		logger.Debugf("Successfully saved %s as %s", info.SeriesUID, finalPath)
 		return nil
-	} else {
-		// Decompression mode: extract and verify
-		tempExtractDir := finalPath + ".uncompressed.tmp"
+	}
 
-		// Extract and verify the ZIP file
-		expectedSize := int64(0)
-		if info.FileSize != "" {
-			expectedSize, _ = strconv.ParseInt(info.FileSize, 10, 64)
+	// Extraction and conversion are CPU-bound: with an extraction pool the worker
+	// hands them over and moves on to the next download
+	finalize := func() error { return info.extractSeries(tempZipPath, finalPath, output, options) }
+	if options.NoDecompress {
+		finalize = func() error { return info.convertSeriesZip(tempZipPath, finalPath, options) }
+	}
+	if extractPool == nil || info.inlineExtract {
+		return finalize()
+	}
+	info.pendingExtract = func() error {
+		err := finalize()
+		if err != nil {
+			// A retry downloads the series again rather than resuming this ZIP
+			os.Remove(tempZipPath)
 		}
+		return err
+	}
+	return nil
+}
 
-		// Parse MD5 hashes if MD5 validation is enabled (default)
-		var md5Map map[string]string
-		if !options.NoMD5 {
-			md5Map, err = parseMD5HashesCSV(tempZipPath)
-			if err != nil {
-				logger.Warnf("Failed to parse MD5 hashes: %v", err)
-				// Continue without MD5 validation
-				md5Map = nil
-			}
-		}
+// extractSeries extracts a downloaded series ZIP into its final directory, verifying
+// the size and the MD5 hashes bundled in the ZIP
+func (info *FileInfo) extractSeries(tempZipPath, finalPath, output string, options *Options) error {
+	tempExtractDir := transferTempPath(finalPath, ".uncompressed.tmp")
 
-		logger.Debugf("Extracting %s to %s", tempZipPath, tempExtractDir)
-		if err := extractAndVerifyZip(tempZipPath, tempExtractDir, expectedSize, md5Map); err != nil {
-			// Clean up temp files on extraction failure
-			logger.Errorf("Extraction failed, cleaning up temporary files")
-			if removeErr := os.Remove(tempZipPath); removeErr != nil {
-				logger.Warnf("Failed to remove temp ZIP after extraction error: %v", removeErr)
-			}
-			if removeErr := os.RemoveAll(tempExtractDir); removeErr != nil {
-				logger.Warnf("Failed to remove temp extract dir after error: %v", removeErr)
-			}
-			return fmt.Errorf("failed to extract/verify ZIP: %v", err)
+	// Extract and verify the ZIP file
+	expectedSize := int64(0)
+	if info.FileSize != "" {
+		expectedSize, _ = strconv.ParseInt(info.FileSize, 10, 64)
+	}
+
+	// Parse MD5 hashes if MD5 validation is enabled (default)
+	var md5Map map[string]string
+	if !options.NoMD5 {
+		var err error
+		md5Map, err = parseMD5HashesCSV(tempZipPath)
+		if err != nil {
+			logger.Warnf("Failed to parse MD5 hashes: %v", err)
+			// Continue without MD5 validation
+			md5Map = nil
 		}
+	}
 
-		// Remove any existing output directory
-		if _, err := os.Stat(finalPath); err == nil {
-			logger.Debugf("Removing existing directory: %s", finalPath)
-			if err := os.RemoveAll(finalPath); err != nil {
-				return fmt.Errorf("failed to remove existing directory: %v", err)
-			}
+	var sha256Sums map[string]string
+	if checksums != nil {
+		sha256Sums = make(map[string]string)
+	}
+
+	logger.Debugf("Extracting %s to %s", tempZipPath, tempExtractDir)
+	if err := extractAndVerifyZip(tempZipPath, tempExtractDir, expectedSize, md5Map, sha256Sums); err != nil {
+		// Clean up temp files on extraction failure
+		logger.Errorf("Extraction failed, cleaning up temporary files")
+		if removeErr := os.Remove(tempZipPath); removeErr != nil {
+			logger.Warnf("Failed to remove temp ZIP after extraction error: %v", removeErr)
+		}
+		if removeErr := os.RemoveAll(tempExtractDir); removeErr != nil {
+			logger.Warnf("Failed to remove temp extract dir after error: %v", removeErr)
 		}
+		events.Record(Event{Action: "verify", SeriesUID: info.SeriesUID, Error: err.Error()})
+		return fmt.Errorf("failed to extract/verify ZIP: %w", err)
+	}
+	if len(md5Map) > 0 {
+		events.Record(Event{Action: "verify", SeriesUID: info.SeriesUID, Detail: fmt.Sprintf("MD5 of %d files verified", len(md5Map))})
+	}
 
-		// Atomic rename from temp extraction to final location
-		if err := os.Rename(tempExtractDir, finalPath); err != nil {
-			// Clean up on rename failure
-			logger.Errorf("Rename failed, cleaning up temporary files")
-			if removeErr := os.RemoveAll(tempExtractDir); removeErr != nil {
-				logger.Warnf("Failed to remove temp extract dir after rename error: %v", removeErr)
-			}
-			if removeErr := os.Remove(tempZipPath); removeErr != nil {
-				logger.Warnf("Failed to remove temp ZIP after rename error: %v", removeErr)
+	// The entry names of NBIA ZIPs say nothing of the instances
+	if options.Rename != nil {
+		renamed, err := renameInstances(tempExtractDir, options.Rename)
+		if err != nil {
+			os.RemoveAll(tempExtractDir)
+			return fmt.Errorf("failed to rename extracted files: %w", err)
+		}
+		if sha256Sums != nil {
+			sums := make(map[string]string, len(sha256Sums))
+			for name, sum := range sha256Sums {
+				if newName, ok := renamed[filepath.FromSlash(name)]; ok {
+					name = newName
+				}
+				sums[name] = sum
 			}
-			return fmt.Errorf("failed to move extracted files: %v", err)
+			sha256Sums = sums
 		}
+	}
 
-		// Clean up the temporary ZIP file
-		if err := os.Remove(tempZipPath); err != nil {
-			logger.Warnf("Failed to remove temporary ZIP file %s: %v", tempZipPath, err)
+	// Replace any existing output directory, e.g. with --force
+	if err := replaceDir(tempExtractDir, finalPath); err != nil {
+		// Clean up on rename failure
+		logger.Errorf("Rename failed, cleaning up temporary files")
+		if removeErr := os.RemoveAll(tempExtractDir); removeErr != nil {
+			logger.Warnf("Failed to remove temp extract dir after rename error: %v", removeErr)
+		}
+		if removeErr := os.Remove(tempZipPath); removeErr != nil {
+			logger.Warnf("Failed to remove temp ZIP after rename error: %v", removeErr)
 		}
+		return fmt.Errorf("failed to move extracted files: %w", err)
+	}
+	checksums.ReplaceDir(finalPath, sha256Sums)
+	// A fresh copy has not been de-identified yet
+	os.Remove(deidRecordPath(output, info.SeriesUID))
 
-		logger.Debugf("Successfully extracted %s to %s", info.SeriesUID, finalPath)
-		return nil
+	// Clean up the temporary ZIP file
+	if err := os.Remove(tempZipPath); err != nil {
+		logger.Warnf("Failed to remove temporary ZIP file %s: %v", tempZipPath, err)
 	}
+
+	logger.Debugf("Successfully extracted %s to %s", info.SeriesUID, finalPath)
+	return nil
 }
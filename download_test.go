@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// benchmarkSeriesZip writes a series ZIP of files of size bytes, half random and half
+// zeros so that they compress about as well as DICOM pixel data, and returns their
+// MD5s as md5hashes.csv lists them
+func benchmarkSeriesZip(b *testing.B, files, size int) (string, map[string]string) {
+	b.Helper()
+	path := filepath.Join(b.TempDir(), "series.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	md5Map := make(map[string]string, files)
+	random := rand.New(rand.NewSource(1))
+	data := make([]byte, size)
+	for i := 0; i < files; i++ {
+		random.Read(data[:size/2])
+		name := fmt.Sprintf("1-%03d.dcm", i+1)
+		entry, err := w.Create(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := entry.Write(data); err != nil {
+			b.Fatal(err)
+		}
+		sum := md5.Sum(data)
+		md5Map[name] = hex.EncodeToString(sum[:])
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return path, md5Map
+}
+
+// BenchmarkExtractZip extracts and verifies a series ZIP with MD5 and SHA-256, one
+// file after the other (--hash-workers 0) and on hash workers, whose default is
+// runtime.NumCPU()
+func BenchmarkExtractZip(b *testing.B) {
+	logger = zap.NewNop().Sugar()
+	const files, size = 64, 512 << 10
+	zipPath, md5Map := benchmarkSeriesZip(b, files, size)
+
+	counts := []int{0, 2, 4}
+	if n := runtime.NumCPU(); n != 2 && n != 4 {
+		counts = append(counts, n)
+	}
+	for _, workers := range counts {
+		name := "serial"
+		if workers > 0 {
+			name = fmt.Sprintf("workers-%d", workers)
+		}
+		b.Run(name, func(b *testing.B) {
+			if workers > 0 {
+				hashPool = NewHashPool(workers)
+				defer func() {
+					hashPool.Close()
+					hashPool = nil
+				}()
+			}
+			dest := b.TempDir()
+			b.SetBytes(files * size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				dir := filepath.Join(dest, fmt.Sprint(i))
+				if err := extractAndVerifyZip(zipPath, dir, 0, md5Map, make(map[string]string)); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				os.RemoveAll(dir)
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// drsChecksum is a GA4GH DRS checksum entry
+type drsChecksum struct {
+	Type     string `json:"type"`
+	Checksum string `json:"checksum"`
+}
+
+// drsManifestEntry is an object of a DRS manifest. It covers the file manifests of
+// Gen3/CRDC portals (object_id, file_name, file_size, md5sum) as well as GA4GH DRS
+// objects and bundles (id, self_uri, name, size, checksums, contents).
+type drsManifestEntry struct {
+	ObjectID  string             `json:"object_id"`
+	ID        string             `json:"id"`
+	SelfURI   string             `json:"self_uri"`
+	DRSURI    json.RawMessage    `json:"drs_uri"` // a string or a list of strings
+	FileName  string             `json:"file_name"`
+	Name      string             `json:"name"`
+	FileSize  int64              `json:"file_size"`
+	Size      int64              `json:"size"`
+	MD5Sum    string             `json:"md5sum"`
+	Checksums []drsChecksum      `json:"checksums"`
+	Contents  []drsManifestEntry `json:"contents"`
+}
+
+// drsManifestKeys are top-level keys that mark a JSON object as a DRS object or
+// bundle rather than a query file
+var drsManifestKeys = []string{"contents", "self_uri", "drs_uri", "object_id", "checksums"}
+
+// isDRSManifest reports whether a JSON input is a DRS manifest: a list of objects,
+// or a single DRS object or bundle
+func isDRSManifest(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		return true
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return false
+	}
+	for _, key := range drsManifestKeys {
+		if _, ok := raw[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// uri returns the drs:// URI of an entry. Bare object IDs, as listed by Gen3
+// portals, are resolved on host.
+func (e *drsManifestEntry) uri(host string) (string, error) {
+	candidates := []string{e.SelfURI}
+	var uris []string
+	if err := json.Unmarshal(e.DRSURI, &uris); err == nil {
+		candidates = append(candidates, uris...)
+	} else {
+		var single string
+		if json.Unmarshal(e.DRSURI, &single) == nil {
+			candidates = append(candidates, single)
+		}
+	}
+	candidates = append(candidates, e.ObjectID, e.ID)
+
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, "drs://") {
+			return candidate, nil
+		}
+	}
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if host == "" {
+			return "", fmt.Errorf("object %s has no drs:// URI; set --drs-host to the data commons serving it", candidate)
+		}
+		return fmt.Sprintf("drs://%s/%s", host, candidate), nil
+	}
+	return "", fmt.Errorf("entry without object ID or DRS URI")
+}
+
+// checksum returns the checksum of the given type (md5, sha256) of an entry
+func (e *drsManifestEntry) checksum(kind string) string {
+	if kind == "md5" && e.MD5Sum != "" {
+		return strings.ToLower(e.MD5Sum)
+	}
+	for _, c := range e.Checksums {
+		if strings.EqualFold(strings.ReplaceAll(c.Type, "-", ""), kind) {
+			return strings.ToLower(c.Checksum)
+		}
+	}
+	return ""
+}
+
+// collect appends the file objects of an entry, descending into bundles
+func (e *drsManifestEntry) collect(host string, files []*FileInfo) ([]*FileInfo, error) {
+	if len(e.Contents) > 0 {
+		for i := range e.Contents {
+			var err error
+			if files, err = e.Contents[i].collect(host, files); err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+
+	uri, err := e.uri(host)
+	if err != nil {
+		return nil, err
+	}
+	name := e.FileName
+	if name == "" {
+		name = e.Name
+	}
+	info := &FileInfo{
+		DRSURI:     uri,
+		SeriesUID:  filepath.Base(uri),
+		MD5Hash:    e.checksum("md5"),
+		SHA256Hash: e.checksum("sha256"),
+	}
+	// Unnamed objects are named from the server response
+	if name != "" {
+		info.FileName = filepath.Base(name)
+	}
+	if size := max(e.FileSize, e.Size); size > 0 {
+		info.FileSize = strconv.FormatInt(size, 10)
+	}
+	return append(files, info), nil
+}
+
+// decodeDRSManifest reads a DRS manifest into Gen3/DRS download items
+func decodeDRSManifest(path, host string) ([]*FileInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []drsManifestEntry
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &entries)
+	} else {
+		var entry drsManifestEntry
+		err = json.Unmarshal(data, &entry)
+		entries = []drsManifestEntry{entry}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DRS manifest %s: %v", path, err)
+	}
+
+	var files []*FileInfo
+	for i := range entries {
+		if files, err = entries[i].collect(host, files); err != nil {
+			return nil, fmt.Errorf("DRS manifest %s: %v", path, err)
+		}
+	}
+	fmt.Printf("Found %d DRS objects in %s\n", len(files), path)
+	return files, nil
+}
+
+// decodeJSONInput decodes a JSON input, which is either a DRS manifest or a query file
+func decodeJSONInput(path string, client *http.Client, token *Token, options *Options) ([]*FileInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isDRSManifest(data) {
+		return decodeDRSManifest(path, options.DRSHost)
+	}
+	return decodeQueryFile(path, client, token, options)
+}
+
+// directHashers returns the hashers needed to verify a direct download
+func (info *FileInfo) directHashers() (md5Hasher, sha256Hasher hash.Hash) {
+	if info.MD5Hash != "" {
+		md5Hasher = md5.New()
+	}
+	if info.SHA256Hash != "" || checksums != nil {
+		sha256Hasher = newSHA256Hash()
+	}
+	return md5Hasher, sha256Hasher
+}
+
+// verifyDirect checks a direct download against the size and checksums from its manifest
+func (info *FileInfo) verifyDirect(written int64, md5Hasher, sha256Hasher hash.Hash) error {
+	if info.FileSize != "" {
+		if expected, err := strconv.ParseInt(info.FileSize, 10, 64); err == nil && expected != written {
+			return fmt.Errorf("%w: expected %d bytes, downloaded %d bytes", ErrChecksumMismatch, expected, written)
+		}
+	}
+	if md5Hasher != nil {
+		if actual := hex.EncodeToString(md5Hasher.Sum(nil)); actual != strings.ToLower(info.MD5Hash) {
+			return fmt.Errorf("%w: MD5 %s, expected %s", ErrChecksumMismatch, actual, info.MD5Hash)
+		}
+	}
+	if sha256Hasher != nil && info.SHA256Hash != "" {
+		if actual := hex.EncodeToString(sha256Hasher.Sum(nil)); actual != strings.ToLower(info.SHA256Hash) {
+			return fmt.Errorf("%w: SHA-256 %s, expected %s", ErrChecksumMismatch, actual, info.SHA256Hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EgressSource is the data transferred out of one cloud storage bucket. Bytes
+// include the partial transfers of failed attempts, which are billed as well.
+type EgressSource struct {
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	Objects  int64  `json:"objects"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// key identifies the source for aggregation
+func (s EgressSource) key() string {
+	return s.Endpoint + "/" + s.Bucket
+}
+
+// cloudSource returns the endpoint and bucket of an S3, GCS or Azure URL: s3://, gs://
+// and az:// URIs as well as virtual-hosted and path-style HTTPS URLs, e.g. presigned URLs
+// returned by DRS servers. Other URLs are not cloud storage.
+func cloudSource(rawURL string) (endpoint, bucket string, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", "", false
+	}
+	firstSegment := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)[0]
+	host := strings.ToLower(u.Hostname())
+
+	switch {
+	case u.Scheme == "s3":
+		// Fetched by s5cmd from the configured endpoint
+		if endpoint, err := url.Parse(endpoints.S3); err == nil && endpoint.Host != "" {
+			return endpoint.Host, u.Host, true
+		}
+		return endpoints.S3, u.Host, true
+	case u.Scheme == "gs":
+		return "storage.googleapis.com", u.Host, true
+	case u.Scheme == "az":
+		return u.Host + azureBlobSuffix, firstSegment, firstSegment != ""
+	case strings.HasSuffix(host, azureBlobSuffix):
+		return host, firstSegment, firstSegment != ""
+	case host == "storage.googleapis.com":
+		return host, firstSegment, firstSegment != ""
+	case strings.HasSuffix(host, ".storage.googleapis.com"):
+		return "storage.googleapis.com", strings.TrimSuffix(host, ".storage.googleapis.com"), true
+	case strings.HasSuffix(host, ".amazonaws.com"):
+		// bucket.s3.region.amazonaws.com or s3.region.amazonaws.com/bucket
+		if i := strings.Index(host, ".s3."); i > 0 {
+			return host[i+1:], host[:i], true
+		}
+		if i := strings.Index(host, ".s3-"); i > 0 {
+			return host[i+1:], host[:i], true
+		}
+		if strings.HasPrefix(host, "s3.") || strings.HasPrefix(host, "s3-") {
+			return host, firstSegment, firstSegment != ""
+		}
+	}
+	return "", "", false
+}
+
+// recordEgress adds a transfer from rawURL to the egress of the active run. URLs
+// that are not cloud storage are ignored.
+func recordEgress(rawURL string, objects, bytes int64) {
+	if activeStats == nil || (objects == 0 && bytes == 0) {
+		return
+	}
+	endpoint, bucket, ok := cloudSource(rawURL)
+	if !ok {
+		return
+	}
+	source := EgressSource{Endpoint: endpoint, Bucket: bucket}
+
+	activeStats.mu.Lock()
+	defer activeStats.mu.Unlock()
+	if activeStats.egress == nil {
+		activeStats.egress = make(map[string]*EgressSource)
+	}
+	total, ok := activeStats.egress[source.key()]
+	if !ok {
+		total = &source
+		activeStats.egress[source.key()] = total
+	}
+	total.Objects += objects
+	total.Bytes += bytes
+}
+
+// Egress returns the egress of the run per source, sorted by endpoint and bucket
+func (stats *DownloadStats) Egress() []EgressSource {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.egressSources()
+}
+
+// egressSources is Egress for callers holding stats.mu
+func (stats *DownloadStats) egressSources() []EgressSource {
+	var sources []EgressSource
+	for _, key := range sortedKeys(stats.egress) {
+		sources = append(sources, *stats.egress[key])
+	}
+	return sources
+}
+
+// mergeEgress sums the egress of several runs per source
+func mergeEgress(total []EgressSource, more []EgressSource) []EgressSource {
+	sums := make(map[string]*EgressSource)
+	for _, sources := range [][]EgressSource{total, more} {
+		for _, s := range sources {
+			sum, ok := sums[s.key()]
+			if !ok {
+				sum = &EgressSource{Endpoint: s.Endpoint, Bucket: s.Bucket}
+				sums[s.key()] = sum
+			}
+			sum.Objects += s.Objects
+			sum.Bytes += s.Bytes
+		}
+	}
+	merged := make([]EgressSource, 0, len(sums))
+	for _, key := range sortedKeys(sums) {
+		merged = append(merged, *sums[key])
+	}
+	return merged
+}
+
+// printEgressSummary prints the estimated egress per source and, with a price per
+// GB, its estimated cost
+func printEgressSummary(sources []EgressSource, pricePerGB float64) {
+	if len(sources) == 0 {
+		return
+	}
+	fmt.Println("\nEstimated cloud egress:")
+	var totalBytes int64
+	for _, s := range sources {
+		fmt.Printf("  %s/%s: %s in %d objects%s\n", s.Endpoint, s.Bucket, formatBytes(s.Bytes), s.Objects, egressCost(s.Bytes, pricePerGB))
+		totalBytes += s.Bytes
+	}
+	if len(sources) > 1 {
+		fmt.Printf("  Total: %s%s\n", formatBytes(totalBytes), egressCost(totalBytes, pricePerGB))
+	}
+}
+
+// egressCost formats the estimated cost of a transfer at pricePerGB (10^9 bytes)
+func egressCost(bytes int64, pricePerGB float64) string {
+	if pricePerGB <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (~$%.2f)", float64(bytes)/1e9*pricePerGB)
+}
+
+// fileState is the size and modification time of a file, used to tell which files
+// an s5cmd run wrote
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// dirFileStates lists the regular files below dir
+func dirFileStates(dir string) map[string]fileState {
+	states := make(map[string]fileState)
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			states[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+		}
+		return nil
+	})
+	return states
+}
+
+// writtenSince counts the files of dir that are new or changed compared to before,
+// and their bytes
+func writtenSince(dir string, before map[string]fileState) (objects, bytes int64) {
+	for path, state := range dirFileStates(dir) {
+		if previous, ok := before[path]; ok && previous.size == state.size && previous.modTime.Equal(state.modTime) {
+			continue
+		}
+		objects++
+		bytes += state.size
+	}
+	return objects, bytes
+}
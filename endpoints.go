@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// nbiaServicesURL is the base of the public NBIA v2 REST services
+const nbiaServicesURL = "https://services.cancerimagingarchive.net/nbia-api/services/v2"
+
+// Endpoints is the single registry of NBIA API URLs used by the retriever
+type Endpoints struct {
+	Token       string
+	Image       string
+	SingleImage string // single instances, next to the image endpoint
+	Meta        string
+	Series      string
+	Updated     string // series updated since a date, next to the series endpoint
+	Study       string
+	S3          string // S3 endpoint of s5cmd transfers
+	NBIAViewer  string // browser viewer of NBIA series, linked from the metadata
+	IDCViewer   string // OHIF viewer of the Imaging Data Commons
+	DataCite    string // DOI records of the collection citations
+}
+
+// DefaultEndpoints are the public NBIA endpoints
+var DefaultEndpoints = Endpoints{
+	Token:       "https://services.cancerimagingarchive.net/nbia-api/oauth/token",
+	Image:       nbiaServicesURL + "/getImage",
+	SingleImage: nbiaServicesURL + "/" + singleImageEndpoint,
+	Meta:        nbiaServicesURL + "/getSeriesMetaData",
+	Series:      nbiaServicesURL + "/getSeries",
+	Updated:     nbiaServicesURL + "/" + updatedSeriesEndpoint,
+	Study:       nbiaServicesURL + "/getPatientStudy",
+	S3:          "https://s3.amazonaws.com",
+	NBIAViewer:  "https://nbia.cancerimagingarchive.net/viewer/",
+	IDCViewer:   "https://viewer.imaging.datacommons.cancer.gov/v3/viewer/",
+	DataCite:    "https://api.datacite.org/dois/",
+}
+
+// nbiaViewerURL links a series to the NBIA viewer
+func nbiaViewerURL(seriesUID string) string {
+	if seriesUID == "" {
+		return ""
+	}
+	return endpoints.NBIAViewer + "?" + url.Values{"series": {seriesUID}}.Encode()
+}
+
+// idcViewerURL links a series to the OHIF viewer of IDC, which opens studies and
+// needs the StudyInstanceUID
+func idcViewerURL(studyUID, seriesUID string) string {
+	if studyUID == "" || seriesUID == "" {
+		return ""
+	}
+	return endpoints.IDCViewer + "?" + url.Values{"StudyInstanceUIDs": {studyUID}, "SeriesInstanceUIDs": {seriesUID}}.Encode()
+}
+
+// md5ImageEndpoint is the image endpoint that bundles md5hashes.csv in the ZIP
+const md5ImageEndpoint = "getImageWithMD5Hash"
+
+// singleImageEndpoint returns a single DICOM instance of a series
+const singleImageEndpoint = "getSingleImage"
+
+// updatedSeriesEndpoint lists the series added or changed since a date
+const updatedSeriesEndpoint = "getUpdatedSeries"
+
+// imageSelectorParams select the series of an image request and cannot be set
+// with --image-param
+var imageSelectorParams = map[string]bool{"seriesinstanceuid": true, "sopinstanceuid": true}
+
+// parseImageParams reads name=value query parameters of TCIA image requests. The
+// NBIA API documents includeAnnotation and NewFileNames; other names are passed on
+// as given, for servers that accept more.
+func parseImageParams(pairs []string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		switch {
+		case !ok || name == "":
+			return nil, fmt.Errorf("%q is not name=value", pair)
+		case imageSelectorParams[strings.ToLower(name)]:
+			return nil, fmt.Errorf("%s is set by the retriever", name)
+		}
+		if previous, set := params[name]; set && previous != value {
+			return nil, fmt.Errorf("%s is given as both %q and %q", name, previous, value)
+		}
+		params[name] = value
+	}
+	return params, nil
+}
+
+// imageQuery returns the query of the TCIA image request of a series
+func imageQuery(seriesUID string, options *Options) map[string]interface{} {
+	query := map[string]interface{}{"SeriesInstanceUID": seriesUID}
+	for name, value := range options.ImageParams {
+		query[name] = value
+	}
+	return query
+}
+
+// endpoints holds the URLs resolved for the current run
+var endpoints = DefaultEndpoints
+
+// endpointNames maps each registry field to the NBIA operations it may point at
+var endpointNames = map[string][]string{
+	"token":  {"token"},
+	"image":  {"getImage", md5ImageEndpoint},
+	"meta":   {"getSeriesMetaData", "getSeriesMetadata"},
+	"series": {"getSeries"},
+	"study":  {"getPatientStudy"},
+}
+
+// resolveEndpoints builds the endpoint registry from the command line options.
+// Custom URLs override the defaults; without a custom image URL the MD5 variant
+// is chosen unless --no-md5 is set.
+func resolveEndpoints(options *Options) Endpoints {
+	resolved := DefaultEndpoints
+
+	resolved.Token = resolveEndpoint("token", options.TokenUrl, DefaultEndpoints.Token)
+	resolved.Meta = resolveEndpoint("meta", options.MetaUrl, DefaultEndpoints.Meta)
+	resolved.Series = resolveEndpoint("series", options.SeriesUrl, DefaultEndpoints.Series)
+	if resolved.Series != DefaultEndpoints.Series {
+		// Updated series are listed next to the custom series endpoint
+		if u, err := url.Parse(resolved.Series); err == nil {
+			u.Path = path.Join(path.Dir(u.Path), updatedSeriesEndpoint)
+			u.RawQuery = ""
+			resolved.Updated = u.String()
+		}
+	}
+	resolved.Study = resolveEndpoint("study", options.StudyUrl, DefaultEndpoints.Study)
+	if options.S3Url != "" && options.S3Url != DefaultEndpoints.S3 {
+		resolved.S3 = options.S3Url
+		logger.Infof("Using custom S3 endpoint: %s", options.S3Url)
+	}
+
+	if options.ImageUrl != "" && options.ImageUrl != DefaultEndpoints.Image {
+		resolved.Image = resolveEndpoint("image", options.ImageUrl, DefaultEndpoints.Image)
+		// Single instances are served next to the custom image endpoint
+		if u, err := url.Parse(resolved.Image); err == nil {
+			u.Path = path.Join(path.Dir(u.Path), singleImageEndpoint)
+			u.RawQuery = ""
+			resolved.SingleImage = u.String()
+		}
+	} else if !options.NoMD5 {
+		// Try v2 API first for MD5 support (will fallback to v1 if needed)
+		resolved.Image = nbiaServicesURL + "/" + md5ImageEndpoint
+		logger.Infof("Using MD5 validation endpoint (v2 with v1 fallback)")
+	}
+
+	return resolved
+}
+
+// resolveEndpoint validates a custom endpoint URL. A URL that names another
+// registry operation (e.g. --meta-url pointing at getSeries) is a known mix-up;
+// it is mapped to the expected operation on the same base with a warning.
+func resolveEndpoint(name, custom, def string) string {
+	if custom == "" || custom == def {
+		return def
+	}
+
+	u, err := url.Parse(custom)
+	if err != nil {
+		logger.Warnf("Custom %s url %s could not be parsed: %v", name, custom, err)
+		return custom
+	}
+
+	operation := path.Base(u.Path)
+	for _, expected := range endpointNames[name] {
+		if strings.EqualFold(operation, expected) {
+			logger.Infof("Using custom %s url: %s", name, custom)
+			return custom
+		}
+	}
+
+	// The OAuth endpoint lives on a different path, so only remap service operations
+	for other, names := range endpointNames {
+		if other == name || other == "token" || name == "token" {
+			continue
+		}
+		for _, n := range names {
+			if strings.EqualFold(operation, n) {
+				u.Path = path.Join(path.Dir(u.Path), endpointNames[name][0])
+				logger.Warnf("Custom %s url %s points at the %s endpoint; using %s instead", name, custom, other, u.String())
+				return u.String()
+			}
+		}
+	}
+
+	logger.Warnf("Custom %s url %s does not look like an NBIA %s endpoint; using it as-is", name, custom, endpointNames[name][0])
+	return custom
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observeLogger replaces the logger for the test and returns the warnings it logs
+func observeLogger(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	core, logs := observer.New(zapcore.WarnLevel)
+	previous := logger
+	logger = zap.New(core).Sugar()
+	t.Cleanup(func() { logger = previous })
+	return logs
+}
+
+func TestResolveEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		image   string
+		meta    string
+		single  string
+		warning string // part of the expected warning, empty for none
+	}{
+		{
+			name:    "defaults",
+			options: Options{ImageUrl: DefaultEndpoints.Image, MetaUrl: DefaultEndpoints.Meta},
+			image:   nbiaServicesURL + "/" + md5ImageEndpoint,
+			meta:    DefaultEndpoints.Meta,
+			single:  DefaultEndpoints.SingleImage,
+		},
+		{
+			name:    "no-md5",
+			options: Options{ImageUrl: DefaultEndpoints.Image, MetaUrl: DefaultEndpoints.Meta, NoMD5: true},
+			image:   DefaultEndpoints.Image,
+			meta:    DefaultEndpoints.Meta,
+			single:  DefaultEndpoints.SingleImage,
+		},
+		{
+			name:    "custom image url",
+			options: Options{ImageUrl: "https://nbia.example.org/nbia-api/services/v1/getImage", MetaUrl: DefaultEndpoints.Meta},
+			image:   "https://nbia.example.org/nbia-api/services/v1/getImage",
+			meta:    DefaultEndpoints.Meta,
+			single:  "https://nbia.example.org/nbia-api/services/v1/" + singleImageEndpoint,
+		},
+		{
+			name:    "meta url pointing at getSeries",
+			options: Options{ImageUrl: DefaultEndpoints.Image, MetaUrl: "https://nbia.example.org/nbia-api/services/v2/getSeries"},
+			image:   nbiaServicesURL + "/" + md5ImageEndpoint,
+			meta:    "https://nbia.example.org/nbia-api/services/v2/getSeriesMetaData",
+			single:  DefaultEndpoints.SingleImage,
+			warning: "points at the series endpoint",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := observeLogger(t)
+			resolved := resolveEndpoints(&tt.options)
+			if resolved.Image != tt.image {
+				t.Errorf("image = %s, want %s", resolved.Image, tt.image)
+			}
+			if resolved.Meta != tt.meta {
+				t.Errorf("meta = %s, want %s", resolved.Meta, tt.meta)
+			}
+			if resolved.SingleImage != tt.single {
+				t.Errorf("single image = %s, want %s", resolved.SingleImage, tt.single)
+			}
+			if resolved.Token != DefaultEndpoints.Token || resolved.Series != DefaultEndpoints.Series || resolved.S3 != DefaultEndpoints.S3 {
+				t.Errorf("token, series and S3 endpoints changed: %+v", resolved)
+			}
+
+			warnings := logs.FilterLevelExact(zapcore.WarnLevel).All()
+			if tt.warning == "" {
+				if len(warnings) > 0 {
+					t.Errorf("unexpected warning %q", warnings[0].Message)
+				}
+				return
+			}
+			if len(warnings) != 1 || !strings.Contains(warnings[0].Message, tt.warning) {
+				t.Errorf("warnings = %v, want one containing %q", warnings, tt.warning)
+			}
+		})
+	}
+}
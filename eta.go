@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// throughputTimeConstant is how quickly the smoothed throughput follows changes;
+// samples older than a few time constants have little influence
+const throughputTimeConstant = 30 * time.Second
+
+// throughputEstimator is an exponentially weighted moving average of byte throughput
+type throughputEstimator struct {
+	rate      float64 // bytes per second
+	lastTime  time.Time
+	lastBytes int64
+}
+
+// Observe adds a sample of the cumulative byte counter and returns the smoothed rate.
+// The weight of each sample depends on the time since the previous one, so irregular
+// progress updates do not bias the average.
+func (e *throughputEstimator) Observe(now time.Time, bytes int64) float64 {
+	if e.lastTime.IsZero() {
+		e.lastTime, e.lastBytes = now, bytes
+		return e.rate
+	}
+	dt := now.Sub(e.lastTime).Seconds()
+	if dt <= 0 {
+		return e.rate
+	}
+	instant := float64(bytes-e.lastBytes) / dt
+	if e.rate == 0 {
+		e.rate = instant
+	} else {
+		alpha := 1 - math.Exp(-dt/throughputTimeConstant.Seconds())
+		e.rate += alpha * (instant - e.rate)
+	}
+	e.lastTime, e.lastBytes = now, bytes
+	return e.rate
+}
+
+// expectedBytes returns the size announced by the metadata, or 0 when unknown
+func (info *FileInfo) expectedBytes() int64 {
+	size, _ := strconv.ParseInt(info.FileSize, 10, 64)
+	return size
+}
+
+// initRemainingBytes records the expected bytes of all queued items
+func (stats *DownloadStats) initRemainingBytes(files []*FileInfo) {
+	var total int64
+	for _, info := range files {
+		total += info.expectedBytes()
+	}
+	atomic.StoreInt64(&stats.RemainingBytes, total)
+}
+
+// completeItem removes a processed item from the remaining bytes; downloaded items
+// also calibrate the ratio between transferred and expected bytes
+func (stats *DownloadStats) completeItem(info *FileInfo, downloaded bool) {
+	size := info.expectedBytes()
+	atomic.AddInt64(&stats.RemainingBytes, -size)
+	if downloaded {
+		atomic.AddInt64(&stats.CompletedExpectedBytes, size)
+	}
+}
+
+// estimateETA predicts the remaining time from the smoothed byte throughput and the
+// expected bytes still to transfer (caller must hold stats.mu). Metadata sizes are
+// uncompressed while the wire carries ZIPs, so the observed ratio scales them.
+// It returns false when there is no size information to base the estimate on.
+func (stats *DownloadStats) estimateETA(now time.Time) (time.Duration, bool) {
+	transferred := atomic.LoadInt64(&stats.BytesDownloaded)
+	rate := stats.throughput.Observe(now, transferred)
+	remaining := atomic.LoadInt64(&stats.RemainingBytes)
+	if remaining <= 0 || rate <= 0 {
+		return 0, false
+	}
+
+	ratio := 1.0
+	if completed := atomic.LoadInt64(&stats.CompletedExpectedBytes); completed > 0 && transferred > 0 {
+		ratio = float64(transferred) / float64(completed)
+		// In-flight bytes inflate the ratio early on; never assume expansion
+		ratio = math.Min(ratio, 1)
+	}
+	return time.Duration(float64(remaining) * ratio / rate * float64(time.Second)), true
+}
+
+// formatRate renders a throughput in binary units per second
+func formatRate(bytesPerSecond float64) string {
+	return fmt.Sprintf("%s/s", formatBytes(int64(bytesPerSecond)))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// eventsFile is the append-only audit log kept in the output directory across all runs
+const eventsFile = "events.jsonl"
+
+// events records actions of the current run; nil disables the audit log
+var events *EventLog
+
+// Event is a single line of events.jsonl
+type Event struct {
+	Time      time.Time `json:"time"`
+	RunID     string    `json:"run_id"`
+	Action    string    `json:"action"` // run_start, run_end, final_retry, courtesy, mirror, download, repair, sync, verify, deferred, unavailable, refused, validate, deidentify, dedup, store, repack
+	SeriesUID string    `json:"series_uid,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Code      string    `json:"code,omitempty"` // failure code (E_*) when Error is set
+}
+
+// EventLog appends events to events.jsonl. Each event is written with a single
+// write on an O_APPEND file, so lines from concurrent workers never interleave.
+type EventLog struct {
+	mu    sync.Mutex
+	f     *os.File
+	runID string
+}
+
+// OpenEventLog opens (or creates) the audit log of the output directory
+func OpenEventLog(output string) (*EventLog, error) {
+	f, err := os.OpenFile(filepath.Join(output, eventsFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	runID := fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), os.Getpid())
+	return &EventLog{f: f, runID: runID}, nil
+}
+
+// Record appends an event, filling in the time and run ID
+func (l *EventLog) Record(event Event) {
+	if l == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	event.RunID = l.runID
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		logger.Warnf("Failed to write %s: %v", eventsFile, err)
+	}
+}
+
+// Close closes the audit log
+func (l *EventLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
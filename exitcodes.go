@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Exit statuses of a run, so that workflow engines can branch on the outcome. Like
+// the failure codes they are part of the machine-readable interface: add new
+// statuses, but never renumber one.
+const (
+	ExitOK      = 0 // every item transferred, or the failures are within --fail-threshold
+	ExitError   = 1 // invalid options or input, and other fatal errors
+	ExitPartial = 2 // more items failed than --fail-threshold allows
+	ExitAuth    = 3 // as ExitPartial with E_AUTH failures, or no login at all
+	ExitDisk    = 4 // as ExitPartial with E_DISK failures, or the output is not writable
+	ExitAborted = 5 // the circuit breaker aborted the run, the server seems down
+	ExitRefused = 6 // items of collections not in --allowed-collections, nothing transferred
+
+	ExitDeidentify = 7 // series failed --deidentify and may still hold identifiers
+
+	// Interrupted runs exit with 128 plus the signal, as shells report them
+	ExitInterrupted = 130 // SIGINT, e.g. Ctrl+C
+	ExitTerminated  = 143 // SIGTERM, e.g. a job cancelled or timed out by the workflow engine
+)
+
+// FailThreshold is how many failed items a run tolerates before it exits with an
+// error status (--fail-threshold): a number of items, or a percentage of them
+type FailThreshold struct {
+	Count     int
+	Percent   float64 // used when IsPercent
+	IsPercent bool
+}
+
+// parseFailThreshold reads a --fail-threshold value such as 5% or 10
+func parseFailThreshold(value string) (FailThreshold, error) {
+	value = strings.TrimSpace(value)
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || p < 0 || p > 100 {
+			return FailThreshold{}, fmt.Errorf("%q is not a percentage between 0%% and 100%%", value)
+		}
+		return FailThreshold{Percent: p, IsPercent: true}, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return FailThreshold{}, fmt.Errorf("%q is neither a number of items nor a percentage such as 5%%", value)
+	}
+	return FailThreshold{Count: n}, nil
+}
+
+// Exceeded reports whether failed items out of total are more than tolerated
+func (t FailThreshold) Exceeded(failed, total int) bool {
+	if failed == 0 {
+		return false
+	}
+	if t.IsPercent {
+		return float64(failed)*100 > t.Percent*float64(total)
+	}
+	return failed > t.Count
+}
+
+// String returns the threshold as given
+func (t FailThreshold) String() string {
+	if t.IsPercent {
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	}
+	return strconv.Itoa(t.Count)
+}
+
+// runExitStatus returns the exit status of a run with these failures. Failed items
+// within the threshold do not fail the run; beyond it, credential and disk problems,
+// which fail every further attempt too, take precedence over other failures.
+func runExitStatus(failures []FailedItem, total int, threshold FailThreshold, aborted bool) int {
+	if aborted {
+		return ExitAborted
+	}
+	if !threshold.Exceeded(len(failures), total) {
+		return ExitOK
+	}
+	counts := countFailures(failures)
+	switch {
+	case counts[CodeAuth] > 0:
+		return ExitAuth
+	case counts[CodeDisk] > 0:
+		return ExitDisk
+	}
+	return ExitPartial
+}
+
+// fatalWithStatus logs a fatal error and exits with status, e.g. ExitAuth when the
+// login fails before anything is transferred
+func fatalWithStatus(status int, args ...interface{}) {
+	logger.Error(args...)
+	_ = logger.Sync()
+	os.Exit(status)
+}
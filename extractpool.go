@@ -0,0 +1,72 @@
+package main
+
+import "sync"
+
+// copyBufferMin is the least size of the buffers extracted files are written with;
+// it is rounded up to a multiple of the file system block size
+const copyBufferMin = 256 * 1024
+
+// copyBuffers holds buffers of copyBufferMin bytes for extraction in order
+var copyBuffers = sync.Pool{New: func() interface{} {
+	buf := make([]byte, copyBufferMin)
+	return &buf
+}}
+
+// copyBufferSize returns the buffer size for writing files below dir: whole blocks
+// of its file system, so that every write but the last of a file fills blocks
+func copyBufferSize(dir string) int {
+	size := int64(copyBufferMin)
+	if block := fsBlockSize(dir); block > 0 && size%block != 0 {
+		size += block - size%block
+	}
+	return int(size)
+}
+
+// extractPool extracts downloaded series ZIPs apart from the download workers; nil
+// extracts inline in the worker that downloaded them
+var extractPool *ExtractPool
+
+// ExtractPool runs the extraction and verification of downloaded ZIPs on a fixed
+// number of goroutines, so the download workers keep the network busy while earlier
+// ZIPs are unpacked
+type ExtractPool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+}
+
+// NewExtractPool starts a pool with the given number of extraction goroutines
+func NewExtractPool(workers int) *ExtractPool {
+	p := &ExtractPool{jobs: make(chan func(), workers)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues a job. It blocks while the pool is saturated, bounding the number of
+// downloaded ZIPs waiting on disk for extraction.
+func (p *ExtractPool) Submit(job func()) {
+	p.jobs <- job
+}
+
+// Close stops the pool after all queued jobs are done
+func (p *ExtractPool) Close() {
+	if p == nil {
+		return
+	}
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// takePendingExtract returns the extraction a download left for the pool, if any
+func (info *FileInfo) takePendingExtract() func() error {
+	extract := info.pendingExtract
+	info.pendingExtract = nil
+	return extract
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"syscall"
+)
+
+// Failure codes reported in failed.csv, the stats file and events.jsonl. They are part
+// of the machine-readable interface: add new codes, but never rename or reuse one.
+const (
+	CodeAuth      = "E_AUTH"       // credentials rejected or token unavailable
+	CodeRateLimit = "E_RATE_LIMIT" // HTTP 429
+	CodeChecksum  = "E_CHECKSUM"   // MD5 or size mismatch, corrupt archive
+	CodeDisk      = "E_DISK"       // local file system error
+	CodeNotFound  = "E_NOT_FOUND"  // series unknown to the server, HTTP 404/410
+	CodeServer    = "E_SERVER"     // other HTTP error statuses, circuit breaker
+	CodeNetwork   = "E_NETWORK"    // timeouts, refused or dropped connections, truncated transfers
+	CodeArchived  = "E_ARCHIVED"   // S3 objects in Glacier/Deep Archive not restored yet
+	CodeUnknown   = "E_UNKNOWN"    // anything not classified above
+)
+
+// failedFile lists the failed items of the last run, below metadata/
+const failedFile = "failed.csv"
+
+// ErrAuthFailed marks errors caused by rejected credentials or a missing token
+var ErrAuthFailed = errors.New("authentication failed")
+
+// ErrChecksumMismatch marks downloads whose content does not match the expected checksums or size
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// FailedItem is a failed item of the current run
+type FailedItem struct {
+	SeriesUID string `json:"series_uid"`
+	Code      string `json:"code"`
+	Error     string `json:"error"`
+
+	info *FileInfo // the item, to queue it again with --final-retries
+}
+
+// finalRetrySkipped are the failure codes not retried at the end of the run, as no
+// wait of minutes changes them
+var finalRetrySkipped = map[string]bool{
+	CodeNotFound: true,
+	CodeArchived: true, // restores take hours
+}
+
+// failureCode returns the stable failure code of an error
+func failureCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	switch {
+	case errors.Is(err, ErrAuthFailed):
+		return CodeAuth
+	case errors.Is(err, ErrChecksumMismatch), errors.Is(err, zip.ErrChecksum), errors.Is(err, zip.ErrFormat):
+		return CodeChecksum
+	case errors.Is(err, ErrSeriesNotFound):
+		return CodeNotFound
+	case errors.Is(err, ErrCircuitOpen):
+		return CodeServer
+	case errors.Is(err, ErrObjectArchived):
+		return CodeArchived
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return CodeAuth
+		case http.StatusNotFound, http.StatusGone:
+			return CodeNotFound
+		case http.StatusTooManyRequests:
+			return CodeRateLimit
+		}
+		return CodeServer
+	}
+
+	// Network errors are checked before local ones: both surface as syscall errors
+	if classifyError(err) != errorPermanent {
+		return CodeNetwork
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) ||
+		errors.Is(err, syscall.EROFS) || errors.Is(err, syscall.EACCES) || isRecallError(err) {
+		return CodeDisk
+	}
+	return CodeUnknown
+}
+
+// recordFailure counts a failed item and remembers it for failed.csv
+func (stats *DownloadStats) recordFailure(info *FileInfo, err error) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	atomic.AddInt32(&stats.Failed, 1)
+	stats.failures = append(stats.failures, FailedItem{SeriesUID: info.SeriesUID, Code: failureCode(err), Error: err.Error(), info: info})
+}
+
+// takeFinalRetries removes the failed items worth another attempt from the failures
+// and counters and returns them, to be queued again
+func (stats *DownloadStats) takeFinalRetries() []*FileInfo {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	var retries []*FileInfo
+	kept := stats.failures[:0]
+	for _, failure := range stats.failures {
+		if failure.info == nil || finalRetrySkipped[failure.Code] {
+			kept = append(kept, failure)
+			continue
+		}
+		retries = append(retries, failure.info)
+		atomic.AddInt32(&stats.Failed, -1)
+		atomic.AddInt64(&stats.RemainingBytes, failure.info.expectedBytes())
+	}
+	stats.failures = kept
+	return retries
+}
+
+// Failures returns the failed items sorted by series UID
+func (stats *DownloadStats) Failures() []FailedItem {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return sortedFailures(stats.failures)
+}
+
+// sortedFailures returns a copy of failed items sorted by series UID
+func sortedFailures(failures []FailedItem) []FailedItem {
+	list := append([]FailedItem(nil), failures...)
+	sort.Slice(list, func(i, j int) bool { return list[i].SeriesUID < list[j].SeriesUID })
+	return list
+}
+
+// countFailures counts failed items by code
+func countFailures(failures []FailedItem) map[string]int32 {
+	if len(failures) == 0 {
+		return nil
+	}
+	counts := make(map[string]int32)
+	for _, failure := range failures {
+		counts[failure.Code]++
+	}
+	return counts
+}
+
+// writeFailedList saves the failed items to failed.csv in the report directory, or
+// removes the list of an earlier run when nothing failed
+func writeFailedList(output string, failures []FailedItem) error {
+	path := filepath.Join(reportDir(output), failedFile)
+	if len(failures) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tempPath := path + ".tmp"
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"series_uid", "code", "error"})
+	for _, failure := range failures {
+		w.Write([]string{failure.SeriesUID, failure.Code, failure.Error})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return renameFile(tempPath, path)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// driveAPIKeyEnv holds the API key of Google Drive folder listings
+const driveAPIKeyEnv = "GOOGLE_API_KEY"
+
+// driveFilesAPI is the Google Drive v3 files endpoint
+const driveFilesAPI = "https://www.googleapis.com/drive/v3/files"
+
+// driveFolderMime is the MIME type of Google Drive folders; other Google types
+// (Docs, Sheets) cannot be downloaded as they are
+const driveFolderMime = "application/vnd.google-apps.folder"
+
+// driveFolderID finds the folder ID in the forms of Google Drive folder links
+var driveFolderID = regexp.MustCompile(`/folders/([A-Za-z0-9_-]+)`)
+
+// checksumListKinds maps the names of checksum lists in folder listings, compared in
+// lower case, to the checksum they hold
+var checksumListKinds = map[string]string{
+	"md5sums":          "md5",
+	"md5sums.txt":      "md5",
+	"md5sum.txt":       "md5",
+	"checksums.md5":    "md5",
+	"sha256sums":       "sha256",
+	"sha256sums.txt":   "sha256",
+	"sha256sum.txt":    "sha256",
+	"checksums.sha256": "sha256",
+}
+
+// isFolderURL reports whether an input is the URL of a folder to download
+func isFolderURL(input string) bool {
+	return strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://")
+}
+
+// decodeFolderURL lists the files below a Google Drive folder or an HTTP directory
+// index as direct downloads, named by their path in the folder
+func decodeFolderURL(folderURL string, httpClient *http.Client, options *Options) ([]*FileInfo, error) {
+	u, err := url.Parse(folderURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid folder URL %s: %v", folderURL, err)
+	}
+	var files []*FileInfo
+	if u.Host == "drive.google.com" {
+		id := u.Query().Get("id")
+		if m := driveFolderID.FindStringSubmatch(u.Path); m != nil {
+			id = m[1]
+		}
+		if id == "" {
+			return nil, fmt.Errorf("no folder ID in %s", folderURL)
+		}
+		if options.DriveAPIKey == "" {
+			return nil, fmt.Errorf("listing Google Drive folders requires an API key, set --drive-api-key or %s", driveAPIKeyEnv)
+		}
+		files, err = listDriveFolder(httpClient, options.DriveAPIKey, id, "", nil)
+	} else {
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+		lister := &indexLister{client: httpClient, base: u, visited: make(map[string]bool)}
+		files, err = lister.list(u)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", folderURL, err)
+	}
+	fmt.Printf("Found %d files in %s\n", len(files), folderURL)
+	return files, nil
+}
+
+// getFolderPage fetches a listing page
+func getFolderPage(httpClient *http.Client, pageURL string) ([]byte, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doRequest(httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: HTTP %d: %s", redactURL(pageURL), resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// redactURL drops the query of a URL, which may carry an API key
+func redactURL(rawURL string) string {
+	if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+		return rawURL[:i]
+	}
+	return rawURL
+}
+
+// indexLister walks the HTML directory index of a web server (Apache, nginx) below
+// a base folder
+type indexLister struct {
+	client  *http.Client
+	base    *url.URL
+	visited map[string]bool
+}
+
+// list returns the files of a folder and its subfolders. Checksum lists found in a
+// folder (md5sums.txt, SHA256SUMS) provide the checksums of the files next to them.
+func (l *indexLister) list(folder *url.URL) ([]*FileInfo, error) {
+	if l.visited[folder.Path] {
+		return nil, nil
+	}
+	l.visited[folder.Path] = true
+
+	page, err := getFolderPage(l.client, folder.String())
+	if err != nil {
+		return nil, err
+	}
+	links, err := indexLinks(page)
+	if err != nil {
+		return nil, err
+	}
+
+	var files, subfiles []*FileInfo
+	sums := make(map[string]map[string]string)
+	for _, href := range links {
+		link, err := folder.Parse(href)
+		if err != nil || link.Host != l.base.Host || link.RawQuery != "" {
+			continue
+		}
+		link.Fragment = ""
+		// Parent links, sort links and links to other sites are not part of the folder
+		if link.Path == folder.Path || !strings.HasPrefix(link.Path, folder.Path) {
+			continue
+		}
+		if strings.HasSuffix(link.Path, "/") {
+			found, err := l.list(link)
+			if err != nil {
+				return nil, err
+			}
+			subfiles = append(subfiles, found...)
+			continue
+		}
+		name := strings.TrimPrefix(link.Path, l.base.Path)
+		if kind, ok := checksumListKinds[strings.ToLower(path.Base(name))]; ok {
+			list, err := l.checksumList(link)
+			if err != nil {
+				logger.Warnf("Failed to read checksum list %s: %v", link, err)
+			} else {
+				sums[kind] = list
+			}
+		}
+		files = append(files, &FileInfo{DownloadURL: link.String(), SeriesUID: name, FileName: name})
+	}
+	for _, info := range files {
+		info.MD5Hash = sums["md5"][path.Base(info.FileName)]
+		info.SHA256Hash = sums["sha256"][path.Base(info.FileName)]
+	}
+	return append(files, subfiles...), nil
+}
+
+// checksumList reads a list of checksums in the format of md5sum and sha256sum,
+// keyed by file name
+func (l *indexLister) checksumList(link *url.URL) (map[string]string, error) {
+	content, err := getFolderPage(l.client, link.String())
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		name = strings.TrimPrefix(strings.TrimSpace(name), "*")
+		sums[path.Base(name)] = strings.ToLower(sum)
+	}
+	return sums, scanner.Err()
+}
+
+// indexLinks returns the href of every link of an HTML page
+func indexLinks(page []byte) ([]string, error) {
+	doc, err := html.Parse(strings.NewReader(string(page)))
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			for _, attr := range n.Attr {
+				if attr.Key == "href" {
+					links = append(links, attr.Val)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return links, nil
+}
+
+// driveFile is a file of a Google Drive folder listing
+type driveFile struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	MimeType       string `json:"mimeType"`
+	Size           string `json:"size"`
+	MD5Checksum    string `json:"md5Checksum"`
+	SHA256Checksum string `json:"sha256Checksum"`
+}
+
+// driveFileName makes a Drive file name safe as a path element
+func driveFileName(name string) string {
+	name = strings.NewReplacer("/", "_", `\`, "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		return "_" + name
+	}
+	return name
+}
+
+// listDriveFolder returns the files of a Google Drive folder and its subfolders
+// through the Drive API, named by their path below the listed folder. Files are
+// downloaded through the API as well, with the key in their URL.
+func listDriveFolder(httpClient *http.Client, apiKey, folderID, prefix string, seen map[string]bool) ([]*FileInfo, error) {
+	if seen == nil {
+		seen = make(map[string]bool)
+	}
+	var files []*FileInfo
+	pageToken := ""
+	for {
+		query := url.Values{
+			"q":        {fmt.Sprintf("'%s' in parents and trashed = false", folderID)},
+			"fields":   {"nextPageToken,files(id,name,mimeType,size,md5Checksum,sha256Checksum)"},
+			"pageSize": {"1000"},
+			"key":      {apiKey},
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		page, err := getFolderPage(httpClient, driveFilesAPI+"?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+		var listing struct {
+			NextPageToken string      `json:"nextPageToken"`
+			Files         []driveFile `json:"files"`
+		}
+		if err := json.Unmarshal(page, &listing); err != nil {
+			return nil, fmt.Errorf("failed to parse Drive listing: %v", err)
+		}
+
+		for _, f := range listing.Files {
+			name := prefix + driveFileName(f.Name)
+			switch {
+			case f.MimeType == driveFolderMime:
+				found, err := listDriveFolder(httpClient, apiKey, f.ID, name+"/", seen)
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, found...)
+				continue
+			case strings.HasPrefix(f.MimeType, "application/vnd.google-apps."):
+				logger.Warnf("Skipping %s, a Google %s document that has no file to download", name, strings.TrimPrefix(f.MimeType, "application/vnd.google-apps."))
+				continue
+			}
+			// Drive allows several files of the same name in a folder
+			if seen[name] {
+				ext := path.Ext(name)
+				name = strings.TrimSuffix(name, ext) + "-" + f.ID + ext
+			}
+			seen[name] = true
+			info := &FileInfo{
+				DownloadURL: driveFilesAPI + "/" + f.ID + "?" + url.Values{"alt": {"media"}, "key": {apiKey}}.Encode(),
+				SeriesUID:   name,
+				FileName:    name,
+				MD5Hash:     f.MD5Checksum,
+				SHA256Hash:  f.SHA256Checksum,
+			}
+			if size, err := strconv.ParseInt(f.Size, 10, 64); err == nil && size > 0 {
+				info.FileSize = f.Size
+			}
+			files = append(files, info)
+		}
+		if pageToken = listing.NextPageToken; pageToken == "" {
+			return files, nil
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Credentials of FTP servers whose URLs carry none; without them the login is
+// anonymous
+const (
+	ftpUserEnv     = "FTP_USER"
+	ftpPasswordEnv = "FTP_PASSWORD"
+)
+
+// ftpImplicitTLSPort is the port of FTPS servers speaking TLS from the start;
+// ftps:// URLs on other ports upgrade the connection with AUTH TLS
+const ftpImplicitTLSPort = "990"
+
+// isFTPURL reports whether a source is on an FTP or FTPS server
+func isFTPURL(uri string) bool {
+	return strings.HasPrefix(uri, "ftp://") || strings.HasPrefix(uri, "ftps://")
+}
+
+// ftpConn is the control connection of an FTP session
+type ftpConn struct {
+	raw  net.Conn
+	text *textproto.Conn
+	host string
+	tls  *tls.Config // protects the data connections too; nil for plain FTP
+	net  NetProfile  // dials the data connections like the control connection
+}
+
+// dialFTP connects and logs in to the server of an ftp:// or ftps:// URL with the
+// dialing settings of profile. Closing ctx closes the connection.
+func dialFTP(ctx context.Context, u *url.URL, profile NetProfile) (*ftpConn, error) {
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "21"
+		if u.Scheme == "ftps" {
+			port = ftpImplicitTLSPort
+		}
+	}
+	raw, err := profile.dialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	c := &ftpConn{raw: raw, host: host, net: profile}
+	context.AfterFunc(ctx, func() { raw.Close() })
+	if u.Scheme == "ftps" {
+		c.tls = &tls.Config{ServerName: host, ClientSessionCache: tls.NewLRUClientSessionCache(4)}
+		if port == ftpImplicitTLSPort {
+			c.raw = tls.Client(raw, c.tls)
+		}
+	}
+	c.text = textproto.NewConn(c.raw)
+
+	if _, err := c.response(220); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := c.login(u); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// login secures the connection for ftps:// URLs, logs in and switches to binary
+func (c *ftpConn) login(u *url.URL) error {
+	if c.tls != nil {
+		if _, ok := c.raw.(*tls.Conn); !ok {
+			if _, err := c.cmd(234, "AUTH TLS"); err != nil {
+				return err
+			}
+			c.raw = tls.Client(c.raw, c.tls)
+			c.text = textproto.NewConn(c.raw)
+		}
+		if _, err := c.cmd(200, "PBSZ 0"); err != nil {
+			return err
+		}
+		if _, err := c.cmd(200, "PROT P"); err != nil {
+			return err
+		}
+	}
+
+	user, password := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	} else if os.Getenv(ftpUserEnv) != "" {
+		user, password = os.Getenv(ftpUserEnv), os.Getenv(ftpPasswordEnv)
+	}
+	code, err := c.cmd(0, "USER %s", user)
+	if err != nil {
+		return err
+	}
+	if code == 331 {
+		if _, err := c.cmd(2, "PASS %s", password); err != nil {
+			return err
+		}
+	} else if code/100 != 2 {
+		return &textproto.Error{Code: code, Msg: "login failed"}
+	}
+	_, err = c.cmd(200, "TYPE I")
+	return err
+}
+
+// cmd sends a command and reads its reply, which must match expect as in
+// textproto.ReadResponse; an expect of 0 accepts any reply
+func (c *ftpConn) cmd(expect int, format string, args ...interface{}) (int, error) {
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return 0, err
+	}
+	return c.response(expect)
+}
+
+// response reads a reply, which must match expect unless it is 0
+func (c *ftpConn) response(expect int) (int, error) {
+	code, msg, err := c.text.ReadResponse(expect)
+	if err != nil {
+		return code, err
+	}
+	logger.Debugf("FTP %s: %d %s", c.host, code, msg)
+	return code, nil
+}
+
+// data opens a passive data connection, with EPSV or else PASV. The address of PASV
+// replies is ignored in favor of the control host, as servers behind NAT announce
+// their private one.
+func (c *ftpConn) data(ctx context.Context) (net.Conn, error) {
+	port := ""
+	if err := c.text.PrintfLine("EPSV"); err != nil {
+		return nil, err
+	}
+	if _, msg, err := c.text.ReadResponse(229); err == nil {
+		// Entering Extended Passive Mode (|||6446|)
+		if start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)"); start >= 0 && end > start {
+			port = msg[start+4 : end]
+		}
+	} else if _, ok := err.(*textproto.Error); !ok {
+		return nil, err
+	}
+	if port == "" {
+		if err := c.text.PrintfLine("PASV"); err != nil {
+			return nil, err
+		}
+		_, msg, err := c.text.ReadResponse(227)
+		if err != nil {
+			return nil, err
+		}
+		// Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+		start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		fields := []string{}
+		if start >= 0 && end > start {
+			fields = strings.Split(msg[start+1:end], ",")
+		}
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid PASV reply: %s", msg)
+		}
+		high, _ := strconv.Atoi(strings.TrimSpace(fields[4]))
+		low, _ := strconv.Atoi(strings.TrimSpace(fields[5]))
+		port = strconv.Itoa(high<<8 | low)
+	}
+
+	conn, err := c.net.dialContext(ctx, "tcp", net.JoinHostPort(c.host, port))
+	if err != nil {
+		return nil, err
+	}
+	context.AfterFunc(ctx, func() { conn.Close() })
+	if c.tls != nil {
+		// Servers commonly require the data connection to resume the TLS session of
+		// the control connection, which the shared session cache provides
+		conn = tls.Client(conn, c.tls)
+	}
+	return conn, nil
+}
+
+// size returns the size of a file, or -1 when the server does not tell
+func (c *ftpConn) size(name string) int64 {
+	if err := c.text.PrintfLine("SIZE %s", name); err != nil {
+		return -1
+	}
+	_, msg, err := c.text.ReadResponse(213)
+	if err != nil {
+		return -1
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// retr starts the transfer of a file from offset. The caller reads the returned
+// connection to its end, closes it and calls finish.
+func (c *ftpConn) retr(ctx context.Context, name string, offset int64) (net.Conn, error) {
+	conn, err := c.data(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := c.cmd(350, "REST %d", offset); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if _, err := c.cmd(1, "RETR %s", name); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// finish reads the reply ending a transfer
+func (c *ftpConn) finish() error {
+	_, err := c.response(2)
+	return err
+}
+
+// list returns the entries of a directory with their size, or -1 when unknown, from
+// MLSD or else NLST
+func (c *ftpConn) list(ctx context.Context, dir string) (map[string]int64, error) {
+	entries := make(map[string]int64)
+	for _, command := range []string{"MLSD", "NLST"} {
+		conn, err := c.data(ctx)
+		if err != nil {
+			return nil, err
+		}
+		code, err := c.cmd(0, "%s %s", command, dir)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if code/100 != 1 {
+			conn.Close()
+			if command == "MLSD" && code/100 == 5 {
+				// MLSD is not implemented by older servers
+				continue
+			}
+			return nil, &textproto.Error{Code: code, Msg: command + " " + dir + " failed"}
+		}
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			if command == "NLST" {
+				if line != "" {
+					entries[path.Base(line)] = -1
+				}
+				continue
+			}
+			// type=file;size=1234;modify=20240101000000; name
+			facts, name, ok := strings.Cut(line, " ")
+			if !ok {
+				continue
+			}
+			size, isFile := int64(-1), false
+			for _, fact := range strings.Split(strings.ToLower(facts), ";") {
+				key, value, _ := strings.Cut(fact, "=")
+				switch key {
+				case "type":
+					isFile = value == "file"
+				case "size":
+					size, _ = strconv.ParseInt(value, 10, 64)
+				}
+			}
+			if isFile {
+				entries[name] = size
+			}
+		}
+		conn.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return entries, c.finish()
+	}
+	return entries, nil
+}
+
+// Close ends the session
+func (c *ftpConn) Close() error {
+	c.text.PrintfLine("QUIT")
+	return c.raw.Close()
+}
+
+// listFTPFiles returns the files of the directory of a prefix (ftp://host/dir/*) as
+// items named after them. Subdirectories are not descended into.
+func listFTPFiles(ctx context.Context, prefix *url.URL, profile NetProfile) ([]*FileInfo, error) {
+	c, err := dialFTP(ctx, prefix, profile)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	dir := strings.TrimSuffix(prefix.Path, "*")
+	entries, err := c.list(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []*FileInfo
+	for _, name := range sortedKeys(entries) {
+		object := *prefix
+		object.Path = path.Join(dir, name)
+		info := &FileInfo{
+			DownloadURL: object.String(),
+			SeriesUID:   name,
+			FileName:    name,
+		}
+		if entries[name] >= 0 {
+			info.FileSize = strconv.FormatInt(entries[name], 10)
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+// downloadFromFTP downloads the files of a directory (ftp://host/dir/*) into the
+// directory of the item
+func (info *FileInfo) downloadFromFTP(output string, options *Options) error {
+	prefix, err := url.Parse(info.DownloadURL)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), options.SeriesTimeout)
+	defer cancel()
+	files, err := listFTPFiles(ctx, prefix, options.Transport)
+	if err != nil {
+		return fmt.Errorf("listing %s failed: %w", prefix.Redacted(), err)
+	}
+	return info.downloadObjects(output, files, nil, options)
+}
+
+// downloadFTPFile downloads a file from an FTP server. With --if-exists resume an
+// interrupted transfer continues where it stopped, with the REST command.
+func (info *FileInfo) downloadFTPFile(output string, options *Options) (err error) {
+	u, err := url.Parse(info.DownloadURL)
+	if err != nil {
+		return err
+	}
+	logger.Debugf("Downloading from FTP: %s", u.Redacted())
+
+	finalPath := info.directPath(output)
+	tempPath := transferTempPath(finalPath, ".tmp")
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Deadline of the whole transfer; stalls are caught much earlier with --stall-timeout
+	ctx, cancel := context.WithTimeout(context.Background(), info.transferTimeout(options))
+	defer cancel()
+	c, err := dialFTP(ctx, u, options.Transport)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", u.Host, err)
+	}
+	defer c.Close()
+
+	offset := partialOffset(tempPath, options)
+	if size := c.size(u.Path); size >= 0 {
+		if info.FileSize == "" {
+			info.FileSize = strconv.FormatInt(size, 10)
+		}
+		if offset > size {
+			offset = 0
+		}
+	}
+	data, err := c.retr(ctx, u.Path, offset)
+	if offset > 0 && err != nil {
+		// Servers without REST send the whole file
+		logger.Debugf("Cannot resume %s at %d bytes: %v", u.Redacted(), offset, err)
+		offset = 0
+		data, err = c.retr(ctx, u.Path, 0)
+	}
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	md5Hasher, sha256Hasher := info.directHashers()
+	f, offset, err := openPartialFile(tempPath, offset, md5Hasher, sha256Hasher)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	keepPartial := false
+	defer func() {
+		f.Close()
+		if err != nil && !keepPartial {
+			os.Remove(tempPath)
+		}
+	}()
+	body := newStallReader(data, options.StallTimeout, cancel)
+	defer body.Stop()
+	written, err := io.Copy(io.MultiWriter(f, hashWriter(md5Hasher, sha256Hasher)), &countingReader{r: body, info: info})
+	data.Close()
+	if err == nil {
+		err = c.finish()
+	}
+	if err != nil {
+		recordEgress(info.DownloadURL, 0, written)
+	} else {
+		recordEgress(info.DownloadURL, 1, written)
+	}
+	written += offset
+	if err != nil {
+		keepPartial = options.IfExists == ifExistsResume
+		return fmt.Errorf("failed to write data after %d bytes: %w", written, err)
+	}
+	logger.Debugf("Downloaded %d bytes for %s", written, info.SeriesUID)
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err = info.verifyDirect(written, md5Hasher, sha256Hasher); err != nil {
+		return err
+	}
+	if err := renameFile(tempPath, finalPath); err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	if sha256Hasher != nil && checksums != nil {
+		checksums.Add(finalPath, hex.EncodeToString(sha256Hasher.Sum(nil)))
+	}
+	logger.Debugf("Successfully saved %s as %s", info.SeriesUID, finalPath)
+	return nil
+}
+
+// ftpErrorClass classifies the reply of an FTP server: 4xx replies are transient
+// negative completions (service not available, data connection failed, ...)
+func ftpErrorClass(err *textproto.Error) errorClass {
+	if err.Code/100 == 4 {
+		return errorInfrastructure
+	}
+	return errorPermanent
+}
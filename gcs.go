@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcsHost serves the objects of Google Cloud Storage over HTTPS
+const gcsHost = "storage.googleapis.com"
+
+// gcsReadScope is the OAuth scope of signed GCS requests
+const gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcsTokens issues the access tokens of --gs-sign, found once from the Application
+// Default Credentials
+var gcsTokens struct {
+	once   sync.Once
+	source oauth2.TokenSource
+	err    error
+}
+
+// gcsObject is an object, or a prefix of objects, in a GCS bucket
+type gcsObject struct {
+	Bucket string
+	Name   string
+}
+
+// isGCSURL reports whether a source is a gs:// URI
+func isGCSURL(uri string) bool {
+	return strings.HasPrefix(uri, "gs://")
+}
+
+// parseGCSURL splits a gs:// URI into its bucket and object
+func parseGCSURL(uri string) (*gcsObject, error) {
+	bucket, name, _ := strings.Cut(strings.TrimPrefix(uri, "gs://"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid GCS URL %s, expected gs://bucket/object", uri)
+	}
+	return &gcsObject{Bucket: bucket, Name: name}, nil
+}
+
+// mediaURL returns the HTTPS URL of the content of an object
+func (o *gcsObject) mediaURL(name string) string {
+	u := url.URL{Scheme: "https", Host: gcsHost, Path: "/" + o.Bucket + "/" + name}
+	return u.String()
+}
+
+// gcsAuthorizer returns how GCS requests are authorized: with a token of the
+// Application Default Credentials under --gs-sign, anonymously otherwise, as public
+// buckets like those of IDC are read
+func gcsAuthorizer(options *Options) func(*http.Request) error {
+	if !options.GSSign {
+		return nil
+	}
+	return func(req *http.Request) error {
+		gcsTokens.once.Do(func() {
+			creds, err := google.FindDefaultCredentials(context.Background(), gcsReadScope)
+			if err != nil {
+				gcsTokens.err = fmt.Errorf("--gs-sign found no Application Default Credentials: %v", err)
+				return
+			}
+			gcsTokens.source = creds.TokenSource
+		})
+		if gcsTokens.err != nil {
+			return gcsTokens.err
+		}
+		token, err := gcsTokens.source.Token()
+		if err != nil {
+			return fmt.Errorf("failed to get a GCS access token: %v", err)
+		}
+		token.SetAuthHeader(req)
+		return nil
+	}
+}
+
+// listGCSObjects returns the objects below a prefix as items named by their path
+// relative to it, with the size and MD5 GCS keeps for them
+func listGCSObjects(httpClient *http.Client, prefix *gcsObject, authorize func(*http.Request) error) ([]*FileInfo, error) {
+	dir := strings.TrimSuffix(prefix.Name, "*")
+	var files []*FileInfo
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {dir}, "fields": {"items(name,size,md5Hash),nextPageToken"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		listURL := url.URL{Scheme: "https", Host: gcsHost, Path: "/storage/v1/b/" + prefix.Bucket + "/o", RawQuery: query.Encode()}
+		req, err := http.NewRequest("GET", listURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if authorize != nil {
+			if err := authorize(req); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := doRequest(httpClient, req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("listing gs://%s/%s failed: HTTP %d: %s", prefix.Bucket, dir, resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		var listing struct {
+			Items []struct {
+				Name    string `json:"name"`
+				Size    string `json:"size"`
+				MD5Hash string `json:"md5Hash"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &listing); err != nil {
+			return nil, fmt.Errorf("failed to parse object listing: %v", err)
+		}
+		for _, object := range listing.Items {
+			name := strings.TrimPrefix(object.Name, dir)
+			if name == "" || strings.HasSuffix(name, "/") {
+				continue
+			}
+			info := &FileInfo{
+				DownloadURL: "gs://" + prefix.Bucket + "/" + object.Name,
+				SeriesUID:   name,
+				FileName:    filepath.FromSlash(path.Clean("/" + name))[1:],
+			}
+			if object.Size != "" && object.Size != "0" {
+				info.FileSize = object.Size
+			}
+			if sum, err := base64.StdEncoding.DecodeString(object.MD5Hash); err == nil && len(sum) > 0 {
+				info.MD5Hash = hex.EncodeToString(sum)
+			}
+			files = append(files, info)
+		}
+		if pageToken = listing.NextPageToken; pageToken == "" {
+			return files, nil
+		}
+	}
+}
+
+// downloadFromGCS downloads the objects below a prefix (gs://bucket/dir/*) into
+// the directory of the item
+func (info *FileInfo) downloadFromGCS(output string, httpClient *http.Client, options *Options) error {
+	prefix, err := parseGCSURL(info.DownloadURL)
+	if err != nil {
+		return err
+	}
+	objects, err := listGCSObjects(httpClient, prefix, gcsAuthorizer(options))
+	if err != nil {
+		return err
+	}
+	return info.downloadObjects(output, objects, httpClient, options)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// refusedItemsFile lists the items of the last run refused by --allowed-collections,
+// in the report directory
+const refusedItemsFile = "refused-items.csv"
+
+// refusedListedMax is the number of refused items listed on the console
+const refusedListedMax = 20
+
+// allowedCollections are the collections runs may transfer; nil without
+// --allowed-collections
+var allowedCollections AllowList
+
+// AllowList is the institutional list of collections that may be downloaded. Names
+// are compared without regard to case, as NBIA spells some of them differently
+// across APIs.
+type AllowList map[string]bool
+
+// RefusedItem is an item of the input whose collection is not allowed
+type RefusedItem struct {
+	SeriesUID  string
+	Collection string
+	SubjectID  string
+	Reason     string
+}
+
+// loadAllowList reads an --allowed-collections file: one collection per line, or a
+// CSV with a Collection column. Lines starting with # are ignored.
+func loadAllowList(path string) (AllowList, error) {
+	names, err := loadIDList(path, map[string]bool{"collection": true})
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s lists no collections", path)
+	}
+	list := make(AllowList, len(names))
+	for name := range names {
+		list[strings.ToLower(name)] = true
+	}
+	return list, nil
+}
+
+// Allows reports whether a collection may be transferred; without a list every
+// collection may
+func (l AllowList) Allows(collection string) bool {
+	return l == nil || l[strings.ToLower(strings.TrimSpace(collection))]
+}
+
+// Refused returns the items whose collection is not allowed. Items whose collection
+// is not known, such as direct downloads and s5cmd copies, are refused too: the
+// list is enforced, not assumed.
+func (l AllowList) Refused(files []*FileInfo) []RefusedItem {
+	if l == nil {
+		return nil
+	}
+	var refused []RefusedItem
+	for _, info := range files {
+		switch {
+		case info.Collection == "":
+			refused = append(refused, RefusedItem{info.SeriesUID, "", info.SubjectID, "collection unknown"})
+		case !l.Allows(info.Collection):
+			refused = append(refused, RefusedItem{info.SeriesUID, info.Collection, info.SubjectID, "collection not allowed"})
+		}
+	}
+	sort.Slice(refused, func(i, j int) bool { return refused[i].SeriesUID < refused[j].SeriesUID })
+	return refused
+}
+
+// refuseRun ends a run whose input has items outside the allow-list, before anything
+// is transferred. The refused items are audited in events.jsonl and listed in
+// refused-items.csv; dry runs only report them.
+func refuseRun(refused []RefusedItem, options *Options) {
+	if !options.WhatIf {
+		if events == nil {
+			// The run ends before it opens the log
+			if log, err := OpenEventLog(options.Output); err == nil {
+				events = log
+			}
+		}
+		for _, item := range refused {
+			events.Record(Event{Action: "refused", SeriesUID: item.SeriesUID, Detail: item.Reason + ": " + item.Collection})
+		}
+		if path, err := writeRefusedItems(options.Output, refused); err != nil {
+			logger.Warnf("Failed to write %s: %v", refusedItemsFile, err)
+		} else {
+			fmt.Printf("Refused items listed in %s\n", path)
+		}
+	}
+
+	var collections []string
+	for _, item := range refused {
+		if item.Collection == "" {
+			collections = appendUnique(collections, "unknown")
+		} else {
+			collections = appendUnique(collections, item.Collection)
+		}
+	}
+	listed := refused
+	if len(listed) > refusedListedMax {
+		listed = listed[:refusedListedMax]
+	}
+	for _, item := range listed {
+		fmt.Printf("  %s\t%s (%s)\n", item.SeriesUID, item.Collection, item.Reason)
+	}
+	if len(refused) > len(listed) {
+		fmt.Printf("  ... and %d more\n", len(refused)-len(listed))
+	}
+	fatalWithStatus(ExitRefused, fmt.Sprintf("%d items of the input are not in --allowed-collections %s (collections: %s); nothing was transferred",
+		len(refused), options.AllowedList, strings.Join(collections, ", ")))
+}
+
+// writeRefusedItems saves the refused items to the report directory
+func writeRefusedItems(output string, refused []RefusedItem) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"series_uid", "collection", "subject_id", "reason"})
+	for _, item := range refused {
+		w.Write([]string{item.SeriesUID, item.Collection, item.SubjectID, item.Reason})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	path := filepath.Join(reportDir(output), refusedItemsFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return path, writeFileAtomic(path, buf.Bytes(), 0644)
+}
@@ -3,7 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
-	"os/exec"
+	"sync"
+
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
@@ -39,9 +40,11 @@ func (b *App) OpenOutputDirectoryDialog() (string, error) {
 	return result, nil
 }
 
-// RunCLIFetch runs the CLI tool with the given manifest and output directory and advanced options
-func (b *App) RunCLIFetch(manifestPath string, outputDir string, maxConnections int, maxRetries int, simultaneousDownloads int, skipExisting bool) (string, error) {
-	cliPath := "../nbia-data-retriever-cli"
+// cliPath is the location of the CLI binary relative to the GUI
+const cliPath = "../nbia-data-retriever-cli"
+
+// buildCLIArgs assembles the CLI arguments for a download
+func buildCLIArgs(manifestPath string, outputDir string, maxConnections int, maxRetries int, simultaneousDownloads int, skipExisting bool) []string {
 	args := []string{"-i", manifestPath, "--output", outputDir,
 		"--max-connections", fmt.Sprintf("%d", maxConnections),
 		"--max-retries", fmt.Sprintf("%d", maxRetries),
@@ -50,21 +53,87 @@ func (b *App) RunCLIFetch(manifestPath string, outputDir string, maxConnections
 	if skipExisting {
 		args = append(args, "--skip-existing")
 	}
+	return args
+}
+
+// RunCLIFetch runs the CLI tool with the given manifest and output directory and advanced options
+func (b *App) RunCLIFetch(manifestPath string, outputDir string, maxConnections int, maxRetries int, simultaneousDownloads int, skipExisting bool) (string, error) {
+	args := buildCLIArgs(manifestPath, outputDir, maxConnections, maxRetries, simultaneousDownloads, skipExisting)
+	b.recordRecent(manifestPath, outputDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.fetchMu.Lock()
+	b.fetchCancel = cancel
+	b.fetchMu.Unlock()
+	defer func() {
+		b.fetchMu.Lock()
+		b.fetchCancel = nil
+		b.fetchMu.Unlock()
+		cancel()
+	}()
 
-	cmd := exec.Command(cliPath, args...)
-	output, err := cmd.CombinedOutput()
+	output, stats, err := b.runCLIWithStats(ctx, outputDir, args)
+	if ctx.Err() != nil {
+		return string(output), fmt.Errorf("cancelled")
+	}
+	b.emitRunResult(runResult(&QueueItem{
+		InputPath:             manifestPath,
+		OutputDir:             outputDir,
+		MaxConnections:        maxConnections,
+		MaxRetries:            maxRetries,
+		SimultaneousDownloads: simultaneousDownloads,
+	}, stats, err))
 	if err != nil {
 		return string(output), err
 	}
 	return string(output), nil
 }
 
+// CancelRun stops the running download, that of Fetch Files or the running queue
+// item, at any phase, e.g. while the metadata is fetched before any download began.
+// A cancelled queue item is not resumed; retry it to run it again.
+func (b *App) CancelRun() QueueState {
+	b.fetchMu.Lock()
+	if b.fetchCancel != nil {
+		b.fetchCancel()
+	}
+	b.fetchMu.Unlock()
+
+	b.queue.mu.Lock()
+	for _, item := range b.queue.state.Items {
+		if item.Status == QueueRunning {
+			item.Status = QueueCancelled
+		}
+	}
+	if b.queue.cancel != nil {
+		b.queue.cancel()
+	}
+	b.queue.mu.Unlock()
+
+	b.notifyQueue()
+	return b.GetQueue()
+}
+
 type App struct {
-	ctx context.Context
+	ctx    context.Context
+	queue  *DownloadQueue
+	recent *RecentManifests
+
+	// fetchCancel stops the download started with Fetch Files
+	fetchCancel context.CancelFunc
+	fetchMu     sync.Mutex
 }
 
 func NewApp() *App {
-	return &App{}
+	path, err := queueStatePath()
+	if err != nil {
+		path = ""
+	}
+	recentPath, err := recentManifestsPath()
+	if err != nil {
+		recentPath = ""
+	}
+	return &App{queue: loadDownloadQueue(path), recent: loadRecentManifests(recentPath)}
 }
 
 func (a *App) FetchFiles() string {
@@ -73,10 +142,18 @@ func (a *App) FetchFiles() string {
 
 func (b *App) startup(ctx context.Context) {
 	b.ctx = ctx
+	runtime.OnFileDrop(ctx, b.onFileDrop)
+	// Resume any unfinished items from a previous session
+	b.startQueue()
 }
 
 func (b *App) shutdown(ctx context.Context) {
-	// Perform teardown here
+	// Stop the running download; it stays queued and resumes on next launch
+	b.queue.mu.Lock()
+	if b.queue.cancel != nil {
+		b.queue.cancel()
+	}
+	b.queue.mu.Unlock()
 }
 
 func (b *App) Greet(name string) string {
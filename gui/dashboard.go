@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// statsUpdatedEvent is emitted to the frontend with each dashboard sample
+const statsUpdatedEvent = "stats:updated"
+
+// WorkerActivity mirrors the CLI's per-worker activity record
+type WorkerActivity struct {
+	WorkerID int       `json:"worker_id"`
+	Current  string    `json:"current"`
+	Since    time.Time `json:"since"`
+}
+
+// MetadataProgress mirrors the CLI's progress of the metadata fetch
+type MetadataProgress struct {
+	Total          int     `json:"total"`
+	Fetched        int32   `json:"fetched"`
+	Cached         int32   `json:"cached"`
+	Revalidated    int32   `json:"revalidated"`
+	Failed         int32   `json:"failed"`
+	Unavailable    int32   `json:"unavailable"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Done           bool    `json:"done"`
+}
+
+// FailedSeries mirrors the CLI's record of a failed item
+type FailedSeries struct {
+	SeriesUID string `json:"series_uid"`
+	Code      string `json:"code"`
+	Error     string `json:"error"`
+}
+
+// DownloadStats mirrors the snapshot the CLI writes with --stats-file. Phase is
+// metadata until the downloads start, then download.
+type DownloadStats struct {
+	Phase           string            `json:"phase"`
+	Metadata        *MetadataProgress `json:"metadata"`
+	Total           int32             `json:"total"`
+	Downloaded      int32             `json:"downloaded"`
+	Synced          int32             `json:"synced"`
+	Skipped         int32             `json:"skipped"`
+	Failed          int32             `json:"failed"`
+	Failures        []FailedSeries    `json:"failures"`
+	BytesDownloaded int64             `json:"bytes_downloaded"`
+	BytesPerSecond  float64           `json:"bytes_per_second"`
+	ElapsedSeconds  float64           `json:"elapsed_seconds"`
+	Workers         []WorkerActivity  `json:"workers"`
+	Done            bool              `json:"done"`
+}
+
+// DashboardSample is a single dashboard update pushed to the frontend
+type DashboardSample struct {
+	Stats         DownloadStats `json:"stats"`
+	FreeDiskBytes uint64        `json:"freeDiskBytes"`
+	OutputDir     string        `json:"outputDir"`
+	Timestamp     time.Time     `json:"timestamp"`
+}
+
+// runCLIWithStats runs the CLI with --stats-file and streams dashboard samples while
+// it runs. It returns the last statistics the CLI wrote.
+func (b *App) runCLIWithStats(ctx context.Context, outputDir string, args []string) ([]byte, DownloadStats, error) {
+	statsFile := filepath.Join(os.TempDir(), fmt.Sprintf("nbia-gui-stats-%d.json", time.Now().UnixNano()))
+	defer os.Remove(statsFile)
+	args = append(args, "--stats-file", statsFile)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var last DownloadStats
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if stats, ok := b.emitDashboardSample(statsFile, outputDir, false); ok {
+					last = stats
+				}
+			case <-stop:
+				// The CLI may have exited before its final snapshot, e.g. when cancelled
+				if stats, ok := b.emitDashboardSample(statsFile, outputDir, true); ok {
+					last = stats
+				}
+				return
+			}
+		}
+	}()
+
+	output, err := exec.CommandContext(ctx, cliPath, args...).CombinedOutput()
+	close(stop)
+	<-done
+	return output, last, err
+}
+
+// emitDashboardSample reads the CLI stats file and pushes it with the free disk
+// space; the sample after the CLI exited is marked done. It returns the statistics
+// and whether the CLI had written any.
+func (b *App) emitDashboardSample(statsFile string, outputDir string, exited bool) (DownloadStats, bool) {
+	sample := DashboardSample{OutputDir: outputDir, Timestamp: time.Now()}
+	data, err := os.ReadFile(statsFile)
+	written := err == nil
+	if written {
+		if err := json.Unmarshal(data, &sample.Stats); err != nil {
+			return DownloadStats{}, false
+		}
+	}
+	if exited {
+		sample.Stats.Done = true
+	}
+	if b.ctx != nil {
+		if free, err := freeDiskSpace(outputDir); err == nil {
+			sample.FreeDiskBytes = free
+		}
+		runtime.EventsEmit(b.ctx, statsUpdatedEvent, sample)
+	}
+	return sample.Stats, written
+}
+
+// GetFreeDiskSpace returns the free bytes on the volume holding dir
+func (b *App) GetFreeDiskSpace(dir string) (uint64, error) {
+	return freeDiskSpace(dir)
+}
@@ -1,13 +1,57 @@
-import { Component } from '@angular/core';
-import { FetchFiles, OpenInputFileDialog, OpenOutputDirectoryDialog, RunCLIFetch } from '../../wailsjs/go/main/App';
+import { Component, NgZone, OnDestroy, OnInit } from '@angular/core';
+import {
+  AddToQueue, CancelRun, GetQueue, GetRecentManifests, MoveQueueItem, OpenInputFileDialog, OpenOutputDirectoryDialog,
+  PauseQueue, RemoveFromQueue, RemoveRecentManifest, ResumeQueue, RetryQueueItem, RetrySeries, RunCLIFetch
+} from '../../wailsjs/go/main/App';
+import { main } from '../../wailsjs/go/models';
+import { EventsOn } from '../../wailsjs/runtime/runtime';
 
 
+// Dashboard sample pushed by the backend while the CLI runs
+interface DashboardSample {
+  stats: {
+    phase: string;
+    metadata: {
+      total: number;
+      fetched: number;
+      cached: number;
+      revalidated: number;
+      failed: number;
+      unavailable: number;
+      elapsed_seconds: number;
+      done: boolean;
+    } | null;
+    total: number;
+    downloaded: number;
+    synced: number;
+    skipped: number;
+    failed: number;
+    bytes_downloaded: number;
+    bytes_per_second: number;
+    elapsed_seconds: number;
+    workers: { worker_id: number; current: string; since: string }[] | null;
+    done: boolean;
+  };
+  freeDiskBytes: number;
+  outputDir: string;
+  timestamp: string;
+}
+
+// Manifest dropped on the window, pushed by the backend
+interface DroppedManifest {
+  path: string;
+  outputDir: string;
+  error: string;
+}
+
+const DASHBOARD_HISTORY = 60;
+
 @Component({
   selector: 'app-root',
   templateUrl: './app.component.html',
   styleUrls: ['./app.component.scss']
 })
-export class AppComponent {
+export class AppComponent implements OnInit, OnDestroy {
   status = 'Ready';
   inputFilePath = '';
   outputDirPath = '';
@@ -19,6 +63,244 @@ export class AppComponent {
   simultaneousDownloads = 2;
   skipExisting = true;
 
+  // Persistent download queue
+  queue: main.QueueState = new main.QueueState();
+  private unsubscribeQueue?: () => void;
+
+  // Recently run or queued manifests, most recent first
+  recent: main.RecentManifest[] = [];
+  private unsubscribeRecent?: () => void;
+  private unsubscribeDrop?: () => void;
+
+  // Live dashboard fed by the CLI stats file
+  dashboard?: DashboardSample;
+  bytesHistory: number[] = [];
+  rateHistory: number[] = [];
+  private unsubscribeStats?: () => void;
+
+  // Failed series of the last finished run, and those selected for a retry
+  runResult?: main.RunResult;
+  selectedSeries = new Set<string>();
+  private unsubscribeResults?: () => void;
+
+  constructor(private zone: NgZone) {}
+
+  ngOnInit() {
+    GetQueue().then((state: main.QueueState) => this.queue = state);
+    this.unsubscribeQueue = EventsOn('queue:updated', (state: main.QueueState) => {
+      this.zone.run(() => this.queue = state);
+    });
+    this.unsubscribeStats = EventsOn('stats:updated', (sample: DashboardSample) => {
+      this.zone.run(() => this.onDashboardSample(sample));
+    });
+    GetRecentManifests().then((items: main.RecentManifest[]) => this.recent = items);
+    this.unsubscribeRecent = EventsOn('recent:updated', (items: main.RecentManifest[]) => {
+      this.zone.run(() => this.recent = items);
+    });
+    this.unsubscribeDrop = EventsOn('manifest:dropped', (dropped: DroppedManifest) => {
+      this.zone.run(() => this.onManifestDropped(dropped));
+    });
+    this.unsubscribeResults = EventsOn('run:finished', (result: main.RunResult) => {
+      this.zone.run(() => this.onRunFinished(result));
+    });
+  }
+
+  ngOnDestroy() {
+    if (this.unsubscribeQueue) {
+      this.unsubscribeQueue();
+    }
+    if (this.unsubscribeStats) {
+      this.unsubscribeStats();
+    }
+    if (this.unsubscribeRecent) {
+      this.unsubscribeRecent();
+    }
+    if (this.unsubscribeDrop) {
+      this.unsubscribeDrop();
+    }
+    if (this.unsubscribeResults) {
+      this.unsubscribeResults();
+    }
+  }
+
+  // A run with failures replaces the results table, with every failed series selected
+  onRunFinished(result: main.RunResult) {
+    if (!result.failures?.length) {
+      this.runResult = undefined;
+      this.selectedSeries.clear();
+      return;
+    }
+    this.runResult = result;
+    this.selectedSeries = new Set(result.failures.map(f => f.series_uid));
+  }
+
+  toggleSeries(uid: string) {
+    if (this.selectedSeries.has(uid)) {
+      this.selectedSeries.delete(uid);
+    } else {
+      this.selectedSeries.add(uid);
+    }
+  }
+
+  allSeriesSelected(): boolean {
+    return !!this.runResult && this.selectedSeries.size === this.runResult.failures.length;
+  }
+
+  toggleAllSeries() {
+    if (!this.runResult) {
+      return;
+    }
+    this.selectedSeries = this.allSeriesSelected()
+      ? new Set<string>()
+      : new Set(this.runResult.failures.map(f => f.series_uid));
+  }
+
+  onRetrySelected() {
+    if (!this.runResult || !this.selectedSeries.size) {
+      return;
+    }
+    const count = this.selectedSeries.size;
+    RetrySeries(this.runResult, [...this.selectedSeries]).then((state: main.QueueState) => {
+      this.queue = state;
+      this.status = `Queued a retry of ${count} series of ${this.runResult?.inputPath}`;
+      this.runResult = undefined;
+      this.selectedSeries.clear();
+    }).catch(err => this.status = "Error: " + err);
+  }
+
+  onDismissResults() {
+    this.runResult = undefined;
+    this.selectedSeries.clear();
+  }
+
+  // A dropped manifest is loaded like a selected one; a manifest run before also
+  // gets the output directory it was last downloaded to
+  onManifestDropped(dropped: DroppedManifest) {
+    if (dropped.error) {
+      this.status = dropped.error;
+      return;
+    }
+    this.inputFilePath = dropped.path;
+    if (dropped.outputDir) {
+      this.outputDirPath = dropped.outputDir;
+    }
+    this.status = 'Loaded: ' + dropped.path;
+  }
+
+  onLoadRecent(item: main.RecentManifest) {
+    this.inputFilePath = item.path;
+    this.outputDirPath = item.outputDir;
+    this.status = 'Loaded: ' + item.path;
+  }
+
+  // onRerunRecent runs a recent manifest again into its last output directory,
+  // with the current advanced options
+  onRerunRecent(item: main.RecentManifest) {
+    this.onLoadRecent(item);
+    this.onFetchFiles();
+  }
+
+  onRemoveRecent(item: main.RecentManifest) {
+    RemoveRecentManifest(item.path).then((items: main.RecentManifest[]) => this.recent = items)
+      .catch(err => this.status = "Error: " + err);
+  }
+
+  onDashboardSample(sample: DashboardSample) {
+    this.dashboard = sample;
+    this.bytesHistory = [...this.bytesHistory, sample.stats.bytes_downloaded].slice(-DASHBOARD_HISTORY);
+    this.rateHistory = [...this.rateHistory, sample.stats.bytes_per_second].slice(-DASHBOARD_HISTORY);
+  }
+
+  // sparkline converts a series of values into SVG polyline points
+  sparkline(values: number[], width = 200, height = 40): string {
+    if (values.length < 2) {
+      return '';
+    }
+    const max = Math.max(...values, 1);
+    const step = width / (values.length - 1);
+    return values.map((v, i) => `${(i * step).toFixed(1)},${(height - (v / max) * height).toFixed(1)}`).join(' ');
+  }
+
+  formatBytes(bytes: number): string {
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    let value = bytes || 0;
+    let unit = 0;
+    while (value >= 1024 && unit < units.length - 1) {
+      value /= 1024;
+      unit++;
+    }
+    return `${value.toFixed(unit === 0 ? 0 : 1)} ${units[unit]}`;
+  }
+
+  onAddToQueue() {
+    if (!this.inputFilePath || !this.outputDirPath) {
+      this.status = "Please select both an input file and an output directory.";
+      return;
+    }
+    AddToQueue(this.inputFilePath, this.outputDirPath, this.maxConnections, this.maxRetries, this.simultaneousDownloads, this.skipExisting)
+      .then((state: main.QueueState) => {
+        this.queue = state;
+        this.status = 'Queued: ' + this.inputFilePath;
+      })
+      .catch(err => this.status = "Error: " + err);
+  }
+
+  // metadataCompleted counts the series whose metadata fetch finished, in any way
+  metadataCompleted(): number {
+    const m = this.dashboard?.stats.metadata;
+    if (!m) {
+      return 0;
+    }
+    return m.fetched + m.cached + m.revalidated + m.failed + m.unavailable;
+  }
+
+  metadataPercent(): number {
+    const m = this.dashboard?.stats.metadata;
+    if (!m || !m.total) {
+      return 0;
+    }
+    return Math.min(100, this.metadataCompleted() * 100 / m.total);
+  }
+
+  // A run is active until its final stats sample
+  isRunning(): boolean {
+    return !!this.dashboard && !this.dashboard.stats.done;
+  }
+
+  onCancelRun() {
+    CancelRun().then((state: main.QueueState) => {
+      this.queue = state;
+      this.status = this.dashboard?.stats.phase === 'metadata'
+        ? 'Cancelled while fetching metadata; nothing was downloaded.'
+        : 'Cancelled.';
+    }).catch(err => this.status = "Error: " + err);
+  }
+
+  onMoveQueueItem(item: main.QueueItem, offset: number) {
+    MoveQueueItem(item.id, offset).then((state: main.QueueState) => this.queue = state)
+      .catch(err => this.status = "Error: " + err);
+  }
+
+  onRemoveQueueItem(item: main.QueueItem) {
+    RemoveFromQueue(item.id).then((state: main.QueueState) => this.queue = state)
+      .catch(err => this.status = "Error: " + err);
+  }
+
+  onRetryQueueItem(item: main.QueueItem) {
+    RetryQueueItem(item.id).then((state: main.QueueState) => this.queue = state)
+      .catch(err => this.status = "Error: " + err);
+  }
+
+  onShowQueueOutput(item: main.QueueItem) {
+    this.status = item.output || '(no output yet)';
+  }
+
+  onToggleQueuePaused() {
+    const action = this.queue.paused ? ResumeQueue() : PauseQueue();
+    action.then((state: main.QueueState) => this.queue = state)
+      .catch(err => this.status = "Error: " + err);
+  }
+
   onSelectOutputDirectory() {
     OpenOutputDirectoryDialog().then((dirPath: string) => {
       if (dirPath) {
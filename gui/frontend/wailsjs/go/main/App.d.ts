@@ -1,14 +1,39 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
+import {main} from '../models';
+
+export function AddToQueue(arg1:string,arg2:string,arg3:number,arg4:number,arg5:number,arg6:boolean):Promise<main.QueueState>;
+
+export function CancelRun():Promise<main.QueueState>;
 
 export function FetchFiles():Promise<string>;
 
+export function GetFreeDiskSpace(arg1:string):Promise<number>;
+
+export function GetQueue():Promise<main.QueueState>;
+
+export function GetRecentManifests():Promise<Array<main.RecentManifest>>;
+
 export function Greet(arg1:string):Promise<string>;
 
+export function MoveQueueItem(arg1:string,arg2:number):Promise<main.QueueState>;
+
 export function OpenInputFileDialog():Promise<string>;
 
 export function OpenOutputDirectoryDialog():Promise<string>;
 
+export function PauseQueue():Promise<main.QueueState>;
+
+export function RemoveFromQueue(arg1:string):Promise<main.QueueState>;
+
+export function RemoveRecentManifest(arg1:string):Promise<Array<main.RecentManifest>>;
+
+export function ResumeQueue():Promise<main.QueueState>;
+
+export function RetryQueueItem(arg1:string):Promise<main.QueueState>;
+
+export function RetrySeries(arg1:main.RunResult,arg2:Array<string>):Promise<main.QueueState>;
+
 export function RunCLIFetch(arg1:string,arg2:string,arg3:number,arg4:number,arg5:number,arg6:boolean):Promise<string>;
 
 export function ShowDialog():Promise<void>;
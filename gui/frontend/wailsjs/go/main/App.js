@@ -2,14 +2,38 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AddToQueue(arg1, arg2, arg3, arg4, arg5, arg6) {
+  return window['go']['main']['App']['AddToQueue'](arg1, arg2, arg3, arg4, arg5, arg6);
+}
+
+export function CancelRun() {
+  return window['go']['main']['App']['CancelRun']();
+}
+
 export function FetchFiles() {
   return window['go']['main']['App']['FetchFiles']();
 }
 
+export function GetFreeDiskSpace(arg1) {
+  return window['go']['main']['App']['GetFreeDiskSpace'](arg1);
+}
+
+export function GetQueue() {
+  return window['go']['main']['App']['GetQueue']();
+}
+
+export function GetRecentManifests() {
+  return window['go']['main']['App']['GetRecentManifests']();
+}
+
 export function Greet(arg1) {
   return window['go']['main']['App']['Greet'](arg1);
 }
 
+export function MoveQueueItem(arg1, arg2) {
+  return window['go']['main']['App']['MoveQueueItem'](arg1, arg2);
+}
+
 export function OpenInputFileDialog() {
   return window['go']['main']['App']['OpenInputFileDialog']();
 }
@@ -18,6 +42,30 @@ export function OpenOutputDirectoryDialog() {
   return window['go']['main']['App']['OpenOutputDirectoryDialog']();
 }
 
+export function PauseQueue() {
+  return window['go']['main']['App']['PauseQueue']();
+}
+
+export function RemoveFromQueue(arg1) {
+  return window['go']['main']['App']['RemoveFromQueue'](arg1);
+}
+
+export function RemoveRecentManifest(arg1) {
+  return window['go']['main']['App']['RemoveRecentManifest'](arg1);
+}
+
+export function ResumeQueue() {
+  return window['go']['main']['App']['ResumeQueue']();
+}
+
+export function RetryQueueItem(arg1) {
+  return window['go']['main']['App']['RetryQueueItem'](arg1);
+}
+
+export function RetrySeries(arg1, arg2) {
+  return window['go']['main']['App']['RetrySeries'](arg1, arg2);
+}
+
 export function RunCLIFetch(arg1, arg2, arg3, arg4, arg5, arg6) {
   return window['go']['main']['App']['RunCLIFetch'](arg1, arg2, arg3, arg4, arg5, arg6);
 }
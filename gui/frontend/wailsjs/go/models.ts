@@ -0,0 +1,116 @@
+export namespace main {
+	
+	export class FailedSeries {
+	    series_uid: string;
+	    code: string;
+	    error: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new FailedSeries(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.series_uid = source["series_uid"];
+	        this.code = source["code"];
+	        this.error = source["error"];
+	    }
+	}
+	export class QueueItem {
+	    id: string;
+	    inputPath: string;
+	    outputDir: string;
+	    maxConnections: number;
+	    maxRetries: number;
+	    simultaneousDownloads: number;
+	    skipExisting: boolean;
+	    seriesUIDs?: string[];
+	    status: string;
+	    output: string;
+	    // Go type: time
+	    addedAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new QueueItem(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.inputPath = source["inputPath"];
+	        this.outputDir = source["outputDir"];
+	        this.maxConnections = source["maxConnections"];
+	        this.maxRetries = source["maxRetries"];
+	        this.simultaneousDownloads = source["simultaneousDownloads"];
+	        this.skipExisting = source["skipExisting"];
+	        this.seriesUIDs = source["seriesUIDs"];
+	        this.status = source["status"];
+	        this.output = source["output"];
+	        this.addedAt = source["addedAt"];
+	    }
+	}
+	export class QueueState {
+	    items: QueueItem[];
+	    paused: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new QueueState(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.items = (source["items"] || []).map((item: any) => new QueueItem(item));
+	        this.paused = source["paused"];
+	    }
+	}
+	export class RunResult {
+	    inputPath: string;
+	    outputDir: string;
+	    maxConnections: number;
+	    maxRetries: number;
+	    simultaneousDownloads: number;
+	    total: number;
+	    failures: FailedSeries[];
+	    error: string;
+	    // Go type: time
+	    finishedAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new RunResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.inputPath = source["inputPath"];
+	        this.outputDir = source["outputDir"];
+	        this.maxConnections = source["maxConnections"];
+	        this.maxRetries = source["maxRetries"];
+	        this.simultaneousDownloads = source["simultaneousDownloads"];
+	        this.total = source["total"];
+	        this.failures = (source["failures"] || []).map((item: any) => new FailedSeries(item));
+	        this.error = source["error"];
+	        this.finishedAt = source["finishedAt"];
+	    }
+	}
+	export class RecentManifest {
+	    path: string;
+	    outputDir: string;
+	    // Go type: time
+	    lastUsed: any;
+	    missing: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new RecentManifest(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.path = source["path"];
+	        this.outputDir = source["outputDir"];
+	        this.lastUsed = source["lastUsed"];
+	        this.missing = source["missing"];
+	    }
+	}
+
+}
+
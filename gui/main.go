@@ -31,6 +31,11 @@ func main() {
 		LogLevel:          logger.DEBUG,
 		OnStartup:         app.startup,
 		OnShutdown:        app.shutdown,
+		// Manifests dropped on the window are loaded by app.onFileDrop
+		DragAndDrop: &options.DragAndDrop{
+			EnableFileDrop:     true,
+			DisableWebViewDrop: true,
+		},
 		Bind: []interface{}{
 			app,
 		},
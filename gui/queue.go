@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Queue item states
+const (
+	QueuePending   = "pending"
+	QueueRunning   = "running"
+	QueueDone      = "done"
+	QueueFailed    = "failed"
+	QueueCancelled = "cancelled"
+)
+
+// queueUpdatedEvent is emitted to the frontend whenever the queue changes
+const queueUpdatedEvent = "queue:updated"
+
+// QueueItem is a single manifest download scheduled in the GUI queue
+type QueueItem struct {
+	ID                    string    `json:"id"`
+	InputPath             string    `json:"inputPath"`
+	OutputDir             string    `json:"outputDir"`
+	MaxConnections        int       `json:"maxConnections"`
+	MaxRetries            int       `json:"maxRetries"`
+	SimultaneousDownloads int       `json:"simultaneousDownloads"`
+	SkipExisting          bool      `json:"skipExisting"`
+	SeriesUIDs            []string  `json:"seriesUIDs,omitempty"`  // only these series of the input, when retrying failures
+	Interrupted           bool      `json:"interrupted,omitempty"` // stopped while running; resumes with --skip-existing
+	Status                string    `json:"status"`
+	Output                string    `json:"output"`
+	AddedAt               time.Time `json:"addedAt"`
+}
+
+// QueueState is the persisted queue snapshot shared with the frontend
+type QueueState struct {
+	Items  []*QueueItem `json:"items"`
+	Paused bool         `json:"paused"`
+}
+
+// DownloadQueue persists queued downloads across sessions and runs them one at a time
+type DownloadQueue struct {
+	state   QueueState
+	path    string
+	running bool
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+}
+
+// queueStatePath returns the location of the queue file in the user config directory
+func queueStatePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "nbia-data-retriever", "queue.json"), nil
+}
+
+// loadDownloadQueue restores the queue from disk. Items interrupted by a previous
+// shutdown are reset to pending so they resume (with --skip-existing) on the next run.
+func loadDownloadQueue(path string) *DownloadQueue {
+	q := &DownloadQueue{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return q
+	}
+	if err := json.Unmarshal(data, &q.state); err != nil {
+		return q
+	}
+	for _, item := range q.state.Items {
+		if item.Status == QueueRunning {
+			item.Status = QueuePending
+			item.Interrupted = true
+		}
+	}
+	return q
+}
+
+// saveLocked writes the queue to disk (caller must hold lock)
+func (q *DownloadQueue) saveLocked() error {
+	if q.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(q.state, "", "\t")
+	if err != nil {
+		return err
+	}
+	tempFile := q.path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, q.path)
+}
+
+// snapshotLocked returns a copy of the queue state safe to hand to the frontend
+func (q *DownloadQueue) snapshotLocked() QueueState {
+	items := make([]*QueueItem, len(q.state.Items))
+	for i, item := range q.state.Items {
+		copied := *item
+		items[i] = &copied
+	}
+	return QueueState{Items: items, Paused: q.state.Paused}
+}
+
+// indexLocked returns the position of an item by ID, or -1
+func (q *DownloadQueue) indexLocked(id string) int {
+	for i, item := range q.state.Items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextPendingLocked returns the first pending item, or nil
+func (q *DownloadQueue) nextPendingLocked() *QueueItem {
+	for _, item := range q.state.Items {
+		if item.Status == QueuePending {
+			return item
+		}
+	}
+	return nil
+}
+
+// notifyQueue persists the queue and pushes the new state to the frontend
+func (b *App) notifyQueue() {
+	b.queue.mu.Lock()
+	if err := b.queue.saveLocked(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save download queue: %v\n", err)
+	}
+	snapshot := b.queue.snapshotLocked()
+	b.queue.mu.Unlock()
+
+	if b.ctx != nil {
+		runtime.EventsEmit(b.ctx, queueUpdatedEvent, snapshot)
+	}
+}
+
+// GetQueue returns the current download queue
+func (b *App) GetQueue() QueueState {
+	b.queue.mu.Lock()
+	defer b.queue.mu.Unlock()
+	return b.queue.snapshotLocked()
+}
+
+// AddToQueue appends a manifest to the download queue and starts it if the queue is idle
+func (b *App) AddToQueue(inputPath string, outputDir string, maxConnections int, maxRetries int, simultaneousDownloads int, skipExisting bool) (QueueState, error) {
+	if inputPath == "" || outputDir == "" {
+		return b.GetQueue(), fmt.Errorf("both an input file and an output directory are required")
+	}
+	b.recordRecent(inputPath, outputDir)
+	return b.enqueue(&QueueItem{
+		InputPath:             inputPath,
+		OutputDir:             outputDir,
+		MaxConnections:        maxConnections,
+		MaxRetries:            maxRetries,
+		SimultaneousDownloads: simultaneousDownloads,
+		SkipExisting:          skipExisting,
+	}), nil
+}
+
+// enqueue appends a new pending item and starts the queue if it is idle
+func (b *App) enqueue(item *QueueItem) QueueState {
+	item.ID = fmt.Sprintf("%d", time.Now().UnixNano())
+	item.Status = QueuePending
+	item.AddedAt = time.Now()
+
+	b.queue.mu.Lock()
+	b.queue.state.Items = append(b.queue.state.Items, item)
+	b.queue.mu.Unlock()
+
+	b.notifyQueue()
+	b.startQueue()
+	return b.GetQueue()
+}
+
+// RemoveFromQueue removes an item that is not currently running
+func (b *App) RemoveFromQueue(id string) (QueueState, error) {
+	b.queue.mu.Lock()
+	i := b.queue.indexLocked(id)
+	if i == -1 {
+		b.queue.mu.Unlock()
+		return b.GetQueue(), fmt.Errorf("queue item %s not found", id)
+	}
+	if b.queue.state.Items[i].Status == QueueRunning {
+		b.queue.mu.Unlock()
+		return b.GetQueue(), fmt.Errorf("cannot remove a running item; pause the queue first")
+	}
+	b.queue.state.Items = append(b.queue.state.Items[:i], b.queue.state.Items[i+1:]...)
+	b.queue.mu.Unlock()
+
+	b.notifyQueue()
+	return b.GetQueue(), nil
+}
+
+// MoveQueueItem moves an item up (negative offset) or down (positive offset) in the queue
+func (b *App) MoveQueueItem(id string, offset int) (QueueState, error) {
+	b.queue.mu.Lock()
+	i := b.queue.indexLocked(id)
+	if i == -1 {
+		b.queue.mu.Unlock()
+		return b.GetQueue(), fmt.Errorf("queue item %s not found", id)
+	}
+	j := i + offset
+	if j < 0 {
+		j = 0
+	}
+	if j >= len(b.queue.state.Items) {
+		j = len(b.queue.state.Items) - 1
+	}
+	item := b.queue.state.Items[i]
+	items := append(b.queue.state.Items[:i:i], b.queue.state.Items[i+1:]...)
+	items = append(items[:j], append([]*QueueItem{item}, items[j:]...)...)
+	b.queue.state.Items = items
+	b.queue.mu.Unlock()
+
+	b.notifyQueue()
+	return b.GetQueue(), nil
+}
+
+// RetryQueueItem resets a finished or failed item so it runs again
+func (b *App) RetryQueueItem(id string) (QueueState, error) {
+	b.queue.mu.Lock()
+	i := b.queue.indexLocked(id)
+	if i == -1 {
+		b.queue.mu.Unlock()
+		return b.GetQueue(), fmt.Errorf("queue item %s not found", id)
+	}
+	if b.queue.state.Items[i].Status != QueueRunning {
+		b.queue.state.Items[i].Status = QueuePending
+	}
+	b.queue.mu.Unlock()
+
+	b.notifyQueue()
+	b.startQueue()
+	return b.GetQueue(), nil
+}
+
+// PauseQueue stops the running download and prevents further items from starting.
+// The interrupted item goes back to pending and resumes with --skip-existing.
+func (b *App) PauseQueue() QueueState {
+	b.queue.mu.Lock()
+	b.queue.state.Paused = true
+	if b.queue.cancel != nil {
+		b.queue.cancel()
+	}
+	b.queue.mu.Unlock()
+
+	b.notifyQueue()
+	return b.GetQueue()
+}
+
+// ResumeQueue unpauses the queue and starts processing pending items
+func (b *App) ResumeQueue() QueueState {
+	b.queue.mu.Lock()
+	b.queue.state.Paused = false
+	b.queue.mu.Unlock()
+
+	b.notifyQueue()
+	b.startQueue()
+	return b.GetQueue()
+}
+
+// startQueue launches the queue runner unless it is already active or paused
+func (b *App) startQueue() {
+	b.queue.mu.Lock()
+	defer b.queue.mu.Unlock()
+	if b.queue.running || b.queue.state.Paused {
+		return
+	}
+	b.queue.running = true
+	go b.runQueue()
+}
+
+// runQueue processes pending items sequentially until none remain or the queue is paused
+func (b *App) runQueue() {
+	for {
+		b.queue.mu.Lock()
+		item := b.queue.nextPendingLocked()
+		if item == nil || b.queue.state.Paused {
+			b.queue.running = false
+			b.queue.mu.Unlock()
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		b.queue.cancel = cancel
+		item.Status = QueueRunning
+		item.Output = ""
+		// Resuming an interrupted item must not re-download completed series
+		skipExisting := item.SkipExisting || item.Interrupted
+		args := buildCLIArgs(item.InputPath, item.OutputDir, item.MaxConnections, item.MaxRetries, item.SimultaneousDownloads, skipExisting)
+		seriesList, err := writeSeriesList(item.SeriesUIDs)
+		if seriesList != "" {
+			args = append(args, "--series-list", seriesList)
+		}
+		b.queue.mu.Unlock()
+		b.notifyQueue()
+
+		var output []byte
+		var stats DownloadStats
+		if err == nil {
+			output, stats, err = b.runCLIWithStats(ctx, item.OutputDir, args)
+		}
+		if seriesList != "" {
+			os.Remove(seriesList)
+		}
+		if ctx.Err() == nil {
+			b.emitRunResult(runResult(item, stats, err))
+		}
+
+		b.queue.mu.Lock()
+		b.queue.cancel = nil
+		item.Output = string(output)
+		switch {
+		case item.Status == QueueCancelled:
+			// Cancelled by the user; it stays until run again
+		case ctx.Err() != nil:
+			item.Status = QueuePending
+			item.Interrupted = true
+		case err != nil:
+			item.Status = QueueFailed
+			item.Output += "\n" + err.Error()
+			item.Interrupted = false
+		default:
+			item.Status = QueueDone
+			item.Interrupted = false
+		}
+		b.queue.mu.Unlock()
+		cancel()
+		b.notifyQueue()
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// maxRecentManifests is the number of manifests kept in the recent list
+const maxRecentManifests = 10
+
+// manifestDroppedEvent is emitted to the frontend when a manifest is dropped on the window
+const manifestDroppedEvent = "manifest:dropped"
+
+// recentUpdatedEvent is emitted to the frontend whenever the recent list changes
+const recentUpdatedEvent = "recent:updated"
+
+// manifestExtensions are the input files the CLI accepts that can be dropped on the window
+var manifestExtensions = map[string]bool{".tcia": true, ".csv": true, ".s5cmd": true}
+
+// RecentManifest is a manifest that was run or queued, with the output directory it
+// was last downloaded to
+type RecentManifest struct {
+	Path      string    `json:"path"`
+	OutputDir string    `json:"outputDir"`
+	LastUsed  time.Time `json:"lastUsed"`
+	Missing   bool      `json:"missing"`
+}
+
+// DroppedManifest is the manifest dropped on the window, with the output directory
+// it was last used with, if any
+type DroppedManifest struct {
+	Path      string `json:"path"`
+	OutputDir string `json:"outputDir"`
+	Error     string `json:"error"`
+}
+
+// RecentManifests persists the recently used manifests, most recent first
+type RecentManifests struct {
+	items []RecentManifest
+	path  string
+	mu    sync.Mutex
+}
+
+// recentManifestsPath returns the location of the recent list in the user config directory
+func recentManifestsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "nbia-data-retriever", "recent.json"), nil
+}
+
+// loadRecentManifests restores the recent list from disk
+func loadRecentManifests(path string) *RecentManifests {
+	r := &RecentManifests{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return r
+	}
+	if err := json.Unmarshal(data, &r.items); err != nil {
+		r.items = nil
+	}
+	return r
+}
+
+// saveLocked writes the recent list to disk (caller must hold lock)
+func (r *RecentManifests) saveLocked() error {
+	if r.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r.items, "", "\t")
+	if err != nil {
+		return err
+	}
+	tempFile := r.path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, r.path)
+}
+
+// record moves a manifest to the top of the list with the output directory it was
+// used with, dropping the oldest entries beyond maxRecentManifests
+func (r *RecentManifests) record(manifestPath string, outputDir string) {
+	if manifestPath == "" || outputDir == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	items := []RecentManifest{{Path: manifestPath, OutputDir: outputDir, LastUsed: time.Now()}}
+	for _, item := range r.items {
+		if item.Path != manifestPath && len(items) < maxRecentManifests {
+			items = append(items, item)
+		}
+	}
+	r.items = items
+	if err := r.saveLocked(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save recent manifests: %v\n", err)
+	}
+}
+
+// lookup returns the entry of a manifest, if it is in the list
+func (r *RecentManifests) lookup(manifestPath string) (RecentManifest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, item := range r.items {
+		if item.Path == manifestPath {
+			return item, true
+		}
+	}
+	return RecentManifest{}, false
+}
+
+// snapshot returns a copy of the list, flagging manifests that no longer exist
+func (r *RecentManifests) snapshot() []RecentManifest {
+	r.mu.Lock()
+	items := make([]RecentManifest, len(r.items))
+	copy(items, r.items)
+	r.mu.Unlock()
+	for i := range items {
+		_, err := os.Stat(items[i].Path)
+		items[i].Missing = os.IsNotExist(err)
+	}
+	return items
+}
+
+// recordRecent records a manifest run or queued and pushes the new list to the frontend
+func (b *App) recordRecent(manifestPath string, outputDir string) {
+	b.recent.record(manifestPath, outputDir)
+	if b.ctx != nil {
+		runtime.EventsEmit(b.ctx, recentUpdatedEvent, b.recent.snapshot())
+	}
+}
+
+// GetRecentManifests returns the recently used manifests, most recent first
+func (b *App) GetRecentManifests() []RecentManifest {
+	return b.recent.snapshot()
+}
+
+// RemoveRecentManifest removes a manifest from the recent list
+func (b *App) RemoveRecentManifest(manifestPath string) ([]RecentManifest, error) {
+	b.recent.mu.Lock()
+	items := b.recent.items[:0]
+	for _, item := range b.recent.items {
+		if item.Path != manifestPath {
+			items = append(items, item)
+		}
+	}
+	b.recent.items = items
+	err := b.recent.saveLocked()
+	b.recent.mu.Unlock()
+	return b.GetRecentManifests(), err
+}
+
+// onFileDrop loads the first manifest dropped on the window. Other files are
+// ignored; if none is a manifest the frontend is told why nothing was loaded.
+func (b *App) onFileDrop(x, y int, paths []string) {
+	dropped := DroppedManifest{Error: "not a manifest: drop a .tcia, .csv or .s5cmd file"}
+	for _, path := range paths {
+		if !manifestExtensions[strings.ToLower(filepath.Ext(path))] {
+			continue
+		}
+		dropped = DroppedManifest{Path: path}
+		if item, ok := b.recent.lookup(path); ok {
+			dropped.OutputDir = item.OutputDir
+		}
+		break
+	}
+	runtime.EventsEmit(b.ctx, manifestDroppedEvent, dropped)
+}
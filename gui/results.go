@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// runFinishedEvent is emitted to the frontend with the results of each finished run
+const runFinishedEvent = "run:finished"
+
+// RunResult is the outcome of a finished run: the series that failed, with the
+// options of the run so that they can be retried
+type RunResult struct {
+	InputPath             string         `json:"inputPath"`
+	OutputDir             string         `json:"outputDir"`
+	MaxConnections        int            `json:"maxConnections"`
+	MaxRetries            int            `json:"maxRetries"`
+	SimultaneousDownloads int            `json:"simultaneousDownloads"`
+	Total                 int32          `json:"total"`
+	Failures              []FailedSeries `json:"failures"`
+	Error                 string         `json:"error"`
+	FinishedAt            time.Time      `json:"finishedAt"`
+}
+
+// runResult collects the results of a queue item from the last statistics of its run
+func runResult(item *QueueItem, stats DownloadStats, err error) RunResult {
+	result := RunResult{
+		InputPath:             item.InputPath,
+		OutputDir:             item.OutputDir,
+		MaxConnections:        item.MaxConnections,
+		MaxRetries:            item.MaxRetries,
+		SimultaneousDownloads: item.SimultaneousDownloads,
+		Total:                 stats.Total,
+		Failures:              stats.Failures,
+		FinishedAt:            time.Now(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// emitRunResult pushes the results of a finished run to the frontend
+func (b *App) emitRunResult(result RunResult) {
+	if b.ctx != nil {
+		runtime.EventsEmit(b.ctx, runFinishedEvent, result)
+	}
+}
+
+// RetrySeries queues the selected failed series of a run again, with the input,
+// output directory and options of that run. Only these series are transferred;
+// the rest of the input is not gone through again.
+func (b *App) RetrySeries(result RunResult, seriesUIDs []string) (QueueState, error) {
+	if len(seriesUIDs) == 0 {
+		return b.GetQueue(), fmt.Errorf("no series selected")
+	}
+	if result.InputPath == "" || result.OutputDir == "" {
+		return b.GetQueue(), fmt.Errorf("the run has no input file or output directory")
+	}
+	return b.enqueue(&QueueItem{
+		InputPath:             result.InputPath,
+		OutputDir:             result.OutputDir,
+		MaxConnections:        result.MaxConnections,
+		MaxRetries:            result.MaxRetries,
+		SimultaneousDownloads: result.SimultaneousDownloads,
+		SkipExisting:          true,
+		SeriesUIDs:            seriesUIDs,
+	}), nil
+}
+
+// writeSeriesList saves series UIDs to a temporary file for the CLI's --series-list.
+// It returns an empty path when there are none, i.e. the whole input is run.
+func writeSeriesList(seriesUIDs []string) (string, error) {
+	if len(seriesUIDs) == 0 {
+		return "", nil
+	}
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("nbia-gui-series-%d.txt", time.Now().UnixNano()))
+	if err := os.WriteFile(path, []byte(strings.Join(seriesUIDs, "\n")+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to write the series list: %w", err)
+	}
+	return path, nil
+}
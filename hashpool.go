@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"sync"
+)
+
+// Hash constructors used for all MD5 and SHA-256 computation. They are the hook for
+// alternative (e.g. SIMD or hardware offloaded) implementations: a build-tagged file
+// can replace them in an init function. The standard library versions already use
+// the CPU's SHA and AVX2 instructions where available.
+var (
+	newMD5Hash    func() hash.Hash = md5.New
+	newSHA256Hash func() hash.Hash = sha256.New
+)
+
+// hashPoolMaxFileSize is the largest extracted file handed to the pool; larger files
+// are hashed inline while streaming so they are never buffered in memory
+const hashPoolMaxFileSize = 8 * 1024 * 1024
+
+// hashPool is shared by all download workers; nil hashes inline
+var hashPool *HashPool
+
+// HashResult holds the hex digests computed for a job (empty when not requested)
+type HashResult struct {
+	MD5    string
+	SHA256 string
+}
+
+type hashJob struct {
+	data   []byte
+	md5    bool
+	sha256 bool
+	result chan HashResult
+}
+
+// HashPool computes digests on a fixed number of goroutines, sized independently of
+// the download workers, so extraction of many small files is not serialized on hashing
+type HashPool struct {
+	jobs    chan *hashJob
+	wg      sync.WaitGroup
+	workers int // also the number of files of a ZIP extracted at once
+}
+
+// NewHashPool starts a pool with the given number of hashing goroutines
+func NewHashPool(workers int) *HashPool {
+	p := &HashPool{jobs: make(chan *hashJob, workers*2), workers: workers}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job.result <- hashBytes(job.data, job.md5, job.sha256)
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues data for hashing and returns a channel receiving the result.
+// It blocks while the pool is saturated, bounding the memory held by pending jobs.
+func (p *HashPool) Submit(data []byte, wantMD5, wantSHA256 bool) <-chan HashResult {
+	job := &hashJob{data: data, md5: wantMD5, sha256: wantSHA256, result: make(chan HashResult, 1)}
+	p.jobs <- job
+	return job.result
+}
+
+// Close stops the pool after all queued jobs are done
+func (p *HashPool) Close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// hashBytes computes the requested digests of data
+func hashBytes(data []byte, wantMD5, wantSHA256 bool) HashResult {
+	var result HashResult
+	if wantMD5 {
+		h := newMD5Hash()
+		h.Write(data)
+		result.MD5 = hex.EncodeToString(h.Sum(nil))
+	}
+	if wantSHA256 {
+		h := newSHA256Hash()
+		h.Write(data)
+		result.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return result
+}
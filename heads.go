@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// urlHeadsFile keeps the HTTP validators of direct URLs, below metadata/
+const urlHeadsFile = "url-heads.json"
+
+// urlHeads holds what is known about direct URLs when --head-check is set; nil otherwise
+var urlHeads *URLHeadCache
+
+// URLHead is the size and validators of a direct URL as reported by the server
+type URLHead struct {
+	Size         int64     `json:"size,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// urlHeadState pairs the latest HEAD answer of a URL with the validators of the copy
+// (or partial transfer) on disk
+type urlHeadState struct {
+	Remote     *URLHead `json:"remote,omitempty"`
+	Downloaded *URLHead `json:"downloaded,omitempty"`
+}
+
+// URLHeadCache is the persistent state of direct URLs
+type URLHeadCache struct {
+	path  string
+	mu    sync.Mutex
+	heads map[string]*urlHeadState
+}
+
+// headOf reads the size and validators of a response. The size of a partial response
+// is the total of its Content-Range.
+func headOf(resp *http.Response) *URLHead {
+	head := &URLHead{
+		Size:         resp.ContentLength,
+		LastModified: resp.Header.Get("Last-Modified"),
+		ETag:         resp.Header.Get("ETag"),
+		CheckedAt:    time.Now().UTC(),
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		head.Size = -1
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			if size, err := strconv.ParseInt(total, 10, 64); err == nil {
+				head.Size = size
+			}
+		}
+	}
+	if head.Size < 0 || resp.Header.Get("Content-Encoding") != "" {
+		head.Size = 0
+	}
+	return head
+}
+
+// sameVersion reports whether two answers describe the same content. Without a
+// common validator the content is assumed unchanged.
+func (h *URLHead) sameVersion(other *URLHead) bool {
+	switch {
+	case h.ETag != "" && other.ETag != "":
+		return h.ETag == other.ETag
+	case h.LastModified != "" && other.LastModified != "":
+		return h.LastModified == other.LastModified
+	}
+	return true
+}
+
+// OpenURLHeadCache loads the URL state of the output directory
+func OpenURLHeadCache(output string) (*URLHeadCache, error) {
+	c := &URLHeadCache{path: filepath.Join(output, "metadata", urlHeadsFile)}
+	heads, err := readURLHeads(c.path)
+	if err != nil {
+		return nil, err
+	}
+	c.heads = heads
+	return c, nil
+}
+
+// readURLHeads reads a URL state file; a missing file is empty
+func readURLHeads(path string) (map[string]*urlHeadState, error) {
+	heads := make(map[string]*urlHeadState)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return heads, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &heads); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return heads, nil
+}
+
+// state returns the entry of a URL, creating it; the caller holds c.mu
+func (c *URLHeadCache) state(url string) *urlHeadState {
+	s, ok := c.heads[url]
+	if !ok {
+		s = &urlHeadState{}
+		c.heads[url] = s
+	}
+	return s
+}
+
+// SetRemote records the answer of a HEAD request
+func (c *URLHeadCache) SetRemote(url string, head *URLHead) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state(url).Remote = head
+}
+
+// SetDownloaded records the validators of the content being written to disk, which
+// are also the latest the server announced
+func (c *URLHeadCache) SetDownloaded(url string, head *URLHead) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.state(url)
+	s.Remote, s.Downloaded = head, head
+}
+
+// Changed reports whether the server announced other content than the local copy
+// of a URL was downloaded from
+func (c *URLHeadCache) Changed(url string) (bool, string) {
+	if c == nil {
+		return false, ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.heads[url]
+	if !ok || s.Remote == nil || s.Downloaded == nil || s.Remote.sameVersion(s.Downloaded) {
+		return false, ""
+	}
+	if s.Remote.ETag != "" && s.Downloaded.ETag != "" {
+		return true, fmt.Sprintf("ETag %s, downloaded %s", s.Remote.ETag, s.Downloaded.ETag)
+	}
+	return true, fmt.Sprintf("Last-Modified %s, downloaded %s", s.Remote.LastModified, s.Downloaded.LastModified)
+}
+
+// SetIfRange makes a resumed request conditional on the content of the partial
+// transfer: a server holding other content answers with the whole new file
+func (c *URLHeadCache) SetIfRange(req *http.Request, url string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.heads[url]
+	if !ok || s.Downloaded == nil {
+		return
+	}
+	// Weak ETags are not allowed in If-Range
+	if etag := s.Downloaded.ETag; etag != "" && !strings.HasPrefix(etag, "W/") {
+		req.Header.Set("If-Range", etag)
+	} else if s.Downloaded.LastModified != "" {
+		req.Header.Set("If-Range", s.Downloaded.LastModified)
+	}
+}
+
+// Save writes the URL state, keeping entries other invocations sharing the output
+// directory added meanwhile
+func (c *URLHeadCache) Save() error {
+	if c == nil {
+		return nil
+	}
+	return outputLock.WithState(func() error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if outputLock.Shared() {
+			saved, err := readURLHeads(c.path)
+			if err != nil {
+				return err
+			}
+			for url, s := range saved {
+				if _, ok := c.heads[url]; !ok {
+					c.heads[url] = s
+				}
+			}
+		}
+		content, err := json.MarshalIndent(c.heads, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+			return err
+		}
+		return writeFileAtomic(c.path, content, 0644)
+	})
+}
+
+// headURL asks the server for the size and validators of a URL
+func headURL(httpClient *http.Client, url string) (*URLHead, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The size of the body as stored, not of a compressed transfer
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := doRequest(httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPStatusError(resp)
+	}
+	return headOf(resp), nil
+}
+
+// headCheckDirect issues HEAD requests for the direct URLs among files with
+// options.MetadataWorkers in parallel. Items without a size in their manifest take
+// the Content-Length; the validators are kept to detect content changed on the
+// server. Failed requests are logged and leave the item as it is.
+func headCheckDirect(files []*FileInfo, httpClient *http.Client, options *Options) {
+	var direct []*FileInfo
+	for _, info := range files {
+		// Azure blobs may need signed requests
+		if info.DRSURI == "" && strings.HasPrefix(info.DownloadURL, "http") && !isAzureURL(info.DownloadURL) {
+			direct = append(direct, info)
+		}
+	}
+	if len(direct) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Checking %d direct URLs...\n", len(direct))
+
+	var mu sync.Mutex
+	var sized, failed int
+	jobs := make(chan *FileInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, options.MetadataWorkers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for info := range jobs {
+				head, err := headURL(httpClient, info.DownloadURL)
+				mu.Lock()
+				switch {
+				case err != nil:
+					failed++
+					logger.Debugf("HEAD %s failed: %v", info.DownloadURL, err)
+				case info.FileSize == "" && head.Size > 0:
+					info.FileSize = strconv.FormatInt(head.Size, 10)
+					sized++
+				}
+				mu.Unlock()
+				if err == nil {
+					urlHeads.SetRemote(info.DownloadURL, head)
+				}
+			}
+		}()
+	}
+	for _, info := range direct {
+		jobs <- info
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failed > 0 {
+		logger.Warnf("HEAD requests failed for %d of %d direct URLs; their sizes stay unknown", failed, len(direct))
+	}
+	logger.Infof("HEAD checked %d direct URLs, %d sizes learned", len(direct)-failed, sized)
+	if err := urlHeads.Save(); err != nil {
+		logger.Warnf("Failed to save %s: %v", urlHeadsFile, err)
+	}
+}
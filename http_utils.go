@@ -11,8 +11,14 @@ func doRequest(client *http.Client, req *http.Request) (*http.Response, error) {
 	// Save original URL for potential fallback
 	originalURL := req.URL.String()
 
+	// Pause while another worker's request was rate limited
+	if err := throttle.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+
 	// Try the request as-is
 	resp, err := client.Do(req)
+	throttle.Observe(resp)
 
 	// If successful or not a v2 endpoint, return as-is
 	if err != nil || !strings.Contains(originalURL, "/v2/") {
@@ -44,9 +50,36 @@ func doRequest(client *http.Client, req *http.Request) (*http.Response, error) {
 
 		// Try v1 endpoint
 		logger.Infof("Attempting v1 endpoint: %s", v1URL)
-		return client.Do(v1Req)
+		resp, err := client.Do(v1Req)
+		throttle.Observe(resp)
+		return resp, err
 	}
 
 	// Return original response for other status codes
 	return resp, nil
 }
+
+// doAuthorizedRequest sends an NBIA request with the access token. A request sent
+// without credentials by an anonymous token and rejected by the API is sent again
+// once the token has logged in.
+func doAuthorizedRequest(client *http.Client, req *http.Request, authToken *Token) (*http.Response, error) {
+	if err := authToken.authorize(req); err != nil {
+		return nil, err
+	}
+	resp, err := doRequest(client, req)
+	if err != nil || req.Header.Get("Authorization") != "" {
+		return resp, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return resp, nil
+	}
+	if !authToken.escalate() {
+		return resp, nil
+	}
+	resp.Body.Close()
+	retry := req.Clone(req.Context())
+	if err := authToken.authorize(retry); err != nil {
+		return nil, err
+	}
+	return doRequest(client, retry)
+}
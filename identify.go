@@ -0,0 +1,88 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// requestIDHeader carries the ID of each request with --request-id
+const requestIDHeader = "X-Request-ID"
+
+// defaultUserAgent identifies the tool, its version and platform to the servers
+func defaultUserAgent() string {
+	return fmt.Sprintf("nbia-data-retriever-cli/%s (%s/%s; +https://github.com/GrigoryEvko/NBIA_data_retriever_CLI)",
+		cmp.Or(version, "dev"), runtime.GOOS, runtime.GOARCH)
+}
+
+// newRequestID returns a random UUID (version 4)
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// identifyingTransport sets the User-Agent of every request and, with --request-id,
+// a new X-Request-ID, which is logged with the outcome of the request so that
+// server operators can find it in their logs
+type identifyingTransport struct {
+	base       http.RoundTripper
+	userAgent  string
+	requestIDs bool
+}
+
+// identifyRequests makes the requests of client carry the user agent and, if
+// requestIDs is set, an X-Request-ID each
+func identifyRequests(client *http.Client, userAgent string, requestIDs bool) {
+	client.Transport = &identifyingTransport{base: client.Transport, userAgent: userAgent, requestIDs: requestIDs}
+}
+
+func (t *identifyingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	if !t.requestIDs {
+		return t.base.RoundTrip(req)
+	}
+
+	id := newRequestID()
+	req.Header.Set(requestIDHeader, id)
+	// The query is left out, it may hold signatures or credentials
+	target := req.URL.Host + req.URL.Path
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil:
+		logger.Warnf("Request %s %s %s failed: %v", id, req.Method, target, err)
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		logger.Warnf("Request %s %s %s answered %s", id, req.Method, target, resp.Status)
+	default:
+		logger.Debugf("Request %s %s %s answered %s in %v", id, req.Method, target, resp.Status,
+			time.Since(start).Round(time.Millisecond))
+	}
+	return resp, err
+}
+
+// requestID returns the X-Request-ID a response answers, if any
+func requestID(resp *http.Response) string {
+	if resp == nil || resp.Request == nil {
+		return ""
+	}
+	return resp.Request.Header.Get(requestIDHeader)
+}
+
+// responseStatus returns the status of a response for error messages, with the
+// request ID if any
+func responseStatus(resp *http.Response) string {
+	if id := requestID(resp); id != "" {
+		return resp.Status + " (request " + id + ")"
+	}
+	return resp.Status
+}
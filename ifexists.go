@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --if-exists policies for items already on disk
+const (
+	ifExistsSkip      = "skip"      // skip whatever is there, without checking it
+	ifExistsVerify    = "verify"    // skip only complete items whose size and checksums match
+	ifExistsResume    = "resume"    // skip complete items, continue partial transfers
+	ifExistsOverwrite = "overwrite" // always download again
+)
+
+// existingPath returns the path an item is stored at and whether anything is there,
+// without looking at its content
+func (info *FileInfo) existingPath(output string, noDecompress bool) (string, bool) {
+	var path string
+	switch {
+	case info.DownloadURL != "" || info.DRSURI != "":
+		path = info.directPath(output)
+	case noDecompress && len(info.SOPInstanceUIDs) == 0:
+		path = info.seriesPath(output) + seriesArchiveExt
+	default:
+		path = info.seriesPath(output)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, true
+	}
+	if info.DownloadURL == "" && info.DRSURI == "" && !noDecompress {
+		if stored, ok := contentStore.Lookup(info.SeriesUID); ok {
+			return stored.Container, true
+		}
+		if archive, ok := repackedArchive(output, info); ok {
+			return archive, true
+		}
+	}
+	return path, false
+}
+
+// existingAction decides with the --if-exists policy what a run does with an item:
+// download, repair or skip, with the reason
+func (info *FileInfo) existingAction(options *Options) (string, string) {
+	if info.S5cmdManifestPath != "" {
+		return "download", "s5cmd transfers are always run"
+	}
+
+	if options.IfExists == ifExistsSkip || options.IfExists == ifExistsOverwrite {
+		path, exists := info.existingPath(options.Output, options.NoDecompress)
+		switch {
+		case !exists:
+			return "download", fmt.Sprintf("%s does not exist", path)
+		case options.IfExists == ifExistsSkip:
+			return "skip", fmt.Sprintf("%s exists (--if-exists skip)", path)
+		default:
+			return "download", fmt.Sprintf("%s exists, re-downloading (--if-exists overwrite)", path)
+		}
+	}
+
+	state, reason := info.LocalState(options.Output, options.NoDecompress)
+	if state == StateComplete && options.IfExists == ifExistsVerify {
+		state, reason = info.verifyContent(options.Output, options.NoDecompress, reason)
+	}
+	switch state {
+	case StateComplete:
+		return "skip", reason
+	case StateInvalid:
+		return "repair", reason
+	default:
+		return "download", reason
+	}
+}
+
+// verifyContent checks a complete item against the checksums known for it: the MD5
+// and SHA-256 of its manifest for direct downloads and the sums recorded in
+// sha256sums.txt. Items without any known checksum keep their state.
+func (info *FileInfo) verifyContent(output string, noDecompress bool, reason string) (LocalState, string) {
+	if info.DownloadURL != "" || info.DRSURI != "" {
+		path := info.directPath(output)
+		if err := info.verifyDirectFile(path); err != nil {
+			return StateInvalid, fmt.Sprintf("%s: %v", path, err)
+		}
+		return StateComplete, reason + ", checksums match"
+	}
+
+	if checksums == nil {
+		return StateComplete, reason
+	}
+	path, _ := info.existingPath(output, noDecompress)
+	verified := 0
+	err := withRecallWait(path, func() error {
+		return filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			expected, ok := checksums.Lookup(file)
+			if !ok {
+				return nil
+			}
+			actual, err := sha256File(file)
+			if err != nil {
+				return err
+			}
+			if actual != expected {
+				return fmt.Errorf("SHA-256 mismatch in %s", file)
+			}
+			verified++
+			return nil
+		})
+	})
+	if err != nil {
+		return StateInvalid, err.Error()
+	}
+	if verified > 0 {
+		return StateComplete, fmt.Sprintf("%s, %d checksums match", reason, verified)
+	}
+	return StateComplete, reason
+}
+
+// verifyDirectFile hashes a direct download on disk and compares it with its manifest
+func (info *FileInfo) verifyDirectFile(path string) error {
+	md5Hasher, sha256Hasher := info.directHashers()
+	if info.SHA256Hash == "" {
+		if _, ok := checksums.Lookup(path); !ok {
+			sha256Hasher = nil
+		}
+	}
+	if md5Hasher == nil && sha256Hasher == nil {
+		return nil
+	}
+
+	var written int64
+	err := withRecallWait(path, func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		written, err = io.Copy(hashWriter(md5Hasher, sha256Hasher), f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := info.verifyDirect(written, md5Hasher, sha256Hasher); err != nil {
+		return err
+	}
+	if expected, ok := checksums.Lookup(path); ok && info.SHA256Hash == "" {
+		if actual := hex.EncodeToString(sha256Hasher.Sum(nil)); actual != expected {
+			return fmt.Errorf("%w: SHA-256 %s, recorded %s", ErrChecksumMismatch, actual, expected)
+		}
+	}
+	return nil
+}
+
+// hashWriter writes to all non-nil hashers
+func hashWriter(hashers ...hash.Hash) io.Writer {
+	writers := []io.Writer{io.Discard}
+	for _, h := range hashers {
+		if h != nil {
+			writers = append(writers, h)
+		}
+	}
+	return io.MultiWriter(writers...)
+}
+
+// resumeOffset prepares req for the transfer to tempPath. With --if-exists resume
+// the partial file of an earlier attempt is kept and only the rest is requested;
+// otherwise it is removed.
+func resumeOffset(req *http.Request, tempPath string, options *Options) int64 {
+	offset := partialOffset(tempPath, options)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return offset
+}
+
+// partialOffset returns the size of the partial file of an earlier attempt to
+// continue from with --if-exists resume, removing it otherwise
+func partialOffset(tempPath string, options *Options) int64 {
+	stat, err := os.Stat(tempPath)
+	if err != nil {
+		return 0
+	}
+	if options.IfExists != ifExistsResume || stat.Size() == 0 {
+		logger.Debugf("Removing incomplete download: %s", tempPath)
+		os.Remove(tempPath)
+		return 0
+	}
+	logger.Debugf("Resuming %s at %d bytes", tempPath, stat.Size())
+	return stat.Size()
+}
+
+// resumed reports whether a response continues a partial file at offset
+func resumed(resp *http.Response, offset int64) bool {
+	return offset > 0 && resp.StatusCode == http.StatusPartialContent &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset))
+}
+
+// transferStatus checks the response of a transfer to tempPath that may have been
+// resumed at offset. A partial file the server cannot continue is dropped, so the
+// next attempt starts over.
+func transferStatus(resp *http.Response, tempPath string, offset int64) error {
+	if resp.StatusCode == http.StatusOK || resumed(resp, offset) {
+		return nil
+	}
+	if offset > 0 {
+		os.Remove(tempPath)
+	}
+	return newHTTPStatusError(resp)
+}
+
+// openTransferFile opens the temporary file of a transfer. A resumed response appends
+// to the partial file, whose content is fed to the hashers first; any other response
+// starts the file over.
+func openTransferFile(tempPath string, resp *http.Response, offset int64, hashers ...hash.Hash) (*os.File, int64, error) {
+	if !resumed(resp, offset) {
+		offset = 0
+	}
+	return openPartialFile(tempPath, offset, hashers...)
+}
+
+// openPartialFile opens the temporary file of a transfer continued at offset, feeding
+// the partial content to the hashers first; an offset of 0 starts the file over
+func openPartialFile(tempPath string, offset int64, hashers ...hash.Hash) (*os.File, int64, error) {
+	if offset == 0 {
+		f, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		return f, 0, err
+	}
+	f, err := os.OpenFile(tempPath, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n, err := io.Copy(hashWriter(hashers...), f); err != nil || n != offset {
+		f.Close()
+		os.Remove(tempPath)
+		return nil, 0, fmt.Errorf("failed to read partial download %s (%d of %d bytes): %v", tempPath, n, offset, err)
+	}
+	return f, offset, nil
+}
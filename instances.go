@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// instanceTimeout bounds the transfer of a single DICOM instance
+const instanceTimeout = 5 * time.Minute
+
+// ErrSOPInstanceUIDColumnNotFound is returned for spreadsheets that select whole series
+var ErrSOPInstanceUIDColumnNotFound = errors.New("no 'SOPInstanceUID' column found")
+
+// getInstancesFromSpreadsheet reads the SOPInstanceUIDs of a spreadsheet grouped by
+// series, with the series in the order of their first row. A StudyInstanceUID
+// column may be present but is not needed: the study comes from the series metadata.
+func getInstancesFromSpreadsheet(filePath string, options *Options) ([]string, map[string][]string, error) {
+	sheet, err := OpenSpreadsheet(filePath, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer sheet.Close()
+
+	sopIndex := sheet.Column("SOPInstanceUID", "SOP Instance UID")
+	seriesIndex := sheet.Column("SeriesInstanceUID", "Series UID")
+	if sopIndex == -1 {
+		return nil, nil, ErrSOPInstanceUIDColumnNotFound
+	}
+	if seriesIndex == -1 {
+		return nil, nil, fmt.Errorf("a 'SOPInstanceUID' column needs a 'SeriesInstanceUID' column in %s", filePath)
+	}
+
+	var seriesUIDs []string
+	instances := make(map[string][]string)
+	seen := make(map[string]bool)
+	err = sheet.Each(func(row SpreadsheetRow) error {
+		seriesUID, sopUID := row.Get(seriesIndex), row.Get(sopIndex)
+		if seriesUID == "" || sopUID == "" || seen[sopUID] {
+			return nil
+		}
+		seen[sopUID] = true
+		if _, ok := instances[seriesUID]; !ok {
+			seriesUIDs = append(seriesUIDs, seriesUID)
+		}
+		instances[seriesUID] = append(instances[seriesUID], sopUID)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return seriesUIDs, instances, nil
+}
+
+// instancePath returns where a selected instance of the series is stored
+func (info *FileInfo) instancePath(output, sopUID string) string {
+	return filepath.Join(info.seriesPath(output), sopUID+".dcm")
+}
+
+// missingInstances counts the selected instances not on disk
+func (info *FileInfo) missingInstances(output string) int {
+	missing := 0
+	for _, sopUID := range info.SOPInstanceUIDs {
+		path := info.instancePath(output, sopUID)
+		err := withRecallWait(path, func() error {
+			_, err := os.Stat(path)
+			return err
+		})
+		if err != nil {
+			missing++
+		}
+	}
+	return missing
+}
+
+// downloadInstances fetches the selected instances of a series one by one with the
+// NBIA getSingleImage endpoint. Instances already on disk are kept, so a retry
+// resumes with the first missing one.
+func (info *FileInfo) downloadInstances(output string, httpClient *http.Client, authToken *Token) error {
+	if err := os.MkdirAll(info.DcimFiles(output), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	fetched := 0
+	for _, sopUID := range info.SOPInstanceUIDs {
+		path := info.instancePath(output, sopUID)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if err := info.downloadInstance(path, sopUID, httpClient, authToken); err != nil {
+			return fmt.Errorf("instance %s: %w", sopUID, err)
+		}
+		fetched++
+	}
+	if fetched > 0 {
+		// New instances have not been de-identified yet
+		os.Remove(deidRecordPath(output, info.SeriesUID))
+	}
+	logger.Debugf("Fetched %d of %d selected instances of %s", fetched, len(info.SOPInstanceUIDs), info.SeriesUID)
+	return nil
+}
+
+// downloadInstance fetches a single DICOM instance to path
+func (info *FileInfo) downloadInstance(path, sopUID string, httpClient *http.Client, authToken *Token) (err error) {
+	url_, err := makeURL(endpoints.SingleImage, map[string]interface{}{
+		"SeriesInstanceUID": info.SeriesUID,
+		"SOPInstanceUID":    sopUID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to make URL: %v", err)
+	}
+	req, err := http.NewRequest("GET", url_, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), instanceTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	resp, err := doAuthorizedRequest(httpClient, req, authToken)
+	if err != nil {
+		return fmt.Errorf("failed to do request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPStatusError(resp)
+	}
+
+	tempPath := path + ".tmp"
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(tempPath)
+		}
+	}()
+
+	var writer io.Writer = f
+	var sha256Hasher hash.Hash
+	if checksums != nil {
+		sha256Hasher = newSHA256Hash()
+		writer = io.MultiWriter(f, sha256Hasher)
+	}
+	written, err := io.Copy(writer, &countingReader{r: resp.Body})
+	if err != nil {
+		return fmt.Errorf("failed to write data after %d bytes: %w", written, err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if !isDicomFile(tempPath) {
+		err = fmt.Errorf("response of %d bytes is not a DICOM file", written)
+		return err
+	}
+	if err = renameFile(tempPath, path); err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	if sha256Hasher != nil {
+		checksums.Add(path, hex.EncodeToString(sha256Hasher.Sum(nil)))
+	}
+	return nil
+}
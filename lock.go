@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// outputLockFile is the lock file of an output directory
+const outputLockFile = ".retriever.lock"
+
+// stateLockFile serializes updates of shared state files below metadata/
+const stateLockFile = ".state.lock"
+
+// errLocked is returned by lockFile when the lock is held elsewhere
+var errLocked = errors.New("locked by another process")
+
+// outputLock is the lock of the output directory; nil when none is held (dry runs)
+var outputLock *OutputLock
+
+// OutputLock keeps other invocations off an output directory. Without --shared the
+// lock is exclusive and a second invocation stops at once. With --shared every
+// invocation takes a shared lock and updates of the state files they have in common
+// (metadata CSVs, sha256sums.txt, the store index, the daily quota) are serialized
+// and merged with what the other invocations wrote in the meantime.
+type OutputLock struct {
+	f      *os.File
+	output string
+	shared bool
+}
+
+// LockOutput locks the output directory, shared or exclusive
+func LockOutput(output string, shared bool) (*OutputLock, error) {
+	path := filepath.Join(output, outputLockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, !shared, false); err != nil {
+		f.Close()
+		if err != errLocked {
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		if shared {
+			return nil, fmt.Errorf("%s is in use by an invocation without --shared", output)
+		}
+		return nil, fmt.Errorf("%s is in use by another invocation; pass --shared to every invocation to run them side by side", output)
+	}
+	return &OutputLock{f: f, output: output, shared: shared}, nil
+}
+
+// Shared reports whether other invocations may be using the output directory
+func (l *OutputLock) Shared() bool {
+	return l != nil && l.shared
+}
+
+// WithState runs fn while holding the state lock, so that a read-modify-write of a
+// shared state file is not interleaved with another invocation. It blocks while
+// another invocation holds the lock; without --shared fn runs directly.
+func (l *OutputLock) WithState(fn func() error) error {
+	if !l.Shared() {
+		return fn()
+	}
+	path := filepath.Join(l.output, "metadata", stateLockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	if err := lockFile(f, true, true); err != nil {
+		return fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+	defer unlockFile(f)
+	return fn()
+}
+
+// Close releases the output directory
+func (l *OutputLock) Close() error {
+	if l == nil {
+		return nil
+	}
+	unlockFile(l.f)
+	return l.f.Close()
+}
@@ -2,13 +2,40 @@ package main
 
 import (
 	"go.uber.org/zap"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"go.uber.org/zap/zapcore"
 )
 
+// consoleWriter is the console destination of the logger. The TUI redirects it to its
+// log pane while the screen is taken over.
+type consoleWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (c *consoleWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Write(p)
+}
+
+// Redirect sends console logging to w and returns the previous destination
+func (c *consoleWriter) Redirect(w io.Writer) io.Writer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous := c.w
+	c.w = w
+	return previous
+}
+
+// console is where log messages are printed
+var console = &consoleWriter{w: os.Stdout}
+
 // newEncoderConfig create EncoderConfig for zap
 func newEncoderConfig() zapcore.EncoderConfig {
 	return zapcore.EncoderConfig{
@@ -40,7 +67,7 @@ func setLogger(debug bool, logfile string) {
 		level = zap.DebugLevel
 	}
 
-	core := zapcore.NewCore(zapcore.NewConsoleEncoder(encoder), zapcore.AddSync(os.Stdout), level)
+	core := zapcore.NewCore(zapcore.NewConsoleEncoder(encoder), zapcore.AddSync(console), level)
 	logger_ := zap.New(core, zap.AddCaller())
 	if logfile != "" {
 		_ = os.MkdirAll(filepath.Dir(logfile), os.ModePerm)
@@ -50,7 +77,7 @@ func setLogger(debug bool, logfile string) {
 		} else {
 			core = zapcore.NewTee(
 				zapcore.NewCore(zapcore.NewJSONEncoder(encoder), zapcore.AddSync(f), zap.DebugLevel),
-				zapcore.NewCore(zapcore.NewConsoleEncoder(encoder), zapcore.AddSync(os.Stdout), level),
+				zapcore.NewCore(zapcore.NewConsoleEncoder(encoder), zapcore.AddSync(console), level),
 			)
 		}
 		logger_ = zap.New(core, zap.AddCaller())
@@ -27,15 +27,33 @@ var (
 
 // DownloadStats tracks download statistics
 type DownloadStats struct {
+	// 64-bit counters are first to keep them aligned for atomic access
+	BytesDownloaded        int64
+	RemainingBytes         int64 // expected (metadata) bytes of items not yet processed
+	CompletedExpectedBytes int64 // expected bytes of items downloaded so far
+
 	Total          int32
 	Downloaded     int32
 	Synced         int32
 	Skipped        int32
 	Failed         int32
+	Deferred       int32
+	Fallbacks      int32 // items downloaded from an alternate source
 	StartTime      time.Time
 	LastUpdate     time.Time
 	LastPercentage int
+	Workers        map[int]*WorkerActivity
+	Subjects       *SubjectTracker // shown in the progress when reporting by subject
 	mu             sync.Mutex
+	failures       []FailedItem
+	egress         map[string]*EgressSource // per endpoint/bucket, see recordEgress
+
+	// Previous sample used to compute instantaneous throughput
+	lastSampleTime  time.Time
+	lastSampleBytes int64
+
+	// Smoothed throughput for the ETA
+	throughput throughputEstimator
 }
 
 // WorkerContext contains all dependencies for workers
@@ -45,35 +63,124 @@ type WorkerContext struct {
 	Gen3Auth   *Gen3AuthManager
 	Options    *Options
 	Stats      *DownloadStats
+	Quota      *DailyQuota
+	Subjects   *SubjectTracker
 	WorkerID   int
 }
 
+// recordDownload records the result of downloading an item and returns its progress
+// outcome, and whether it was transferred as opposed to synced
+func (ctx *WorkerContext) recordDownload(fileInfo *FileInfo, action, reason string, err error) (ProgressEvent, bool) {
+	if err != nil {
+		logger.Warnf("[Worker %d] Download %s failed - %s", ctx.WorkerID, fileInfo.SeriesUID, err)
+		ctx.Stats.recordFailure(fileInfo, err)
+		events.Record(Event{Action: action, SeriesUID: fileInfo.SeriesUID, Detail: reason, Error: err.Error(), Code: failureCode(err)})
+		stamps.Remove(fileInfo.SeriesUID)
+		ctx.Subjects.Record(fileInfo, false)
+		return failedEvent(fileInfo, ctx.WorkerID, err), false
+	}
+
+	detail := reason
+	if fileInfo.Source != "" {
+		detail = strings.TrimSpace(reason + " source " + fileInfo.Source)
+	}
+	events.Record(Event{Action: action, SeriesUID: fileInfo.SeriesUID, Detail: detail})
+	ctx.Subjects.Record(fileInfo, true)
+	if fileInfo.IsSyncJob || fileInfo.S5cmdManifestPath == "" {
+		stamps.Done(fileInfo.stamp(action, ctx.Options))
+	}
+	isSpreadsheetInput := fileInfo.DownloadURL != "" || fileInfo.DRSURI != "" || fileInfo.S5cmdManifestPath != ""
+	if !isSpreadsheetInput {
+		if err := fileInfo.GetMeta(ctx.Options.Output); err != nil {
+			logger.Warnf("[Worker %d] Save meta info %s failed - %s", ctx.WorkerID, fileInfo.SeriesUID, err)
+		}
+	}
+	if err := ctx.Quota.Save(); err != nil {
+		logger.Warnf("[Worker %d] Failed to save daily quota state: %v", ctx.WorkerID, err)
+	}
+	// Increment correct counter
+	if fileInfo.IsSyncJob {
+		atomic.AddInt32(&ctx.Stats.Synced, 1)
+		return itemEvent(ProgressFinished, fileInfo, ctx.WorkerID), false
+	}
+	atomic.AddInt32(&ctx.Stats.Downloaded, 1)
+	return itemEvent(ProgressFinished, fileInfo, ctx.WorkerID), true
+}
+
+// extractDownload runs the extraction a download left for the pool. When a fresh
+// copy may cure the failure (e.g. a damaged ZIP), the series is downloaded again
+// and extracted inline, with the usual retries.
+func (ctx *WorkerContext) extractDownload(fileInfo *FileInfo, extract func() error) error {
+	err := extract()
+	if err == nil || !isRetryableError(err) || ctx.Options.MaxRetries == 0 {
+		return err
+	}
+	logger.Warnf("[Worker %d] Extracting %s failed, downloading it again: %v", ctx.WorkerID, fileInfo.SeriesUID, err)
+	fileInfo.inlineExtract = true
+	return fileInfo.Download(ctx.Options.Output, ctx.HTTPClient, ctx.AuthToken, ctx.Gen3Auth, ctx.Options)
+}
+
 // SetupCloseHandler creates a 'listener' on a new goroutine which will notify the
 // program if it receives an interrupt from the OS. We then handle this by calling
-// our clean-up procedure and exiting the program.
+// our clean-up procedure and exiting the program with ExitInterrupted or
+// ExitTerminated, so that a cancelled run never looks complete.
 func setupCloseHandler() {
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-c
-		fmt.Println("\r- Ctrl+C pressed in Terminal")
-		os.Exit(0)
+		sig := <-c
+		activeTUI.Load().Stop()
+		status := ExitInterrupted
+		if sig == syscall.SIGTERM {
+			status = ExitTerminated
+			fmt.Println("\r- Terminated")
+		} else {
+			fmt.Println("\r- Ctrl+C pressed in Terminal")
+		}
+
+		detail := fmt.Sprintf("interrupted by %v exit=%d", sig, status)
+		if stats := activeStats; stats != nil {
+			detail = fmt.Sprintf("downloaded=%d synced=%d skipped=%d failed=%d deferred=%d bytes=%d %s",
+				atomic.LoadInt32(&stats.Downloaded), atomic.LoadInt32(&stats.Synced), atomic.LoadInt32(&stats.Skipped),
+				atomic.LoadInt32(&stats.Failed), atomic.LoadInt32(&stats.Deferred), atomic.LoadInt64(&stats.BytesDownloaded), detail)
+		}
+		events.Record(Event{Action: "run_end", Detail: detail})
+		os.Exit(status)
 	}()
 }
 
 // decodeInputFile determines the input file type and calls the appropriate decoder
 func decodeInputFile(filePath string, client *http.Client, token *Token, options *Options, s5cmdMap map[string]string) ([]*FileInfo, int, error) {
+	if isFolderURL(filePath) {
+		files, err := decodeFolderURL(filePath, client, options)
+		return files, 0, err
+	}
 	ext := strings.ToLower(filepath.Ext(filePath))
 	switch ext {
 	case ".tcia":
 		files, err := decodeTCIA(filePath, client, token, options)
 		return files, 0, err
 	case ".s5cmd":
-		files, newJobs := decodeS5cmd(filePath, options.Output, s5cmdMap)
+		files, newJobs := decodeS5cmd(filePath, options.Output, s5cmdMap, options.WhatIf)
 		return files, newJobs, nil
+	case ".json":
+		files, err := decodeJSONInput(filePath, client, token, options)
+		return files, 0, err
 	case ".csv", ".tsv", ".xlsx":
-		// Try to decode as a SeriesInstanceUID spreadsheet first
-		seriesUIDs, err := getSeriesUIDsFromSpreadsheet(filePath)
+		// Spreadsheets with a SOPInstanceUID column select single instances
+		seriesUIDs, instances, err := getInstancesFromSpreadsheet(filePath, options)
+		if err == nil {
+			files, err := FetchMetadataForSeriesUIDs(seriesUIDs, client, token, options)
+			for _, info := range files {
+				info.SOPInstanceUIDs = instances[info.SeriesUID]
+			}
+			return files, 0, err
+		} else if err != ErrSOPInstanceUIDColumnNotFound {
+			return nil, 0, fmt.Errorf("could not get instance UIDs from spreadsheet: %w", err)
+		}
+
+		// Then as a SeriesInstanceUID spreadsheet
+		seriesUIDs, err = getSeriesUIDsFromSpreadsheet(filePath, options)
 		if err == nil {
 			// Success, handle like a TCIA manifest
 			files, err := FetchMetadataForSeriesUIDs(seriesUIDs, client, token, options)
@@ -84,7 +191,7 @@ func decodeInputFile(filePath string, client *http.Client, token *Token, options
 		}
 
 		// Fallback to regular spreadsheet handling
-		files, err := decodeSpreadsheet(filePath)
+		files, err := decodeSpreadsheet(filePath, options)
 		return files, 0, err
 	default:
 		return nil, 0, fmt.Errorf("unsupported input file format: %s", ext)
@@ -104,14 +211,22 @@ func updateProgress(stats *DownloadStats, currentSeriesID string) {
 	}
 	stats.LastUpdate = now
 
+	// The full-screen display renders progress itself
+	if activeTUI.Load() != nil {
+		return
+	}
+
 	// Calculate progress
-	processed := atomic.LoadInt32(&stats.Downloaded) + atomic.LoadInt32(&stats.Synced) + atomic.LoadInt32(&stats.Skipped) + atomic.LoadInt32(&stats.Failed)
+	processed := atomic.LoadInt32(&stats.Downloaded) + atomic.LoadInt32(&stats.Synced) + atomic.LoadInt32(&stats.Skipped) + atomic.LoadInt32(&stats.Failed) + atomic.LoadInt32(&stats.Deferred)
 	percentage := float64(processed) / float64(stats.Total) * 100
 
-	// Calculate ETA based on download/sync rate
+	// Calculate ETA from smoothed byte throughput, falling back to the item rate
+	// when the metadata carries no sizes (e.g. spreadsheet or s5cmd input)
 	elapsed := time.Since(stats.StartTime)
 	var eta string
-	if downloadedAndSynced := atomic.LoadInt32(&stats.Downloaded) + atomic.LoadInt32(&stats.Synced); downloadedAndSynced > 0 && elapsed > 0 {
+	if remaining, ok := stats.estimateETA(now); ok && processed < stats.Total {
+		eta = fmt.Sprintf(" | %s | ETA: %s", formatRate(stats.throughput.rate), remaining.Round(time.Second))
+	} else if downloadedAndSynced := atomic.LoadInt32(&stats.Downloaded) + atomic.LoadInt32(&stats.Synced); downloadedAndSynced > 0 && elapsed > 0 {
 		rate := float64(downloadedAndSynced) / elapsed.Seconds()
 		remainingFiles := float64(stats.Total - processed)
 		if remainingFiles > 0 && rate > 0 {
@@ -127,11 +242,16 @@ func updateProgress(stats *DownloadStats, currentSeriesID string) {
 		displayID = displayID[:30] + "..."
 	}
 
+	var subjectCount string
+	if complete, total := stats.Subjects.Counts(); total > 0 {
+		subjectCount = fmt.Sprintf(" | Subjects: %d/%d", complete, total)
+	}
+
 	// Clear line and print progress
-	fmt.Fprintf(os.Stderr, "\r\033[K[%d/%d] %.1f%% | Downloaded: %d | Synced: %d | Skipped: %d | Failed: %d%s | Current: %s",
+	fmt.Fprintf(os.Stderr, "\r\033[K[%d/%d] %.1f%% | Downloaded: %d | Synced: %d | Skipped: %d | Failed: %d%s%s | Current: %s",
 		processed, stats.Total, percentage,
 		stats.Downloaded, stats.Synced, stats.Skipped, stats.Failed,
-		eta, displayID)
+		subjectCount, eta, displayID)
 }
 
 func main() {
@@ -146,49 +266,329 @@ func main() {
 		logger.Infof("Golang Version : %s", goVersion)
 		os.Exit(0)
 	} else {
-		client = newClient(options.Proxy, options.MaxConnsPerHost)
+		progress = NewProgressBus()
+		if options.ProgressJSON {
+			// Standard output carries only the events; everything else goes to stderr
+			progress.Subscribe(ndjsonProgress(os.Stdout))
+			console.Redirect(os.Stderr)
+			os.Stdout = os.Stderr
+		}
 
-		err := os.MkdirAll(options.Output, os.ModePerm)
-		if err != nil {
-			logger.Fatalf("failed to create output directory: %v", err)
+		// Transfers have their own deadlines, up to --series-timeout-max
+		client = newClient(options.Proxy, options.Transport, max(10*time.Minute, options.MaxTimeout))
+		identifyRequests(client, options.UserAgent, options.RequestID)
+		throttle = NewThrottle(options.RetryDelay, options.RetryMaxDelay)
+		if options.Courtesy {
+			throttle.EnableCourtesy("--courtesy")
 		}
-		token, err = NewToken(
-			options.Username, options.Password,
-			filepath.Join(options.Output, fmt.Sprintf("%s.json", options.Username)))
 
+		// The checks report an unusable output directory or login instead of failing on it
+		if options.Command == "doctor" {
+			if err := runDoctor(options); err != nil {
+				logger.Fatal(err)
+			}
+			return
+		}
+		if options.Command == "stats" {
+			if err := runUsageStats(options); err != nil {
+				logger.Fatal(err)
+			}
+			return
+		}
+
+		err := os.MkdirAll(options.Output, os.ModePerm)
 		if err != nil {
-			logger.Fatal(err)
+			fatalWithStatus(ExitDisk, fmt.Sprintf("failed to create output directory: %v", err))
+		}
+		// Dry runs and the listing commands only read the output directory
+		if !options.WhatIf && !readOnlyCommands[options.Command] {
+			if outputLock, err = LockOutput(options.Output, options.Shared); err != nil {
+				logger.Fatal(err)
+			}
+			defer outputLock.Close()
+		}
+		if options.Command == "encrypt" {
+			if err := runEncrypt(options.Args); err != nil {
+				logger.Fatalf("Encrypt failed: %v", err)
+			}
+			return
+		}
+		if options.Command == "merge-reports" {
+			if err := runMergeReports(options.Args, options); err != nil {
+				logger.Fatalf("Merging reports failed: %v", err)
+			}
+			return
+		}
+		// Download dry runs work from local state only and never log in
+		if !options.WhatIf || options.Command != "" {
+			path, err := tokenPath(options)
+			if err != nil {
+				logger.Warnf("%v; keeping the token in %s", err, options.Output)
+				path = filepath.Join(options.Output, fmt.Sprintf("%s.json", tokenOwner(options)))
+			}
+			if options.Anonymous {
+				// Public data needs no login; the token logs in on the first 401
+				token = NewAnonymousToken(options.Username, options.Password, path)
+			} else if token, err = NewToken(options.Username, options.Password, path); err != nil {
+				fatalWithStatus(ExitAuth, err)
+			}
 		}
 
 		// Create metadata directory
 		if err := createMetadataDir(options.Output); err != nil {
-			logger.Fatalf("Failed to create metadata directory: %v", err)
+			fatalWithStatus(ExitDisk, fmt.Sprintf("Failed to create metadata directory: %v", err))
+		}
+		if options.SaveRawResponse {
+			rawResponses = NewRawResponseArchive(options.Output)
+		}
+
+		// Series packed by --store count as present, with or without the option
+		if contentStore, err = LoadContentStore(options.Output, options.Store); err != nil {
+			logger.Fatalf("Failed to load content store index: %v", err)
+		}
+
+		switch options.Command {
+		case "browse":
+			if err := runBrowse(client, token, options); err != nil {
+				logger.Fatalf("Browse failed: %v", err)
+			}
+			return
+		case "refresh-meta":
+			if err := runRefreshMeta(client, token, options); err != nil {
+				logger.Fatalf("Metadata refresh failed: %v", err)
+			}
+			return
+		case "size":
+			if err := runSize(client, token, options); err != nil {
+				logger.Fatalf("Size report failed: %v", err)
+			}
+			return
+		case "diff":
+			if err := runDiff(client, token, options); err != nil {
+				logger.Fatalf("Diff failed: %v", err)
+			}
+			return
+		case "updates":
+			if err := runUpdates(client, token, options); err != nil {
+				logger.Fatalf("Listing updates failed: %v", err)
+			}
+			return
 		}
 
 		// Load the s5cmd series map
-		s5cmdMap, err := loadS5cmdSeriesMapFromCSVs(options.Output)
+		var s5cmdMap map[string]string
+		err = outputLock.WithState(func() (err error) {
+			s5cmdMap, err = loadS5cmdSeriesMapFromCSVs(options.Output)
+			return err
+		})
 		if err != nil {
 			logger.Fatalf("Failed to load s5cmd series map from CSVs: %v", err)
 		}
+		interruptedS5cmdDirs = scanS5cmdTempDirs(options.Output)
 
 		var wg sync.WaitGroup
-		files, newS5cmdJobs, err := decodeInputFile(options.Input, client, token, options, s5cmdMap)
+		// Priority inputs are decoded first so their entries win when a series is listed twice
+		files, newS5cmdJobs, err := decodePriorityInputs(options.PriorityInputs, client, token, options, s5cmdMap)
 		if err != nil {
-			logger.Fatalf("Failed to decode input file: %v", err)
+			logger.Fatalf("Failed to decode priority input: %v", err)
+		}
+		// Items from stdin, or the input file with --stream, are passed to the workers
+		// as they are read, except for dry runs, which need the complete list
+		streamed := options.Input == stdinInput || options.Stream
+		streaming := streamed && !options.WhatIf
+		if streamed && options.WhatIf {
+			err := readStreamInput(client, token, options, s5cmdMap, func(info *FileInfo) {
+				files = append(files, info)
+			})
+			if err != nil {
+				logger.Fatalf("Failed to decode input file: %v", err)
+			}
+		} else if options.Command == "apply" {
+			files, newS5cmdJobs = approvedPlan.Files()
+			fmt.Printf("Applying plan %s made %s: %d items to transfer\n",
+				options.Args[0], approvedPlan.CreatedAt.Local().Format("2006-01-02 15:04"), len(files))
+		} else if options.Command == "sync" {
+			if files, err = syncSeries(client, token, options); err != nil {
+				logger.Fatalf("Sync failed: %v", err)
+			}
+		} else if !streaming && (options.Input != "" || len(options.PriorityInputs) == 0) {
+			bulk, newJobs, err := decodeInputFile(options.Input, client, token, options, s5cmdMap)
+			if err != nil {
+				logger.Fatalf("Failed to decode input file: %v", err)
+			}
+			files = append(files, bulk...)
+			newS5cmdJobs += newJobs
+		}
+		if len(options.PriorityInputs) > 0 {
+			files = orderByPriority(files)
+		}
+		if activeShard != nil {
+			total := len(files)
+			files = activeShard.Select(files)
+			fmt.Printf("Shard %s: %d of %d items\n", activeShard, len(files), total)
+		}
+		if activeSeriesList != nil {
+			total := len(files)
+			files = activeSeriesList.Select(files)
+			fmt.Printf("Series list: %d of %d items\n", len(files), total)
+		}
+		if subjectSelection != nil {
+			total := len(files)
+			var sampled []string
+			files, sampled = subjectSelection.Select(files)
+			fmt.Printf("Subject selection: %d of %d items\n", len(files), total)
+			if sampled != nil {
+				fmt.Printf("Sampled %d subjects with --sample-seed %d\n", len(sampled), subjectSelection.Seed)
+			}
+			if sampled != nil && !options.WhatIf {
+				if path, err := writeSampledSubjects(options.Output, sampled, subjectSelection.Seed); err != nil {
+					logger.Warnf("Failed to write %s: %v", sampledSubjectsFile, err)
+				} else {
+					fmt.Printf("The sampled subjects are listed in %s\n", path)
+				}
+			}
+		}
+		if refused := allowedCollections.Refused(files); len(refused) > 0 {
+			refuseRun(refused, options)
+		}
+		if options.HeadCheck {
+			if urlHeads, err = OpenURLHeadCache(options.Output); err != nil {
+				logger.Fatalf("Failed to load %s: %v", urlHeadsFile, err)
+			}
+		}
+		if hasDirectItems(files) || streaming {
+			if fileNames, err = OpenFileNames(options.Output); err != nil {
+				logger.Fatalf("Failed to load %s: %v", fileNamesFile, err)
+			}
+		}
+
+		if options.Command == "plan" {
+			if err := writePlan(files, options.Args[0], options); err != nil {
+				logger.Fatalf("Failed to write plan: %v", err)
+			}
+			return
+		}
+		if options.WhatIf {
+			if err := runWhatIf(files, options); err != nil {
+				logger.Fatalf("What-if failed: %v", err)
+			}
+			if options.RecallList != "" {
+				if err := writeRecallList(files, options); err != nil {
+					logger.Fatalf("Failed to write recall list: %v", err)
+				}
+			}
+			return
+		}
+
+		if options.CheckAccess {
+			if files, err = checkAccess(files, client, token, options); err != nil {
+				logger.Fatalf("Access check failed: %v", err)
+			}
+		}
+		// A streamed .s5cmd manifest is known to need s5cmd before its lines are read
+		if needsS5cmd(files) || (streaming && strings.ToLower(filepath.Ext(options.Input)) == ".s5cmd") {
+			if err := checkS5cmd(options); err != nil {
+				logger.Fatal(err)
+			}
 		}
 
 		// If input is a spreadsheet, copy it to the metadata folder
-		ext := strings.ToLower(filepath.Ext(options.Input))
-		if ext == ".csv" || ext == ".tsv" || ext == ".xlsx" {
-			metaDir := filepath.Join(options.Output, "metadata")
-			destPath := filepath.Join(metaDir, filepath.Base(options.Input))
-			if err := copyFile(options.Input, destPath); err != nil {
-				logger.Warnf("Failed to copy spreadsheet to metadata folder: %v", err)
+		inputPaths := []string{options.Input}
+		for _, input := range options.PriorityInputs {
+			inputPaths = append(inputPaths, input.Path)
+		}
+		for _, inputPath := range inputPaths {
+			ext := strings.ToLower(filepath.Ext(inputPath))
+			if ext == ".csv" || ext == ".tsv" || ext == ".xlsx" {
+				metaDir := filepath.Join(options.Output, "metadata")
+				destPath := filepath.Join(metaDir, filepath.Base(inputPath))
+				if err := copyFile(inputPath, destPath); err != nil {
+					logger.Warnf("Failed to copy spreadsheet to metadata folder: %v", err)
+				}
 			}
 		}
 
+		// Sizes learned here count towards the ETA
+		if urlHeads != nil {
+			headCheckDirect(files, client, options)
+		}
+
 		stats := &DownloadStats{Total: int32(len(files))}
 		stats.StartTime = time.Now()
+		stats.initRemainingBytes(files)
+		activeStats = stats
+		usage := NewUsageRecorder(progress)
+		stopStatsWriter := startStatsWriter(options.StatsFile, stats, time.Second)
+		progress.Subscribe(func(event ProgressEvent) {
+			if event.Kind != ProgressBytes && event.Kind != ProgressRetrying {
+				updateProgress(stats, event.SeriesUID)
+			}
+		})
+
+		if options.SHA256Sums {
+			if checksums, err = NewChecksumManifest(options.Output); err != nil {
+				logger.Fatalf("Failed to load checksum manifest: %v", err)
+			}
+		}
+
+		if options.CircuitBreaker > 0 {
+			circuitBreaker = &CircuitBreaker{Threshold: options.CircuitBreaker}
+		}
+
+		if hasDRSItems(files) || streaming {
+			if presignedURLs, err = OpenPresignedURLCache(options.Output); err != nil {
+				logger.Warnf("Failed to open %s, download URLs will not be reused: %v", presignedURLsFile, err)
+			}
+			defer presignedURLs.Close()
+		}
+
+		if options.HashWorkers > 0 {
+			hashPool = NewHashPool(options.HashWorkers)
+			defer hashPool.Close()
+		}
+		if options.ExtractWorkers > 0 && !options.Meta {
+			extractPool = NewExtractPool(options.ExtractWorkers)
+		}
+		if (newS5cmdJobs > 0 || streaming) && !options.Meta {
+			s5cmdOrganizer = NewS5cmdOrganizer(options.Output)
+		}
+		if options.S5cmdBatch > 0 {
+			s5cmdBatch = NewS5cmdBatcher(options.S5cmdBatch, options)
+		}
+
+		if events, err = OpenEventLog(options.Output); err != nil {
+			logger.Warnf("Failed to open %s, actions will not be audited: %v", eventsFile, err)
+		}
+		defer events.Close()
+		if options.StampDir != "" && !options.Meta {
+			if stamps, err = OpenStampDir(options.StampDir); err != nil {
+				logger.Fatalf("Failed to create --stamp-dir: %v", err)
+			}
+		}
+		events.Record(Event{Action: "run_start", Path: options.Input, Detail: fmt.Sprintf("%d items, version %s", len(files), version)})
+		if len(options.Mirrors) > 0 && needsS5cmd(files) {
+			selectMirror(client, files, options)
+		}
+
+		var subjects *SubjectTracker
+		if !options.Meta {
+			subjects = NewSubjectTracker(files)
+			if options.ReportBy == "subject" {
+				stats.Subjects = subjects
+			}
+		}
+
+		var deidProfile *DeidProfile
+		if options.Deidentify != "" && !options.Meta {
+			if deidProfile, err = LoadDeidProfile(options.Deidentify, options.Output); err != nil {
+				logger.Fatalf("Failed to load de-identification profile: %v", err)
+			}
+		}
+
+		var quota *DailyQuota
+		if options.DailyQuota > 0 {
+			quota = NewDailyQuota(options.Output, options.DailyQuota, options.QuotaWait)
+		}
 
 		itemType := "items"
 		if len(files) > 0 {
@@ -199,19 +599,36 @@ func main() {
 			}
 		}
 
-		if options.Debug {
+		if options.Schedule != nil {
+			fmt.Fprintf(os.Stderr, "Transfers restricted to schedule window %s (local time)\n", options.Schedule)
+		}
+
+		if streaming {
+			fmt.Fprintf(os.Stderr, "\nDownloading items from %s with %d workers...\n\n", streamInputName(options), options.Concurrent)
+		} else if options.Debug {
 			logger.Infof("Starting download of %d %s with %d workers", len(files), itemType, options.Concurrent)
 		} else {
 			fmt.Fprintf(os.Stderr, "\nDownloading %d %s with %d workers...\n\n", len(files), itemType, options.Concurrent)
 		}
 
+		var tui *TUI
+		if options.TUI {
+			tui = startTUI(stats, options.Concurrent)
+		}
+
 		wg.Add(options.Concurrent)
-		inputChan := make(chan *FileInfo, len(files))
+		// Items queued and not yet completed, extractions included
+		var pending sync.WaitGroup
+		queueSize := len(files)
+		if streaming {
+			queueSize += streamQueueSize(options.Concurrent)
+		}
+		inputChan := make(chan *FileInfo, queueSize)
 
 		// Create Gen3 Auth Manager
 		gen3Auth, err := NewGen3AuthManager(client, options.Auth)
 		if err != nil {
-			logger.Fatalf("Failed to initialize Gen3 auth manager: %v", err)
+			fatalWithStatus(ExitAuth, fmt.Sprintf("Failed to initialize Gen3 auth manager: %v", err))
 		}
 
 		for i := 0; i < options.Concurrent; i++ {
@@ -221,13 +638,23 @@ func main() {
 				Gen3Auth:   gen3Auth,
 				Options:    options,
 				Stats:      stats,
+				Quota:      quota,
+				Subjects:   subjects,
 				WorkerID:   i + 1,
 			}
 
 			go func(ctx *WorkerContext, input chan *FileInfo) {
 				defer wg.Done()
 				for fileInfo := range input {
-					updateProgress(ctx.Stats, fileInfo.SeriesUID)
+					if circuitBreaker.Tripped() {
+						// Drain the queue without attempting further transfers
+						pending.Done()
+						continue
+					}
+					ctx.Stats.setWorkerActivity(ctx.WorkerID, fileInfo.SeriesUID)
+					progress.Emit(itemEvent(ProgressStarted, fileInfo, ctx.WorkerID))
+					transferred := false // downloaded by this worker, as opposed to skipped or synced
+					outcome := itemEvent(ProgressFinished, fileInfo, ctx.WorkerID)
 					logger.Debugf("[Worker %d] Processing %s", ctx.WorkerID, fileInfo.SeriesUID)
 
 					isSpreadsheetInput := fileInfo.DownloadURL != "" || fileInfo.DRSURI != "" || fileInfo.S5cmdManifestPath != ""
@@ -236,100 +663,123 @@ func main() {
 						if isSpreadsheetInput {
 							logger.Debugf("[Worker %d] Skipping metadata for item %s", ctx.WorkerID, fileInfo.SeriesUID)
 							atomic.AddInt32(&ctx.Stats.Skipped, 1)
+							outcome.Kind = ProgressSkipped
 						} else {
 							if err := fileInfo.GetMeta(ctx.Options.Output); err != nil {
 								logger.Warnf("[Worker %d] Save meta info %s failed - %s", ctx.WorkerID, fileInfo.SeriesUID, err)
-								atomic.AddInt32(&ctx.Stats.Failed, 1)
+								ctx.Stats.recordFailure(fileInfo, err)
+								outcome = failedEvent(fileInfo, ctx.WorkerID, err)
 							} else {
 								atomic.AddInt32(&ctx.Stats.Downloaded, 1)
 							}
 						}
 					} else {
-						if ctx.Options.SkipExisting && !fileInfo.NeedsDownload(ctx.Options.Output, false, ctx.Options.NoDecompress) {
-							logger.Debugf("[Worker %d] Skip existing %s", ctx.WorkerID, fileInfo.SeriesUID)
-							atomic.AddInt32(&ctx.Stats.Skipped, 1)
-						} else if fileInfo.NeedsDownload(ctx.Options.Output, ctx.Options.Force, ctx.Options.NoDecompress) {
-							if err := fileInfo.Download(ctx.Options.Output, ctx.HTTPClient, ctx.AuthToken, ctx.Gen3Auth, ctx.Options); err != nil {
-								logger.Warnf("[Worker %d] Download %s failed - %s", ctx.WorkerID, fileInfo.SeriesUID, err)
-								atomic.AddInt32(&ctx.Stats.Failed, 1)
+						action, reason := fileInfo.existingAction(ctx.Options)
+						if action != "skip" {
+							logger.Debugf("[Worker %d] %s, need to download", ctx.WorkerID, reason)
+							ctx.Options.Schedule.Wait(ctx.WorkerID)
+							if fileInfo.IsSyncJob {
+								action, reason = "sync", ""
+							} else if action == "download" {
+								reason = ""
+							}
+							if !ctx.Quota.Allow(fileInfo) {
+								logger.Debugf("[Worker %d] Deferring %s (daily quota reached)", ctx.WorkerID, fileInfo.SeriesUID)
+								atomic.AddInt32(&ctx.Stats.Deferred, 1)
+								events.Record(Event{Action: "deferred", SeriesUID: fileInfo.SeriesUID, Detail: "daily quota reached"})
+								outcome.Kind = ProgressDeferred
 							} else {
-								if !isSpreadsheetInput {
-									if err := fileInfo.GetMeta(ctx.Options.Output); err != nil {
-										logger.Warnf("[Worker %d] Save meta info %s failed - %s", ctx.WorkerID, fileInfo.SeriesUID, err)
-									}
+								err := fileInfo.Download(ctx.Options.Output, ctx.HTTPClient, ctx.AuthToken, ctx.Gen3Auth, ctx.Options)
+								if extract := fileInfo.takePendingExtract(); err == nil && extract != nil {
+									// The pool completes the item while this worker downloads the next one
+									extractPool.Submit(func() {
+										outcome, transferred := ctx.recordDownload(fileInfo, action, reason, ctx.extractDownload(fileInfo, extract))
+										ctx.Stats.completeItem(fileInfo, transferred)
+										progress.Emit(outcome)
+										pending.Done()
+									})
+									ctx.Stats.setWorkerActivity(ctx.WorkerID, "")
+									continue
 								}
-								// Increment correct counter
-								if fileInfo.IsSyncJob {
-									atomic.AddInt32(&ctx.Stats.Synced, 1)
-								} else {
-									atomic.AddInt32(&ctx.Stats.Downloaded, 1)
+								outcome, transferred = ctx.recordDownload(fileInfo, action, reason, err)
+								if err == nil {
+									// Copied s5cmd series move into their series folder meanwhile
+									s5cmdOrganizer.Submit(fileInfo)
 								}
 							}
 						} else {
-							logger.Debugf("[Worker %d] Skip %s (already exists with correct size/checksum)", ctx.WorkerID, fileInfo.SeriesUID)
+							logger.Debugf("[Worker %d] Skip %s (%s)", ctx.WorkerID, fileInfo.SeriesUID, reason)
 							atomic.AddInt32(&ctx.Stats.Skipped, 1)
+							ctx.Subjects.Record(fileInfo, true)
+							stamps.Done(fileInfo.stamp("skip", ctx.Options))
+							outcome.Kind = ProgressSkipped
 						}
 					}
-					updateProgress(ctx.Stats, fileInfo.SeriesUID)
+					ctx.Stats.completeItem(fileInfo, transferred)
+					progress.Emit(outcome)
+					ctx.Stats.setWorkerActivity(ctx.WorkerID, "")
+					pending.Done()
 				}
 			}(ctx, inputChan)
 		}
 
+		runSeries := make([]string, 0, len(files))
 		for _, f := range files {
+			runSeries = append(runSeries, f.SeriesUID)
+			pending.Add(1)
 			inputChan <- f
 		}
+		if streaming {
+			// Blocks while the queue is full, so the input is read only as fast as items
+			// are downloaded. Items are kept after the run only for the steps needing them.
+			keepItems := options.ValidateDicom || deidProfile != nil || options.Store != "" || options.Repack != "" ||
+				options.Dedup != ""
+			err := readStreamInput(client, token, options, s5cmdMap, func(info *FileInfo) {
+				stats.addItem(info)
+				subjects.Add(info)
+				if info.S5cmdManifestPath != "" && !info.IsSyncJob {
+					newS5cmdJobs++
+				}
+				if keepItems {
+					files = append(files, info)
+				}
+				// Only the UID is kept, for the metadata CSV of the run
+				runSeries = append(runSeries, info.SeriesUID)
+				pending.Add(1)
+				inputChan <- info
+			})
+			if err != nil {
+				logger.Errorf("%v", err)
+			}
+		}
+		// Failed items get more passes once everything else is done
+		for pass := 1; pass <= options.FinalRetries; pass++ {
+			pending.Wait()
+			if circuitBreaker.Tripped() {
+				break
+			}
+			retries := stats.takeFinalRetries()
+			if len(retries) == 0 {
+				break
+			}
+			logger.Infof("Retrying %d failed items (pass %d of %d)", len(retries), pass, options.FinalRetries)
+			events.Record(Event{Action: "final_retry", Detail: fmt.Sprintf("pass %d, %d items", pass, len(retries))})
+			for _, info := range retries {
+				subjects.Retry(info)
+				pending.Add(1)
+				inputChan <- info
+			}
+		}
 		close(inputChan)
 		wg.Wait()
+		extractPool.Close()
+		tui.Stop()
 
-		// Post-processing for s5cmd series
+		// Series copied by s5cmd were organized as they finished
+		s5cmdSeriesToFetchMeta := s5cmdOrganizer.Close() // Map SeriesUID to OriginalS5cmdURI
+		var fetchedMetadata []*FileInfo
 		if newS5cmdJobs > 0 {
-			fmt.Println("\nOrganizing s5cmd downloaded series...")
-			s5cmdSeriesToFetchMeta := make(map[string]string) // Map SeriesUID to OriginalS5cmdURI
-
-			for _, seriesInfo := range files {
-				if seriesInfo.IsSyncJob || seriesInfo.S5cmdManifestPath == "" {
-					continue
-				}
-
-				tempDir := seriesInfo.S5cmdManifestPath
-				filesInDir, err := os.ReadDir(tempDir)
-				if err != nil {
-					logger.Warnf("Could not read temp directory %s: %v", tempDir, err)
-					continue
-				}
-				if len(filesInDir) == 0 {
-					logger.Warnf("No files found in temp directory %s", tempDir)
-					os.Remove(tempDir)
-					continue
-				}
-
-				firstFilePath := filepath.Join(tempDir, filesInDir[0].Name())
-				firstDicom, err := ProcessDicomFile(firstFilePath)
-				if err != nil {
-					logger.Warnf("Could not get SeriesUID from %s: %v", firstFilePath, err)
-					continue
-				}
-
-				seriesUID := firstDicom.SeriesUID
-				finalDir := filepath.Join(options.Output, seriesUID)
-
-				// If the destination directory already exists, remove it. This handles cases
-				// where a user manually deletes a metadata entry to re-download a series.
-				if _, err := os.Stat(finalDir); err == nil {
-					logger.Warnf("Destination directory %s already exists. Removing it before proceeding.", finalDir)
-					if err := os.RemoveAll(finalDir); err != nil {
-						logger.Errorf("Failed to remove existing directory %s: %v", finalDir, err)
-						continue // Skip this series if cleanup fails
-					}
-				}
-
-				if err := os.Rename(tempDir, finalDir); err != nil {
-					logger.Errorf("Could not rename temp dir %s to %s: %v", tempDir, finalDir, err)
-					continue
-				}
-				s5cmdSeriesToFetchMeta[seriesUID] = seriesInfo.OriginalS5cmdURI
-			}
-			fmt.Println("s5cmd series organization complete.")
+			logger.Infof("Organized %d of %d new s5cmd series", len(s5cmdSeriesToFetchMeta), newS5cmdJobs)
 
 			// Fetch and save metadata
 			if len(s5cmdSeriesToFetchMeta) > 0 {
@@ -339,25 +789,37 @@ func main() {
 				}
 
 				fmt.Println("\nFetching metadata for new s5cmd series...")
-				fetchedMetadata, err := FetchMetadataForSeriesUIDs(uids, client, token, options)
-				if err != nil {
+				if fetchedMetadata, err = FetchMetadataForSeriesUIDs(uids, client, token, options); err != nil {
 					logger.Errorf("Failed to fetch s5cmd metadata: %v", err)
-				} else {
-					for _, meta := range fetchedMetadata {
-						meta.OriginalS5cmdURI = s5cmdSeriesToFetchMeta[meta.SeriesUID]
-					}
-					manifestName := strings.TrimSuffix(filepath.Base(options.Input), filepath.Ext(options.Input))
-					csvPath := filepath.Join(options.Output, "metadata", fmt.Sprintf("%s-metadata.csv", manifestName))
-					if err := writeMetadataToCSV(csvPath, fetchedMetadata); err != nil {
-						logger.Errorf("Failed to write s5cmd metadata to CSV: %v", err)
-					} else {
-						fmt.Printf("Metadata for %d series saved to %s\n", len(fetchedMetadata), csvPath)
-					}
 				}
 			}
 		}
 
+		// The metadata CSVs are written once, from the metadata cache
+		if csvPath, count, err := writeRunMetadataCSV(runSeries, fetchedMetadata, s5cmdSeriesToFetchMeta, stats.StartTime, options); err != nil {
+			logger.Errorf("Failed to write the metadata CSV of the run: %v", err)
+		} else if count > 0 {
+			fmt.Printf("Metadata for %d series saved to %s\n", count, csvPath)
+		}
+		if options.CombinedCSV {
+			if csvPath, count, err := writeCombinedMetadataCSV(options.Output); err != nil {
+				logger.Errorf("Failed to write %s: %v", combinedMetadataCSV, err)
+			} else {
+				fmt.Printf("Metadata for all %d series saved to %s\n", count, csvPath)
+			}
+		}
+
 		updateProgress(stats, "Complete")
+		stopStatsWriter()
+
+		if options.ValidateDicom && !options.Meta && !options.NoDecompress {
+			runDicomValidation(files, options)
+		}
+
+		deidFailed := 0
+		if deidProfile != nil {
+			deidFailed = runDeidentification(deidProfile, files, options)
+		}
 
 		if !options.Debug {
 			fmt.Fprintf(os.Stderr, "\n")
@@ -372,15 +834,142 @@ func main() {
 		}
 		fmt.Printf("Skipped: %d\n", stats.Skipped)
 		fmt.Printf("Failed: %d\n", stats.Failed)
+		failures := stats.Failures()
+		counts := countFailures(failures)
+		for _, code := range sortedKeys(counts) {
+			fmt.Printf("  %s: %d\n", code, counts[code])
+		}
+		if err := writeFailedList(options.Output, failures); err != nil {
+			logger.Warnf("Failed to write %s: %v", failedFile, err)
+		}
+		if stats.Deferred > 0 {
+			fmt.Printf("Deferred (daily quota reached): %d\n", stats.Deferred)
+		}
+		if stats.Fallbacks > 0 {
+			fmt.Printf("Downloaded from an alternate source: %d\n", stats.Fallbacks)
+		}
+		if merges := s5cmdOrganizer.Merges(); merges.Series > 0 {
+			fmt.Printf("Merged into existing s5cmd series: %d (%d instances added, %d duplicates dropped, %d replaced)\n",
+				merges.Series, merges.Added, merges.Duplicates, merges.Replaced)
+		}
 		fmt.Printf("Total time: %s\n", elapsed.Round(time.Second))
 
 		if stats.Total > 0 {
 			rate := float64(stats.Downloaded+stats.Synced+stats.Skipped) / elapsed.Seconds()
 			fmt.Printf("Average rate: %.1f items/second\n", rate)
 		}
+		printEgressSummary(stats.Egress(), options.EgressPrice)
+
+		reportUnavailableSeries(options.Output)
+		reportPendingRestores(options.Output)
+		if err := writeProvenance(options, stats); err != nil {
+			logger.Warnf("Failed to write %s: %v", provenanceFile, err)
+		}
+		if !options.NoCitations {
+			writeCitations(client, options)
+		}
+
+		if options.ReportBy == "subject" {
+			printSubjectReport(subjects, options.Output)
+		}
+
+		if activeShard != nil {
+			path, err := writeRunReport(reportDir(options.Output), newShardReport(stats, subjects, options))
+			if err != nil {
+				logger.Warnf("Failed to write the shard report: %v", err)
+			} else {
+				fmt.Printf("Report of shard %s saved to %s\n", activeShard, path)
+			}
+		}
+
+		if circuitBreaker.Tripped() {
+			processed := stats.Downloaded + stats.Synced + stats.Skipped + stats.Failed + stats.Deferred
+			logger.Errorf("Run aborted after %d consecutive infrastructure failures; %d items were not attempted",
+				circuitBreaker.Threshold, stats.Total-processed)
+		}
 
 		if stats.Failed > 0 {
-			logger.Warnf("Some downloads failed. Check the logs above for details.")
+			logger.Warnf("Some downloads failed. See %s for the failure codes and the logs above for details.",
+				filepath.Join(reportDir(options.Output), failedFile))
+		}
+		exitStatus := runExitStatus(failures, int(stats.Total), options.FailThreshold, circuitBreaker.Tripped())
+		if stats.Failed > 0 && exitStatus == ExitOK {
+			fmt.Printf("%d failed items are within --fail-threshold %s\n", stats.Failed, options.FailThreshold)
+		}
+		if deidFailed > 0 {
+			logger.Errorf("%d series failed de-identification and may still hold identifiers", deidFailed)
+			if exitStatus == ExitOK {
+				exitStatus = ExitDeidentify
+			}
+		}
+
+		events.Record(Event{Action: "run_end", Path: options.Input, Detail: fmt.Sprintf(
+			"downloaded=%d synced=%d skipped=%d failed=%d deferred=%d bytes=%d exit=%d",
+			stats.Downloaded, stats.Synced, stats.Skipped, stats.Failed, stats.Deferred, stats.BytesDownloaded, exitStatus)})
+		recordUsage(usage, stats, options, elapsed, exitStatus)
+
+		if err := urlHeads.Save(); err != nil {
+			logger.Errorf("Failed to save %s: %v", urlHeadsFile, err)
+		}
+		if err := fileNames.Save(); err != nil {
+			logger.Errorf("Failed to save %s: %v", fileNamesFile, err)
+		}
+
+		if options.Dedup != "" && !options.Meta {
+			runDedup(files, options)
+		}
+
+		if options.Store != "" && !options.Meta {
+			fmt.Printf("\nPacking series into %s containers...\n", options.Store)
+			if _, err := contentStore.Pack(files); err != nil {
+				logger.Errorf("Failed to pack series: %v", err)
+			}
+		}
+
+		if options.Repack != "" && !options.Meta {
+			runRepack(files, options)
+		}
+
+		// After packing and repacking, which replace the entries of the series by their
+		// containers and archives
+		if checksums != nil {
+			if err := checksums.Save(); err != nil {
+				logger.Errorf("Failed to write checksum manifest: %v", err)
+			} else {
+				fmt.Printf("SHA-256 sums of %d files saved to %s\n", checksums.Len(), checksums.Path())
+			}
+		}
+
+		// Last, so that the bags hold the output as deduplicated, packed and repacked
+		if options.BagIt != "" {
+			fmt.Println("\nPackaging BagIt bags...")
+			bags, err := buildBags(options, options.BagIt == "collection")
+			if err != nil {
+				logger.Errorf("Failed to create BagIt bag: %v", err)
+			}
+			for _, bag := range bags {
+				fmt.Printf("BagIt bag written to %s\n", bag)
+			}
+		}
+
+		if quota != nil {
+			if err := quota.Save(); err != nil {
+				logger.Warnf("Failed to save daily quota state: %v", err)
+			}
+			if deferred := quota.Deferred(); len(deferred) > 0 {
+				resumePath, err := writeResumeManifest(options.Output, deferred)
+				if err != nil {
+					logger.Errorf("Failed to write quota resume manifest: %v", err)
+				} else if resumePath != "" {
+					fmt.Printf("Resume tomorrow with: -i %s --skip-existing\n", resumePath)
+				} else {
+					fmt.Println("Resume tomorrow by re-running the same input with --skip-existing")
+				}
+			}
+		}
+
+		if exitStatus != ExitOK {
+			os.Exit(exitStatus)
 		}
 	}
 }
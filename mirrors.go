@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mirrorProbeBytes is the size of the ranged request measuring the throughput of a
+// mirror
+const mirrorProbeBytes = 1 << 20
+
+// mirrorProbeTimeout bounds the probe of each mirror, so that an unreachable one
+// does not hold up the run
+const mirrorProbeTimeout = 20 * time.Second
+
+// knownMirrors are the --mirrors names of the S3 endpoints serving the public IDC
+// buckets
+var knownMirrors = map[string]string{
+	"aws": DefaultEndpoints.S3,
+	"gcp": "https://storage.googleapis.com",
+}
+
+// mirrorChoice is the mirror selected for this run; nil without --mirrors
+var mirrorChoice *MirrorChoice
+
+// Mirror is an S3 endpoint serving the same buckets as the others of --mirrors
+type Mirror struct {
+	Name     string
+	Endpoint string
+}
+
+// MirrorProbe is the measurement of a mirror: the time to list the probed object
+// and the throughput of fetching its first MB
+type MirrorProbe struct {
+	Name        string  `json:"name"`
+	Endpoint    string  `json:"endpoint"`
+	LatencyMs   int64   `json:"latency_ms,omitempty"`
+	BytesPerSec float64 `json:"bytes_per_sec,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// MirrorChoice is the mirror a run downloaded s5cmd manifests from, with the probes
+// it was chosen by
+type MirrorChoice struct {
+	Name     string        `json:"name"`
+	Endpoint string        `json:"endpoint"`
+	Object   string        `json:"object,omitempty"` // the object probed, s3://bucket/key
+	Probes   []MirrorProbe `json:"probes"`
+}
+
+// s3Listing is the part of a ListObjectsV2 response the probes read
+type s3Listing struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+}
+
+// parseMirrors reads a --mirrors list: names of knownMirrors or S3 endpoint URLs,
+// separated by commas
+func parseMirrors(value string) ([]Mirror, error) {
+	var mirrors []Mirror
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		mirror := Mirror{Name: strings.ToLower(field), Endpoint: knownMirrors[strings.ToLower(field)]}
+		if mirror.Endpoint == "" {
+			u, err := url.Parse(field)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return nil, fmt.Errorf("%q is neither %s nor an http(s) endpoint URL", field, strings.Join(sortedKeys(knownMirrors), ", "))
+			}
+			mirror = Mirror{Name: u.Host, Endpoint: strings.TrimSuffix(field, "/")}
+		}
+		if !seen[mirror.Endpoint] {
+			seen[mirror.Endpoint] = true
+			mirrors = append(mirrors, mirror)
+		}
+	}
+	if len(mirrors) < 2 {
+		return nil, fmt.Errorf("%q names fewer than two mirrors to choose from", value)
+	}
+	return mirrors, nil
+}
+
+// mirrorProbeObject returns the bucket and key prefix of the first S3 item of the
+// input, which every mirror is probed with
+func mirrorProbeObject(files []*FileInfo) (bucket, prefix string, ok bool) {
+	for _, info := range files {
+		u, err := url.Parse(info.DownloadURL)
+		if err != nil || u.Scheme != "s3" || u.Host == "" {
+			continue
+		}
+		return u.Host, strings.TrimSuffix(strings.TrimPrefix(u.Path, "/"), "*"), true
+	}
+	return "", "", false
+}
+
+// probeMirror lists the first object below prefix on a mirror and fetches up to
+// mirrorProbeBytes of it. Probes are anonymous, like the default s5cmd requests.
+func probeMirror(httpClient *http.Client, mirror Mirror, bucket, prefix string) (MirrorProbe, string) {
+	probe := MirrorProbe{Name: mirror.Name, Endpoint: mirror.Endpoint}
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorProbeTimeout)
+	defer cancel()
+	get := func(rawURL string, header http.Header) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		return body, nil
+	}
+
+	listURL, err := url.JoinPath(mirror.Endpoint, bucket)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe, ""
+	}
+	listURL += "?" + url.Values{"list-type": {"2"}, "prefix": {prefix}, "max-keys": {"1"}}.Encode()
+	start := time.Now()
+	body, err := get(listURL, nil)
+	if err != nil {
+		probe.Error = "listing failed: " + err.Error()
+		return probe, ""
+	}
+	probe.LatencyMs = time.Since(start).Milliseconds()
+	var listing s3Listing
+	if err := xml.Unmarshal(body, &listing); err != nil || len(listing.Contents) == 0 {
+		probe.Error = "no object below s3://" + bucket + "/" + prefix
+		return probe, ""
+	}
+	key := listing.Contents[0].Key
+
+	objectURL, err := url.JoinPath(mirror.Endpoint, bucket, key)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe, ""
+	}
+	start = time.Now()
+	body, err = get(objectURL, http.Header{"Range": {fmt.Sprintf("bytes=0-%d", mirrorProbeBytes-1)}})
+	if err != nil {
+		probe.Error = "download failed: " + err.Error()
+		return probe, ""
+	}
+	probe.BytesPerSec = float64(len(body)) / max(time.Since(start).Seconds(), 1e-3)
+	return probe, "s3://" + bucket + "/" + key
+}
+
+// fastestMirror returns the index of the probe with the highest throughput, the
+// lower latency breaking ties, or -1 when every probe failed
+func fastestMirror(probes []MirrorProbe) int {
+	best := -1
+	for i, probe := range probes {
+		if probe.Error != "" {
+			continue
+		}
+		if best < 0 || probe.BytesPerSec > probes[best].BytesPerSec ||
+			(probe.BytesPerSec == probes[best].BytesPerSec && probe.LatencyMs < probes[best].LatencyMs) {
+			best = i
+		}
+	}
+	return best
+}
+
+// selectMirror probes the mirrors of --mirrors at once and points s5cmd at the
+// fastest for the whole run. The choice is recorded in events.jsonl and in
+// PROVENANCE.json; without an S3 item in the input, or when no mirror answers, the
+// --s3-url endpoint is kept.
+func selectMirror(httpClient *http.Client, files []*FileInfo, options *Options) {
+	bucket, prefix, ok := mirrorProbeObject(files)
+	if !ok {
+		logger.Debugf("No S3 items to choose a mirror for")
+		return
+	}
+	probes := make([]MirrorProbe, len(options.Mirrors))
+	objects := make([]string, len(options.Mirrors))
+	var wg sync.WaitGroup
+	for i, mirror := range options.Mirrors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probes[i], objects[i] = probeMirror(httpClient, mirror, bucket, prefix)
+		}()
+	}
+	wg.Wait()
+
+	fmt.Println("Mirror probes:")
+	for _, probe := range probes {
+		if probe.Error != "" {
+			fmt.Printf("  %-8s %s: %s\n", probe.Name, probe.Endpoint, probe.Error)
+		} else {
+			fmt.Printf("  %-8s %s: %d ms, %s/s\n", probe.Name, probe.Endpoint, probe.LatencyMs, formatBytes(int64(probe.BytesPerSec)))
+		}
+	}
+	best := fastestMirror(probes)
+	if best < 0 {
+		logger.Warnf("No mirror of --mirrors answered; downloading from %s", endpoints.S3)
+		return
+	}
+
+	mirrorChoice = &MirrorChoice{
+		Name:     probes[best].Name,
+		Endpoint: probes[best].Endpoint,
+		Object:   objects[best],
+		Probes:   probes,
+	}
+	endpoints.S3 = mirrorChoice.Endpoint
+	fmt.Printf("Downloading S3 items from the %s mirror (%s)\n", mirrorChoice.Name, mirrorChoice.Endpoint)
+	events.Record(Event{Action: "mirror", Detail: fmt.Sprintf("%s %s, %s/s", mirrorChoice.Name, mirrorChoice.Endpoint,
+		formatBytes(int64(probes[best].BytesPerSec)))})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fileNamesFile records the names direct and DRS downloads were saved under when
+// their manifest gave none, below metadata/
+const fileNamesFile = "file-names.json"
+
+// fileNames maps download sources to the names taken from the server; nil disables it
+var fileNames *FileNameMap
+
+// mimeExtensions are the extensions of the content types common in imaging data.
+// Other types fall back to the system MIME table.
+var mimeExtensions = map[string]string{
+	"application/dicom":            ".dcm",
+	"application/dicom+json":       ".json",
+	"application/zip":              ".zip",
+	"application/x-zip-compressed": ".zip",
+	"application/gzip":             ".gz",
+	"application/x-gzip":           ".gz",
+	"application/x-tar":            ".tar",
+	"application/json":             ".json",
+	"application/pdf":              ".pdf",
+	"application/x-hdf5":           ".h5",
+	"application/x-nifti":          ".nii",
+	"application/xml":              ".xml",
+	"text/csv":                     ".csv",
+	"text/tab-separated-values":    ".tsv",
+	"text/plain":                   ".txt",
+	"text/xml":                     ".xml",
+	"image/jpeg":                   ".jpg",
+	"image/png":                    ".png",
+	"image/tiff":                   ".tif",
+}
+
+// FileNameMap holds the file names chosen from server responses, keyed by source URI.
+// A name is given to a single source so that items cannot overwrite each other.
+type FileNameMap struct {
+	path    string
+	mu      sync.Mutex
+	names   map[string]string
+	claimed map[string]string // name -> source
+	changed bool
+}
+
+// OpenFileNames loads the file names recorded in the output directory
+func OpenFileNames(output string) (*FileNameMap, error) {
+	m := &FileNameMap{path: filepath.Join(output, "metadata", fileNamesFile)}
+	names, err := readFileNames(m.path)
+	if err != nil {
+		return nil, err
+	}
+	m.names = names
+	m.claimed = make(map[string]string, len(names))
+	for source, name := range names {
+		m.claimed[name] = source
+	}
+	return m, nil
+}
+
+// readFileNames reads a file names record; a missing file is empty
+func readFileNames(path string) (map[string]string, error) {
+	names := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return names, nil
+}
+
+// Lookup returns the name recorded for a source
+func (m *FileNameMap) Lookup(source string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, ok := m.names[source]
+	return name, ok
+}
+
+// Claim records name for source unless another source has it already
+func (m *FileNameMap) Claim(source, name string) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if owner, ok := m.claimed[name]; ok && owner != source {
+		return false
+	}
+	if previous, ok := m.names[source]; ok {
+		delete(m.claimed, previous)
+	}
+	m.names[source] = name
+	m.claimed[name] = source
+	m.changed = true
+	return true
+}
+
+// Save writes the recorded names when the run added any, merging the names other
+// invocations sharing the output directory recorded meanwhile
+func (m *FileNameMap) Save() error {
+	if m == nil {
+		return nil
+	}
+	return outputLock.WithState(func() error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if !m.changed {
+			return nil
+		}
+		if outputLock.Shared() {
+			saved, err := readFileNames(m.path)
+			if err != nil {
+				return err
+			}
+			for source, name := range saved {
+				if _, ok := m.names[source]; !ok {
+					m.names[source] = name
+				}
+			}
+		}
+		content, err := json.MarshalIndent(m.names, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(m.path, content, 0644); err != nil {
+			return err
+		}
+		m.changed = false
+		return nil
+	})
+}
+
+// cleanFileName reduces a server-provided name to a plain file name, or "" when
+// nothing usable is left
+func cleanFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(strings.TrimSpace(name), `\`, "/"))
+	if name == "." || name == ".." || name == "/" || strings.HasPrefix(name, ".") {
+		return ""
+	}
+	return name
+}
+
+// extensionForType returns the file extension of a Content-Type, or ""
+func extensionForType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		return ""
+	}
+	if ext, ok := mimeExtensions[mediaType]; ok {
+		return ext
+	}
+	exts, _ := mime.ExtensionsByType(mediaType)
+	sort.Strings(exts)
+	if len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// responseFileName names a download without a name from its manifest: the
+// Content-Disposition filename, or else fallback with the extension of the
+// Content-Type when it has none
+func responseFileName(resp *http.Response, fallback string) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := cleanFileName(params["filename"]); name != "" {
+			return name
+		}
+	}
+	if filepath.Ext(fallback) == "" {
+		return fallback + extensionForType(resp.Header.Get("Content-Type"))
+	}
+	return fallback
+}
+
+// fileExtension returns the extension of a file name, including that of the
+// compressed file, e.g. .nii.gz
+func fileExtension(name string) string {
+	ext := filepath.Ext(name)
+	switch ext {
+	case ".gz", ".bz2", ".xz", ".zst":
+		return filepath.Ext(strings.TrimSuffix(name, ext)) + ext
+	}
+	return ext
+}
+
+// hasDirectItems reports whether any item is a direct or DRS download without a
+// name from its manifest
+func hasDirectItems(files []*FileInfo) bool {
+	for _, info := range files {
+		if info.FileName == "" && (info.DRSURI != "" || info.DownloadURL != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// namedPath returns where a download without a name from its manifest is stored.
+// The first download takes the name from the response; a name another item
+// holds already falls back to the SeriesUID with the extension of the response.
+func (info *FileInfo) namedPath(output string, resp *http.Response) string {
+	source := info.source()
+	if fileNames == nil {
+		return info.directPath(output)
+	}
+	if name, ok := fileNames.Lookup(source); ok {
+		return filepath.Join(output, name)
+	}
+	name := responseFileName(resp, info.SeriesUID)
+	if name == info.SeriesUID {
+		return filepath.Join(output, name)
+	}
+	if !fileNames.Claim(source, name) {
+		logger.Warnf("%s is the name of another item, saving %s as %s", name, source, info.SeriesUID+fileExtension(name))
+		name = info.SeriesUID + fileExtension(name)
+		if !fileNames.Claim(source, name) {
+			name = info.SeriesUID
+		}
+	}
+	return filepath.Join(output, name)
+}
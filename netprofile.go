@@ -0,0 +1,41 @@
+package main
+
+import "time"
+
+// NetProfile holds the tunable settings of the HTTP transport
+type NetProfile struct {
+	MaxConnsPerHost int
+	IdleConnTimeout time.Duration
+	KeepAlive       bool
+	HTTP2           bool
+	ReadBufferSize  int // 0 keeps the Go default of 4 KB
+	WriteBufferSize int
+
+	// Dialing, the same in every profile
+	IPVersion       string // "4" or "6" to dial only that IP version; "" dials both
+	NoHappyEyeballs bool   // dial the addresses one after another instead of racing IPv4 against IPv6
+	DNSServer       string // host:port of the DNS server; "" uses the system resolver
+}
+
+// netProfiles are the --net-profile presets. balanced matches the settings used
+// before profiles existed.
+var netProfiles = map[string]NetProfile{
+	"conservative": {
+		MaxConnsPerHost: 2,
+		IdleConnTimeout: 15 * time.Second,
+		KeepAlive:       true,
+	},
+	"balanced": {
+		MaxConnsPerHost: 8,
+		IdleConnTimeout: 30 * time.Second,
+		KeepAlive:       true,
+	},
+	"aggressive": {
+		MaxConnsPerHost: 32,
+		IdleConnTimeout: 90 * time.Second,
+		KeepAlive:       true,
+		HTTP2:           true,
+		ReadBufferSize:  256 * 1024,
+		WriteBufferSize: 64 * 1024,
+	},
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrOffline is returned for the metadata API requests refused with --offline
+var ErrOffline = errors.New("metadata API not used with --offline")
+
+// metadataOffline refuses every metadata API request of the run (--offline)
+var metadataOffline bool
+
+// missingMetadataFile is the manifest of the series an --offline run found no cached
+// metadata for, in the report directory
+const missingMetadataFile = "missing-metadata.tcia"
+
+// offlineMissingMax is the number of series lacking metadata listed on the console
+const offlineMissingMax = 20
+
+// offlineMissingError saves the series lacking cached metadata to a manifest and
+// returns the error listing them. The manifest is the input of a metadata-only run
+// on a machine with access to the API.
+func offlineMissingError(output string, missing []string) error {
+	sort.Strings(missing)
+	path := filepath.Join(reportDir(output), missingMetadataFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+		err = writeFileAtomic(path, []byte("ListOfSeriesToDownload=\n"+strings.Join(missing, "\n")+"\n"), 0644)
+		if err != nil {
+			logger.Warnf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	listed := missing
+	more := ""
+	if len(listed) > offlineMissingMax {
+		listed = listed[:offlineMissingMax]
+		more = fmt.Sprintf("\n  ... and %d more", len(missing)-offlineMissingMax)
+	}
+	return fmt.Errorf("%d series have no cached metadata and --offline does not fetch it:\n  %s%s\n"+
+		"The list is saved to %s; fetch their metadata with --meta -i %s where the API is reachable",
+		len(missing), strings.Join(listed, "\n  "), more, path, missingMetadataFile)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// s5cmdOrganizeWorkers is the number of goroutines moving copied s5cmd series into
+// their series folder
+const s5cmdOrganizeWorkers = 4
+
+// s5cmdTempPrefix names the directories s5cmd copies a series into before it is
+// organized
+const s5cmdTempPrefix = "s5cmd-tmp-"
+
+// interruptedS5cmdDirs are the temporary series directories found with files at
+// startup; copies into them resume with sync
+var interruptedS5cmdDirs map[string]bool
+
+// s5cmdOrganizer organizes copied s5cmd series as the workers finish them; nil when
+// the run has no s5cmd copies
+var s5cmdOrganizer *S5cmdOrganizer
+
+// S5cmdOrganizer moves s5cmd series from their temporary directory into a folder
+// named by their SeriesInstanceUID, on a few goroutines of its own, so that series
+// are organized while the next ones download and a crash loses none that finished
+type S5cmdOrganizer struct {
+	output string
+	jobs   chan *FileInfo
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex        // serializes the moves into series folders
+	organized map[string]string // SeriesUID to the manifest URI it was copied from
+	merges    InstanceMerge     // totals of the series merged into existing folders
+}
+
+// InstanceMerge counts the instances of copied series merged into series folders
+// that existed already
+type InstanceMerge struct {
+	Series     int // series merged
+	Added      int // instances the folder lacked
+	Duplicates int // instances the folder had, dropped from the copy
+	Replaced   int // instances the folder had with another size, replaced by the copy
+}
+
+// NewS5cmdOrganizer starts an organizer of the series copied below output
+func NewS5cmdOrganizer(output string) *S5cmdOrganizer {
+	o := &S5cmdOrganizer{
+		output:    output,
+		jobs:      make(chan *FileInfo, s5cmdOrganizeWorkers),
+		organized: make(map[string]string),
+	}
+	o.wg.Add(s5cmdOrganizeWorkers)
+	for i := 0; i < s5cmdOrganizeWorkers; i++ {
+		go func() {
+			defer o.wg.Done()
+			for info := range o.jobs {
+				seriesUID, err := o.organize(info)
+				if err != nil {
+					logger.Errorf("Failed to organize s5cmd series %s: %v", info.OriginalS5cmdURI, err)
+					continue
+				}
+				o.mu.Lock()
+				o.organized[seriesUID] = info.OriginalS5cmdURI
+				o.mu.Unlock()
+			}
+		}()
+	}
+	return o
+}
+
+// Submit queues a copied series. It blocks while the organizer is saturated.
+func (o *S5cmdOrganizer) Submit(info *FileInfo) {
+	if o == nil || info.IsSyncJob || info.S5cmdManifestPath == "" {
+		return
+	}
+	o.jobs <- info
+}
+
+// Close waits for the queued series and returns the organized ones, by SeriesUID
+func (o *S5cmdOrganizer) Close() map[string]string {
+	if o == nil {
+		return nil
+	}
+	close(o.jobs)
+	o.wg.Wait()
+	return o.organized
+}
+
+// Merges returns the totals of the series merged into existing folders
+func (o *S5cmdOrganizer) Merges() InstanceMerge {
+	if o == nil {
+		return InstanceMerge{}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.merges
+}
+
+// organize moves the temporary directory of a copied series to the folder of the
+// SeriesInstanceUID of its first DICOM file and hashes it
+func (o *S5cmdOrganizer) organize(info *FileInfo) (string, error) {
+	tempDir := info.S5cmdManifestPath
+	filesInDir, err := os.ReadDir(tempDir)
+	if err != nil {
+		return "", fmt.Errorf("could not read temp directory %s: %w", tempDir, err)
+	}
+	if len(filesInDir) == 0 {
+		os.Remove(tempDir)
+		return "", fmt.Errorf("no files found in temp directory %s", tempDir)
+	}
+
+	seriesUID, err := dirSeriesUID(tempDir)
+	if err != nil {
+		return "", fmt.Errorf("could not get SeriesUID: %w", err)
+	}
+	finalDir := filepath.Join(o.output, seriesUID)
+
+	o.mu.Lock()
+	if _, statErr := os.Lstat(finalDir); statErr != nil {
+		if err = renameFile(tempDir, finalDir); err != nil {
+			err = fmt.Errorf("could not rename %s to %s: %w", tempDir, finalDir, err)
+		}
+	} else {
+		// A partial copy of an earlier run, or a series whose metadata entry was
+		// deleted to download it again
+		var merge InstanceMerge
+		if merge, err = mergeSeriesDir(tempDir, finalDir); err == nil {
+			logger.Infof("Merged %s into existing %s: %d instances added, %d duplicates, %d replaced",
+				info.OriginalS5cmdURI, finalDir, merge.Added, merge.Duplicates, merge.Replaced)
+			o.merges.Series++
+			o.merges.Added += merge.Added
+			o.merges.Duplicates += merge.Duplicates
+			o.merges.Replaced += merge.Replaced
+		}
+	}
+	o.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	logger.Debugf("Organized s5cmd series %s into %s", info.OriginalS5cmdURI, finalDir)
+	if err := checksums.HashDir(finalDir); err != nil {
+		logger.Warnf("Failed to compute checksums for %s: %v", finalDir, err)
+	}
+	stamps.Done(Stamp{SeriesUID: seriesUID, Action: "download", Path: finalDir})
+	return seriesUID, nil
+}
+
+// mergeSeriesDir moves the instances of the series copied into from into the existing
+// series folder to, matching them by SOPInstanceUID. An instance the folder has
+// already is dropped, or replaces it when their sizes differ; other files replace
+// those of the same name. from is removed afterwards.
+func mergeSeriesDir(from, to string) (InstanceMerge, error) {
+	var merge InstanceMerge
+	existing := make(map[string]string) // SOPInstanceUID -> file
+	err := filepath.WalkDir(to, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isDicomFile(path) {
+			return err
+		}
+		if uid, err := sopInstanceUID(path); err == nil && uid != "" {
+			existing[uid] = path
+		}
+		return nil
+	})
+	if err != nil {
+		return merge, err
+	}
+
+	err = filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		uid := ""
+		if isDicomFile(path) {
+			uid, _ = sopInstanceUID(path)
+		}
+		if current, ok := existing[uid]; uid != "" && ok {
+			if sameSize(path, current) {
+				merge.Duplicates++
+				return os.Remove(path)
+			}
+			merge.Replaced++
+			target = current
+		} else if uid != "" {
+			if _, err := os.Lstat(target); err == nil {
+				// Another instance under the same name
+				ext := filepath.Ext(target)
+				target = strings.TrimSuffix(target, ext) + "-" + uid + ext
+			}
+			existing[uid] = target
+			merge.Added++
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return renameFile(path, target)
+	})
+	if err != nil {
+		return merge, fmt.Errorf("could not merge %s into %s: %w", from, to, err)
+	}
+	return merge, os.RemoveAll(from)
+}
+
+// sameSize reports whether two files have the same size
+func sameSize(a, b string) bool {
+	statA, errA := os.Stat(a)
+	statB, errB := os.Stat(b)
+	return errA == nil && errB == nil && statA.Size() == statB.Size()
+}
+
+// scanS5cmdTempDirs finds the temporary series directories an interrupted run left
+// with files in them, below the output directory and --temp-dir. The copies of this
+// run into them resume with sync instead of copying every object again.
+func scanS5cmdTempDirs(output string) map[string]bool {
+	found := make(map[string]bool)
+	for _, dir := range []string{output, transferTempDir} {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || !strings.Contains(entry.Name(), s5cmdTempPrefix) {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if files, err := os.ReadDir(path); err == nil && len(files) > 0 {
+				found[path] = true
+			}
+		}
+	}
+	if len(found) > 0 {
+		logger.Infof("Found %d s5cmd series left by an interrupted run", len(found))
+	}
+	return found
+}
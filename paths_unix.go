@@ -0,0 +1,30 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// outputPath normalizes the output directory; paths have no length limit to work
+// around outside Windows
+func outputPath(path string) (string, error) {
+	return path, nil
+}
+
+// fsBlockSize returns the preferred I/O block size of the file system holding path,
+// or 0 when unknown
+func fsBlockSize(path string) int64 {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0
+	}
+	return int64(stat.Bsize)
+}
+
+// isCrossDevice reports whether a rename failed because the paths are on different
+// file systems
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,44 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// longPathPrefix marks paths the Windows API takes literally, without the MAX_PATH
+// limit of 260 characters
+const longPathPrefix = `\\?\`
+
+const errorNotSameDevice syscall.Errno = 17
+
+// outputPath normalizes the output directory to an absolute path in the \\?\ form,
+// so that files deep below it can exceed MAX_PATH. UNC shares (\\server\share)
+// become \\?\UNC\server\share.
+func outputPath(path string) (string, error) {
+	if strings.HasPrefix(path, longPathPrefix) {
+		return path, nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return longPathPrefix + `UNC\` + strings.TrimPrefix(abs, `\\`), nil
+	}
+	return longPathPrefix + abs, nil
+}
+
+// fsBlockSize returns the preferred I/O block size of the file system holding path;
+// it is not looked up on Windows
+func fsBlockSize(path string) int64 {
+	return 0
+}
+
+// isCrossDevice reports whether a rename failed because the paths are on different volumes
+func isCrossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
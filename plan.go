@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// planFormat is the version of the plan file format
+const planFormat = 1
+
+// approvedPlan is the plan executed by apply; nil for other commands
+var approvedPlan *Plan
+
+// Plan is what a download run will do, computed by plan and executed by apply, so
+// that what is transferred can be reviewed and approved beforehand
+type Plan struct {
+	Format      int               `json:"format"`
+	Version     string            `json:"version,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	CommandLine []string          `json:"command_line"`
+	Inputs      []ProvenanceInput `json:"inputs,omitempty"`
+	Endpoints   Endpoints         `json:"endpoints"`
+	Output      string            `json:"output"`
+	IfExists    string            `json:"if_exists"`
+	Summary     map[string]int    `json:"summary"`
+	Items       []PlanItem        `json:"items"`
+}
+
+// PlanItem is an item of a plan with the action planned for it. The fields of the
+// item that are not part of its JSON form are kept next to them.
+type PlanItem struct {
+	*FileInfo
+	Instances []string `json:"sop_instance_uids,omitempty"`
+	Sources   []string `json:"alternate_sources,omitempty"`
+	Action    string   `json:"action"`
+	Reason    string   `json:"reason"`
+}
+
+// planFile is the on-disk form of a plan: the plan and its signature
+type planFile struct {
+	Signature planSignature   `json:"signature"`
+	Plan      json.RawMessage `json:"plan"`
+}
+
+// planSignature is an HMAC-SHA256 of the compacted plan with a key derived from
+// --secret-key
+type planSignature struct {
+	Algorithm  string `json:"algorithm"` // hmac-sha256
+	KeySource  string `json:"key_source"`
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	MAC        []byte `json:"mac"`
+}
+
+// mac returns the signature of content, deriving the key from the passphrase with
+// the salt and iterations of the signature. The keychain key also encrypts
+// secrets, so plans are signed with a key derived from it.
+func (k *SecretKey) mac(signature *planSignature, content []byte) ([]byte, error) {
+	key := k.keychain
+	if signature.KeySource == secretKeyPassphrase {
+		if k.passphrase == "" {
+			return nil, fmt.Errorf("plan was signed with a passphrase, set --secret-key passphrase")
+		}
+		var err error
+		if key, err = pbkdf2.Key(sha256.New, k.passphrase, signature.Salt, signature.Iterations, 32); err != nil {
+			return nil, err
+		}
+	} else if key == nil {
+		return nil, fmt.Errorf("plan was signed with the keychain key, set --secret-key keychain")
+	} else {
+		derive := hmac.New(sha256.New, key)
+		derive.Write([]byte("nbia-data-retriever plan signature"))
+		key = derive.Sum(nil)
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(content)
+	return h.Sum(nil), nil
+}
+
+// writePlan computes the action of every item and saves the signed plan to path
+func writePlan(files []*FileInfo, path string, options *Options) error {
+	output, err := filepath.Abs(options.Output)
+	if err != nil {
+		return err
+	}
+	plan := Plan{
+		Format:      planFormat,
+		Version:     version,
+		CreatedAt:   time.Now().UTC(),
+		CommandLine: redactCommandLine(os.Args),
+		Inputs:      provenanceInputs(options),
+		Endpoints:   endpoints,
+		Output:      output,
+		IfExists:    options.IfExists,
+		Summary:     make(map[string]int),
+		Items:       make([]PlanItem, 0, len(files)),
+	}
+	for _, info := range files {
+		action := planAction(info, options)
+		plan.Items = append(plan.Items, PlanItem{
+			FileInfo:  info,
+			Instances: info.SOPInstanceUIDs,
+			Sources:   info.Alternates,
+			Action:    action.Action,
+			Reason:    action.Reason,
+		})
+		plan.Summary[action.Action]++
+	}
+
+	content, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	signature := planSignature{Algorithm: "hmac-sha256", KeySource: secretKey.source}
+	if secretKey.source == secretKeyPassphrase {
+		signature.Salt = make([]byte, 16)
+		if _, err := rand.Read(signature.Salt); err != nil {
+			return err
+		}
+		signature.Iterations = pbkdf2Iterations
+	}
+	if signature.MAC, err = secretKey.mac(&signature, content); err != nil {
+		return err
+	}
+	file, err := json.MarshalIndent(planFile{Signature: signature, Plan: content}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, file, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Plan of %d items written to %s\n", len(plan.Items), path)
+	for _, action := range sortedKeys(plan.Summary) {
+		fmt.Printf("  %s: %d\n", action, plan.Summary[action])
+	}
+	fmt.Printf("Review it, then run: apply %s with the same --secret-key\n", path)
+	return nil
+}
+
+// endpointChanges lists the endpoints of resolved that differ from those a plan was
+// made against
+func endpointChanges(planned, resolved Endpoints) []string {
+	var changes []string
+	for _, e := range []struct{ name, planned, resolved string }{
+		{"token", planned.Token, resolved.Token},
+		{"image", planned.Image, resolved.Image},
+		{"meta", planned.Meta, resolved.Meta},
+		{"series", planned.Series, resolved.Series},
+		{"study", planned.Study, resolved.Study},
+		{"s3", planned.S3, resolved.S3},
+	} {
+		if e.planned != e.resolved {
+			changes = append(changes, fmt.Sprintf("%s %s instead of %s", e.name, e.resolved, e.planned))
+		}
+	}
+	return changes
+}
+
+// loadPlan reads a plan and verifies its signature. Reformatting the file keeps
+// the signature valid; any other change invalidates it.
+func loadPlan(path string) (*Plan, error) {
+	if secretKey == nil {
+		return nil, fmt.Errorf("verifying the plan requires --secret-key")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file planFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if file.Signature.Algorithm != "hmac-sha256" {
+		return nil, fmt.Errorf("%s: unsupported signature %q", path, file.Signature.Algorithm)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, file.Plan); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	mac, err := secretKey.mac(&file.Signature, compact.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if !hmac.Equal(mac, file.Signature.MAC) {
+		return nil, fmt.Errorf("%s: signature mismatch, the plan was changed after it was made or the key differs", path)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(compact.Bytes(), &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if plan.Format != planFormat {
+		return nil, fmt.Errorf("%s: unsupported plan format %d", path, plan.Format)
+	}
+	return &plan, nil
+}
+
+// Files returns the items the plan downloads, repairs or syncs; items planned to be
+// skipped are not transferred even if they changed since
+func (p *Plan) Files() (files []*FileInfo, newS5cmdJobs int) {
+	for _, item := range p.Items {
+		if item.Action == "skip" || item.FileInfo == nil {
+			continue
+		}
+		info := item.FileInfo
+		info.SOPInstanceUIDs = item.Instances
+		info.Alternates = item.Sources
+		if !info.IsSyncJob && info.S5cmdManifestPath != "" {
+			newS5cmdJobs++
+		}
+		files = append(files, info)
+	}
+	return files, newS5cmdJobs
+}
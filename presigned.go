@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// presignedURLsFile is the append-only store of resolved DRS download URLs under metadata/
+const presignedURLsFile = "presigned-urls.jsonl"
+
+// presignedURLMargin is the minimum validity left when a cached URL is used, on top of
+// the expected transfer time, so the transfer starts well before the signature expires
+const presignedURLMargin = 5 * time.Minute
+
+// presignedURLs caches resolved Gen3 download URLs across runs; nil disables it
+var presignedURLs *PresignedURLCache
+
+// presignedURL is one line of presigned-urls.jsonl; an empty URL invalidates the key
+type presignedURL struct {
+	Key     string    `json:"key"`
+	URL     string    `json:"url,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// PresignedURLCache keeps presigned URLs with their expiry time, so a resumed run
+// within the validity window does not resolve every DRS object again. Entries are
+// appended as they are resolved, which keeps them even when the run is interrupted.
+type PresignedURLCache struct {
+	mu      sync.Mutex
+	f       *os.File
+	entries map[string]presignedURL
+}
+
+// OpenPresignedURLCache loads the unexpired URLs and compacts the store
+func OpenPresignedURLCache(output string) (*PresignedURLCache, error) {
+	path := filepath.Join(output, "metadata", presignedURLsFile)
+	c := &PresignedURLCache{entries: make(map[string]presignedURL)}
+
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry presignedURL
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			if entry.URL == "" {
+				delete(c.entries, entry.Key)
+			} else {
+				c.entries[entry.Key] = entry
+			}
+		}
+		f.Close()
+	}
+
+	now := time.Now()
+	for key, entry := range c.entries {
+		if !entry.Expires.After(now) {
+			delete(c.entries, key)
+		}
+	}
+
+	// Rewrite the store with the live entries only. Invocations sharing the output
+	// directory append to it at the same time, so it is left alone with --shared.
+	if !outputLock.Shared() {
+		if err := c.compact(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if c.f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	if len(c.entries) > 0 {
+		logger.Infof("Reusing %d presigned download URLs from a previous run", len(c.entries))
+	}
+	return c, nil
+}
+
+// compact rewrites the store at path with the loaded entries
+func (c *PresignedURLCache) compact(path string) error {
+	tempPath := path + ".tmp"
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, key := range sortedKeys(c.entries) {
+		line, _ := json.Marshal(c.entries[key])
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	f.Close()
+	return renameFile(tempPath, path)
+}
+
+// Get returns the cached URL for key if it stays valid for at least need
+func (c *PresignedURLCache) Get(key string, need time.Duration) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Until(entry.Expires) < need {
+		logger.Debugf("Presigned URL for %s expires at %s, resolving again", key, entry.Expires.Format(time.RFC3339))
+		return "", false
+	}
+	return entry.URL, true
+}
+
+// Put stores a resolved URL. URLs without a recognizable expiry are not cached.
+func (c *PresignedURLCache) Put(key, rawURL string) {
+	if c == nil {
+		return
+	}
+	expires, ok := presignedURLExpiry(rawURL)
+	if !ok {
+		logger.Debugf("Not caching download URL for %s: expiry unknown", key)
+		return
+	}
+	c.append(presignedURL{Key: key, URL: rawURL, Expires: expires})
+}
+
+// Invalidate drops a URL that was rejected by the storage server
+func (c *PresignedURLCache) Invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.append(presignedURL{Key: key})
+}
+
+func (c *PresignedURLCache) append(entry presignedURL) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry.URL == "" {
+		delete(c.entries, entry.Key)
+	} else {
+		c.entries[entry.Key] = entry
+	}
+	if _, err := c.f.Write(append(line, '\n')); err != nil {
+		logger.Warnf("Failed to write %s: %v", presignedURLsFile, err)
+	}
+}
+
+// Close closes the store
+func (c *PresignedURLCache) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.f.Close()
+}
+
+// presignedURLExpiry reads the expiry time from the signature parameters of S3
+// (SigV4 and SigV2), Google Cloud Storage and Azure SAS URLs
+func presignedURLExpiry(rawURL string) (time.Time, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return time.Time{}, false
+	}
+	q := u.Query()
+
+	for _, prefix := range []string{"X-Amz-", "X-Goog-"} {
+		date, expires := q.Get(prefix+"Date"), q.Get(prefix+"Expires")
+		if date == "" || expires == "" {
+			continue
+		}
+		signed, err := time.Parse("20060102T150405Z", date)
+		seconds, err2 := strconv.Atoi(expires)
+		if err != nil || err2 != nil {
+			return time.Time{}, false
+		}
+		return signed.Add(time.Duration(seconds) * time.Second), true
+	}
+	if expires := q.Get("Expires"); expires != "" {
+		if unix, err := strconv.ParseInt(expires, 10, 64); err == nil {
+			return time.Unix(unix, 0), true
+		}
+	}
+	if se := q.Get("se"); se != "" && q.Get("sig") != "" {
+		if t, err := time.Parse(time.RFC3339, se); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// presignedURLNeed is how long a URL must stay valid to download info: the margin
+// plus the transfer time at the current per-worker throughput
+func presignedURLNeed(info *FileInfo, workers int) time.Duration {
+	need := presignedURLMargin
+	if activeStats == nil || workers < 1 {
+		return need
+	}
+	activeStats.mu.Lock()
+	rate := activeStats.throughput.rate
+	activeStats.mu.Unlock()
+	if rate > 0 {
+		perWorker := rate / float64(workers)
+		need += time.Duration(float64(info.expectedBytes()) / perWorker * float64(time.Second))
+	}
+	return need
+}
+
+// hasDRSItems reports whether any item is resolved through a Gen3 DRS URI
+func hasDRSItems(files []*FileInfo) bool {
+	for _, info := range files {
+		if info.DRSURI != "" {
+			return true
+		}
+	}
+	return false
+}
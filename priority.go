@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultInputPriority is used for --priority-input values without an explicit level
+const defaultInputPriority = 1
+
+// PriorityInput is an additional input file whose items are dispatched before
+// those of lower priority, e.g. an urgent manifest on top of a collection mirror
+type PriorityInput struct {
+	Path     string
+	Priority int
+}
+
+// parsePriorityInput parses a --priority-input value of the form [N:]path. Drive
+// letters are not mistaken for a level since the prefix must be numeric.
+func parsePriorityInput(value string) (PriorityInput, error) {
+	input := PriorityInput{Path: value, Priority: defaultInputPriority}
+	if prefix, path, ok := strings.Cut(value, ":"); ok {
+		if level, err := strconv.Atoi(prefix); err == nil {
+			if level < 1 {
+				return input, fmt.Errorf("priority must be at least 1 in %q", value)
+			}
+			input.Path, input.Priority = path, level
+		}
+	}
+	if input.Path == "" {
+		return input, fmt.Errorf("missing input path in %q", value)
+	}
+	return input, nil
+}
+
+// decodePriorityInputs decodes the priority inputs and tags their items
+func decodePriorityInputs(inputs []PriorityInput, client *http.Client, token *Token, options *Options, s5cmdMap map[string]string) ([]*FileInfo, int, error) {
+	var files []*FileInfo
+	newJobs := 0
+	for _, input := range inputs {
+		decoded, jobs, err := decodeInputFile(input.Path, client, token, options, s5cmdMap)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", input.Path, err)
+		}
+		for _, info := range decoded {
+			info.Priority = input.Priority
+		}
+		logger.Infof("Priority %d input %s: %d items", input.Priority, input.Path, len(decoded))
+		files = append(files, decoded...)
+		newJobs += jobs
+	}
+	return files, newJobs, nil
+}
+
+// orderByPriority removes series listed by several inputs, keeping the highest
+// priority, and orders the queue so higher priorities are dispatched first. Items
+// of equal priority keep their input order, so bulk inputs still fill the
+// remaining worker capacity in manifest order.
+func orderByPriority(files []*FileInfo) []*FileInfo {
+	index := make(map[string]int, len(files))
+	ordered := make([]*FileInfo, 0, len(files))
+	for _, info := range files {
+		if info.SeriesUID == "" {
+			ordered = append(ordered, info)
+			continue
+		}
+		if i, ok := index[info.SeriesUID]; ok {
+			if info.Priority > ordered[i].Priority {
+				ordered[i].Priority = info.Priority
+			}
+			continue
+		}
+		index[info.SeriesUID] = len(ordered)
+		ordered = append(ordered, info)
+	}
+	if dropped := len(files) - len(ordered); dropped > 0 {
+		logger.Infof("Removed %d series listed by more than one input", dropped)
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority > ordered[j].Priority
+	})
+	return ordered
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Kinds of progress events
+const (
+	ProgressStarted  = "started"  // a worker took the item
+	ProgressBytes    = "bytes"    // bytes of the item transferred so far in this attempt
+	ProgressRetrying = "retrying" // an attempt failed and the item is tried again
+	ProgressFinished = "finished" // downloaded or synced
+	ProgressSkipped  = "skipped"  // already on disk, or not downloadable in this mode
+	ProgressDeferred = "deferred" // left for a later run by the daily quota
+	ProgressFailed   = "failed"   // given up on
+)
+
+// progressBytesInterval is the least time between two bytes events of an item
+const progressBytesInterval = time.Second
+
+// progress delivers the progress events of the run to its subscribers; nil drops them
+var progress *ProgressBus
+
+// ProgressEvent is a step in the processing of an item, in the order a worker goes
+// through them: started, any number of bytes and retrying, then one of finished,
+// skipped, deferred or failed
+type ProgressEvent struct {
+	Kind       string    `json:"event"`
+	Time       time.Time `json:"time"`
+	SeriesUID  string    `json:"series_uid"`
+	Worker     int       `json:"worker,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	TotalBytes int64     `json:"total_bytes,omitempty"` // expected from the metadata, 0 if unknown
+	Attempt    int       `json:"attempt,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Code       string    `json:"code,omitempty"`
+}
+
+// ProgressFunc receives progress events. It is called from the download workers and
+// must not block for long.
+type ProgressFunc func(ProgressEvent)
+
+// ProgressBus passes progress events to every subscriber in turn
+type ProgressBus struct {
+	mu          sync.RWMutex
+	subscribers []ProgressFunc
+}
+
+// NewProgressBus returns a bus without subscribers
+func NewProgressBus() *ProgressBus {
+	return &ProgressBus{}
+}
+
+// Subscribe adds fn to the receivers of all later events
+func (b *ProgressBus) Subscribe(fn ProgressFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// Emit stamps an event and delivers it
+func (b *ProgressBus) Emit(event ProgressEvent) {
+	if b == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+// ndjsonProgress returns a subscriber writing every event as a line of JSON
+func ndjsonProgress(w io.Writer) ProgressFunc {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(event ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := encoder.Encode(event); err != nil {
+			logger.Debugf("Failed to write progress event: %v", err)
+		}
+	}
+}
+
+// itemEvent returns an event about an item, with its expected size
+func itemEvent(kind string, info *FileInfo, workerID int) ProgressEvent {
+	return ProgressEvent{Kind: kind, SeriesUID: info.SeriesUID, Worker: workerID, TotalBytes: info.expectedBytes()}
+}
+
+// failedEvent returns the failed event of an item
+func failedEvent(info *FileInfo, workerID int, err error) ProgressEvent {
+	event := itemEvent(ProgressFailed, info, workerID)
+	event.Error, event.Code = err.Error(), failureCode(err)
+	return event
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// provenanceFile records at the root of the output how its data was retrieved
+const provenanceFile = "PROVENANCE.json"
+
+// provenanceSecretFlags are the options whose values are not recorded
+var provenanceSecretFlags = map[string]bool{
+	"passwd":        true,
+	"client-secret": true,
+	"drive-api-key": true,
+}
+
+// Provenance describes the data of an output directory and the runs that
+// retrieved it, for the reproducibility statements of publications
+type Provenance struct {
+	Tool        ProvenanceTool         `json:"tool"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+	Collections []ProvenanceCollection `json:"collections"`
+	Runs        []ProvenanceRun        `json:"runs"`
+}
+
+// ProvenanceTool is the build of the retriever that last updated the output
+type ProvenanceTool struct {
+	Name      string `json:"name"`
+	Version   string `json:"version,omitempty"`
+	GitCommit string `json:"git_commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// ProvenanceCollection is a collection with series in the output, with the
+// license and DOI its data is to be cited and used under
+type ProvenanceCollection struct {
+	Name     string   `json:"name"`
+	Series   int      `json:"series"`
+	DOIs     []string `json:"dois,omitempty"`
+	Licenses []string `json:"licenses,omitempty"`
+}
+
+// ProvenanceRun is a run that downloaded into the output
+type ProvenanceRun struct {
+	Version     string            `json:"version,omitempty"`
+	CommandLine []string          `json:"command_line"`
+	Inputs      []ProvenanceInput `json:"inputs,omitempty"`
+	Endpoints   Endpoints         `json:"endpoints"`
+	Mirror      *MirrorChoice     `json:"mirror,omitempty"`
+	StartedAt   time.Time         `json:"started_at"`
+	FinishedAt  time.Time         `json:"finished_at"`
+	Stats       StatsSnapshot     `json:"stats"`
+}
+
+// ProvenanceInput is a manifest of a run and its SHA-256
+type ProvenanceInput struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// writeProvenance adds the run to PROVENANCE.json and describes the collections now
+// in the output from their cached metadata
+func writeProvenance(options *Options, stats *DownloadStats) error {
+	return outputLock.WithState(func() error {
+		path := filepath.Join(options.Output, provenanceFile)
+		var provenance Provenance
+		if content, err := os.ReadFile(path); err == nil {
+			if err := json.Unmarshal(content, &provenance); err != nil {
+				logger.Warnf("Replacing unreadable %s: %v", path, err)
+				provenance = Provenance{}
+			}
+		}
+
+		snapshot := stats.Snapshot()
+		snapshot.Workers = nil
+		snapshot.Done = true
+		provenance.Tool = ProvenanceTool{
+			Name:      "nbia-data-retriever-cli",
+			Version:   version,
+			GitCommit: gitHash,
+			BuildTime: buildStamp,
+			GoVersion: runtime.Version(),
+		}
+		provenance.UpdatedAt = time.Now().UTC()
+		provenance.Collections = provenanceCollections(loadCachedSeriesMetadata(options.Output))
+		provenance.Runs = append(provenance.Runs, ProvenanceRun{
+			Version:     version,
+			CommandLine: redactCommandLine(os.Args),
+			Inputs:      provenanceInputs(options),
+			Endpoints:   endpoints,
+			Mirror:      mirrorChoice,
+			StartedAt:   stats.StartTime.UTC(),
+			FinishedAt:  time.Now().UTC(),
+			Stats:       snapshot,
+		})
+
+		content, err := json.MarshalIndent(provenance, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, content, 0644)
+	})
+}
+
+// provenanceCollections groups series by collection with their DOIs and licenses
+func provenanceCollections(series []*FileInfo) []ProvenanceCollection {
+	byName := make(map[string]*ProvenanceCollection)
+	for _, info := range series {
+		if info.Collection == "" {
+			continue
+		}
+		collection, ok := byName[info.Collection]
+		if !ok {
+			collection = &ProvenanceCollection{Name: info.Collection}
+			byName[info.Collection] = collection
+		}
+		collection.Series++
+		collection.DOIs = appendUnique(collection.DOIs, info.DataDescriptionURI)
+		license := strings.TrimSpace(info.LicenseName)
+		if info.LicenseURL != "" {
+			license = strings.TrimSpace(license + " " + info.LicenseURL)
+		}
+		collection.Licenses = appendUnique(collection.Licenses, license)
+	}
+	collections := make([]ProvenanceCollection, 0, len(byName))
+	for _, name := range sortedKeys(byName) {
+		collections = append(collections, *byName[name])
+	}
+	return collections
+}
+
+// appendUnique appends a non-empty value that values lacks, keeping them sorted
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	i := sort.SearchStrings(values, value)
+	if i < len(values) && values[i] == value {
+		return values
+	}
+	return append(values[:i], append([]string{value}, values[i:]...)...)
+}
+
+// provenanceInputs returns the input files of a run with their SHA-256
+func provenanceInputs(options *Options) []ProvenanceInput {
+	var paths []string
+	for _, input := range options.PriorityInputs {
+		paths = append(paths, input.Path)
+	}
+	if options.Input != "" {
+		paths = append(paths, options.Input)
+	}
+	var inputs []ProvenanceInput
+	for _, path := range paths {
+		input := ProvenanceInput{Path: path}
+		// Standard input and pipes cannot be read again
+		if stat, err := os.Stat(path); err == nil && stat.Mode().IsRegular() {
+			if sum, err := sha256File(path); err == nil {
+				input.SHA256 = sum
+			}
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs
+}
+
+// redactCommandLine replaces the values of secret options, given as --flag value
+// or --flag=value
+func redactCommandLine(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+	for i := 1; i < len(redacted); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(redacted[i], "-"), "=")
+		if !strings.HasPrefix(redacted[i], "-") || !provenanceSecretFlags[name] {
+			continue
+		}
+		if hasValue {
+			redacted[i] = strings.SplitN(redacted[i], "=", 2)[0] + "=REDACTED"
+		} else if i+1 < len(redacted) {
+			i++
+			redacted[i] = "REDACTED"
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// queryExpansionPrefix names the cached expansions of query files below metadata/
+const queryExpansionPrefix = "query-expansion-"
+
+// SeriesQuery is an NBIA cohort definition read from a JSON query file. Every field
+// takes a string or a list of strings; lists are OR-ed and fields are AND-ed.
+type SeriesQuery struct {
+	Collections   []string `json:"collection,omitempty"`
+	Modalities    []string `json:"modality,omitempty"`
+	BodyParts     []string `json:"body_part,omitempty"`
+	PatientIDs    []string `json:"patient_id,omitempty"`
+	StudyUIDs     []string `json:"study_uid,omitempty"`
+	Manufacturers []string `json:"manufacturer,omitempty"`
+	DateFrom      string   `json:"date_from,omitempty"` // inclusive, YYYY-MM-DD
+	DateTo        string   `json:"date_to,omitempty"`   // inclusive, YYYY-MM-DD
+}
+
+// queryFieldAliases maps normalized key names (lower case, without '_' and '-') of
+// hand-written files and nbia-search exports to the SeriesQuery fields
+var queryFieldAliases = map[string]string{
+	"collection": "collection", "collections": "collection",
+	"modality": "modality", "modalities": "modality",
+	"bodypart": "body_part", "bodyparts": "body_part", "bodypartexamined": "body_part",
+	"patientid": "patient_id", "patientids": "patient_id", "subjectid": "patient_id",
+	"studyuid": "study_uid", "studyinstanceuid": "study_uid",
+	"manufacturer": "manufacturer", "manufacturers": "manufacturer",
+	"datefrom": "date_from", "fromdate": "date_from", "startdate": "date_from",
+	"dateto": "date_to", "todate": "date_to", "enddate": "date_to",
+}
+
+// QueryExpansion is the cached result of expanding a query file into series
+type QueryExpansion struct {
+	Query      SeriesQuery `json:"query"`
+	ExpandedAt time.Time   `json:"expanded_at"`
+	SeriesUIDs []string    `json:"series_uids"`
+}
+
+// querySeries is a series as returned by getSeries, with the dates used for filtering
+type querySeries struct {
+	SeriesUID  string `json:"SeriesInstanceUID"`
+	SeriesDate string `json:"SeriesDate"`
+	StudyDate  string `json:"StudyDate"`
+}
+
+// parseSeriesQuery reads a query file, accepting the aliases of queryFieldAliases
+func parseSeriesQuery(data []byte) (*SeriesQuery, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string][]string)
+	for key, value := range raw {
+		normalized := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+		field, ok := queryFieldAliases[normalized]
+		if !ok {
+			return nil, fmt.Errorf("unknown query field %q", key)
+		}
+		var values []string
+		if err := json.Unmarshal(value, &values); err != nil {
+			var single string
+			if err := json.Unmarshal(value, &single); err != nil {
+				return nil, fmt.Errorf("query field %q must be a string or a list of strings", key)
+			}
+			values = []string{single}
+		}
+		for _, v := range values {
+			if v = strings.TrimSpace(v); v != "" {
+				fields[field] = append(fields[field], v)
+			}
+		}
+	}
+
+	query := &SeriesQuery{
+		Collections:   fields["collection"],
+		Modalities:    fields["modality"],
+		BodyParts:     fields["body_part"],
+		PatientIDs:    fields["patient_id"],
+		StudyUIDs:     fields["study_uid"],
+		Manufacturers: fields["manufacturer"],
+	}
+	for name, target := range map[string]*string{"date_from": &query.DateFrom, "date_to": &query.DateTo} {
+		values := fields[name]
+		if len(values) > 1 {
+			return nil, fmt.Errorf("query field %s takes a single date", name)
+		}
+		if len(values) == 1 {
+			date, ok := parseQueryDate(values[0])
+			if !ok {
+				return nil, fmt.Errorf("query field %s: %q is not a date (YYYY-MM-DD)", name, values[0])
+			}
+			*target = date.Format("2006-01-02")
+		}
+	}
+	if len(query.Collections) == 0 && len(query.PatientIDs) == 0 && len(query.StudyUIDs) == 0 {
+		return nil, fmt.Errorf("a query needs a collection, patient_id or study_uid")
+	}
+	return query, nil
+}
+
+// parseQueryDate reads the date formats of query files and NBIA responses
+func parseQueryDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01-02", "20060102", "01/02/2006"} {
+		if len(value) >= len(layout) {
+			if date, err := time.Parse(layout, value[:len(layout)]); err == nil {
+				return date, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// requests returns the getSeries queries of all combinations of the listed values
+func (q *SeriesQuery) requests() []map[string]interface{} {
+	requests := []map[string]interface{}{{}}
+	for _, param := range []struct {
+		name   string
+		values []string
+	}{
+		{"Collection", q.Collections},
+		{"PatientID", q.PatientIDs},
+		{"StudyInstanceUID", q.StudyUIDs},
+		{"Modality", q.Modalities},
+		{"BodyPartExamined", q.BodyParts},
+		{"Manufacturer", q.Manufacturers},
+	} {
+		if len(param.values) == 0 {
+			continue
+		}
+		var expanded []map[string]interface{}
+		for _, request := range requests {
+			for _, value := range param.values {
+				next := make(map[string]interface{}, len(request)+1)
+				for k, v := range request {
+					next[k] = v
+				}
+				next[param.name] = value
+				expanded = append(expanded, next)
+			}
+		}
+		requests = expanded
+	}
+	return requests
+}
+
+// matchesDates reports whether a series falls into the date range of the query. The
+// study date is used when known; series without any date never match a range.
+func (q *SeriesQuery) matchesDates(series querySeries) bool {
+	if q.DateFrom == "" && q.DateTo == "" {
+		return true
+	}
+	raw := series.StudyDate
+	if raw == "" {
+		raw = series.SeriesDate
+	}
+	date, ok := parseQueryDate(raw)
+	if !ok {
+		return false
+	}
+	day := date.Format("2006-01-02")
+	return (q.DateFrom == "" || day >= q.DateFrom) && (q.DateTo == "" || day <= q.DateTo)
+}
+
+// expandQuery lists the series matching a query with getSeries, sorted by UID
+func expandQuery(query *SeriesQuery, httpClient *http.Client, authToken *Token) ([]string, error) {
+	seen := make(map[string]bool)
+	var seriesUIDs []string
+	undated := 0
+	for _, request := range query.requests() {
+		var series []querySeries
+		if err := queryNBIA(httpClient, authToken, endpoints.Series, request, &series); err != nil {
+			return nil, fmt.Errorf("failed to list series: %w", err)
+		}
+		for _, s := range series {
+			if s.SeriesUID == "" || seen[s.SeriesUID] {
+				continue
+			}
+			seen[s.SeriesUID] = true
+			if !query.matchesDates(s) {
+				if s.StudyDate == "" && s.SeriesDate == "" {
+					undated++
+				}
+				continue
+			}
+			seriesUIDs = append(seriesUIDs, s.SeriesUID)
+		}
+	}
+	if undated > 0 {
+		logger.Warnf("%d series without a study or series date were left out by the date range", undated)
+	}
+	sort.Strings(seriesUIDs)
+	return seriesUIDs, nil
+}
+
+// queryExpansionPath returns the cache file of a query file's expansion, keyed by
+// the query content so that an edited query is expanded again
+func queryExpansionPath(output, queryPath string, data []byte) string {
+	sum := sha256.Sum256(data)
+	name := strings.TrimSuffix(filepath.Base(queryPath), filepath.Ext(queryPath))
+	return filepath.Join(output, "metadata", fmt.Sprintf("%s%s-%s.json", queryExpansionPrefix, name, hex.EncodeToString(sum[:6])))
+}
+
+// decodeQueryFile expands a JSON query file into series and fetches their metadata.
+// The expansion is cached in metadata/ and reused by later runs, so the cohort stays
+// fixed even when the archive adds series; --refresh-metadata expands it again.
+func decodeQueryFile(path string, httpClient *http.Client, authToken *Token, options *Options) ([]*FileInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	query, err := parseSeriesQuery(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query file %s: %v", path, err)
+	}
+
+	cachePath := queryExpansionPath(options.Output, path, data)
+	var expansion QueryExpansion
+	cached := false
+	if content, err := os.ReadFile(cachePath); err == nil && !options.RefreshMetadata {
+		if err := json.Unmarshal(content, &expansion); err != nil {
+			logger.Warnf("Ignoring unreadable query expansion %s: %v", cachePath, err)
+		} else {
+			cached = true
+		}
+	}
+
+	if cached {
+		fmt.Printf("Using the expansion of %s from %s (%d series)\n",
+			path, expansion.ExpandedAt.Local().Format("2006-01-02 15:04"), len(expansion.SeriesUIDs))
+	} else {
+		if options.WhatIf {
+			return nil, fmt.Errorf("%s has not been expanded yet; --what-if only uses cached expansions", path)
+		}
+		seriesUIDs, err := expandQuery(query, httpClient, authToken)
+		if err != nil {
+			return nil, err
+		}
+		expansion = QueryExpansion{Query: *query, ExpandedAt: time.Now().UTC(), SeriesUIDs: seriesUIDs}
+		content, err := json.MarshalIndent(expansion, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(cachePath, content, 0644); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Query %s matched %d series, expansion saved to %s\n", path, len(seriesUIDs), cachePath)
+	}
+
+	if len(expansion.SeriesUIDs) == 0 {
+		return []*FileInfo{}, nil
+	}
+	return FetchMetadataForSeriesUIDs(expansion.SeriesUIDs, httpClient, authToken, options)
+}
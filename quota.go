@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// quotaStateFile is the per-output-directory record of bytes transferred today
+const quotaStateFile = ".daily-quota.json"
+
+// DailyQuota limits the bytes transferred per local calendar day across runs
+type DailyQuota struct {
+	Limit int64
+	Wait  bool // sleep until midnight instead of deferring the remaining items
+
+	path       string
+	mu         sync.Mutex
+	day        string
+	usedBefore int64 // bytes used today before baseline was taken
+	baseline   int64 // activeStats.BytesDownloaded when counting started for this day
+	savedOwn   int64 // bytes of this process included in the last saved state
+	deferred   []*FileInfo
+
+	announcedWait time.Time // midnight last logged as the end of the wait, to warn once per wait
+}
+
+// quotaState is the persisted form of the daily usage
+type quotaState struct {
+	Day   string `json:"day"`
+	Bytes int64  `json:"bytes"`
+}
+
+// parseByteSize parses sizes like 500GB, 2TB or 1.5TiB. Decimal units (KB, MB, ...)
+// are powers of 1000 and binary units (KiB, MiB, ...) powers of 1024.
+func parseByteSize(value string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(value))
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1},
+	}
+	factor := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			factor = unit.factor
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(n * factor), nil
+}
+
+// today returns the local calendar day key
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+// NewDailyQuota loads today's usage for the output directory
+func NewDailyQuota(output string, limit int64, wait bool) *DailyQuota {
+	q := &DailyQuota{
+		Limit: limit,
+		Wait:  wait,
+		path:  filepath.Join(output, quotaStateFile),
+		day:   today(),
+	}
+	if state, ok := readQuotaState(q.path); ok && state.Day == q.day {
+		q.usedBefore = state.Bytes
+	}
+	return q
+}
+
+// readQuotaState reads the persisted daily usage
+func readQuotaState(path string) (quotaState, bool) {
+	var state quotaState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, false
+	}
+	return state, json.Unmarshal(data, &state) == nil
+}
+
+// currentBytes returns the bytes transferred by this process so far
+func currentBytes() int64 {
+	if activeStats == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&activeStats.BytesDownloaded)
+}
+
+// usedLocked returns today's usage, rolling over at midnight (caller must hold lock)
+func (q *DailyQuota) usedLocked() int64 {
+	if day := today(); day != q.day {
+		q.day = day
+		q.usedBefore = 0
+		q.baseline = currentBytes()
+		q.savedOwn = 0
+	}
+	return q.usedBefore + currentBytes() - q.baseline
+}
+
+// Save persists today's usage so later runs on the same day share the allowance.
+// With --shared the transfers other invocations saved in the meantime are kept and
+// count against this run's allowance from now on.
+func (q *DailyQuota) Save() error {
+	if q == nil {
+		return nil
+	}
+	return outputLock.WithState(func() error {
+		q.mu.Lock()
+		used := q.usedLocked()
+		own := used - q.usedBefore
+		if outputLock.Shared() {
+			if saved, ok := readQuotaState(q.path); ok && saved.Day == q.day {
+				used = saved.Bytes + own - q.savedOwn
+				q.usedBefore = used - own
+			}
+			q.savedOwn = own
+		}
+		state := quotaState{Day: q.day, Bytes: used}
+		q.mu.Unlock()
+
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(q.path, data, 0644)
+	})
+}
+
+// Allow reports whether another transfer may start. With Wait set it blocks
+// until midnight when the quota is exhausted; otherwise the item is recorded as
+// deferred and false is returned. Transfers already in flight are not interrupted,
+// so usage can overshoot the limit by up to one series per worker.
+func (q *DailyQuota) Allow(info *FileInfo) bool {
+	if q == nil {
+		return true
+	}
+	for {
+		q.mu.Lock()
+		used := q.usedLocked()
+		if used < q.Limit {
+			q.mu.Unlock()
+			return true
+		}
+		if !q.Wait {
+			q.deferred = append(q.deferred, info)
+			q.mu.Unlock()
+			return false
+		}
+		q.mu.Unlock()
+
+		if err := q.Save(); err != nil {
+			logger.Warnf("Failed to save daily quota state: %v", err)
+		}
+		y, m, d := time.Now().Date()
+		midnight := time.Date(y, m, d+1, 0, 0, 0, 0, time.Local)
+		q.mu.Lock()
+		if !q.announcedWait.Equal(midnight) {
+			q.announcedWait = midnight
+			logger.Warnf("Daily quota of %s reached; waiting until %s", formatBytes(q.Limit), midnight.Format("2006-01-02 15:04"))
+		}
+		q.mu.Unlock()
+		sleep := time.Until(midnight)
+		if sleep > time.Minute {
+			sleep = time.Minute
+		}
+		time.Sleep(sleep)
+	}
+}
+
+// Deferred returns the items skipped because the quota was exhausted
+func (q *DailyQuota) Deferred() []*FileInfo {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.deferred
+}
+
+// writeResumeManifest saves deferred TCIA series as a manifest for the next day's run
+func writeResumeManifest(output string, deferred []*FileInfo) (string, error) {
+	var uids []string
+	for _, info := range deferred {
+		if info.DownloadURL == "" && info.DRSURI == "" && info.S5cmdManifestPath == "" {
+			uids = append(uids, info.SeriesUID)
+		}
+	}
+	if len(uids) == 0 {
+		return "", nil
+	}
+	path := filepath.Join(output, "metadata", fmt.Sprintf("quota-resume-%s.tcia", today()))
+	return path, writeTCIAManifest(path, uids)
+}
+
+// formatBytes renders a byte count using binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// rawResponsesDirName is the directory under metadata/ archiving raw API responses
+const rawResponsesDirName = "raw-responses"
+
+// rawResponses archives the metadata API responses of the run; nil unless
+// --save-raw-responses is given
+var rawResponses *RawResponseArchive
+
+// RawResponseArchive keeps every metadata API response as received, gzip-compressed
+// and keyed by request, so the cached metadata can be audited against what the
+// portal answered. Unlike the API response cache nothing is overwritten: each
+// response gets a file of its own, listed in index.jsonl.
+type RawResponseArchive struct {
+	dir string
+	mu  sync.Mutex // serializes appends to the index
+}
+
+// rawResponseRecord is a line of index.jsonl
+type rawResponseRecord struct {
+	URL        string    `json:"url"`
+	Key        string    `json:"key"`
+	File       string    `json:"file"`
+	Status     int       `json:"status"`
+	ReceivedAt time.Time `json:"received_at"`
+	Size       int       `json:"size"`
+	ETag       string    `json:"etag,omitempty"`
+}
+
+// NewRawResponseArchive creates an archive under the output metadata directory
+func NewRawResponseArchive(output string) *RawResponseArchive {
+	return &RawResponseArchive{dir: filepath.Join(output, "metadata", rawResponsesDirName)}
+}
+
+// Save archives the body of a response to a GET of requestURL. Failures are logged;
+// they never fail the request.
+func (a *RawResponseArchive) Save(requestURL string, resp *http.Response, body []byte) {
+	if a == nil {
+		return
+	}
+	if err := a.save(requestURL, resp, body); err != nil {
+		logger.Warnf("Failed to archive the response of %s: %v", requestURL, err)
+	}
+}
+
+// save writes <key>/<time>.json.gz and indexes it
+func (a *RawResponseArchive) save(requestURL string, resp *http.Response, body []byte) error {
+	now := time.Now().UTC()
+	key := requestKey("GET", requestURL)
+	file := filepath.Join(key, now.Format("20060102T150405.000000000Z")+".json.gz")
+	path := filepath.Join(a.dir, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	// The gzip header names the request, so a file is self-describing
+	zw.Name = requestURL
+	zw.Comment = resp.Status
+	zw.ModTime = now
+	if _, err := zw.Write(body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(rawResponseRecord{
+		URL:        requestURL,
+		Key:        key,
+		File:       filepath.ToSlash(file),
+		Status:     resp.StatusCode,
+		ReceivedAt: now,
+		Size:       len(body),
+		ETag:       resp.Header.Get("ETag"),
+	})
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	index, err := os.OpenFile(filepath.Join(a.dir, "index.jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(index, "%s\n", line); err != nil {
+		index.Close()
+		return err
+	}
+	return index.Close()
+}
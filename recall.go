@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Poll intervals while waiting for a file to come back from a tape or SMR tier
+const (
+	recallPollInterval    = 10 * time.Second
+	recallMaxPollInterval = 2 * time.Minute
+)
+
+// recallWait is how long local reads wait for data being recalled from slow storage
+// tiers before failing; zero fails on the first error
+var recallWait time.Duration
+
+// isRecallError reports whether a local file system error is what hierarchical
+// storage typically returns while an offline file is being staged
+func isRecallError(err error) bool {
+	return errors.Is(err, syscall.EIO) ||
+		errors.Is(err, syscall.ENODEV) ||
+		errors.Is(err, syscall.ENXIO) ||
+		errors.Is(err, syscall.ETIMEDOUT)
+}
+
+// withRecallWait runs a local file system operation on path and, with
+// --wait-for-recall, repeats it while it fails with recall errors
+func withRecallWait(path string, op func() error) error {
+	err := op()
+	if recallWait <= 0 || !isRecallError(err) {
+		return err
+	}
+
+	deadline := time.Now().Add(recallWait)
+	for attempt := 1; isRecallError(err); attempt++ {
+		delay := backoffDelay(attempt, recallPollInterval, recallMaxPollInterval)
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("%s not recalled within %s: %w", path, recallWait, err)
+		}
+		logger.Infof("Waiting %s for %s to be recalled from storage: %v", delay.Round(time.Second), path, err)
+		time.Sleep(delay)
+		err = op()
+	}
+	return err
+}
+
+// recallPaths lists the local files of an item that a run reads while verifying it.
+// Only directory entries are inspected, so offline files are not recalled by this.
+func recallPaths(info *FileInfo, output string, noDecompress bool) []string {
+	if info.S5cmdManifestPath != "" {
+		return nil
+	}
+	if info.DownloadURL == "" && info.DRSURI == "" && info.SubjectID == "" && info.StudyUID == "" {
+		// Metadata not cached, the local copy cannot be located
+		return nil
+	}
+
+	if info.DownloadURL != "" || info.DRSURI != "" {
+		path := info.directPath(output)
+		if _, err := os.Lstat(path); err != nil {
+			return nil
+		}
+		return []string{path}
+	}
+	if noDecompress {
+		path := info.seriesPath(output) + seriesArchiveExt
+		if _, err := os.Lstat(path); err != nil {
+			return nil
+		}
+		return []string{path}
+	}
+
+	var paths []string
+	_ = filepath.WalkDir(info.seriesPath(output), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths
+}
+
+// writeRecallList writes the absolute paths a run over files would read, one per
+// line, so storage administrators can stage them before the run starts
+func writeRecallList(files []*FileInfo, options *Options) error {
+	output, err := filepath.Abs(options.Output)
+	if err != nil {
+		return err
+	}
+
+	tempPath := options.RecallList + ".tmp"
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var count, series int
+	var bytes int64
+	for _, info := range files {
+		paths := recallPaths(info, output, options.NoDecompress)
+		if len(paths) > 0 {
+			series++
+		}
+		for _, path := range paths {
+			if stat, err := os.Lstat(path); err == nil {
+				bytes += stat.Size()
+			}
+			fmt.Fprintln(w, path)
+			count++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := renameFile(tempPath, options.RecallList); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Recall list written to %s: %d files (%s) in %d of %d series\n",
+		options.RecallList, count, formatBytes(bytes), series, len(files))
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst a copy-on-write clone of src on APFS. clonefile creates its
+// target, so the empty dst is replaced.
+func cloneFile(src, dst *os.File) error {
+	if err := os.Remove(dst.Name()); err != nil {
+		return err
+	}
+	return unix.Clonefile(src.Name(), dst.Name(), unix.CLONE_NOFOLLOW)
+}
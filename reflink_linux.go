@@ -0,0 +1,15 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst share the blocks of src with the FICLONE ioctl, on Btrfs,
+// XFS with reflink=1 and bcachefs; it fails elsewhere and across file systems
+func cloneFile(src, dst *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is not supported on this platform
+func cloneFile(src, dst *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// dicomUIDPattern matches DICOM UIDs, which name the study and series directories
+var dicomUIDPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)+$`)
+
+// discoverSeries lists the series stored in the output tree with their Subject/Study
+// directory: extracted series, ZIPs kept by --no-decompress, repacked archives and
+// series packed into the content store
+func discoverSeries(output string) (map[string]string, error) {
+	found := make(map[string]string)
+	subjects, err := os.ReadDir(output)
+	if err != nil {
+		return nil, err
+	}
+	for _, subject := range subjects {
+		if !subject.IsDir() || subject.Name() == "metadata" || strings.HasPrefix(subject.Name(), ".") {
+			continue
+		}
+		studies, err := os.ReadDir(filepath.Join(output, subject.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, study := range studies {
+			if !study.IsDir() || !dicomUIDPattern.MatchString(study.Name()) {
+				continue
+			}
+			location := filepath.Join(subject.Name(), study.Name())
+			entries, err := os.ReadDir(filepath.Join(output, location))
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				name := strings.TrimSuffix(entry.Name(), ".sha256")
+				for _, ext := range []string{".zip", ".tar.gz", ".tar.zst"} {
+					name = strings.TrimSuffix(name, ext)
+				}
+				if dicomUIDPattern.MatchString(name) {
+					found[name] = location
+				}
+			}
+		}
+	}
+	for _, seriesUID := range contentStore.SeriesUIDs() {
+		if _, ok := found[seriesUID]; !ok {
+			stored, _ := contentStore.Lookup(seriesUID)
+			found[seriesUID] = filepath.Dir(filepath.FromSlash(strings.TrimSuffix(stored.Prefix, "/")))
+		}
+	}
+	return found, nil
+}
+
+// rewriteMetadataCSV updates the rows of refreshed series in a *-metadata.csv file,
+// keeping the s5cmd URIs the rows were recorded with. It returns the rows updated.
+func rewriteMetadataCSV(path string, refreshed map[string]*FileInfo) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	records, err := csv.NewReader(f).ReadAll()
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	uidIndex, ok := columns["SeriesInstanceUID"]
+	if !ok {
+		return 0, fmt.Errorf("no 'SeriesInstanceUID' column")
+	}
+
+	updated := 0
+	for _, record := range records[1:] {
+		if len(record) <= uidIndex {
+			continue
+		}
+		info, ok := refreshed[record[uidIndex]]
+		if !ok {
+			continue
+		}
+		values := metadataCSVRecord(info)
+		for i, name := range metadataCSVHeader {
+			index, ok := columns[name]
+			if !ok || index >= len(record) || (name == "OriginalS5cmdURI" && values[i] == "") {
+				continue
+			}
+			record[index] = values[i]
+		}
+		updated++
+	}
+	if updated == 0 {
+		return 0, nil
+	}
+
+	tempPath := path + ".tmp"
+	out, err := os.Create(tempPath)
+	if err != nil {
+		return 0, err
+	}
+	w := csv.NewWriter(out)
+	if err := w.WriteAll(records); err != nil {
+		out.Close()
+		os.Remove(tempPath)
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tempPath)
+		return 0, err
+	}
+	return updated, renameFile(tempPath, path)
+}
+
+// runRefreshMeta re-fetches the NBIA metadata of every series in the output tree and
+// rewrites the per-series metadata files and the *-metadata.csv files. Image data
+// is left untouched.
+func runRefreshMeta(httpClient *http.Client, authToken *Token, options *Options) error {
+	locations, err := discoverSeries(options.Output)
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %v", options.Output, err)
+	}
+	if len(locations) == 0 {
+		return fmt.Errorf("no downloaded series found in %s", options.Output)
+	}
+	seriesUIDs := sortedKeys(locations)
+
+	previous := make(map[string]*FileInfo)
+	for _, seriesUID := range seriesUIDs {
+		if info, err := loadMetadataFromCache(getMetadataCachePath(options.Output, seriesUID)); err == nil {
+			// Viewer links are derived, and missing from sidecars of earlier versions,
+			// which also kept dates as NBIA sent them
+			info.setViewerURLs()
+			info.normalizeDates()
+			previous[seriesUID] = info
+		}
+	}
+
+	// Cached responses the server confirms are reused unless --refresh-metadata forces a full fetch
+	options.RevalidateMeta = !options.RefreshMetadata
+	files, err := FetchMetadataForSeriesUIDs(seriesUIDs, httpClient, authToken, options)
+	if err != nil {
+		return err
+	}
+
+	refreshed := make(map[string]*FileInfo, len(files))
+	changed := 0
+	var moved []string
+	for _, info := range files {
+		old := previous[info.SeriesUID]
+		if old != nil && info.OriginalS5cmdURI == "" && old.OriginalS5cmdURI != "" {
+			// The s5cmd source is local knowledge, not NBIA metadata
+			info.OriginalS5cmdURI = old.OriginalS5cmdURI
+			if err := saveMetadataToCache(info, getMetadataCachePath(options.Output, info.SeriesUID)); err != nil {
+				logger.Warnf("Failed to save metadata of %s: %v", info.SeriesUID, err)
+			}
+		}
+		refreshed[info.SeriesUID] = info
+		if old == nil || !reflect.DeepEqual(old, info) {
+			changed++
+			logger.Debugf("Metadata of %s changed", info.SeriesUID)
+		}
+		if location, ok := locations[info.SeriesUID]; ok && info.SubjectID != "" && location != filepath.Join(info.SubjectID, info.StudyUID) {
+			moved = append(moved, fmt.Sprintf("%s: stored under %s, metadata now says %s",
+				info.SeriesUID, location, filepath.Join(info.SubjectID, info.StudyUID)))
+		}
+	}
+
+	csvPaths, _ := filepath.Glob(filepath.Join(options.Output, "metadata", "*-metadata.csv"))
+	sort.Strings(csvPaths)
+	for _, path := range csvPaths {
+		var updated int
+		err := outputLock.WithState(func() (err error) {
+			updated, err = rewriteMetadataCSV(path, refreshed)
+			return err
+		})
+		if err != nil {
+			logger.Warnf("Failed to update %s: %v", path, err)
+		} else if updated > 0 {
+			fmt.Printf("Updated %d rows in %s\n", updated, path)
+		}
+	}
+
+	fmt.Println("\n=== Metadata Refresh Summary ===")
+	fmt.Printf("Series found: %d\n", len(seriesUIDs))
+	fmt.Printf("Refreshed: %d\n", len(refreshed))
+	fmt.Printf("Changed: %d\n", changed)
+	if missing := len(seriesUIDs) - len(refreshed); missing > 0 {
+		fmt.Printf("Not refreshed: %d\n", missing)
+	}
+	if len(moved) > 0 {
+		sort.Strings(moved)
+		fmt.Printf("\nSeries whose subject or study changed (files were not moved): %d\n", len(moved))
+		for _, line := range moved {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	reportUnavailableSeries(options.Output)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// namePatternField finds the fields of a --rename pattern: {Field} or {Field:05d}
+var namePatternField = regexp.MustCompile(`\{([A-Za-z]+)(?::([^}]*))?\}`)
+
+// namePatternFormat is the format of number fields, as in printf
+var namePatternFormat = regexp.MustCompile(`^0?[0-9]*d$`)
+
+// namePatternFields are the fields of --rename patterns and whether they are numbers
+var namePatternFields = map[string]bool{
+	"InstanceNumber":    true,
+	"AcquisitionNumber": true,
+	"Index":             true, // position of the file in the series, from 1
+	"NumberOfFrames":    true,
+	"SOPInstanceUID":    false,
+	"SeriesInstanceUID": false,
+}
+
+// NamePattern names extracted DICOM files after their attributes,
+// e.g. "{InstanceNumber:05d}.dcm"
+type NamePattern struct {
+	pattern string
+}
+
+// parseNamePattern checks the fields and formats of a --rename pattern
+func parseNamePattern(pattern string) (*NamePattern, error) {
+	if strings.ContainsAny(pattern, `/\`) {
+		return nil, fmt.Errorf("%q names a file and cannot contain a path separator", pattern)
+	}
+	matches := namePatternField.FindAllStringSubmatch(pattern, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%q has no field, e.g. {InstanceNumber:05d} or {SOPInstanceUID}", pattern)
+	}
+	for _, m := range matches {
+		number, known := namePatternFields[m[1]]
+		switch {
+		case !known:
+			return nil, fmt.Errorf("unknown field {%s}, expected InstanceNumber, AcquisitionNumber, Index, NumberOfFrames, SOPInstanceUID or SeriesInstanceUID", m[1])
+		case m[2] != "" && !number:
+			return nil, fmt.Errorf("{%s} is not a number and takes no format", m[1])
+		case m[2] != "" && !namePatternFormat.MatchString(m[2]):
+			return nil, fmt.Errorf("invalid format %q of {%s}, expected e.g. 05d", m[2], m[1])
+		}
+	}
+	return &NamePattern{pattern: pattern}, nil
+}
+
+// Name returns the file name of an instance, the index-th of its series
+func (p *NamePattern) Name(file *DicomFile, index int) string {
+	return namePatternField.ReplaceAllStringFunc(p.pattern, func(field string) string {
+		m := namePatternField.FindStringSubmatch(field)
+		var value interface{}
+		switch m[1] {
+		case "InstanceNumber":
+			value = file.InstanceNumber
+		case "AcquisitionNumber":
+			value = file.AcquisitionNumber
+		case "Index":
+			value = index
+		case "NumberOfFrames":
+			value = file.NumberOfFrames
+		case "SOPInstanceUID":
+			return file.SOPInstanceUID
+		case "SeriesInstanceUID":
+			return file.SeriesUID
+		}
+		if m[2] == "" {
+			return fmt.Sprint(value)
+		}
+		return fmt.Sprintf("%"+m[2], value)
+	})
+}
+
+// renameInstances renames the DICOM files extracted into dir after the pattern, in
+// the order of sortInstances. Files that cannot be
+// parsed, and the other files of the series, keep their name; names taken twice get
+// a counter. It returns the new path of every renamed file relative to dir, keyed
+// by the old one.
+func renameInstances(dir string, pattern *NamePattern) (map[string]string, error) {
+	var instances []*DicomFile
+	taken := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if isDicomFile(path) {
+			file, err := ProcessDicomFile(path)
+			if err == nil {
+				instances = append(instances, file)
+				return nil
+			}
+			logger.Debugf("Keeping the name of %s: %v", path, err)
+		}
+		rel, _ := filepath.Rel(dir, path)
+		taken[rel] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortInstances(instances)
+
+	renamed := make(map[string]string, len(instances))
+	for i, file := range instances {
+		old, _ := filepath.Rel(dir, file.Path)
+		base := filepath.Join(filepath.Dir(old), pattern.Name(file, i+1))
+		ext := filepath.Ext(base)
+		name := base
+		for n := 2; taken[name]; n++ {
+			name = strings.TrimSuffix(base, ext) + "-" + strconv.Itoa(n) + ext
+		}
+		taken[name] = true
+		renamed[old] = name
+	}
+
+	// Through temporary names, as a new name may be the old one of another file
+	for old := range renamed {
+		if err := os.Rename(filepath.Join(dir, old), filepath.Join(dir, old+".rename.tmp")); err != nil {
+			return nil, err
+		}
+	}
+	for old, name := range renamed {
+		if err := os.Rename(filepath.Join(dir, old+".rename.tmp"), filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+	}
+	return renamed, nil
+}
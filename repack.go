@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// repackFormats maps the --repack formats to their archive extensions
+var repackFormats = map[string]string{
+	"zip":    ".zip",
+	"tar.gz": ".tar.gz",
+}
+
+// repackedArchive returns the archive a series directory was repacked into. An
+// archive counts only once its .sha256 sidecar, written last, exists.
+func repackedArchive(output string, info *FileInfo) (string, bool) {
+	for _, format := range sortedKeys(repackFormats) {
+		path := info.seriesPath(output) + repackFormats[format]
+		if _, err := os.Stat(path + ".sha256"); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// repackSeries packs a verified series directory into a single archive next to it,
+// writes the archive's SHA-256 to a sha256sum-style sidecar and removes the
+// directory. Entry names start with the series directory name, so unpacking the
+// archive in the study directory restores the original layout.
+func repackSeries(output string, info *FileInfo, format string) (string, error) {
+	dir := info.seriesPath(output)
+	path := dir + repackFormats[format]
+	tempPath := path + ".tmp"
+
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tempPath)
+	hash := sha256.New()
+	w := io.MultiWriter(f, hash)
+
+	if format == "zip" {
+		err = writeSeriesZip(w, dir)
+	} else {
+		err = writeSeriesTarGz(w, dir)
+	}
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := renameFile(tempPath, path); err != nil {
+		return "", err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	sidecar := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	if err := os.WriteFile(path+".sha256.tmp", []byte(sidecar), 0644); err != nil {
+		return "", err
+	}
+	if err := renameFile(path+".sha256.tmp", path+".sha256"); err != nil {
+		return "", err
+	}
+
+	// The archive is complete; drop the loose files
+	if err := os.RemoveAll(dir); err != nil {
+		logger.Warnf("Failed to remove %s after repacking: %v", dir, err)
+	}
+	checksums.RemoveDir(dir)
+	checksums.Add(path, sum)
+	return path, nil
+}
+
+// walkSeriesFiles calls fn for every regular file of a series directory with its
+// entry name (slash-separated, starting with the directory name)
+func walkSeriesFiles(dir string, fn func(path, name string, stat fs.FileInfo) error) error {
+	parent := filepath.Dir(dir)
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(parent, path)
+		return fn(path, filepath.ToSlash(rel), stat)
+	})
+}
+
+func writeSeriesZip(w io.Writer, dir string) error {
+	zw := zip.NewWriter(w)
+	err := walkSeriesFiles(dir, func(path, name string, _ fs.FileInfo) error {
+		return addZipEntry(zw, storeEntry{path: path, name: name})
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeSeriesTarGz(w io.Writer, dir string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := walkSeriesFiles(dir, func(path, name string, stat fs.FileInfo) error {
+		header, err := tar.FileInfoHeader(stat, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// runRepack repacks the verified series among files into per-series archives
+func runRepack(files []*FileInfo, options *Options) {
+	series := extractedSeries(files, options.Output)
+	if len(series) == 0 {
+		return
+	}
+
+	fmt.Printf("\nRepacking %d series into %s archives...\n", len(series), options.Repack)
+	var repacked, failed int32
+	forEachSeries(series, options.Concurrent, func(info *FileInfo) {
+		path, err := repackSeries(options.Output, info, options.Repack)
+		if err != nil {
+			logger.Warnf("Failed to repack %s: %v", info.SeriesUID, err)
+			atomic.AddInt32(&failed, 1)
+			events.Record(Event{Action: "repack", SeriesUID: info.SeriesUID, Error: err.Error(), Code: failureCode(err)})
+			return
+		}
+		atomic.AddInt32(&repacked, 1)
+		events.Record(Event{Action: "repack", SeriesUID: info.SeriesUID, Path: path})
+	})
+	fmt.Printf("Repacked %d series", repacked)
+	if failed > 0 {
+		fmt.Printf(", %d failed (loose files kept)", failed)
+	}
+	fmt.Println()
+}
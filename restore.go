@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrObjectArchived marks S3 objects in an archive storage class (Glacier, Deep
+// Archive) that are not restored
+var ErrObjectArchived = errors.New("object in an archive storage class")
+
+// restoresFile lists the restore requests not yet followed by a download, below metadata/
+const restoresFile = "pending-restores.json"
+
+// Poll intervals while waiting for restores; they take minutes to days depending on the tier
+const (
+	restorePollInterval    = 5 * time.Minute
+	restoreMaxPollInterval = 30 * time.Minute
+)
+
+// s3URIPattern finds the object URIs in s5cmd error lines
+var s3URIPattern = regexp.MustCompile(`s3://[^\s"']+`)
+
+// PendingRestore is a restore requested for an archived object
+type PendingRestore struct {
+	URI         string    `json:"uri"`
+	Item        string    `json:"item"` // the manifest entry the object was fetched for
+	Tier        string    `json:"tier"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// archivedObjects returns the objects s5cmd could not read because of their storage
+// class, from its output
+func archivedObjects(output string) []string {
+	seen := make(map[string]bool)
+	var uris []string
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, "InvalidObjectState") {
+			continue
+		}
+		if uri := s3URIPattern.FindString(line); uri != "" && !seen[uri] {
+			seen[uri] = true
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// restoresPath returns the pending restores file of an output directory
+func restoresPath(output string) string {
+	return filepath.Join(output, "metadata", restoresFile)
+}
+
+// loadRestores reads the pending restores by object URI
+func loadRestores(path string) (map[string]PendingRestore, error) {
+	restores := make(map[string]PendingRestore)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return restores, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []PendingRestore
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	for _, restore := range list {
+		restores[restore.URI] = restore
+	}
+	return restores, nil
+}
+
+// updateRestores changes the pending restores of output under the state lock. The
+// file is removed once no restore is pending.
+func updateRestores(output string, update func(map[string]PendingRestore)) error {
+	path := restoresPath(output)
+	return outputLock.WithState(func() error {
+		restores, err := loadRestores(path)
+		if err != nil {
+			return err
+		}
+		update(restores)
+		if len(restores) == 0 {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+
+		list := make([]PendingRestore, 0, len(restores))
+		for _, uri := range sortedKeys(restores) {
+			list = append(list, restores[uri])
+		}
+		content, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return writeFileAtomic(path, content, 0644)
+	})
+}
+
+// clearRestores forgets the restores of an item once it was downloaded
+func clearRestores(output, item string) {
+	if _, err := os.Stat(restoresPath(output)); err != nil {
+		return
+	}
+	err := updateRestores(output, func(restores map[string]PendingRestore) {
+		for uri, restore := range restores {
+			if restore.Item == item {
+				delete(restores, uri)
+			}
+		}
+	})
+	if err != nil {
+		logger.Warnf("Failed to update %s: %v", restoresFile, err)
+	}
+}
+
+// awsS3API runs an s3api command of the AWS CLI with the endpoint and credentials
+// of the s5cmd transfers, returning its standard output
+func awsS3API(options *Options, args ...string) ([]byte, error) {
+	flags := append([]string{"s3api"}, args...)
+	flags = append(flags, "--endpoint-url", endpoints.S3, "--output", "json")
+	switch {
+	case options.S3Profile != "":
+		flags = append(flags, "--profile", options.S3Profile)
+	case !options.S3Sign && !options.S3RequesterPays:
+		flags = append(flags, "--no-sign-request")
+	}
+	if options.S3RequesterPays {
+		flags = append(flags, "--request-payer", "requester")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("aws", flags...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("aws s3api %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// splitS3URI returns the bucket and key of an s3:// URI
+func splitS3URI(uri string) (bucket, key string, err error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(uri, "s3://"), "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("%s is not an S3 object URI", uri)
+	}
+	return bucket, key, nil
+}
+
+// requestRestore asks S3 to restore an archived object for options.RestoreDays
+func requestRestore(uri string, options *Options) error {
+	bucket, key, err := splitS3URI(uri)
+	if err != nil {
+		return err
+	}
+	request := fmt.Sprintf(`{"Days":%d,"GlacierJobParameters":{"Tier":"%s"}}`, options.RestoreDays, options.RestoreTier)
+	_, err = awsS3API(options, "restore-object", "--bucket", bucket, "--key", key, "--restore-request", request)
+	if err != nil && strings.Contains(err.Error(), "RestoreAlreadyInProgress") {
+		return nil
+	}
+	return err
+}
+
+// isRestored reports whether the restored copy of an archived object is available
+func isRestored(uri string, options *Options) (bool, error) {
+	bucket, key, err := splitS3URI(uri)
+	if err != nil {
+		return false, err
+	}
+	out, err := awsS3API(options, "head-object", "--bucket", bucket, "--key", key)
+	if err != nil {
+		return false, err
+	}
+	var head struct {
+		Restore string `json:"Restore"`
+	}
+	if err := json.Unmarshal(out, &head); err != nil {
+		return false, fmt.Errorf("unexpected head-object output for %s: %v", uri, err)
+	}
+	return strings.Contains(head.Restore, `ongoing-request="false"`), nil
+}
+
+// restoreArchived requests the restore of the archived objects of an item, unless
+// requested before, and with --wait-for-restore polls until they are available.
+// It returns nil when the item can be fetched again.
+func (info *FileInfo) restoreArchived(uris []string, options *Options) error {
+	if options.RestoreTier == "" {
+		return fmt.Errorf("%w: %d objects of %s must be restored first, see --restore-tier", ErrObjectArchived, len(uris), info.DownloadURL)
+	}
+
+	pending, err := loadRestores(restoresPath(options.Output))
+	if err != nil {
+		return err
+	}
+	var requested []PendingRestore
+	for _, uri := range uris {
+		if _, ok := pending[uri]; ok {
+			continue
+		}
+		if err := requestRestore(uri, options); err != nil {
+			return fmt.Errorf("%w: %v", ErrObjectArchived, err)
+		}
+		requested = append(requested, PendingRestore{URI: uri, Item: info.DownloadURL, Tier: options.RestoreTier, RequestedAt: time.Now().UTC()})
+	}
+	if len(requested) > 0 {
+		logger.Infof("Requested %s restore of %d archived objects of %s", options.RestoreTier, len(requested), info.DownloadURL)
+		err := updateRestores(options.Output, func(restores map[string]PendingRestore) {
+			for _, restore := range requested {
+				restores[restore.URI] = restore
+			}
+		})
+		if err != nil {
+			logger.Warnf("Failed to update %s: %v", restoresFile, err)
+		}
+	}
+
+	if options.WaitForRestore <= 0 {
+		return fmt.Errorf("%w: restore of %d objects of %s pending, run again once restored", ErrObjectArchived, len(uris), info.DownloadURL)
+	}
+	deadline := time.Now().Add(options.WaitForRestore)
+	remaining := uris
+	for attempt := 1; ; attempt++ {
+		var waiting []string
+		for _, uri := range remaining {
+			restored, err := isRestored(uri, options)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrObjectArchived, err)
+			}
+			if !restored {
+				waiting = append(waiting, uri)
+			}
+		}
+		if remaining = waiting; len(remaining) == 0 {
+			logger.Infof("Archived objects of %s restored", info.DownloadURL)
+			return nil
+		}
+
+		delay := backoffDelay(attempt, restorePollInterval, restoreMaxPollInterval)
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("%w: %d objects of %s not restored within %s", ErrObjectArchived, len(remaining), info.DownloadURL, options.WaitForRestore)
+		}
+		logger.Infof("Waiting %s for %d archived objects of %s to be restored", delay.Round(time.Second), len(remaining), info.DownloadURL)
+		time.Sleep(delay)
+	}
+}
+
+// reportPendingRestores prints the restores requested but not yet downloaded
+func reportPendingRestores(output string) {
+	restores, err := loadRestores(restoresPath(output))
+	if err != nil || len(restores) == 0 {
+		return
+	}
+	items := make(map[string]bool)
+	var oldest time.Time
+	for _, restore := range restores {
+		items[restore.Item] = true
+		if oldest.IsZero() || restore.RequestedAt.Before(oldest) {
+			oldest = restore.RequestedAt
+		}
+	}
+	fmt.Printf("\nPending restores: %d archived objects of %d items, requested since %s (%s)\n",
+		len(restores), len(items), oldest.Local().Format("2006-01-02 15:04"), restoresPath(output))
+	fmt.Println("Run again once they are restored, or use --wait-for-restore")
+}
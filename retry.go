@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrCircuitOpen is returned for downloads not attempted because the circuit breaker tripped
+var ErrCircuitOpen = errors.New("run aborted by circuit breaker")
+
+// HTTPStatusError is returned when a download request is answered with a non-OK status
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+	RequestID  string // X-Request-ID of the request with --request-id
+}
+
+// newHTTPStatusError returns the error of a response with a non-OK status
+func newHTTPStatusError(resp *http.Response) *HTTPStatusError {
+	return &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status, RequestID: requestID(resp)}
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("HTTP error %d: %s (request %s)", e.StatusCode, e.Status, e.RequestID)
+	}
+	return fmt.Sprintf("HTTP error %d: %s", e.StatusCode, e.Status)
+}
+
+// S5cmdError is returned when the s5cmd tool fails; these are not retried
+type S5cmdError struct {
+	URL    string
+	Err    error
+	Output string
+}
+
+func (e *S5cmdError) Error() string {
+	return fmt.Sprintf("s5cmd command failed for %s: %s\nOutput: %s", e.URL, e.Err, e.Output)
+}
+
+func (e *S5cmdError) Unwrap() error {
+	return e.Err
+}
+
+// errorClass is the retry classification of a download error
+type errorClass int
+
+const (
+	errorPermanent      errorClass = iota // retrying cannot help (bad request, missing data, local I/O)
+	errorTransient                        // retry the series (truncated transfer, corrupt archive)
+	errorInfrastructure                   // retry, and count towards the circuit breaker
+)
+
+// classifyError decides from the error chain whether and how a download failure is retried
+func classifyError(err error) errorClass {
+	var s5cmdErr *S5cmdError
+	if errors.As(err, &s5cmdErr) || errors.Is(err, ErrCircuitOpen) {
+		return errorPermanent
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return errorInfrastructure
+		}
+		return errorPermanent
+	}
+
+	var ftpErr *textproto.Error
+	if errors.As(err, &ftpErr) {
+		return ftpErrorClass(ftpErr)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errorInfrastructure
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTransferStalled) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, net.ErrClosed) {
+		return errorInfrastructure
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		// DNS failures, unreachable hosts and similar
+		return errorInfrastructure
+	}
+
+	// Truncated transfers surface as EOF while reading the body or as a corrupt archive
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, zip.ErrFormat) || errors.Is(err, zip.ErrChecksum) {
+		return errorTransient
+	}
+	return errorPermanent
+}
+
+// isRetryableError checks if an error is retryable
+func isRetryableError(err error) bool {
+	return classifyError(err) != errorPermanent
+}
+
+// backoffDelay returns the wait before retry number attempt (1-based): exponential
+// growth from base, capped at max, with equal jitter so that workers that failed
+// together do not retry in lockstep
+func backoffDelay(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// CircuitBreaker aborts the run after too many consecutive infrastructure failures,
+// which indicate an outage rather than problems with individual series
+type CircuitBreaker struct {
+	Threshold int
+
+	mu          sync.Mutex
+	consecutive int
+	tripped     bool
+}
+
+// circuitBreaker guards the current run; nil disables it
+var circuitBreaker *CircuitBreaker
+
+// Record updates the breaker with the outcome of a download attempt
+func (b *CircuitBreaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.consecutive = 0
+		return
+	}
+	if classifyError(err) != errorInfrastructure {
+		return
+	}
+	b.consecutive++
+	if b.consecutive >= b.Threshold && !b.tripped {
+		b.tripped = true
+		logger.Errorf("Aborting run after %d consecutive infrastructure failures (last: %v)", b.consecutive, err)
+	}
+}
+
+// Tripped reports whether the run should be aborted
+func (b *CircuitBreaker) Tripped() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tripped
+}
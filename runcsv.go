@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// combinedMetadataCSV is the CSV of --combined-csv in metadata/, with every series
+// in the output
+const combinedMetadataCSV = "combined-metadata.csv"
+
+// runMetadataCSVName names the metadata CSV of a run after its input and start
+// time, e.g. manifest-20261017T120000Z-metadata.csv
+func runMetadataCSVName(options *Options, started time.Time) string {
+	name := options.Command
+	if options.Input != "" && options.Input != stdinInput {
+		name = strings.TrimSuffix(filepath.Base(options.Input), filepath.Ext(options.Input))
+	} else if len(options.PriorityInputs) > 0 {
+		path := options.PriorityInputs[0].Path
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if name == "" {
+		name = "run"
+	}
+	return fmt.Sprintf("%s-%s", bagUnsafeChars.ReplaceAllString(name, "_"), started.UTC().Format("20060102T150405Z"))
+}
+
+// writeRunMetadataCSV writes the metadata of the series of a run to a CSV of its
+// own in metadata/, once at the end of the run and from the metadata cache, so
+// runs sharing the output never write to the same file. seriesUIDs are the series
+// of the run; fetched holds metadata not read from the cache, such as that of
+// series copied by s5cmd, and s5cmdURIs maps their UIDs to the URI they were
+// copied from.
+func writeRunMetadataCSV(seriesUIDs []string, fetched []*FileInfo, s5cmdURIs map[string]string, started time.Time, options *Options) (string, int, error) {
+	bySeries := make(map[string]*FileInfo)
+	for _, uid := range seriesUIDs {
+		if uid == "" || bySeries[uid] != nil {
+			continue
+		}
+		if cached, err := loadMetadataFromCache(getMetadataCachePath(options.Output, uid)); err == nil && cached.SubjectID != "" {
+			bySeries[uid] = cached
+		}
+	}
+	for _, info := range fetched {
+		bySeries[info.SeriesUID] = info
+	}
+	if len(bySeries) == 0 {
+		return "", 0, nil
+	}
+	series := make([]*FileInfo, 0, len(bySeries))
+	for _, uid := range sortedKeys(bySeries) {
+		series = append(series, bySeries[uid])
+	}
+	content, err := encodeMetadataCSV(series, s5cmdURIs)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var path string
+	err = outputLock.WithState(func() error {
+		// Runs started in the same second get a numbered file each
+		base := filepath.Join(options.Output, "metadata", runMetadataCSVName(options, started))
+		path = base + "-metadata.csv"
+		for i := 2; ; i++ {
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				break
+			}
+			path = fmt.Sprintf("%s-%d-metadata.csv", base, i)
+		}
+		return writeFileAtomic(path, content, 0644)
+	})
+	return path, len(series), err
+}
+
+// writeCombinedMetadataCSV rewrites metadata/combined-metadata.csv with every series
+// in the output that has cached metadata, with the s5cmd URIs recorded by the
+// metadata CSVs of all runs
+func writeCombinedMetadataCSV(output string) (string, int, error) {
+	path := filepath.Join(output, "metadata", combinedMetadataCSV)
+	var count int
+	err := outputLock.WithState(func() error {
+		uriSeries, err := loadS5cmdSeriesMapFromCSVs(output)
+		if err != nil {
+			return err
+		}
+		s5cmdURIs := make(map[string]string, len(uriSeries))
+		for uri, uid := range uriSeries {
+			s5cmdURIs[uid] = uri
+		}
+		series := loadCachedSeriesMetadata(output)
+		count = len(series)
+		content, err := encodeMetadataCSV(series, s5cmdURIs)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, content, 0644)
+	})
+	return path, count, err
+}
+
+// encodeMetadataCSV renders series as a metadata CSV: the columns of
+// metadataCSVHeader, rows ordered by collection, subject, study and series
+func encodeMetadataCSV(series []*FileInfo, s5cmdURIs map[string]string) ([]byte, error) {
+	sorted := make([]*FileInfo, len(series))
+	copy(sorted, series)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Collection != b.Collection {
+			return a.Collection < b.Collection
+		}
+		if a.SubjectID != b.SubjectID {
+			return a.SubjectID < b.SubjectID
+		}
+		if a.StudyUID != b.StudyUID {
+			return a.StudyUID < b.StudyUID
+		}
+		return a.SeriesUID < b.SeriesUID
+	})
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(metadataCSVHeader); err != nil {
+		return nil, err
+	}
+	for _, info := range sorted {
+		record := metadataCSVRecord(info)
+		for i, name := range metadataCSVHeader {
+			if name == "OriginalS5cmdURI" && record[i] == "" {
+				record[i] = s5cmdURIs[info.SeriesUID]
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, fmt.Errorf("failed to write CSV record for series %s: %w", info.SeriesUID, err)
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
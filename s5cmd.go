@@ -3,13 +3,85 @@ package main
 import (
 	"bufio"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+// s5cmdMinVersion is the oldest s5cmd with the commands and flags used here: sync
+// and --no-sign-request arrived in 2.1.0
+var s5cmdMinVersion = [3]int{2, 1, 0}
+
+// checkS5cmd verifies that the s5cmd binary can be run and is recent enough, so
+// that a missing or outdated binary is reported once instead of by every series
+func checkS5cmd(options *Options) error {
+	out, err := exec.Command(options.S5cmdPath, "version").Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("s5cmd not found at %q; install it from https://github.com/peak/s5cmd or set --s5cmd-path", options.S5cmdPath)
+	} else if err != nil {
+		return fmt.Errorf("failed to run %s version: %v", options.S5cmdPath, err)
+	}
+	version, ok := parseS5cmdVersion(string(out))
+	if !ok {
+		logger.Warnf("Could not read the version of %s from %q, assuming it is recent enough", options.S5cmdPath, strings.TrimSpace(string(out)))
+		return nil
+	}
+	for i := range version {
+		if version[i] != s5cmdMinVersion[i] {
+			if version[i] < s5cmdMinVersion[i] {
+				return fmt.Errorf("%s is version %d.%d.%d, at least %d.%d.%d is required", options.S5cmdPath,
+					version[0], version[1], version[2], s5cmdMinVersion[0], s5cmdMinVersion[1], s5cmdMinVersion[2])
+			}
+			break
+		}
+	}
+	logger.Debugf("Using %s %d.%d.%d", options.S5cmdPath, version[0], version[1], version[2])
+	return nil
+}
+
+// parseS5cmdVersion reads the output of s5cmd version, e.g. "v2.2.2-48f7e59"
+func parseS5cmdVersion(output string) ([3]int, bool) {
+	var version [3]int
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return version, false
+	}
+	core, _, _ := strings.Cut(strings.TrimPrefix(fields[0], "v"), "-")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return version, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return version, false
+		}
+		version[i] = n
+	}
+	return version, true
+}
+
+// needsS5cmd reports whether any item, or any of its alternate sources, is copied
+// with s5cmd
+func needsS5cmd(files []*FileInfo) bool {
+	for _, info := range files {
+		if info.S5cmdManifestPath != "" || sourceKind(info.DownloadURL) == sourceS3 {
+			return true
+		}
+		for _, alternate := range info.Alternates {
+			if sourceKind(alternate) == sourceS3 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // loadS5cmdSeriesMapFromCSVs scans all '*-metadata.csv' files in the metadata
 // directory to build a map of previously downloaded s5cmd series.
 func loadS5cmdSeriesMapFromCSVs(outputDir string) (map[string]string, error) {
@@ -67,7 +139,8 @@ func loadS5cmdSeriesMapFromCSVs(outputDir string) (map[string]string, error) {
 				logger.Warnf("Error reading record from %s: %v", filePath, err)
 				continue
 			}
-			if len(record) > uriIndex && len(record) > uidIndex {
+			// Rows of series not copied by s5cmd have no URI
+			if len(record) > uriIndex && len(record) > uidIndex && record[uriIndex] != "" {
 				seriesMap[record[uriIndex]] = record[uidIndex]
 			}
 		}
@@ -76,7 +149,9 @@ func loadS5cmdSeriesMapFromCSVs(outputDir string) (map[string]string, error) {
 	return seriesMap, nil
 }
 
-func decodeS5cmd(filePath string, outputDir string, processedSeries map[string]string) ([]*FileInfo, int) {
+// decodeS5cmd turns an s5cmd manifest into sync jobs for known series and copy jobs for new
+// ones. Copy jobs get a temporary directory unless dryRun is set.
+func decodeS5cmd(filePath string, outputDir string, processedSeries map[string]string, dryRun bool) ([]*FileInfo, int) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		logger.Fatalf("could not open s5cmd manifest: %v", err)
@@ -87,50 +162,14 @@ func decodeS5cmd(filePath string, outputDir string, processedSeries map[string]s
 	var newJobs int
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.Fields(line)
-		var originalURI string
-		if len(parts) >= 2 && parts[0] == "cp" {
-			originalURI = parts[1]
-		} else if len(parts) == 1 && strings.HasPrefix(parts[0], "s3://") {
-			originalURI = parts[0]
-		} else {
-			continue // Skip comments and invalid lines
-		}
-
-		if seriesUID, ok := processedSeries[originalURI]; ok {
-			// This is a sync job for an existing series
-			logger.Infof("Queueing sync job for existing series: %s", originalURI)
-			finalDirPath := filepath.Join(outputDir, seriesUID)
-			jobsToProcess = append(jobsToProcess, &FileInfo{
-				DownloadURL:      originalURI,
-				SeriesUID:        seriesUID, // We already know the final UID
-				OriginalS5cmdURI: originalURI,
-				S5cmdManifestPath: finalDirPath, // The final directory is the target for sync
-				IsSyncJob:        true,
-			})
-		} else {
-			// This is a new copy job
+		job := s5cmdJob(scanner.Text(), outputDir, processedSeries, dryRun)
+		if job == nil {
+			continue
+		}
+		if !job.IsSyncJob && job.S5cmdManifestPath != "" {
 			newJobs++
-			logger.Infof("Queueing new copy job for series: %s", originalURI)
-			cleanURI := strings.TrimSuffix(originalURI, "/*")
-			seriesGUID := filepath.Base(cleanURI)
-			tempDirName := "s5cmd-tmp-" + seriesGUID
-			tempDirPath := filepath.Join(outputDir, tempDirName)
-
-			if err := os.MkdirAll(tempDirPath, 0755); err != nil {
-				logger.Warnf("Could not create temp directory for %s: %v", originalURI, err)
-				continue
-			}
-
-			jobsToProcess = append(jobsToProcess, &FileInfo{
-				DownloadURL:      originalURI,
-				SeriesUID:        filepath.Base(originalURI), // Temporary ID for progress
-				OriginalS5cmdURI: originalURI,
-				S5cmdManifestPath: tempDirPath, // The temporary directory is the target for copy
-				IsSyncJob:        false,
-			})
 		}
+		jobsToProcess = append(jobsToProcess, job)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -140,3 +179,58 @@ func decodeS5cmd(filePath string, outputDir string, processedSeries map[string]s
 	logger.Infof("Found %d s5cmd jobs to process (%d new, %d existing)", len(jobsToProcess), newJobs, len(jobsToProcess)-newJobs)
 	return jobsToProcess, newJobs
 }
+
+// s5cmdJob returns the job of an s5cmd manifest line: a sync of a series copied by
+// an earlier run, or a copy into a new temporary directory. It returns nil for
+// comments and lines that are not copies.
+func s5cmdJob(line string, outputDir string, processedSeries map[string]string, dryRun bool) *FileInfo {
+	parts := strings.Fields(line)
+	var originalURI string
+	if len(parts) >= 2 && parts[0] == "cp" {
+		originalURI = parts[1]
+	} else if len(parts) == 1 && strings.HasPrefix(parts[0], "s3://") {
+		originalURI = parts[0]
+	} else {
+		return nil // Skip comments and invalid lines
+	}
+	// s5cmd cannot read gs:// URIs; they are downloaded natively like spreadsheet sources
+	if isGCSURL(originalURI) {
+		return newSourceItem([]string{originalURI}, "", sourceGCS)
+	}
+
+	if seriesUID, ok := processedSeries[originalURI]; ok {
+		// This is a sync job for an existing series
+		logger.Infof("Queueing sync job for existing series: %s", originalURI)
+		finalDirPath := filepath.Join(outputDir, seriesUID)
+		return &FileInfo{
+			DownloadURL:      originalURI,
+			SeriesUID:        seriesUID, // We already know the final UID
+			OriginalS5cmdURI: originalURI,
+			S5cmdManifestPath: finalDirPath, // The final directory is the target for sync
+			IsSyncJob:        true,
+		}
+	} else {
+		// This is a new copy job, or one resuming the copy of an interrupted run
+		logger.Infof("Queueing new copy job for series: %s", originalURI)
+		cleanURI := strings.TrimSuffix(originalURI, "/*")
+		seriesGUID := filepath.Base(cleanURI)
+		tempDirName := s5cmdTempPrefix + seriesGUID
+		tempDirPath := transferTempPath(filepath.Join(outputDir, tempDirName), "")
+
+		if !dryRun {
+			if err := os.MkdirAll(tempDirPath, 0755); err != nil {
+				logger.Warnf("Could not create temp directory for %s: %v", originalURI, err)
+				return nil
+			}
+		}
+
+		return &FileInfo{
+			DownloadURL:      originalURI,
+			SeriesUID:        filepath.Base(originalURI), // Temporary ID for progress
+			OriginalS5cmdURI: originalURI,
+			S5cmdManifestPath: tempDirPath, // The temporary directory is the target for copy
+			IsSyncJob:        false,
+			s5cmdResume:      interruptedS5cmdDirs[tempDirPath],
+		}
+	}
+}
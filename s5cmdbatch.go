@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s5cmdBatchLinger is how long the first job of a batch waits for others before the
+// batch is run without them
+const s5cmdBatchLinger = 2 * time.Second
+
+// s5cmdBatch runs the copies and syncs of the workers in shared s5cmd processes;
+// nil runs one s5cmd process per series
+var s5cmdBatch *S5cmdBatcher
+
+// s5cmdJobRequest is a copy or sync waiting in a batch
+type s5cmdJobRequest struct {
+	info      *FileInfo
+	targetDir string
+	done      chan error
+}
+
+// S5cmdBatcher collects the s5cmd jobs of the download workers and runs them as the
+// commands of a single s5cmd run process. Workers block until the batch holding
+// their job has finished, so retries, events and statistics stay per series.
+type S5cmdBatcher struct {
+	size    int
+	options *Options
+
+	mu      sync.Mutex
+	pending []*s5cmdJobRequest
+	timer   *time.Timer
+}
+
+// NewS5cmdBatcher returns a batcher running up to size jobs per s5cmd process
+func NewS5cmdBatcher(size int, options *Options) *S5cmdBatcher {
+	return &S5cmdBatcher{size: size, options: options}
+}
+
+// Run copies or syncs a series into targetDir, as part of the next batch
+func (b *S5cmdBatcher) Run(info *FileInfo, targetDir string, options *Options) error {
+	if b == nil {
+		return info.runS5cmd(targetDir, options)
+	}
+	request := &s5cmdJobRequest{info: info, targetDir: targetDir, done: make(chan error, 1)}
+	b.mu.Lock()
+	b.pending = append(b.pending, request)
+	switch {
+	case len(b.pending) >= b.size:
+		b.flushLocked()
+	case b.timer == nil:
+		b.timer = time.AfterFunc(s5cmdBatchLinger, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.flushLocked()
+		})
+	}
+	b.mu.Unlock()
+	return <-request.done
+}
+
+// flushLocked starts the pending jobs as a batch; b.mu is held
+func (b *S5cmdBatcher) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	batch := b.pending
+	b.pending = nil
+	go b.runBatch(batch)
+}
+
+// runBatch writes the jobs to a run file, runs it with one s5cmd process and hands
+// every job the errors s5cmd reported for it
+func (b *S5cmdBatcher) runBatch(batch []*s5cmdJobRequest) {
+	runFile, err := os.CreateTemp("", "nbia-s5cmd-*.txt")
+	if err != nil {
+		b.finish(batch, fmt.Errorf("failed to create s5cmd run file: %w", err))
+		return
+	}
+	defer os.Remove(runFile.Name())
+
+	before := make([]map[string]fileState, len(batch))
+	w := bufio.NewWriter(runFile)
+	for i, request := range batch {
+		if err := os.MkdirAll(request.targetDir, 0755); err != nil {
+			b.finish(batch, fmt.Errorf("could not create target directory %s: %w", request.targetDir, err))
+			runFile.Close()
+			return
+		}
+		before[i] = dirFileStates(request.targetDir)
+		fmt.Fprintln(w, s5cmdRunLine(request.info, request.targetDir, b.options))
+	}
+	if err := w.Flush(); err != nil {
+		runFile.Close()
+		b.finish(batch, fmt.Errorf("failed to write s5cmd run file: %w", err))
+		return
+	}
+	runFile.Close()
+
+	logger.Debugf("Running %d s5cmd jobs in one batch", len(batch))
+	cmd := exec.Command(b.options.S5cmdPath, append(s5cmdGlobalFlags(b.options), "run", runFile.Name())...)
+	output, runErr := cmd.CombinedOutput()
+	lines := strings.Split(string(output), "\n")
+
+	for i, request := range batch {
+		objects, bytes := writtenSince(request.targetDir, before[i])
+		recordEgress(request.info.DownloadURL, objects, bytes)
+
+		// s5cmd names the source of every failed command in its ERROR lines
+		source := strings.TrimSuffix(request.info.DownloadURL, "*")
+		var own []string
+		failed := false
+		for _, line := range lines {
+			if strings.Contains(line, source) {
+				own = append(own, line)
+				failed = failed || strings.HasPrefix(line, "ERROR")
+			}
+		}
+		switch {
+		case failed:
+			request.done <- &S5cmdError{URL: request.info.DownloadURL, Err: runErr, Output: strings.Join(own, "\n")}
+		case runErr != nil && !strings.Contains(string(output), "ERROR"):
+			// The process itself failed, not single commands
+			request.done <- &S5cmdError{URL: request.info.DownloadURL, Err: runErr, Output: string(output)}
+		default:
+			request.done <- nil
+		}
+	}
+}
+
+// finish fails every job of a batch that could not be run
+func (b *S5cmdBatcher) finish(batch []*s5cmdJobRequest, err error) {
+	for _, request := range batch {
+		request.done <- err
+	}
+}
+
+// s5cmdRunLine returns the command of a job in an s5cmd run file, copying into the
+// target directory
+func s5cmdRunLine(info *FileInfo, targetDir string, options *Options) string {
+	args := []string{"cp"}
+	if info.IsSyncJob || info.s5cmdResume {
+		args = []string{"sync", "--size-only"}
+	}
+	args = append(args, s5cmdTransferFlags(options)...)
+	if abs, err := filepath.Abs(targetDir); err == nil {
+		targetDir = abs
+	}
+	args = append(args, strconv.Quote(info.DownloadURL), strconv.Quote(filepath.ToSlash(targetDir)+"/"))
+	return strings.Join(args, " ")
+}
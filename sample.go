@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sampledSubjectsFile lists the subjects drawn by the last --sample-n run, below
+// metadata/, to pass to --subjects for the same subset
+const sampledSubjectsFile = "sampled-subjects.txt"
+
+// subjectListColumns are the header names of the subject ID column in the CSVs
+// --subjects reads, e.g. the metadata CSVs and NBIA clinical spreadsheets
+var subjectListColumns = map[string]bool{"subjectid": true, "subject id": true, "subject_id": true, "patientid": true, "patient id": true, "patient_id": true}
+
+// subjectSelection are the patients this invocation handles; nil without --subjects
+// and --sample-n
+var subjectSelection *SubjectSelection
+
+// SubjectSelection restricts a run to some patients: those listed by --subjects,
+// then a random sample of --sample-n of them
+type SubjectSelection struct {
+	Listed map[string]bool // nil selects every subject
+	Sample int             // 0 keeps every selected subject
+	Seed   int64
+}
+
+// loadSubjectList reads the subject IDs of a --subjects file: one ID per line, or a
+// CSV with a SubjectID or PatientID column. Lines starting with # are ignored.
+func loadSubjectList(path string) (map[string]bool, error) {
+	ids, err := loadIDList(path, subjectListColumns)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%s lists no subjects", path)
+	}
+	return ids, nil
+}
+
+// Select returns the items of the selected subjects, and the sampled subjects. The
+// sample only depends on the subjects of the input and the seed, not on their
+// order, so the same input and seed always give the same subset. Items whose
+// subject is not known, such as direct downloads, are left out.
+func (s *SubjectSelection) Select(files []*FileInfo) ([]*FileInfo, []string) {
+	if s == nil {
+		return files, nil
+	}
+	subjects := make(map[string]bool)
+	unknown := 0
+	for _, info := range files {
+		switch {
+		case info.SubjectID == "":
+			unknown++
+		case s.Listed == nil || s.Listed[info.SubjectID]:
+			subjects[info.SubjectID] = true
+		}
+	}
+	if unknown > 0 {
+		logger.Warnf("%d items have no subject ID and are left out of the subject selection", unknown)
+	}
+	if missing := len(s.Listed) - len(subjects); s.Listed != nil && missing > 0 {
+		logger.Warnf("%d subjects of --subjects are not in the input", missing)
+	}
+
+	var sampled []string
+	if s.Sample > 0 && s.Sample < len(subjects) {
+		ids := sortedKeys(subjects)
+		rand.New(rand.NewSource(s.Seed)).Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+		sampled = ids[:s.Sample]
+		sort.Strings(sampled)
+		subjects = make(map[string]bool, len(sampled))
+		for _, subject := range sampled {
+			subjects[subject] = true
+		}
+	}
+
+	selected := make([]*FileInfo, 0, len(files))
+	for _, info := range files {
+		if subjects[info.SubjectID] {
+			selected = append(selected, info)
+		}
+	}
+	return selected, sampled
+}
+
+// writeSampledSubjects saves the subjects drawn by --sample-n to metadata/
+func writeSampledSubjects(output string, subjects []string, seed int64) (string, error) {
+	path := filepath.Join(output, "metadata", sampledSubjectsFile)
+	content := fmt.Sprintf("# %d subjects sampled with --sample-seed %d\n%s\n", len(subjects), seed, strings.Join(subjects, "\n"))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return path, writeFileAtomic(path, []byte(content), 0644)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScheduleWindow is a daily time-of-day window during which transfers are allowed.
+// Windows may wrap past midnight, e.g. 22:00-06:00.
+type ScheduleWindow struct {
+	Start time.Duration // offset from local midnight
+	End   time.Duration // offset from local midnight
+
+	mu            sync.Mutex
+	announcedOpen time.Time // last reopening time logged, to warn once per pause
+}
+
+// parseScheduleWindow parses "HH:MM-HH:MM" into a ScheduleWindow
+func parseScheduleWindow(value string) (*ScheduleWindow, error) {
+	startStr, endStr, found := strings.Cut(value, "-")
+	if !found {
+		return nil, fmt.Errorf("schedule window %q must look like HH:MM-HH:MM", value)
+	}
+	start, err := parseClock(strings.TrimSpace(startStr))
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(strings.TrimSpace(endStr))
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("schedule window %q is empty", value)
+	}
+	return &ScheduleWindow{Start: start, End: end}, nil
+}
+
+// parseClock parses HH:MM into an offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// sinceMidnight returns the offset of t from its local midnight
+func sinceMidnight(t time.Time) time.Duration {
+	y, m, d := t.Date()
+	return t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+}
+
+// Contains reports whether t falls inside the window
+func (w *ScheduleWindow) Contains(t time.Time) bool {
+	offset := sinceMidnight(t)
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	// Window wraps past midnight
+	return offset >= w.Start || offset < w.End
+}
+
+// NextOpen returns the next time at or after t when the window opens
+func (w *ScheduleWindow) NextOpen(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	y, m, d := t.Date()
+	open := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(w.Start)
+	if open.Before(t) {
+		open = open.AddDate(0, 0, 1)
+	}
+	return open
+}
+
+// String formats the window as HH:MM-HH:MM
+func (w *ScheduleWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.Start) + "-" + clock(w.End)
+}
+
+// Wait blocks until the window is open. Workers call this before each transfer so
+// that an in-flight download finishes but no new one starts outside the window.
+func (w *ScheduleWindow) Wait(workerID int) {
+	if w == nil {
+		return
+	}
+	for {
+		now := time.Now()
+		if w.Contains(now) {
+			return
+		}
+		next := w.NextOpen(now)
+		w.mu.Lock()
+		if !w.announcedOpen.Equal(next) {
+			w.announcedOpen = next
+			logger.Warnf("Outside schedule window %s; pausing transfers until %s", w, next.Format("2006-01-02 15:04"))
+		}
+		w.mu.Unlock()
+		logger.Debugf("[Worker %d] Waiting for schedule window until %s", workerID, next.Format(time.RFC3339))
+		// Re-check periodically so clock changes (DST, suspend) are picked up
+		sleep := time.Until(next)
+		if sleep > time.Minute {
+			sleep = time.Minute
+		}
+		time.Sleep(sleep)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// secretPassphraseEnv holds the passphrase of --secret-key passphrase
+const secretPassphraseEnv = "NBIA_SECRET_PASSPHRASE"
+
+// keychainService and keychainAccount name the key kept in the OS keychain
+const (
+	keychainService = "nbia-data-retriever"
+	keychainAccount = "secrets-key"
+)
+
+// pbkdf2Iterations is the work factor of passphrase-derived keys
+const pbkdf2Iterations = 600000
+
+// Sources of the key of --secret-key
+const (
+	secretKeyPassphrase = "passphrase"
+	secretKeyKeychain   = "keychain"
+)
+
+// secretKey encrypts the token and reads encrypted API key files; nil stores
+// secrets in plain text
+var secretKey *SecretKey
+
+// errSecretEncrypted is returned when reading an encrypted file without --secret-key
+var errSecretEncrypted = errors.New("file is encrypted, set --secret-key to read it")
+
+// SecretKey is the source of the key that encrypts secrets at rest
+type SecretKey struct {
+	source     string
+	passphrase string
+	keychain   []byte
+}
+
+// secretEnvelope is the on-disk form of an encrypted secret
+type secretEnvelope struct {
+	Encrypted  string `json:"encrypted"` // cipher, aes-256-gcm
+	KeySource  string `json:"key_source"`
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// NewSecretKey prepares the key of a --secret-key source: the passphrase from
+// NBIA_SECRET_PASSPHRASE, or a random key kept in the OS keychain
+func NewSecretKey(source string) (*SecretKey, error) {
+	switch source {
+	case secretKeyPassphrase:
+		passphrase := os.Getenv(secretPassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("--secret-key passphrase reads the passphrase from %s, which is not set", secretPassphraseEnv)
+		}
+		return &SecretKey{source: source, passphrase: passphrase}, nil
+	case secretKeyKeychain:
+		key, err := keychainKey()
+		if err != nil {
+			return nil, err
+		}
+		return &SecretKey{source: source, keychain: key}, nil
+	}
+	return nil, fmt.Errorf("unknown secret key source %q", source)
+}
+
+// keychainKey returns the key kept in the OS keychain, creating it on first use.
+// The keychain is reached through security on macOS and secret-tool (libsecret) on
+// Linux. A new key is only created when the lookup reports that there is none: a
+// locked keychain or a missing D-Bus session fails instead, as a new key would
+// replace the one that encrypted the existing secrets.
+func keychainKey() ([]byte, error) {
+	encoded, err := keychainLookup()
+	if err != nil {
+		return nil, err
+	}
+	if encoded != "" {
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	encoded = base64.StdEncoding.EncodeToString(key)
+	// The key is passed on standard input, never on a command line that ps shows
+	var store *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		store = exec.Command("security", "-i")
+		store.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -s %s -a %s -w %s\n",
+			keychainService, keychainAccount, encoded))
+	} else {
+		store = exec.Command("secret-tool", "store", "--label=NBIA Data Retriever secrets key", "service", keychainService, "account", keychainAccount)
+		store.Stdin = strings.NewReader(encoded)
+	}
+	if out, err := store.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to store the key in the keychain: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	// security -i reports failed commands without failing itself
+	if stored, err := keychainLookup(); err != nil || stored != encoded {
+		return nil, fmt.Errorf("failed to store the key in the keychain: it cannot be read back (%v)", err)
+	}
+	logger.Infof("Created the secrets key in the %s keychain entry %s", runtime.GOOS, keychainService)
+	return key, nil
+}
+
+// keychainLookup returns the encoded key kept in the OS keychain, or an empty
+// string when there is no entry
+func keychainLookup() (string, error) {
+	var lookup *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		lookup = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	case "linux", "freebsd":
+		lookup = exec.Command("secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+	default:
+		return "", fmt.Errorf("--secret-key keychain is not supported on %s, use --secret-key passphrase", runtime.GOOS)
+	}
+	var stderr strings.Builder
+	lookup.Stderr = &stderr
+	out, err := lookup.Output()
+	switch {
+	case err == nil && strings.TrimSpace(string(out)) == "":
+		return "", fmt.Errorf("the keychain entry %s is empty", keychainService)
+	case err == nil:
+		return strings.TrimSpace(string(out)), nil
+	case errors.Is(err, exec.ErrNotFound):
+		return "", fmt.Errorf("--secret-key keychain requires %s in PATH", lookup.Args[0])
+	case keychainEntryMissing(err, stderr.String()):
+		return "", nil
+	}
+	return "", fmt.Errorf("failed to read the key from the keychain: %v: %s", err, strings.TrimSpace(stderr.String()))
+}
+
+// keychainEntryMissing reports whether a failed keychain lookup means that there
+// is no entry: security exits with 44 (errSecItemNotFound), secret-tool with 1 and
+// no message, while other failures print why
+func keychainEntryMissing(err error, stderr string) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	if runtime.GOOS == "darwin" {
+		return exitErr.ExitCode() == 44
+	}
+	return exitErr.ExitCode() == 1 && strings.TrimSpace(stderr) == ""
+}
+
+// aead returns the cipher of an envelope, deriving the key from the passphrase
+// with the salt and iterations of the envelope
+func (k *SecretKey) aead(envelope *secretEnvelope) (cipher.AEAD, error) {
+	key := k.keychain
+	if envelope.KeySource == secretKeyPassphrase {
+		if k.passphrase == "" {
+			return nil, fmt.Errorf("secret was encrypted with a passphrase, set --secret-key passphrase")
+		}
+		var err error
+		if key, err = pbkdf2.Key(sha256.New, k.passphrase, envelope.Salt, envelope.Iterations, 32); err != nil {
+			return nil, err
+		}
+	} else if key == nil {
+		return nil, fmt.Errorf("secret was encrypted with the keychain key, set --secret-key keychain")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts plaintext into an envelope
+func (k *SecretKey) Seal(plaintext []byte) ([]byte, error) {
+	envelope := &secretEnvelope{Encrypted: "aes-256-gcm", KeySource: k.source}
+	if k.source == secretKeyPassphrase {
+		envelope.Salt = make([]byte, 16)
+		if _, err := rand.Read(envelope.Salt); err != nil {
+			return nil, err
+		}
+		envelope.Iterations = pbkdf2Iterations
+	}
+	aead, err := k.aead(envelope)
+	if err != nil {
+		return nil, err
+	}
+	envelope.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(envelope.Nonce); err != nil {
+		return nil, err
+	}
+	envelope.Data = aead.Seal(nil, envelope.Nonce, plaintext, []byte(envelope.KeySource))
+	return json.MarshalIndent(envelope, "", "    ")
+}
+
+// openSecret returns the plaintext of a secret file's content: that of an envelope
+// decrypted with the key, or the content itself when it is not encrypted
+func openSecret(k *SecretKey, content []byte) ([]byte, error) {
+	var envelope secretEnvelope
+	if json.Unmarshal(content, &envelope) != nil || envelope.Encrypted == "" {
+		return content, nil
+	}
+	if envelope.Encrypted != "aes-256-gcm" {
+		return nil, fmt.Errorf("unsupported cipher %q", envelope.Encrypted)
+	}
+	if k == nil {
+		return nil, errSecretEncrypted
+	}
+	aead, err := k.aead(&envelope)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Data, []byte(envelope.KeySource))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt, wrong key or damaged file")
+	}
+	return plaintext, nil
+}
+
+// sealSecret encrypts content with the key, or returns it as it is without one
+func sealSecret(k *SecretKey, content []byte) ([]byte, error) {
+	if k == nil {
+		return content, nil
+	}
+	return k.Seal(content)
+}
+
+// runEncrypt encrypts the secret files given as arguments in place, e.g. a Gen3
+// credentials file passed to --auth
+func runEncrypt(paths []string) error {
+	if secretKey == nil {
+		return fmt.Errorf("encrypt requires --secret-key")
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("encrypt takes the files to encrypt as arguments")
+	}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		plaintext, err := openSecret(secretKey, content)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		sealed, err := secretKey.Seal(plaintext)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if err := writeFileAtomic(path, sealed, 0600); err != nil {
+			return err
+		}
+		fmt.Printf("Encrypted %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// activeSeriesList are the only series this invocation handles; nil without --series-list
+var activeSeriesList SeriesList
+
+// SeriesList is the set of series a run is restricted to, e.g. the failed series of
+// an earlier run to retry them without going through the rest of the input again
+type SeriesList map[string]bool
+
+// seriesListColumns are the header names of the series UID column in the CSVs
+// --series-list reads: failed.csv and the metadata CSVs
+var seriesListColumns = map[string]bool{"series_uid": true, "seriesinstanceuid": true}
+
+// loadSeriesList reads the series UIDs of a --series-list file: one UID per line, a
+// .tcia manifest, or a CSV with a series_uid or SeriesInstanceUID column such as
+// metadata/failed.csv. Lines starting with # are ignored.
+func loadSeriesList(path string) (SeriesList, error) {
+	ids, err := loadIDList(path, seriesListColumns)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%s lists no series", path)
+	}
+	return SeriesList(ids), nil
+}
+
+// loadIDList reads a list of IDs: one per line, or the column of a CSV whose header
+// names one of columns (lower case). Lines starting with # and manifest headers
+// such as ListOfSeriesToDownload= are ignored.
+func loadIDList(path string, columns map[string]bool) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	list := make(map[string]bool)
+	column := 0
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if first {
+			if i := headerColumn(record, columns); i >= 0 {
+				column = i
+				continue
+			}
+		}
+		if column >= len(record) {
+			continue
+		}
+		id := strings.TrimSpace(record[column])
+		// Manifest headers, e.g. ListOfSeriesToDownload=
+		if id == "" || strings.Contains(id, "=") {
+			continue
+		}
+		list[id] = true
+	}
+	return list, nil
+}
+
+// headerColumn returns the index of the first of columns in a CSV header, or -1
+func headerColumn(record []string, columns map[string]bool) int {
+	for i, name := range record {
+		if columns[strings.ToLower(strings.TrimSpace(name))] {
+			return i
+		}
+	}
+	return -1
+}
+
+// Contains reports whether a series is in the list; without a list every series is
+// included
+func (l SeriesList) Contains(seriesUID string) bool {
+	return l == nil || l[seriesUID]
+}
+
+// Select returns the items of the listed series
+func (l SeriesList) Select(files []*FileInfo) []*FileInfo {
+	if l == nil {
+		return files
+	}
+	selected := make([]*FileInfo, 0, len(l))
+	for _, info := range files {
+		if l.Contains(info.SeriesUID) {
+			selected = append(selected, info)
+		}
+	}
+	return selected
+}
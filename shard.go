@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runReportFile is the machine-readable report of a sharded run, and of merged shards
+const runReportFile = "run-report.json"
+
+// activeShard is the part of the input this invocation handles; nil without --shard
+var activeShard *Shard
+
+// Shard selects every item whose series UID hashes to Index (1-based) out of Count,
+// so that array jobs on several machines split a manifest without coordination
+type Shard struct {
+	Index int
+	Count int
+}
+
+// parseShard reads an --shard value such as 3/8
+func parseShard(value string) (*Shard, error) {
+	index, count, ok := strings.Cut(value, "/")
+	if !ok {
+		return nil, fmt.Errorf("%q is not of the form i/N", value)
+	}
+	i, err1 := strconv.Atoi(strings.TrimSpace(index))
+	n, err2 := strconv.Atoi(strings.TrimSpace(count))
+	if err1 != nil || err2 != nil || n < 1 || i < 1 || i > n {
+		return nil, fmt.Errorf("%q is not of the form i/N with 1 <= i <= N", value)
+	}
+	return &Shard{Index: i, Count: n}, nil
+}
+
+// String returns the shard as i/N
+func (s *Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Contains reports whether a series belongs to the shard. All items of a series
+// fall into the same shard; without a shard every series is included.
+func (s *Shard) Contains(seriesUID string) bool {
+	if s == nil {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(seriesUID))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index-1
+}
+
+// Select returns the items of the shard
+func (s *Shard) Select(files []*FileInfo) []*FileInfo {
+	if s == nil {
+		return files
+	}
+	selected := make([]*FileInfo, 0, len(files)/s.Count+1)
+	for _, info := range files {
+		if s.Contains(info.SeriesUID) {
+			selected = append(selected, info)
+		}
+	}
+	return selected
+}
+
+// reportDir returns the directory of the run reports (failed.csv,
+// unavailable-series.txt, complete-subjects.txt): metadata/, or a directory per
+// shard below metadata/shards/ so that shards sharing an output directory keep
+// their reports apart
+func reportDir(output string) string {
+	if activeShard == nil {
+		return filepath.Join(output, "metadata")
+	}
+	return filepath.Join(output, "metadata", "shards",
+		fmt.Sprintf("shard-%d-of-%d", activeShard.Index, activeShard.Count))
+}
+
+// RunReport is the outcome of a sharded run, combined by merge-reports
+type RunReport struct {
+	Shard       string              `json:"shard,omitempty"`  // i/N of a single shard
+	Shards      []string            `json:"shards,omitempty"` // the shards of a merged report
+	Host        string              `json:"host,omitempty"`
+	Input       string              `json:"input,omitempty"`
+	StartedAt   time.Time           `json:"started_at"`
+	FinishedAt  time.Time           `json:"finished_at"`
+	Stats       StatsSnapshot       `json:"stats"`
+	Failures    []FailedItem        `json:"failures,omitempty"`
+	Unavailable []UnavailableSeries `json:"unavailable,omitempty"`
+	Subjects    []SubjectProgress   `json:"subjects,omitempty"`
+}
+
+// writeRunReport saves a report to runReportFile in dir
+func writeRunReport(dir string, report *RunReport) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, runReportFile)
+	return path, writeFileAtomic(path, content, 0644)
+}
+
+// newShardReport builds the report of the current shard run
+func newShardReport(stats *DownloadStats, subjects *SubjectTracker, options *Options) *RunReport {
+	host, _ := os.Hostname()
+	snapshot := stats.Snapshot()
+	snapshot.Workers = nil
+	snapshot.Done = true
+	report := &RunReport{
+		Shard:       activeShard.String(),
+		Host:        host,
+		Input:       options.Input,
+		StartedAt:   stats.StartTime.UTC(),
+		FinishedAt:  time.Now().UTC(),
+		Stats:       snapshot,
+		Failures:    stats.Failures(),
+		Unavailable: unavailableSeries.List(),
+	}
+	if subjects != nil {
+		report.Subjects = subjects.Subjects()
+	}
+	return report
+}
+
+// findRunReports lists the shard reports in the given files or directories. A
+// directory is searched for reports directly, one level down and below
+// metadata/shards/ of an output directory.
+func findRunReports(paths []string) ([]string, error) {
+	var reports []string
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !stat.IsDir() {
+			reports = append(reports, path)
+			continue
+		}
+		for _, pattern := range []string{
+			filepath.Join(path, runReportFile),
+			filepath.Join(path, "*", runReportFile),
+			filepath.Join(path, "metadata", "shards", "*", runReportFile),
+		} {
+			matches, _ := filepath.Glob(pattern)
+			reports = append(reports, matches...)
+		}
+	}
+	return reports, nil
+}
+
+// mergeRunReports combines shard reports into one. A shard reported more than once
+// counts with its latest report; the shards missing from the set are returned.
+func mergeRunReports(reports []*RunReport) (*RunReport, []string, error) {
+	latest := make(map[string]*RunReport)
+	count := 0
+	for _, report := range reports {
+		shard, err := parseShard(report.Shard)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid shard in report: %v", err)
+		}
+		if count == 0 {
+			count = shard.Count
+		} else if shard.Count != count {
+			return nil, nil, fmt.Errorf("reports of shards out of %d and %d cannot be merged", count, shard.Count)
+		}
+		if previous, ok := latest[report.Shard]; !ok || report.FinishedAt.After(previous.FinishedAt) {
+			latest[report.Shard] = report
+		}
+	}
+
+	merged := &RunReport{FinishedAt: time.Now().UTC()}
+	merged.Stats.Done = true
+	subjects := make(map[string]*SubjectProgress)
+	unavailable := make(map[string]UnavailableSeries)
+	var missing []string
+	for i := 1; i <= count; i++ {
+		report, ok := latest[fmt.Sprintf("%d/%d", i, count)]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("%d/%d", i, count))
+			continue
+		}
+		merged.Shards = append(merged.Shards, report.Shard)
+		if merged.StartedAt.IsZero() || report.StartedAt.Before(merged.StartedAt) {
+			merged.StartedAt = report.StartedAt
+		}
+
+		stats := report.Stats
+		merged.Stats.Total += stats.Total
+		merged.Stats.Downloaded += stats.Downloaded
+		merged.Stats.Synced += stats.Synced
+		merged.Stats.Skipped += stats.Skipped
+		merged.Stats.Failed += stats.Failed
+		merged.Stats.Deferred += stats.Deferred
+		merged.Stats.Fallbacks += stats.Fallbacks
+		merged.Stats.BytesDownloaded += stats.BytesDownloaded
+		merged.Stats.Egress = mergeEgress(merged.Stats.Egress, stats.Egress)
+		merged.Stats.ElapsedSeconds = max(merged.Stats.ElapsedSeconds, stats.ElapsedSeconds)
+		merged.Stats.Done = merged.Stats.Done && stats.Done
+
+		merged.Failures = append(merged.Failures, report.Failures...)
+		for _, series := range report.Unavailable {
+			unavailable[series.SeriesUID] = series
+		}
+		// Series of a subject are spread over the shards
+		for _, s := range report.Subjects {
+			subject, ok := subjects[s.SubjectID]
+			if !ok {
+				subject = &SubjectProgress{SubjectID: s.SubjectID, Collection: s.Collection}
+				subjects[s.SubjectID] = subject
+			}
+			subject.Total += s.Total
+			subject.Complete += s.Complete
+			subject.Failed += s.Failed
+		}
+	}
+
+	sort.Slice(merged.Failures, func(i, j int) bool { return merged.Failures[i].SeriesUID < merged.Failures[j].SeriesUID })
+	merged.Stats.FailuresByCode = countFailures(merged.Failures)
+	if merged.Stats.ElapsedSeconds > 0 {
+		merged.Stats.BytesPerSecond = float64(merged.Stats.BytesDownloaded) / merged.Stats.ElapsedSeconds
+	}
+	merged.Stats.UpdatedAt = merged.FinishedAt
+	for _, seriesUID := range sortedKeys(unavailable) {
+		merged.Unavailable = append(merged.Unavailable, unavailable[seriesUID])
+	}
+	for _, subjectID := range sortedKeys(subjects) {
+		merged.Subjects = append(merged.Subjects, *subjects[subjectID])
+	}
+	return merged, missing, nil
+}
+
+// runMergeReports combines the reports of sharded runs into metadata/ of the output
+// directory: run-report.json, failed.csv, unavailable-series.txt and, for reports
+// with subjects, complete-subjects.txt
+func runMergeReports(paths []string, options *Options) error {
+	if len(paths) == 0 {
+		paths = []string{filepath.Join(options.Output, "metadata", "shards")}
+	}
+	files, err := findRunReports(paths)
+	if err != nil {
+		return err
+	}
+
+	var reports []*RunReport
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var report RunReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if report.Shard == "" {
+			// A merged report
+			continue
+		}
+		reports = append(reports, &report)
+	}
+	if len(reports) == 0 {
+		return fmt.Errorf("no shard reports found in %s", strings.Join(paths, ", "))
+	}
+
+	merged, missing, err := mergeRunReports(reports)
+	if err != nil {
+		return err
+	}
+
+	stats := merged.Stats
+	fmt.Printf("=== Merged Summary (%d shards) ===\n", len(merged.Shards))
+	fmt.Printf("Total items: %d\n", stats.Total)
+	fmt.Printf("Downloaded: %d\n", stats.Downloaded)
+	if stats.Synced > 0 {
+		fmt.Printf("Synced: %d\n", stats.Synced)
+	}
+	fmt.Printf("Skipped: %d\n", stats.Skipped)
+	fmt.Printf("Failed: %d\n", stats.Failed)
+	for _, code := range sortedKeys(stats.FailuresByCode) {
+		fmt.Printf("  %s: %d\n", code, stats.FailuresByCode[code])
+	}
+	if stats.Deferred > 0 {
+		fmt.Printf("Deferred (daily quota reached): %d\n", stats.Deferred)
+	}
+	if stats.Fallbacks > 0 {
+		fmt.Printf("Downloaded from an alternate source: %d\n", stats.Fallbacks)
+	}
+	fmt.Printf("Transferred: %s\n", formatBytes(stats.BytesDownloaded))
+	printEgressSummary(stats.Egress, options.EgressPrice)
+	if len(missing) > 0 {
+		fmt.Printf("Missing shards: %s\n", strings.Join(missing, ", "))
+	}
+
+	if err := writeFailedList(options.Output, merged.Failures); err != nil {
+		logger.Warnf("Failed to write %s: %v", failedFile, err)
+	}
+	unavailableSeries.series = merged.Unavailable
+	reportUnavailableSeries(options.Output)
+	if len(merged.Subjects) > 0 {
+		tracker := &SubjectTracker{subjects: make(map[string]*SubjectProgress)}
+		for i := range merged.Subjects {
+			tracker.subjects[merged.Subjects[i].SubjectID] = &merged.Subjects[i]
+		}
+		printSubjectReport(tracker, options.Output)
+	}
+
+	path, err := writeRunReport(filepath.Join(options.Output, "metadata"), merged)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nMerged report saved to %s\n", path)
+	if len(missing) > 0 {
+		return fmt.Errorf("%d of %d shards have no report yet", len(missing), len(missing)+len(merged.Shards))
+	}
+	return nil
+}
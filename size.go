@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// ModalitySize is the share of one modality in a SizeSummary
+type ModalitySize struct {
+	Modality string `json:"modality"`
+	Series   int    `json:"series"`
+	Images   int64  `json:"images"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// SizeSummary is the result of the size command, printed as JSON with --json
+type SizeSummary struct {
+	Patients   int             `json:"patients"`
+	Studies    int             `json:"studies"`
+	Series     int             `json:"series"`
+	Images     int64           `json:"images"`
+	Bytes      int64           `json:"bytes"`
+	Unsized    int             `json:"unsized_series,omitempty"` // series listed without a size
+	Modalities []*ModalitySize `json:"modalities"`
+
+	patients, studies map[string]bool
+	modalities        map[string]*ModalitySize
+}
+
+// add counts one series
+func (s *SizeSummary) add(patient, study, modality string, images, bytes int64) {
+	if s.patients == nil {
+		s.patients, s.studies, s.modalities = make(map[string]bool), make(map[string]bool), make(map[string]*ModalitySize)
+	}
+	if patient != "" {
+		s.patients[patient] = true
+	}
+	if study != "" {
+		s.studies[study] = true
+	}
+	if modality == "" {
+		modality = "unknown"
+	}
+	m, ok := s.modalities[modality]
+	if !ok {
+		m = &ModalitySize{Modality: modality}
+		s.modalities[modality] = m
+	}
+	s.Series++
+	m.Series++
+	s.Images += images
+	m.Images += images
+	s.Bytes += bytes
+	m.Bytes += bytes
+	if bytes <= 0 {
+		s.Unsized++
+	}
+}
+
+// finish fills the counts derived from the series added
+func (s *SizeSummary) finish() {
+	s.Patients, s.Studies = len(s.patients), len(s.studies)
+	s.Modalities = make([]*ModalitySize, 0, len(s.modalities))
+	for _, modality := range sortedKeys(s.modalities) {
+		s.Modalities = append(s.Modalities, s.modalities[modality])
+	}
+}
+
+// sizeOfListing sums the series the NBIA series listing returns for the browse filters
+func sizeOfListing(httpClient *http.Client, authToken *Token, options *Options) (*SizeSummary, error) {
+	var series []*SeriesSummary
+	if err := queryNBIA(httpClient, authToken, endpoints.Series, browseQuery(options), &series); err != nil {
+		return nil, fmt.Errorf("failed to list series: %w", err)
+	}
+	summary := &SizeSummary{}
+	for _, s := range series {
+		summary.add(s.PatientID, s.StudyUID, s.Modality, int64(s.ImageCount), int64(s.FileSize))
+	}
+	summary.finish()
+	return summary, nil
+}
+
+// sizeOfInput sums the items of an input file from their metadata, fetched like
+// for a download and cached in the output directory
+func sizeOfInput(httpClient *http.Client, authToken *Token, options *Options) (*SizeSummary, error) {
+	// Nothing is transferred: s5cmd manifests are read without preparing their copies
+	options.WhatIf = true
+	files, _, err := decodeInputFile(options.Input, httpClient, authToken, options, nil)
+	if err != nil {
+		return nil, err
+	}
+	summary := &SizeSummary{}
+	for _, info := range files {
+		images, _ := strconv.ParseInt(info.NumberOfImages, 10, 64)
+		bytes, _ := strconv.ParseInt(info.FileSize, 10, 64)
+		summary.add(info.SubjectID, info.StudyUID, info.Modality, images, bytes)
+	}
+	summary.finish()
+	return summary, nil
+}
+
+// printSizeSummary prints the totals and the modality breakdown
+func printSizeSummary(summary *SizeSummary) {
+	fmt.Printf("Patients: %d\nStudies:  %d\nSeries:   %d\nImages:   %d\nSize:     %s\n",
+		summary.Patients, summary.Studies, summary.Series, summary.Images, formatBytes(summary.Bytes))
+	if summary.Unsized > 0 {
+		fmt.Printf("          (%d series of unknown size not included)\n", summary.Unsized)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\nMODALITY\tSERIES\tIMAGES\tSIZE\n")
+	for _, m := range summary.Modalities {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", m.Modality, m.Series, m.Images, formatBytes(m.Bytes))
+	}
+	_ = w.Flush()
+}
+
+// runSize reports the size of a collection, patient or study, or of the series of
+// an input file, without downloading anything
+func runSize(httpClient *http.Client, authToken *Token, options *Options) error {
+	var summary *SizeSummary
+	var err error
+	switch {
+	case options.Input != "":
+		summary, err = sizeOfInput(httpClient, authToken, options)
+	case options.Collection != "" || options.PatientID != "":
+		summary, err = sizeOfListing(httpClient, authToken, options)
+	default:
+		return fmt.Errorf("size requires --collection and/or --patient, or --input")
+	}
+	if err != nil {
+		return err
+	}
+
+	if options.JSON {
+		content, err := json.MarshalIndent(summary, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+		return nil
+	}
+	printSizeSummary(summary)
+	return nil
+}
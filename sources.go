@@ -0,0 +1,111 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Kinds of sources a manifest row can list, in the default order of preference
+const (
+	sourceDRS   = "drs"
+	sourceURL   = "url"
+	sourceS3    = "s3"
+	sourceGCS   = "gcs"
+	sourceAzure = "azure"
+	sourceFTP   = "ftp"
+)
+
+// sourceOrder ranks the kinds of sources after the preferred one
+var sourceOrder = []string{sourceDRS, sourceURL, sourceS3, sourceGCS, sourceAzure, sourceFTP}
+
+// sourceColumns maps the spreadsheet columns holding download sources to their kind
+var sourceColumns = map[string]string{
+	"drs_uri":        sourceDRS,
+	"imageUrl":       sourceURL,
+	"s3_url":         sourceS3,
+	"aws_url":        sourceS3,
+	"series_aws_url": sourceS3,
+	"gcs_url":        sourceGCS,
+	"series_gcs_url": sourceGCS,
+	"azure_url":      sourceAzure,
+	"ftp_url":        sourceFTP,
+}
+
+// sourceKind returns the kind of a source URI
+func sourceKind(uri string) string {
+	switch {
+	case strings.HasPrefix(uri, "drs://"):
+		return sourceDRS
+	case strings.HasPrefix(uri, "s3://"):
+		return sourceS3
+	case isGCSURL(uri):
+		return sourceGCS
+	case isAzureURL(uri):
+		return sourceAzure
+	case isFTPURL(uri):
+		return sourceFTP
+	}
+	return sourceURL
+}
+
+// orderSources sorts sources by preference: the preferred kind first, the others
+// in sourceOrder, keeping the order of sources of the same kind
+func orderSources(sources []string, preferred string) []string {
+	rank := func(uri string) int {
+		kind := sourceKind(uri)
+		if kind == preferred {
+			return 0
+		}
+		for i, k := range sourceOrder {
+			if k == kind {
+				return i + 1
+			}
+		}
+		return len(sourceOrder) + 1
+	}
+	ordered := append([]string(nil), sources...)
+	sort.SliceStable(ordered, func(i, j int) bool { return rank(ordered[i]) < rank(ordered[j]) })
+	return ordered
+}
+
+// newSourceItem builds the item of a manifest row listing one or more sources. It
+// downloads from the preferred source and falls back to the others in order. Without
+// a file name the item is named from the server response.
+func newSourceItem(sources []string, fileName, preferred string) *FileInfo {
+	sources = orderSources(sources, preferred)
+	info := (&FileInfo{}).withSource(sources[0])
+	info.SeriesUID = filepath.Base(strings.TrimSuffix(sources[0], "/*"))
+	info.FileName = fileName
+	info.Alternates = sources[1:]
+	return info
+}
+
+// source returns the URI an item is downloaded from
+func (info *FileInfo) source() string {
+	if info.DRSURI != "" {
+		return info.DRSURI
+	}
+	return info.DownloadURL
+}
+
+// withSource returns a copy of an item that downloads from uri instead
+func (info *FileInfo) withSource(uri string) *FileInfo {
+	alternate := *info
+	alternate.DRSURI, alternate.DownloadURL = "", ""
+	if sourceKind(uri) == sourceDRS {
+		alternate.DRSURI = uri
+	} else {
+		alternate.DownloadURL = uri
+	}
+	alternate.Alternates = nil
+	return &alternate
+}
+
+// recordFallback counts an item downloaded from an alternate source
+func (stats *DownloadStats) recordFallback() {
+	if stats != nil {
+		atomic.AddInt32(&stats.Fallbacks, 1)
+	}
+}
@@ -1,18 +1,32 @@
 package main
 
 import (
+	"bufio"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"unicode"
 
-	"github.com/tealeg/xlsx"
+	"github.com/xuri/excelize/v2"
 )
 
-// SpreadSheetDecoder is an interface for decoding spreadsheets
+// utf8BOM starts spreadsheets saved as "CSV UTF-8" by Excel
+const utf8BOM = "\ufeff"
+
+// SpreadSheetDecoder is an interface for reading spreadsheets row by row
 type SpreadSheetDecoder interface {
-	Decode(file *os.File) ([][]string, error)
+	Rows(file *os.File) (RowReader, error)
+}
+
+// RowReader returns the rows of a spreadsheet one at a time
+type RowReader interface {
+	// Read returns the next row and the line it starts on, or io.EOF after the last row
+	Read() ([]string, int, error)
 }
 
 // CSVDecoder decodes CSV files
@@ -22,56 +36,110 @@ type CSVDecoder struct{}
 type TSVDecoder struct{}
 
 // XLSXDecoder decodes XLSX files
-type XLSXDecoder struct{}
+type XLSXDecoder struct {
+	Sheet string // name of the sheet to read, the first one when empty
+}
+
+// Rows reads a CSV file
+func (d *CSVDecoder) Rows(file *os.File) (RowReader, error) {
+	return newSVReader(file, ','), nil
+}
 
-// Decode decodes a CSV file and returns the values from the "imageUrl" or "drs_uri" column
-func (d *CSVDecoder) Decode(file *os.File) ([][]string, error) {
-	return decodesv(file, ',')
+// Rows reads a TSV file
+func (d *TSVDecoder) Rows(file *os.File) (RowReader, error) {
+	return newSVReader(file, '\t'), nil
 }
 
-// Decode decodes a TSV file and returns the values from the "imageUrl" or "drs_uri" column
-func (d *TSVDecoder) Decode(file *os.File) ([][]string, error) {
-	return decodesv(file, '\t')
+// svReader reads a separated value file without loading it into memory
+type svReader struct {
+	reader *csv.Reader
 }
 
-// decodesv decodes a separated value file and returns the values from the "imageUrl" or "drs_uri" column
-func decodesv(file *os.File, separator rune) ([][]string, error) {
-	reader := csv.NewReader(file)
+// newSVReader returns a reader of a separated value file. Rows may have fewer or more
+// fields than the header; a leading BOM is skipped.
+func newSVReader(r io.Reader, separator rune) *svReader {
+	buffered := bufio.NewReader(r)
+	if lead, err := buffered.Peek(len(utf8BOM)); err == nil && string(lead) == utf8BOM {
+		_, _ = buffered.Discard(len(utf8BOM))
+	}
+	reader := csv.NewReader(buffered)
 	reader.Comma = separator
-	records, err := reader.ReadAll()
+	reader.FieldsPerRecord = -1
+	return &svReader{reader: reader}
+}
+
+// Read returns the next row, csv.ParseError carrying the line of malformed quoting
+func (r *svReader) Read() ([]string, int, error) {
+	record, err := r.reader.Read()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return records, nil
+	line, _ := r.reader.FieldPos(0)
+	return record, line, nil
+}
+
+// xlsxReader returns the rows of a sheet of a workbook as they are parsed
+type xlsxReader struct {
+	workbook *excelize.File
+	rows     *excelize.Rows
+	row      int
 }
 
-// Decode decodes an XLSX file and returns the values from the "imageUrl" or "drs_uri" column
-func (d *XLSXDecoder) Decode(file *os.File) ([][]string, error) {
-	stat, err := file.Stat()
+// Rows reads a sheet of an XLSX file. The sheet XML is decoded as it is read, large
+// sheets spilling to temporary files instead of memory.
+func (d *XLSXDecoder) Rows(file *os.File) (RowReader, error) {
+	workbook, err := excelize.OpenReader(file)
 	if err != nil {
-		return nil, fmt.Errorf("could not get file stats: %w", err)
+		return nil, err
+	}
+	sheets := workbook.GetSheetList()
+	sheet := d.Sheet
+	switch {
+	case len(sheets) == 0:
+		workbook.Close()
+		return nil, fmt.Errorf("workbook has no sheets")
+	case sheet == "":
+		sheet = sheets[0]
+		if len(sheets) > 1 {
+			logger.Warnf("%s has %d sheets, reading %q; choose another with --sheet", file.Name(), len(sheets), sheet)
+		}
+	case !slices.Contains(sheets, sheet):
+		workbook.Close()
+		return nil, fmt.Errorf("no sheet %q, the workbook has %s", sheet, strings.Join(sheets, ", "))
 	}
-	size := stat.Size()
-	xlFile, err := xlsx.OpenReaderAt(file, size)
+	rows, err := workbook.Rows(sheet)
 	if err != nil {
+		workbook.Close()
 		return nil, err
 	}
+	return &xlsxReader{workbook: workbook, rows: rows}, nil
+}
 
-	var records [][]string
-	for _, sheet := range xlFile.Sheets {
-		for _, row := range sheet.Rows {
-			var record []string
-			for _, cell := range row.Cells {
-				record = append(record, cell.String())
-			}
-			records = append(records, record)
+// Read returns the next row; the line is the row number of the sheet
+func (r *xlsxReader) Read() ([]string, int, error) {
+	if !r.rows.Next() {
+		if err := r.rows.Error(); err != nil {
+			return nil, 0, err
 		}
+		return nil, 0, io.EOF
 	}
-	return records, nil
+	r.row++
+	record, err := r.rows.Columns()
+	if err != nil {
+		return nil, 0, fmt.Errorf("row %d: %w", r.row, err)
+	}
+	return record, r.row, nil
+}
+
+// Close removes the temporary files of the workbook
+func (r *xlsxReader) Close() error {
+	r.rows.Close()
+	return r.workbook.Close()
 }
 
-// getSpreadsheetDecoder returns a decoder based on the file extension
-func getSpreadsheetDecoder(filename string) (SpreadSheetDecoder, error) {
+// getSpreadsheetDecoder returns a decoder based on the file extension. The sheet
+// selects a sheet of XLSX workbooks.
+func getSpreadsheetDecoder(filename, sheet string) (SpreadSheetDecoder, error) {
 	ext := strings.ToLower(filepath.Ext(filename))
 	switch ext {
 	case ".csv":
@@ -79,133 +147,243 @@ func getSpreadsheetDecoder(filename string) (SpreadSheetDecoder, error) {
 	case ".tsv":
 		return &TSVDecoder{}, nil
 	case ".xlsx":
-		return &XLSXDecoder{}, nil
+		return &XLSXDecoder{Sheet: sheet}, nil
 	default:
 		return nil, fmt.Errorf("unsupported spreadsheet format: %s", ext)
 	}
 }
 
-// decodeSpreadsheet decodes a spreadsheet file and returns a slice of FileInfo objects
-func decodeSpreadsheet(filePath string) ([]*FileInfo, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
+// columnRoles maps the roles of --column-map to the column names they stand for
+var columnRoles = map[string]string{
+	"series":   "SeriesInstanceUID",
+	"instance": "SOPInstanceUID",
+	"url":      "imageUrl",
+	"drs":      "drs_uri",
+	"s3":       "s3_url",
+	"name":     "name",
+}
+
+// parseColumnMap parses --column-map, e.g. "series=SeriesUID,url=download_link",
+// into the column names keyed by the header they are read from
+func parseColumnMap(value string) (map[string]string, error) {
+	columns := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		role, header, ok := strings.Cut(pair, "=")
+		role, header = strings.ToLower(strings.TrimSpace(role)), strings.TrimSpace(header)
+		name, known := columnRoles[role]
+		switch {
+		case !ok || header == "":
+			return nil, fmt.Errorf("%q is not role=column", pair)
+		case !known:
+			return nil, fmt.Errorf("unknown column role %q, expected series, instance, url, drs, s3 or name", role)
+		}
+		columns[headerKey(header)] = name
 	}
-	defer file.Close()
+	return columns, nil
+}
 
-	decoder, err := getSpreadsheetDecoder(filePath)
+// headerKey is the form column names are compared in: without case and whitespace,
+// so that "Series UID" matches "seriesuid"
+func headerKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// Spreadsheet reads the rows of a spreadsheet below its header, finding columns by
+// name so that their order does not matter
+type Spreadsheet struct {
+	path   string
+	file   *os.File
+	rows   RowReader
+	header []string
+}
+
+// SpreadsheetRow is a row of a spreadsheet
+type SpreadsheetRow struct {
+	Line   int
+	fields []string
+}
+
+// Get returns the trimmed field of a column, empty for a column the row lacks
+func (r SpreadsheetRow) Get(column int) string {
+	if column < 0 || column >= len(r.fields) {
+		return ""
+	}
+	return strings.TrimSpace(r.fields[column])
+}
+
+// OpenSpreadsheet opens a spreadsheet, or the --sheet of a workbook, and reads its
+// header row. Columns named in --column-map are renamed to the column of their role.
+// An empty spreadsheet has no header and no rows.
+func OpenSpreadsheet(filePath string, options *Options) (*Spreadsheet, error) {
+	decoder, err := getSpreadsheetDecoder(filePath, options.Sheet)
 	if err != nil {
 		return nil, err
 	}
-
-	records, err := decoder.Decode(file)
+	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(records) == 0 {
-		return []*FileInfo{}, nil
+	rows, err := decoder.Rows(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	sheet := &Spreadsheet{path: filePath, file: file, rows: rows}
+	header, _, err := rows.Read()
+	if err != nil && err != io.EOF {
+		sheet.Close()
+		return nil, sheet.rowError(err)
 	}
-
-	header := records[0]
-	drsURIIndex := -1
-	imageURLIndex := -1
-	nameIndex := -1
 	for i, col := range header {
-		switch col {
-		case "drs_uri":
-			drsURIIndex = i
-		case "imageUrl":
-			imageURLIndex = i
-		case "name":
-			nameIndex = i
+		col = strings.TrimSpace(col)
+		if i == 0 {
+			col = strings.TrimPrefix(col, utf8BOM)
 		}
+		if name, ok := options.ColumnMap[headerKey(col)]; ok {
+			col = name
+		}
+		sheet.header = append(sheet.header, col)
 	}
+	return sheet, nil
+}
 
-	if drsURIIndex == -1 && imageURLIndex == -1 {
-		return nil, fmt.Errorf("no 'drs_uri', 'imageUrl', 'SeriesInstanceUID', or 'Series UID' column found in %s", file.Name())
+// Close closes the file of the spreadsheet
+func (s *Spreadsheet) Close() error {
+	if closer, ok := s.rows.(io.Closer); ok {
+		closer.Close()
 	}
+	return s.file.Close()
+}
 
-	var fileInfos []*FileInfo
-	for _, record := range records[1:] {
-		var fileName string
-		if nameIndex != -1 && len(record) > nameIndex {
-			fileName = record[nameIndex]
-		}
+// Header returns the column names
+func (s *Spreadsheet) Header() []string {
+	return s.header
+}
 
-		if drsURIIndex != -1 {
-			if len(record) > drsURIIndex {
-				uri := record[drsURIIndex]
-				if fileName == "" {
-					fileName = filepath.Base(uri)
-				}
-				fileInfos = append(fileInfos, &FileInfo{
-					DRSURI:    uri,
-					SeriesUID: filepath.Base(uri),
-					FileName:  fileName,
-				})
-			}
-		} else {
-			if len(record) > imageURLIndex {
-				url := record[imageURLIndex]
-				if fileName == "" {
-					fileName = filepath.Base(url)
-				}
-				fileInfos = append(fileInfos, &FileInfo{
-					DownloadURL: url,
-					SeriesUID:   filepath.Base(url),
-					FileName:    fileName,
-				})
+// Column returns the index of the first column with one of the names, or -1. Names
+// match regardless of case and whitespace.
+func (s *Spreadsheet) Column(names ...string) int {
+	for i, col := range s.header {
+		for _, name := range names {
+			if headerKey(col) == headerKey(name) {
+				return i
 			}
 		}
 	}
+	return -1
+}
 
-	return fileInfos, nil
+// Each calls fn with every row below the header in turn. Errors of fn are returned
+// with the line of the row.
+func (s *Spreadsheet) Each(fn func(SpreadsheetRow) error) error {
+	if s.header == nil {
+		return nil
+	}
+	for {
+		fields, line, err := s.rows.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return s.rowError(err)
+		}
+		if err := fn(SpreadsheetRow{Line: line, fields: fields}); err != nil {
+			return fmt.Errorf("%s: line %d: %w", s.path, line, err)
+		}
+	}
 }
 
-var ErrSeriesUIDColumnNotFound = fmt.Errorf("no 'SeriesInstanceUID' column found")
+// rowError names the spreadsheet in a read error; parse errors carry their line
+func (s *Spreadsheet) rowError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	return fmt.Errorf("failed to read %s: %w", s.path, err)
+}
 
-// getSeriesUIDsFromSpreadsheet extracts a list of SeriesInstanceUIDs from a spreadsheet
-func getSeriesUIDsFromSpreadsheet(filePath string) ([]string, error) {
-	file, err := os.Open(filePath)
+// decodeSpreadsheet decodes a spreadsheet file and returns a slice of FileInfo objects.
+// Rows listing several sources (drs_uri, imageUrl, an S3 path) download from the
+// preferred kind and fall back to the others.
+func decodeSpreadsheet(filePath string, options *Options) ([]*FileInfo, error) {
+	sheet, err := OpenSpreadsheet(filePath, options)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	defer sheet.Close()
 
-	decoder, err := getSpreadsheetDecoder(filePath)
+	if sheet.Header() == nil {
+		return []*FileInfo{}, nil
+	}
+
+	var sourceIndexes []int
+	for i, col := range sheet.Header() {
+		for name := range sourceColumns {
+			if headerKey(col) == headerKey(name) {
+				sourceIndexes = append(sourceIndexes, i)
+				break
+			}
+		}
+	}
+	if len(sourceIndexes) == 0 {
+		return nil, fmt.Errorf("no 'drs_uri', 'imageUrl', 's3_url', 'SeriesInstanceUID', or 'Series UID' column found in %s, name other columns with --column-map", filePath)
+	}
+	nameIndex := sheet.Column("name")
+
+	var fileInfos []*FileInfo
+	err = sheet.Each(func(row SpreadsheetRow) error {
+		var sources []string
+		for _, i := range sourceIndexes {
+			if source := row.Get(i); source != "" {
+				sources = append(sources, source)
+			}
+		}
+		if len(sources) > 0 {
+			fileInfos = append(fileInfos, newSourceItem(sources, row.Get(nameIndex), options.PreferSource))
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return fileInfos, nil
+}
+
+var ErrSeriesUIDColumnNotFound = fmt.Errorf("no 'SeriesInstanceUID' column found")
 
-	records, err := decoder.Decode(file)
+// getSeriesUIDsFromSpreadsheet extracts a list of SeriesInstanceUIDs from a spreadsheet
+func getSeriesUIDsFromSpreadsheet(filePath string, options *Options) ([]string, error) {
+	sheet, err := OpenSpreadsheet(filePath, options)
 	if err != nil {
 		return nil, err
 	}
+	defer sheet.Close()
 
-	if len(records) == 0 {
+	if sheet.Header() == nil {
 		return []string{}, nil
 	}
-
-	header := records[0]
-	seriesInstanceUIDIndex := -1
-	for i, col := range header {
-		if col == "SeriesInstanceUID" || col == "Series UID" {
-			seriesInstanceUIDIndex = i
-			break
-		}
-	}
-
+	seriesInstanceUIDIndex := sheet.Column("SeriesInstanceUID", "Series UID")
 	if seriesInstanceUIDIndex == -1 {
 		return nil, ErrSeriesUIDColumnNotFound
 	}
 
 	var seriesUIDs []string
-	for _, record := range records[1:] {
-		if len(record) > seriesInstanceUIDIndex {
-			seriesUIDs = append(seriesUIDs, record[seriesInstanceUIDIndex])
+	err = sheet.Each(func(row SpreadsheetRow) error {
+		if uid := row.Get(seriesInstanceUIDIndex); uid != "" {
+			seriesUIDs = append(seriesUIDs, uid)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
 	return seriesUIDs, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTransferStalled is returned for transfers aborted by --stall-timeout
+var ErrTransferStalled = errors.New("transfer stalled")
+
+// stallReader cancels a transfer when a read waits longer than timeout for data.
+// Only the time spent waiting in Read counts, not writing the data to disk.
+type stallReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+// newStallReader watches the reads of r, calling cancel once one stalls; a timeout
+// of 0 never cancels
+func newStallReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *stallReader {
+	s := &stallReader{r: r, timeout: timeout}
+	if timeout > 0 {
+		s.timer = time.AfterFunc(timeout, func() {
+			s.stalled.Store(true)
+			cancel()
+		})
+		s.timer.Stop()
+	}
+	return s
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	if s.timer == nil {
+		return s.r.Read(p)
+	}
+	s.timer.Reset(s.timeout)
+	n, err := s.r.Read(p)
+	s.timer.Stop()
+	if err != nil && err != io.EOF && s.stalled.Load() {
+		err = fmt.Errorf("%w: no data received for %s", ErrTransferStalled, s.timeout)
+	}
+	return n, err
+}
+
+// Stop ends the watch
+func (s *stallReader) Stop() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// transferTimeout returns the deadline of a whole HTTP transfer: --series-timeout
+// plus --series-timeout-per-100mb for every 100 MB of the expected size, capped at
+// --series-timeout-max. Items of unknown size get the maximum.
+func (info *FileInfo) transferTimeout(options *Options) time.Duration {
+	fileSize, err := strconv.ParseInt(info.FileSize, 10, 64)
+	if err != nil || fileSize <= 0 {
+		return options.MaxTimeout
+	}
+	timeout := options.SeriesTimeout + time.Duration(fileSize/(100*1024*1024))*options.TimeoutPer100MB
+	return min(timeout, options.MaxTimeout)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stampExt is the extension of the per-series stamp files of --stamp-dir
+const stampExt = ".done"
+
+// stamps writes a stamp per completed series; nil without --stamp-dir
+var stamps *StampDir
+
+// Stamp is the content of <SeriesUID>.done: the series complete on disk and where
+type Stamp struct {
+	SeriesUID   string    `json:"series_uid"`
+	Action      string    `json:"action"` // download, repair, sync or skip (complete already)
+	Path        string    `json:"path"`
+	Collection  string    `json:"collection,omitempty"`
+	SubjectID   string    `json:"subject_id,omitempty"`
+	StudyUID    string    `json:"study_uid,omitempty"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// StampDir is the directory of --stamp-dir, where workflow engines such as Nextflow
+// or Snakemake watch for the stamp of each series instead of parsing logs. A stamp
+// exists only while its series is known to be complete.
+type StampDir struct {
+	dir string
+}
+
+// OpenStampDir creates the stamp directory
+func OpenStampDir(dir string) (*StampDir, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &StampDir{dir: dir}, nil
+}
+
+// path returns the stamp file of a series; the IDs of direct downloads may contain
+// characters unsafe in file names
+func (s *StampDir) path(seriesUID string) string {
+	return filepath.Join(s.dir, bagUnsafeChars.ReplaceAllString(seriesUID, "_")+stampExt)
+}
+
+// Done writes the stamp of a completed series. It is written atomically, so a
+// stamp that exists is complete.
+func (s *StampDir) Done(stamp Stamp) {
+	if s == nil || stamp.SeriesUID == "" {
+		return
+	}
+	stamp.CompletedAt = time.Now().UTC()
+	if path, err := filepath.Abs(stamp.Path); err == nil {
+		stamp.Path = path
+	}
+	content, err := json.Marshal(stamp)
+	if err != nil {
+		return
+	}
+	if err := writeFileAtomic(s.path(stamp.SeriesUID), append(content, '\n'), 0644); err != nil {
+		logger.Warnf("Failed to write the stamp of %s: %v", stamp.SeriesUID, err)
+	}
+}
+
+// Remove deletes the stamp of a series that failed, e.g. when a repair of a series
+// stamped by an earlier run did not succeed
+func (s *StampDir) Remove(seriesUID string) {
+	if s == nil {
+		return
+	}
+	if err := os.Remove(s.path(seriesUID)); err != nil && !os.IsNotExist(err) {
+		logger.Warnf("Failed to remove the stamp of %s: %v", seriesUID, err)
+	}
+}
+
+// stamp returns the stamp of an item completed by action. Copied s5cmd series are
+// stamped by the organizer instead, once their SeriesInstanceUID is known.
+func (info *FileInfo) stamp(action string, options *Options) Stamp {
+	path := info.seriesPath(options.Output)
+	switch {
+	case info.DownloadURL != "" || info.DRSURI != "":
+		path = info.directPath(options.Output)
+	case info.S5cmdManifestPath != "":
+		path = filepath.Join(options.Output, info.SeriesUID)
+	case options.NoDecompress && len(info.SOPInstanceUIDs) == 0:
+		path += seriesArchiveExt
+	}
+	return Stamp{
+		SeriesUID:  info.SeriesUID,
+		Action:     action,
+		Path:       path,
+		Collection: info.Collection,
+		SubjectID:  info.SubjectID,
+		StudyUID:   info.StudyUID,
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// activeStats is the download stats of the current run, used to account transferred bytes
+var activeStats *DownloadStats
+
+// Phases of a run reported by --stats-file
+const (
+	phaseMetadata = "metadata"
+	phaseDownload = "download"
+)
+
+// lastMetadataStats is the metadata fetch of the run whose counts are kept in the
+// snapshots of the download phase
+var lastMetadataStats *MetadataStats
+
+// StatsSnapshot is the machine-readable view of DownloadStats written by --stats-file
+type StatsSnapshot struct {
+	Phase           string            `json:"phase"` // metadata, then download
+	Metadata        *MetadataSnapshot `json:"metadata,omitempty"`
+	Total           int32             `json:"total"`
+	Downloaded      int32             `json:"downloaded"`
+	Synced          int32             `json:"synced"`
+	Skipped         int32             `json:"skipped"`
+	Failed          int32             `json:"failed"`
+	Deferred        int32             `json:"deferred"`
+	Fallbacks       int32             `json:"fallbacks,omitempty"`
+	FailuresByCode  map[string]int32  `json:"failures_by_code,omitempty"`
+	Failures        []FailedItem      `json:"failures,omitempty"`
+	BytesDownloaded int64             `json:"bytes_downloaded"`
+	BytesPerSecond  float64           `json:"bytes_per_second"`
+	ElapsedSeconds  float64           `json:"elapsed_seconds"`
+	Egress          []EgressSource    `json:"egress,omitempty"`
+	Workers         []WorkerActivity  `json:"workers"`
+	Done            bool              `json:"done"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+// MetadataSnapshot is the progress of fetching the metadata of the input series
+type MetadataSnapshot struct {
+	Total          int     `json:"total"`
+	Fetched        int32   `json:"fetched"`
+	Cached         int32   `json:"cached"`
+	Revalidated    int32   `json:"revalidated"`
+	Failed         int32   `json:"failed"`
+	Unavailable    int32   `json:"unavailable"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Done           bool    `json:"done"`
+}
+
+// Snapshot returns a consistent copy of the metadata fetch progress
+func (m *MetadataStats) Snapshot() *MetadataSnapshot {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := &MetadataSnapshot{
+		Total:          m.Total,
+		Fetched:        m.Fetched,
+		Cached:         m.Cached,
+		Revalidated:    m.Revalidated,
+		Failed:         m.Failed,
+		Unavailable:    m.Unavailable,
+		ElapsedSeconds: time.Since(m.StartTime).Seconds(),
+	}
+	completed := int(m.Fetched + m.Cached + m.Revalidated + m.Failed + m.Unavailable)
+	snapshot.Done = completed >= m.Total
+	return snapshot
+}
+
+// metadataStatsSnapshot is the --stats-file view of the metadata phase, before any
+// download started
+func metadataStatsSnapshot(m *MetadataStats) StatsSnapshot {
+	return StatsSnapshot{Phase: phaseMetadata, Metadata: m.Snapshot(), UpdatedAt: time.Now()}
+}
+
+// WorkerActivity describes what a single download worker is doing
+type WorkerActivity struct {
+	WorkerID int       `json:"worker_id"`
+	Current  string    `json:"current"`
+	Since    time.Time `json:"since"`
+}
+
+// countingReader adds every byte read to the active download stats and, when it
+// reads the body of an item, reports its progress at most every second
+type countingReader struct {
+	r    io.Reader
+	info *FileInfo
+
+	read     int64
+	reported time.Time
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 && activeStats != nil {
+		atomic.AddInt64(&activeStats.BytesDownloaded, int64(n))
+	}
+	c.read += int64(n)
+	if c.info != nil && progress != nil && time.Since(c.reported) >= progressBytesInterval {
+		c.reported = time.Now()
+		event := itemEvent(ProgressBytes, c.info, 0)
+		event.Bytes = c.read
+		progress.Emit(event)
+	}
+	return n, err
+}
+
+// setWorkerActivity records the item a worker is processing (empty when idle)
+func (stats *DownloadStats) setWorkerActivity(workerID int, current string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	if stats.Workers == nil {
+		stats.Workers = make(map[int]*WorkerActivity)
+	}
+	stats.Workers[workerID] = &WorkerActivity{WorkerID: workerID, Current: current, Since: time.Now()}
+}
+
+// Snapshot returns a consistent copy of the stats for export
+func (stats *DownloadStats) Snapshot() StatsSnapshot {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	snapshot := StatsSnapshot{
+		Phase:           phaseDownload,
+		Metadata:        lastMetadataStats.Snapshot(),
+		Total:           stats.Total,
+		Downloaded:      atomic.LoadInt32(&stats.Downloaded),
+		Synced:          atomic.LoadInt32(&stats.Synced),
+		Skipped:         atomic.LoadInt32(&stats.Skipped),
+		Failed:          atomic.LoadInt32(&stats.Failed),
+		Deferred:        atomic.LoadInt32(&stats.Deferred),
+		Fallbacks:       atomic.LoadInt32(&stats.Fallbacks),
+		BytesDownloaded: atomic.LoadInt64(&stats.BytesDownloaded),
+		ElapsedSeconds:  time.Since(stats.StartTime).Seconds(),
+		FailuresByCode:  countFailures(stats.failures),
+		Failures:        sortedFailures(stats.failures),
+		Egress:          stats.egressSources(),
+		UpdatedAt:       time.Now(),
+	}
+	for id := 1; id <= len(stats.Workers); id++ {
+		if activity, ok := stats.Workers[id]; ok {
+			snapshot.Workers = append(snapshot.Workers, *activity)
+		}
+	}
+
+	// Instantaneous rate since the previous snapshot
+	if !stats.lastSampleTime.IsZero() {
+		if dt := snapshot.UpdatedAt.Sub(stats.lastSampleTime).Seconds(); dt > 0 {
+			snapshot.BytesPerSecond = float64(snapshot.BytesDownloaded-stats.lastSampleBytes) / dt
+		}
+	}
+	stats.lastSampleTime = snapshot.UpdatedAt
+	stats.lastSampleBytes = snapshot.BytesDownloaded
+
+	return snapshot
+}
+
+// writeStatsFile atomically writes a stats snapshot to path
+func writeStatsFile(path string, snapshot StatsSnapshot) error {
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tempFile := path + ".tmp"
+	if err := os.WriteFile(tempFile, content, 0644); err != nil {
+		return err
+	}
+	return renameFile(tempFile, path)
+}
+
+// startStatsWriter periodically exports stats to path until the returned stop function is called.
+// The stop function writes a final snapshot marked as done.
+func startStatsWriter(path string, stats *DownloadStats, interval time.Duration) func() {
+	return startSnapshotWriter(path, stats.Snapshot, interval, true)
+}
+
+// startSnapshotWriter periodically writes the snapshots of take to path until the
+// returned stop function is called, which writes a final one, marked as done when
+// the run ends with it
+func startSnapshotWriter(path string, take func() StatsSnapshot, interval time.Duration, final bool) func() {
+	if path == "" {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := writeStatsFile(path, take()); err != nil {
+					logger.Debugf("Failed to write stats file %s: %v", path, err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-finished
+		snapshot := take()
+		snapshot.Done = final
+		if err := writeStatsFile(path, snapshot); err != nil {
+			logger.Warnf("Failed to write stats file %s: %v", path, err)
+		}
+	}
+}
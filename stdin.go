@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// stdinInput is the --input value that reads series UIDs or URLs from standard input
+const stdinInput = "-"
+
+// streamQueueSize bounds the items resolved ahead of the download workers
+func streamQueueSize(workers int) int {
+	return 2 * max(1, workers)
+}
+
+// addItem counts an item queued after the run started
+func (stats *DownloadStats) addItem(info *FileInfo) {
+	stats.mu.Lock()
+	stats.Total++
+	stats.mu.Unlock()
+	atomic.AddInt64(&stats.RemainingBytes, info.expectedBytes())
+}
+
+// streamInputExts are the line-based input formats --stream reads row by row
+var streamInputExts = map[string]bool{".tcia": true, ".s5cmd": true, ".txt": true}
+
+// openStreamInput opens the input read by streamInput: standard input, or the
+// --input file with --stream
+func openStreamInput(options *Options) (io.ReadCloser, error) {
+	if options.Input == stdinInput {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(options.Input)
+}
+
+// streamInput reads one series UID or URL per line from r and passes every resolved
+// item to emit as soon as its line arrives. Blank lines, # comments and the
+// key=value header lines of TCIA manifests are skipped, so manifests can be piped
+// in as well. URLs are downloaded directly (drs:// through Gen3); series UIDs are
+// resolved with the metadata API; s5cmd cp lines, and the bare s3:// lines of
+// .s5cmd manifests, become s5cmd jobs like in decodeS5cmd. Of the lines read only
+// the text is kept, to skip duplicates; the items are not held once emitted.
+func streamInput(r io.Reader, httpClient *http.Client, authToken *Token, options *Options, s5cmdMap map[string]string, emit func(*FileInfo)) error {
+	apiCache := NewAPIResponseCache(options.Output, options.APICacheTTL)
+	seen := make(map[string]struct{})
+	s5cmdInput := strings.ToLower(filepath.Ext(options.Input)) == ".s5cmd"
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, ok := seen[line]; ok {
+			continue
+		}
+		seen[line] = struct{}{}
+
+		switch {
+		case strings.Contains(line, "=") && !strings.Contains(line, "://"):
+			// Manifest header, e.g. downloadServerUrl=...
+		case strings.HasPrefix(line, "cp ") || (s5cmdInput && strings.HasPrefix(line, "s3://")):
+			if job := s5cmdJob(line, options.Output, s5cmdMap, options.WhatIf); job != nil && activeShard.Contains(job.SeriesUID) && activeSeriesList.Contains(job.SeriesUID) {
+				emit(job)
+			}
+		case !activeShard.Contains(filepath.Base(line)) || !activeSeriesList.Contains(filepath.Base(line)):
+			// Another shard's item, or one not in --series-list
+		case strings.HasPrefix(line, "drs://"):
+			emit(&FileInfo{DRSURI: line, SeriesUID: filepath.Base(line)})
+		case strings.Contains(line, "://"):
+			emit(&FileInfo{DownloadURL: line, SeriesUID: filepath.Base(line)})
+		default:
+			files, action := fetchSeriesMetadata(line, httpClient, authToken, options, apiCache, 0)
+			if action == "failed" && len(files) == 0 {
+				logger.Warnf("Skipping %s from %s: no metadata", line, streamInputName(options))
+			}
+			for _, info := range files {
+				emit(info)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %v", streamInputName(options), err)
+	}
+	return nil
+}
+
+// readStreamInput opens the streamed input and passes its items to emit
+func readStreamInput(httpClient *http.Client, authToken *Token, options *Options, s5cmdMap map[string]string, emit func(*FileInfo)) error {
+	r, err := openStreamInput(options)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return streamInput(r, httpClient, authToken, options, s5cmdMap, emit)
+}
+
+// streamInputName names the streamed input in messages
+func streamInputName(options *Options) string {
+	if options.Input == stdinInput {
+		return "standard input"
+	}
+	return options.Input
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storeIndexFile lists the series packed into container files, below metadata/
+const storeIndexFile = "store-index.json"
+
+// sqlarBatchSize limits the directories passed to one sqlite3 invocation
+const sqlarBatchSize = 200
+
+// contentStore is the index of packed series; nil when nothing was ever packed
+var contentStore *ContentStore
+
+// StoredSeries is the index entry of a series packed into a container
+type StoredSeries struct {
+	Container string    `json:"container"` // relative to the output directory
+	Prefix    string    `json:"prefix"`    // entry name prefix of the series files
+	Files     int       `json:"files"`
+	Bytes     int64     `json:"bytes"`
+	StoredAt  time.Time `json:"stored_at"`
+}
+
+// ContentStore packs extracted series into one container file per collection,
+// a SQLite archive (sqlar) or a ZIP, for file systems that cannot handle millions
+// of inodes. Entry names are the usual Subject/Study/Series paths.
+type ContentStore struct {
+	Kind string // sqlar or zip; empty when only the index of an earlier run is used
+
+	output string
+	mu     sync.Mutex
+	index  map[string]StoredSeries
+}
+
+// LoadContentStore reads the store index. It returns nil when no store is
+// requested and no series were packed before.
+func LoadContentStore(output, kind string) (*ContentStore, error) {
+	s := &ContentStore{Kind: kind, output: output, index: make(map[string]StoredSeries)}
+	data, err := os.ReadFile(s.indexPath())
+	if os.IsNotExist(err) {
+		if kind == "" {
+			return nil, nil
+		}
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", s.indexPath(), err)
+	}
+	return s, nil
+}
+
+func (s *ContentStore) indexPath() string {
+	return filepath.Join(s.output, "metadata", storeIndexFile)
+}
+
+// Lookup returns where a series was packed
+func (s *ContentStore) Lookup(seriesUID string) (StoredSeries, bool) {
+	if s == nil {
+		return StoredSeries{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.index[seriesUID]
+	return stored, ok
+}
+
+// SeriesUIDs returns the packed series, sorted
+func (s *ContentStore) SeriesUIDs() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.index)
+}
+
+// save atomically writes the index (caller holds the state lock). With --shared the
+// series other invocations packed in the meantime are merged in first.
+func (s *ContentStore) save() error {
+	if outputLock.Shared() {
+		var saved map[string]StoredSeries
+		if data, err := os.ReadFile(s.indexPath()); err == nil && json.Unmarshal(data, &saved) == nil {
+			s.mu.Lock()
+			for seriesUID, stored := range saved {
+				if _, ok := s.index[seriesUID]; !ok {
+					s.index[seriesUID] = stored
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.index, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.indexPath(), data, 0644)
+}
+
+// containerName returns the container file of a collection, relative to the output directory
+func (s *ContentStore) containerName(collection string) string {
+	if collection == "" {
+		collection = "unknown"
+	}
+	return bagUnsafeChars.ReplaceAllString(collection, "_") + "." + s.Kind
+}
+
+// storeEntry describes one extracted file to pack
+type storeEntry struct {
+	path string
+	name string // slash-separated path below the output directory
+}
+
+// seriesEntries lists the files of an extracted series directory
+func seriesEntries(output, dir string) ([]storeEntry, int64, error) {
+	var entries []storeEntry
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(output, path)
+		entries = append(entries, storeEntry{path: path, name: filepath.ToSlash(rel)})
+		size += info.Size()
+		return nil
+	})
+	return entries, size, err
+}
+
+// Pack moves the extracted series among files into their collection containers,
+// replacing earlier copies, and removes the directories once the container and
+// index are written. It returns the number of series packed.
+func (s *ContentStore) Pack(files []*FileInfo) (int, error) {
+	byContainer := make(map[string][]*FileInfo)
+	for _, info := range extractedSeries(files, s.output) {
+		name := s.containerName(info.Collection)
+		byContainer[name] = append(byContainer[name], info)
+	}
+
+	packed := 0
+	for _, name := range sortedKeys(byContainer) {
+		series := byContainer[name]
+		container := filepath.Join(s.output, name)
+
+		stored := make(map[string]StoredSeries, len(series))
+		var entries []storeEntry
+		for _, info := range series {
+			seriesFiles, size, err := seriesEntries(s.output, info.seriesPath(s.output))
+			if err != nil {
+				return packed, err
+			}
+			prefix, _ := filepath.Rel(s.output, info.seriesPath(s.output))
+			stored[info.SeriesUID] = StoredSeries{
+				Container: name,
+				Prefix:    filepath.ToSlash(prefix) + "/",
+				Files:     len(seriesFiles),
+				Bytes:     size,
+				StoredAt:  time.Now().UTC(),
+			}
+			entries = append(entries, seriesFiles...)
+		}
+
+		// Containers are shared by the invocations on the output directory
+		err := outputLock.WithState(func() (err error) {
+			if s.Kind == "sqlar" {
+				if container, err = filepath.Abs(container); err == nil {
+					err = packSQLAR(container, s.output, series)
+				}
+			} else {
+				err = packZip(container, entries)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to pack %s: %v", name, err)
+			}
+
+			s.mu.Lock()
+			for uid, entry := range stored {
+				s.index[uid] = entry
+			}
+			s.mu.Unlock()
+			if err := s.save(); err != nil {
+				return fmt.Errorf("failed to write %s: %v", storeIndexFile, err)
+			}
+			// Hashed before another invocation rewrites the container
+			if checksums != nil {
+				sum, err := sha256File(container)
+				if err != nil {
+					return fmt.Errorf("failed to hash %s: %v", name, err)
+				}
+				checksums.Add(filepath.Join(s.output, name), sum)
+			}
+			return nil
+		})
+		if err != nil {
+			return packed, err
+		}
+
+		// The series are safely in the container; drop the loose files
+		for _, info := range series {
+			if err := os.RemoveAll(info.seriesPath(s.output)); err != nil {
+				logger.Warnf("Failed to remove %s after packing: %v", info.seriesPath(s.output), err)
+			}
+			checksums.RemoveDir(info.seriesPath(s.output))
+			// Study and subject directories go once empty; Remove fails on the others
+			studyDir := filepath.Dir(info.seriesPath(s.output))
+			if os.Remove(studyDir) == nil {
+				os.Remove(filepath.Dir(studyDir))
+			}
+			events.Record(Event{Action: "store", SeriesUID: info.SeriesUID, Path: name})
+		}
+		packed += len(series)
+		fmt.Printf("Packed %d series into %s\n", len(series), container)
+	}
+	return packed, nil
+}
+
+// packZip rewrites the collection ZIP with the new entries. Existing entries are
+// copied without recompression, except those of series being replaced.
+func packZip(container string, entries []storeEntry) error {
+	replaced := make(map[string]bool)
+	for _, entry := range entries {
+		replaced[seriesPrefix(entry.name)] = true
+	}
+
+	tempPath := container + ".tmp"
+	out, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+	w := zip.NewWriter(out)
+
+	if reader, err := zip.OpenReader(container); err == nil {
+		for _, f := range reader.File {
+			if replaced[seriesPrefix(f.Name)] {
+				continue
+			}
+			if err := w.Copy(f); err != nil {
+				reader.Close()
+				out.Close()
+				return err
+			}
+		}
+		reader.Close()
+	} else if !os.IsNotExist(err) {
+		out.Close()
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := addZipEntry(w, entry); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return renameFile(tempPath, container)
+}
+
+func addZipEntry(w *zip.Writer, entry storeEntry) error {
+	f, err := os.Open(entry.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(stat)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.name
+	header.Method = zip.Deflate
+	fw, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+// seriesPrefix returns the Subject/Study/Series part of an entry name
+func seriesPrefix(name string) string {
+	parts := strings.SplitN(name, "/", 4)
+	if len(parts) < 4 {
+		return name
+	}
+	return strings.Join(parts[:3], "/")
+}
+
+// packSQLAR adds the series directories to a SQLite archive with the sqlite3
+// command-line tool, which creates the archive if needed and replaces changed files
+func packSQLAR(container, output string, series []*FileInfo) error {
+	dirs := make([]string, 0, len(series))
+	for _, info := range series {
+		rel, _ := filepath.Rel(output, info.seriesPath(output))
+		dirs = append(dirs, rel)
+	}
+	sort.Strings(dirs)
+
+	for start := 0; start < len(dirs); start += sqlarBatchSize {
+		end := min(start+sqlarBatchSize, len(dirs))
+		args := append([]string{container, "-A", "--update", "--directory", output}, dirs[start:end]...)
+		if out, err := exec.Command("sqlite3", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("sqlite3 failed: %v\nOutput: %s", err, out)
+		}
+	}
+	return nil
+}
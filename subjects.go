@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// SubjectProgress is the completion state of all requested series of one patient
+type SubjectProgress struct {
+	SubjectID  string `json:"subject_id"`
+	Collection string `json:"collection"`
+	Total      int    `json:"total"`
+	Complete   int    `json:"complete"`
+	Failed     int    `json:"failed"`
+}
+
+// Done reports whether every requested series of the subject is present and verified
+func (s *SubjectProgress) Done() bool {
+	return s.Complete == s.Total
+}
+
+// SubjectTracker aggregates series outcomes per SubjectID, since analysis pipelines
+// usually need complete patients rather than a share of series
+type SubjectTracker struct {
+	mu       sync.Mutex
+	subjects map[string]*SubjectProgress
+}
+
+// NewSubjectTracker counts the series queued for each subject. Items without a
+// SubjectID (spreadsheet and s5cmd input) are not tracked.
+func NewSubjectTracker(files []*FileInfo) *SubjectTracker {
+	t := &SubjectTracker{subjects: make(map[string]*SubjectProgress)}
+	for _, info := range files {
+		t.Add(info)
+	}
+	return t
+}
+
+// Add counts a series queued after the tracker was created, e.g. streamed from stdin
+func (t *SubjectTracker) Add(info *FileInfo) {
+	if t == nil || info.SubjectID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	subject, ok := t.subjects[info.SubjectID]
+	if !ok {
+		subject = &SubjectProgress{SubjectID: info.SubjectID, Collection: info.Collection}
+		t.subjects[info.SubjectID] = subject
+	}
+	subject.Total++
+}
+
+// Record adds the outcome of a processed series; complete means it was downloaded,
+// synced or found present with the expected size
+func (t *SubjectTracker) Record(info *FileInfo, complete bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	subject, ok := t.subjects[info.SubjectID]
+	if !ok {
+		return
+	}
+	if complete {
+		subject.Complete++
+	} else {
+		subject.Failed++
+	}
+}
+
+// Retry forgets a failed item of a subject, queued again
+func (t *SubjectTracker) Retry(info *FileInfo) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if subject, ok := t.subjects[info.SubjectID]; ok && subject.Failed > 0 {
+		subject.Failed--
+	}
+}
+
+// Counts returns the number of complete subjects and the number tracked
+func (t *SubjectTracker) Counts() (int, int) {
+	if t == nil {
+		return 0, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	complete := 0
+	for _, subject := range t.subjects {
+		if subject.Done() {
+			complete++
+		}
+	}
+	return complete, len(t.subjects)
+}
+
+// Subjects returns a copy of the per-subject progress sorted by SubjectID
+func (t *SubjectTracker) Subjects() []SubjectProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]SubjectProgress, 0, len(t.subjects))
+	for _, subject := range t.subjects {
+		list = append(list, *subject)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SubjectID < list[j].SubjectID })
+	return list
+}
+
+// printSubjectReport prints the per-subject summary and saves the complete subjects
+// to complete-subjects.txt in the report directory for downstream pipelines
+func printSubjectReport(t *SubjectTracker, output string) {
+	if t == nil {
+		return
+	}
+	subjects := t.Subjects()
+	if len(subjects) == 0 {
+		fmt.Println("\nNo subject information available for this input")
+		return
+	}
+
+	var completeIDs []string
+	fmt.Println("\n=== Subject Summary ===")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "SUBJECT\tCOLLECTION\tSERIES\tCOMPLETE\tFAILED\tPENDING\tSTATUS\n")
+	for _, s := range subjects {
+		status := "incomplete"
+		if s.Done() {
+			status = "complete"
+			completeIDs = append(completeIDs, s.SubjectID)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\n", s.SubjectID, s.Collection, s.Total, s.Complete,
+			s.Failed, s.Total-s.Complete-s.Failed, status)
+	}
+	_ = w.Flush()
+	fmt.Printf("Complete subjects: %d/%d\n", len(completeIDs), len(subjects))
+
+	path := filepath.Join(reportDir(output), "complete-subjects.txt")
+	content := strings.Join(completeIDs, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Warnf("Failed to create %s: %v", filepath.Dir(path), err)
+	} else if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		logger.Warnf("Failed to write %s: %v", path, err)
+	}
+}
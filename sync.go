@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// updatedSinceLayout is the date format of --updated-since
+const updatedSinceLayout = "2006-01-02"
+
+// listedChange reports whether the listing describes another version of a series than
+// the metadata it was downloaded with: NBIA revises series in place, adding or
+// replacing images
+func listedChange(listed *FileInfo, output string) (bool, string) {
+	cached, err := loadMetadataFromCache(getMetadataCachePath(output, listed.SeriesUID))
+	if err != nil {
+		return false, ""
+	}
+	if listed.NumberOfImages != "" && cached.NumberOfImages != "" && listed.NumberOfImages != cached.NumberOfImages {
+		return true, fmt.Sprintf("%s images on the server, %s downloaded", listed.NumberOfImages, cached.NumberOfImages)
+	}
+	if listed.FileSize != "" && cached.FileSize != "" && listed.FileSize != cached.FileSize {
+		return true, fmt.Sprintf("%s bytes on the server, %s downloaded", listed.FileSize, cached.FileSize)
+	}
+	return false, ""
+}
+
+// updatedSeries returns the UIDs of the series NBIA reports as added or changed since a date
+func updatedSeries(httpClient *http.Client, authToken *Token, since time.Time) (map[string]bool, error) {
+	series, err := listUpdatedSeries(httpClient, authToken, since)
+	if err != nil {
+		return nil, err
+	}
+	updated := make(map[string]bool, len(series))
+	for _, s := range series {
+		updated[s.SeriesUID] = true
+	}
+	return updated, nil
+}
+
+// syncSeries lists the current series of the collection and returns the items of
+// those that are new or changed compared to the output directory, with their full
+// metadata. With --updated-since only series NBIA reports as updated since then are
+// considered. Series removed from the collection are left alone; diff lists them.
+func syncSeries(httpClient *http.Client, authToken *Token, options *Options) ([]*FileInfo, error) {
+	var series []*SeriesSummary
+	if err := queryNBIA(httpClient, authToken, endpoints.Series, browseQuery(options), &series); err != nil {
+		return nil, fmt.Errorf("failed to list series: %w", err)
+	}
+	listed := listedFiles(series)
+	fmt.Printf("Collection %s lists %d series\n", options.Collection, len(listed))
+
+	if !options.UpdatedSince.IsZero() {
+		updated, err := updatedSeries(httpClient, authToken, options.UpdatedSince)
+		if err != nil {
+			return nil, err
+		}
+		selected := listed[:0]
+		for _, info := range listed {
+			if updated[info.SeriesUID] {
+				selected = append(selected, info)
+			}
+		}
+		fmt.Printf("%d series updated since %s\n", len(selected), options.UpdatedSince.Format(updatedSinceLayout))
+		listed = selected
+	}
+
+	var added, changed []string
+	for _, info := range listed {
+		state, reason := info.LocalState(options.Output, options.NoDecompress)
+		switch {
+		case state == StateMissing:
+			added = append(added, info.SeriesUID)
+		case state == StateInvalid:
+			logger.Debugf("%s changed: %s", info.SeriesUID, reason)
+			changed = append(changed, info.SeriesUID)
+		default:
+			if isChanged, reason := listedChange(info, options.Output); isChanged {
+				logger.Debugf("%s changed: %s", info.SeriesUID, reason)
+				changed = append(changed, info.SeriesUID)
+			}
+		}
+	}
+	fmt.Printf("%d new, %d changed, %d up to date\n", len(added), len(changed), len(listed)-len(added)-len(changed))
+
+	files, err := FetchMetadataForSeriesUIDs(added, httpClient, authToken, options)
+	if err != nil {
+		return nil, err
+	}
+	if len(changed) > 0 {
+		// The cached metadata describes the version on disk
+		refresh := *options
+		refresh.RevalidateMeta = !options.WhatIf && !options.RefreshMetadata
+		updated, err := FetchMetadataForSeriesUIDs(changed, httpClient, authToken, &refresh)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, updated...)
+	}
+	if len(files) == 0 && !options.WhatIf {
+		fmt.Fprintf(os.Stderr, "%s is up to date\n", options.Output)
+	}
+	return files, nil
+}
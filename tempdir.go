@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+)
+
+// transferTempDir holds partial downloads, extractions and s5cmd copies with
+// --temp-dir; "" keeps them next to their final location
+var transferTempDir string
+
+// transferTempPath returns where the transfer of finalPath is kept until it is
+// complete, finalPath+suffix by default. Under --temp-dir the name is derived from
+// the final path, so that the next run finds an interrupted transfer again.
+func transferTempPath(finalPath, suffix string) string {
+	if transferTempDir == "" {
+		return finalPath + suffix
+	}
+	key := finalPath
+	if abs, err := filepath.Abs(finalPath); err == nil {
+		key = abs
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(transferTempDir, hex.EncodeToString(sum[:6])+"-"+filepath.Base(finalPath)+suffix)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// throttleRecoverAfter is the number of consecutive accepted requests after which
+// the shared penalty is halved again
+const throttleRecoverAfter = 10
+
+// Limits of courtesy mode, after TCIA's request to keep automated access light:
+// few parallel transfers and requests spread out in time
+const (
+	courtesyInterval        = 500 * time.Millisecond // at most 2 requests per second
+	courtesyJitter          = 0.5
+	courtesyTransfers       = 2
+	courtesyMetadataWorkers = 3
+	courtesyRequestDelay    = time.Second
+)
+
+// courtesyTrigger rate-limit responses within courtesyWindow switch courtesy mode on
+const (
+	courtesyTrigger = 3
+	courtesyWindow  = 5 * time.Minute
+)
+
+// throttle paces requests of all workers; nil disables coordination
+var throttle *Throttle
+
+// Throttle shares rate-limit backoff across workers: when the server answers one
+// worker with 429 or 503, every worker pauses, instead of each discovering the
+// limit on its own and retrying in lockstep
+type Throttle struct {
+	base time.Duration
+	max  time.Duration
+
+	mu        sync.Mutex
+	until     time.Time     // no request starts before this time
+	penalty   time.Duration // current pause, doubled on every rate-limit response
+	successes int
+
+	// Courtesy mode spaces requests and caps the transfers running at once
+	courtesy  bool
+	next      time.Time   // the next request starts no earlier, in courtesy mode
+	limited   []time.Time // recent rate-limit responses
+	transfers int
+	admitted  *sync.Cond
+}
+
+// NewThrottle creates a throttle whose pauses start at base and are capped at max
+func NewThrottle(base, max time.Duration) *Throttle {
+	if base <= 0 {
+		base = time.Second
+	}
+	if max < base {
+		max = base
+	}
+	t := &Throttle{base: base, max: max}
+	t.admitted = sync.NewCond(&t.mu)
+	return t
+}
+
+// EnableCourtesy switches courtesy mode on for the rest of the run
+func (t *Throttle) EnableCourtesy(reason string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enableCourtesy(reason)
+}
+
+// enableCourtesy switches courtesy mode on (caller holds mu)
+func (t *Throttle) enableCourtesy(reason string) {
+	if t.courtesy {
+		return
+	}
+	t.courtesy = true
+	logger.Warnf("Courtesy mode (%s): at most %d transfers at once and %d requests per second",
+		reason, courtesyTransfers, time.Second/courtesyInterval)
+	events.Record(Event{Action: "courtesy", Detail: reason})
+}
+
+// Courtesy reports whether courtesy mode is on
+func (t *Throttle) Courtesy() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.courtesy
+}
+
+// Admit blocks while courtesy mode is on and its number of transfers is running,
+// and returns the function to call once the transfer is done
+func (t *Throttle) Admit() func() {
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	for t.courtesy && t.transfers >= courtesyTransfers {
+		t.admitted.Wait()
+	}
+	t.transfers++
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		t.transfers--
+		t.mu.Unlock()
+		t.admitted.Signal()
+	}
+}
+
+// Wait blocks until the shared pause is over or ctx is done
+func (t *Throttle) Wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	delay := time.Until(t.until)
+	if t.courtesy {
+		// Requests take turns at the courtesy interval, jittered so they do not
+		// arrive at a regular beat
+		start := time.Now().Add(max(delay, 0))
+		if t.next.After(start) {
+			start = t.next
+		}
+		t.next = start.Add(jitter(courtesyInterval, courtesyJitter))
+		delay = time.Until(start)
+	}
+	t.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Observe updates the shared state from a response. Rate-limit responses pause all
+// workers for the Retry-After time or the doubled penalty; accepted requests
+// gradually lift the penalty.
+func (t *Throttle) Observe(resp *http.Response) {
+	if t == nil || resp == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		t.successes++
+		if t.successes >= throttleRecoverAfter && t.penalty > 0 {
+			t.penalty /= 2
+			if t.penalty < t.base {
+				t.penalty = 0
+			}
+			t.successes = 0
+		}
+		return
+	}
+
+	t.successes = 0
+	now := time.Now()
+	recent := t.limited[:0]
+	for _, at := range t.limited {
+		if now.Sub(at) < courtesyWindow {
+			recent = append(recent, at)
+		}
+	}
+	t.limited = append(recent, now)
+	if len(t.limited) >= courtesyTrigger {
+		t.enableCourtesy(fmt.Sprintf("%d rate-limit responses within %s", len(t.limited), courtesyWindow))
+	}
+	if t.penalty == 0 {
+		t.penalty = t.base
+	} else {
+		t.penalty = min(t.penalty*2, t.max)
+	}
+	pause := t.penalty
+	if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok && retryAfter > pause {
+		pause = min(retryAfter, t.max)
+	}
+	// Jitter keeps the workers from resuming at the same instant
+	until := time.Now().Add(jitter(pause, 0.2))
+	if until.After(t.until) {
+		logger.Warnf("Server is rate limiting (%s), pausing all workers for %s", resp.Status, pause.Round(time.Second))
+		t.until = until
+	}
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// jitter randomizes d by up to ±fraction
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if d <= 0 || fraction <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + fraction*(2*rand.Float64()-1)))
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -34,6 +36,52 @@ type Token struct {
 	username string
 	password string
 	path     string
+
+	// Anonymous tokens send no credentials until the API asks for them
+	anonymous bool
+	loginErr  error
+}
+
+// Grant types of the token request
+const (
+	grantPassword          = "password"
+	grantClientCredentials = "client_credentials"
+)
+
+// clientSecretEnv holds the client secret when --client-secret is not given
+const clientSecretEnv = "NBIA_CLIENT_SECRET"
+
+// OAuthClient is the OAuth client tokens are requested as
+type OAuthClient struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Scope        string
+}
+
+// DefaultOAuthClient is the public NBIA client, logging users in with their password
+var DefaultOAuthClient = OAuthClient{GrantType: grantPassword, ClientID: "NBIA"}
+
+// oauthClient is the client of the current run
+var oauthClient = DefaultOAuthClient
+
+// form returns the token request of the client. Service accounts log in with the
+// client credentials alone; the user name and password are sent with the password grant.
+func (c OAuthClient) form(username, passwd string) url.Values {
+	formData := url.Values{}
+	if c.GrantType == grantPassword {
+		formData.Set("username", username)
+		formData.Set("password", passwd)
+	}
+	formData.Set("client_id", c.ClientID)
+	if c.ClientSecret != "" {
+		formData.Set("client_secret", c.ClientSecret)
+	}
+	formData.Set("grant_type", c.GrantType)
+	if c.Scope != "" {
+		formData.Set("scope", c.Scope)
+	}
+	return formData
 }
 
 // GetAccessToken returns the access token, refreshing if necessary
@@ -61,7 +109,18 @@ func (token *Token) GetAccessToken() (string, error) {
 		return "", fmt.Errorf("failed to refresh token: %v", err)
 	}
 
-	// Copy new token data
+	token.copyFrom(newToken)
+
+	// Save updated token
+	if err := token.dumpInternal(); err != nil {
+		logger.Warnf("Failed to save refreshed token: %v", err)
+	}
+
+	return token.AccessToken, nil
+}
+
+// copyFrom copies the token data of another token (caller must hold lock)
+func (token *Token) copyFrom(newToken *Token) {
 	token.AccessToken = newToken.AccessToken
 	token.SessionState = newToken.SessionState
 	token.ExpiresIn = newToken.ExpiresIn
@@ -72,13 +131,53 @@ func (token *Token) GetAccessToken() (string, error) {
 	token.RefreshToken = newToken.RefreshToken
 	token.TokenType = newToken.TokenType
 	token.ExpiredTime = newToken.ExpiredTime
+}
 
-	// Save updated token
-	if err := token.dumpInternal(); err != nil {
-		logger.Warnf("Failed to save refreshed token: %v", err)
+// NewAnonymousToken returns a token that sends requests without credentials. It logs
+// in with the given credentials only once the API rejects an anonymous request.
+func NewAnonymousToken(username, passwd, path string) *Token {
+	return &Token{username: username, password: passwd, path: path, anonymous: true}
+}
+
+// authorize adds the access token to a request; anonymous tokens add nothing
+func (token *Token) authorize(req *http.Request) error {
+	token.mu.RLock()
+	anonymous := token.anonymous
+	token.mu.RUnlock()
+	if anonymous {
+		return nil
 	}
+	accessToken, err := token.GetAccessToken()
+	if err != nil {
+		return fmt.Errorf("%w: failed to get access token: %v", ErrAuthFailed, err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	return nil
+}
 
-	return token.AccessToken, nil
+// escalate logs an anonymous token in after the API rejected a request sent
+// without credentials, and reports whether the request can be sent again with
+// them. A failed login is not retried: the series needing it fail instead.
+func (token *Token) escalate() bool {
+	token.mu.Lock()
+	defer token.mu.Unlock()
+	if !token.anonymous {
+		// Another worker logged in already
+		return true
+	}
+	if token.loginErr != nil {
+		return false
+	}
+	logger.Infof("The API requires a login, logging in as %s", token.username)
+	newToken, err := NewToken(token.username, token.password, token.path)
+	if err != nil {
+		token.loginErr = err
+		logger.Warnf("Login failed, series requiring it will fail: %v", err)
+		return false
+	}
+	token.copyFrom(newToken)
+	token.anonymous = false
+	return true
 }
 
 func makeURL(url_ string, values map[string]interface{}) (string, error) {
@@ -109,6 +208,10 @@ func NewToken(username, passwd, path string) (*Token, error) {
 	if _, err := os.Stat(path); !os.IsNotExist(err) {
 		logger.Infof("restore token from %v", path)
 		err = token.Load(path)
+		if errors.Is(err, errSecretEncrypted) {
+			// Replacing it would store the new token in plain text
+			return nil, fmt.Errorf("token %s: %w", path, err)
+		}
 		if err != nil {
 			logger.Error(err)
 			logger.Infof("create new token")
@@ -117,6 +220,12 @@ func NewToken(username, passwd, path string) (*Token, error) {
 			token.username = username
 			token.password = passwd
 			token.path = path
+			if secretKey != nil {
+				// Encrypts a token saved in plain text
+				if err := token.Dump(path); err != nil {
+					logger.Warnf("Failed to save token: %v", err)
+				}
+			}
 			return token, nil
 		} else {
 			logger.Warn("token expired, create new token")
@@ -140,19 +249,15 @@ func NewToken(username, passwd, path string) (*Token, error) {
 // createNewToken creates a new token from the API
 func createNewToken(username, passwd, path string) (*Token, error) {
 	// Create form data
-	formData := url.Values{}
-	formData.Set("username", username)
-	formData.Set("password", passwd)
-	formData.Set("client_id", "NBIA")
-	formData.Set("grant_type", "password")
+	formData := oauthClient.form(username, passwd)
 
-	req, err := http.NewRequest("POST", TokenUrl, strings.NewReader(formData.Encode()))
+	req, err := http.NewRequest("POST", endpoints.Token, strings.NewReader(formData.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := doRequest(client, req)
+	resp, err := doRequest(client, withCredentials(req))
 	if err != nil {
 		return nil, fmt.Errorf("failed to do request: %v", err)
 	}
@@ -200,12 +305,13 @@ func (token *Token) dumpInternal() error {
 
 	logger.Debugf("saving token to %s", token.path)
 
-	// Create temp file first
-	tempPath := token.path + ".tmp"
-	f, err := os.OpenFile(tempPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	// Create temp file first, uniquely named as invocations sharing the output
+	// directory share the token
+	f, err := os.CreateTemp(filepath.Dir(token.path), filepath.Base(token.path)+".*.tmp")
 	if err != nil {
 		return fmt.Errorf("failed to open token json: %v", err)
 	}
+	tempPath := f.Name()
 
 	// Create a copy without internal fields
 	tokenCopy := struct {
@@ -238,6 +344,11 @@ func (token *Token) dumpInternal() error {
 		os.Remove(tempPath)
 		return fmt.Errorf("failed to marshal token: %v", err)
 	}
+	if content, err = sealSecret(secretKey, content); err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to encrypt token: %v", err)
+	}
 
 	_, err = f.Write(content)
 	if err != nil {
@@ -252,7 +363,7 @@ func (token *Token) dumpInternal() error {
 	}
 
 	// Atomic rename
-	if err := os.Rename(tempPath, token.path); err != nil {
+	if err := renameFile(tempPath, token.path); err != nil {
 		os.Remove(tempPath)
 		return fmt.Errorf("failed to rename token file: %v", err)
 	}
@@ -271,6 +382,10 @@ func (token *Token) Load(path string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read token: %v", err)
 	}
+	if content, err = openSecret(secretKey, content); err != nil {
+		f.Close()
+		return err
+	}
 	err = json.Unmarshal(content, token)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal token: %v", err)
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tokenCacheDirName is the directory below the user cache directory holding tokens
+const tokenCacheDirName = "nbia-data-retriever"
+
+// defaultTokenCacheDir returns the per-user token directory: $XDG_CACHE_HOME (or
+// ~/.cache) on Linux, ~/Library/Caches on macOS and %LocalAppData% on Windows
+func defaultTokenCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, tokenCacheDirName, "tokens"), nil
+}
+
+// tokenFileName names the token of a user. Tokens of other servers than the public
+// NBIA carry the host, so that the same user name on two servers does not collide.
+func tokenFileName(username, tokenURL string) string {
+	name := strings.NewReplacer("/", "_", `\`, "_", ":", "_").Replace(username)
+	if tokenURL != DefaultEndpoints.Token {
+		if u, err := url.Parse(tokenURL); err == nil && u.Host != "" {
+			name += "@" + strings.ReplaceAll(u.Host, ":", "_")
+		}
+	}
+	return name + ".json"
+}
+
+// tokenPath returns where the token of the user is kept, creating the directory,
+// and moves a token saved in the output directory by earlier versions there
+func tokenPath(options *Options) (string, error) {
+	dir := options.TokenCache
+	if dir == "" {
+		var err error
+		if dir, err = defaultTokenCacheDir(); err != nil {
+			return "", fmt.Errorf("no user cache directory, set --token-cache: %v", err)
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create token cache %s: %v", dir, err)
+	}
+	path := filepath.Join(dir, tokenFileName(tokenOwner(options), endpoints.Token))
+	if options.GrantType != grantClientCredentials {
+		migrateToken(filepath.Join(options.Output, fmt.Sprintf("%s.json", options.Username)), path)
+	}
+	return path, nil
+}
+
+// tokenOwner names whom the token is issued to: the user, or the client of a
+// service account
+func tokenOwner(options *Options) string {
+	if options.GrantType == grantClientCredentials {
+		return "client-" + options.ClientID
+	}
+	return options.Username
+}
+
+// migrateToken moves a token from the output directory to the token cache. When the
+// cache has a token already, the one in the output directory is removed.
+func migrateToken(legacy, path string) {
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+	// Only files that hold a token are touched
+	if err := new(Token).Load(legacy); err != nil {
+		logger.Debugf("Leaving %s in place, not a token: %v", legacy, err)
+		return
+	}
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Remove(legacy); err != nil {
+			logger.Warnf("Failed to remove old token %s: %v", legacy, err)
+			return
+		}
+		logger.Infof("Removed old token %s, the token is kept in %s", legacy, path)
+		return
+	}
+	if err := renameFile(legacy, path); err != nil {
+		logger.Warnf("Failed to move token %s to %s: %v", legacy, path, err)
+		return
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		logger.Warnf("Failed to restrict permissions of %s: %v", path, err)
+	}
+	logger.Infof("Moved token %s to %s", legacy, path)
+}
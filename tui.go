@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// activeTUI is the running full-screen display; nil when the single progress line is used
+var activeTUI atomic.Pointer[TUI]
+
+// ansiEscape matches the color sequences written by the console log encoder
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// tuiLogLines is the number of log lines kept for the log pane
+const tuiLogLines = 200
+
+// TUI is a full-screen terminal display with an aggregate bar, one line per worker
+// and a tail of the log. It uses plain ANSI sequences on the alternate screen.
+type TUI struct {
+	stats   *DownloadStats
+	workers int
+	out     *os.File
+
+	mu      sync.Mutex
+	logs    []string
+	partial []byte
+
+	stop     chan struct{}
+	finished chan struct{}
+	stopOnce sync.Once
+	console  io.Writer
+}
+
+// startTUI takes over the terminal until Stop is called. It returns nil when stderr
+// is not a terminal, in which case the regular progress line is used.
+func startTUI(stats *DownloadStats, workers int) *TUI {
+	out := os.Stderr
+	if _, _, ok := terminalSize(out); !ok {
+		logger.Warnf("--tui requires a terminal; falling back to the progress line")
+		return nil
+	}
+	enableVirtualTerminal(out)
+
+	t := &TUI{
+		stats:    stats,
+		workers:  workers,
+		out:      out,
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	t.console = console.Redirect(t)
+
+	// Alternate screen, hidden cursor
+	fmt.Fprint(out, "\033[?1049h\033[?25l")
+	go t.loop()
+	activeTUI.Store(t)
+	return t
+}
+
+// Write collects log output for the log pane
+func (t *TUI) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := ansiEscape.ReplaceAllString(string(t.partial[:i]), "")
+		t.partial = t.partial[i+1:]
+		t.logs = append(t.logs, strings.ReplaceAll(line, "\t", " "))
+	}
+	if len(t.logs) > tuiLogLines {
+		t.logs = t.logs[len(t.logs)-tuiLogLines:]
+	}
+	return len(p), nil
+}
+
+// Stop restores the terminal and replays the log tail to the console
+func (t *TUI) Stop() {
+	if t == nil {
+		return
+	}
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		<-t.finished
+		activeTUI.Store(nil)
+
+		fmt.Fprint(t.out, "\033[?25h\033[?1049l")
+		console.Redirect(t.console)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for _, line := range t.logs {
+			fmt.Fprintln(t.console, line)
+		}
+	})
+}
+
+func (t *TUI) loop() {
+	defer close(t.finished)
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		t.render()
+		select {
+		case <-ticker.C:
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// render redraws the whole screen
+func (t *TUI) render() {
+	width, height, ok := terminalSize(t.out)
+	if !ok {
+		width, height = 80, 24
+	}
+	stats := t.stats
+	now := time.Now()
+
+	stats.mu.Lock()
+	downloaded := atomic.LoadInt32(&stats.Downloaded)
+	synced := atomic.LoadInt32(&stats.Synced)
+	skipped := atomic.LoadInt32(&stats.Skipped)
+	failed := atomic.LoadInt32(&stats.Failed)
+	deferred := atomic.LoadInt32(&stats.Deferred)
+	processed := downloaded + synced + skipped + failed + deferred
+	eta, hasETA := stats.estimateETA(now)
+	rate := stats.throughput.rate
+	workers := make([]WorkerActivity, 0, t.workers)
+	for id := 1; id <= t.workers; id++ {
+		if activity, ok := stats.Workers[id]; ok {
+			workers = append(workers, *activity)
+		} else {
+			workers = append(workers, WorkerActivity{WorkerID: id})
+		}
+	}
+	stats.mu.Unlock()
+
+	var lines []string
+	percentage := 0.0
+	if stats.Total > 0 {
+		percentage = float64(processed) / float64(stats.Total) * 100
+	}
+	header := fmt.Sprintf(" NBIA Data Retriever  [%d/%d] %.1f%%  %s  elapsed %s",
+		processed, stats.Total, percentage, formatRate(rate), now.Sub(stats.StartTime).Round(time.Second))
+	if hasETA {
+		header += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+	}
+	lines = append(lines, header)
+
+	barWidth := width - 4
+	if barWidth < 10 {
+		barWidth = 10
+	}
+	filled := int(percentage / 100 * float64(barWidth))
+	lines = append(lines, " ["+strings.Repeat("#", filled)+strings.Repeat("-", barWidth-filled)+"]")
+
+	counters := fmt.Sprintf(" Downloaded: %d | Synced: %d | Skipped: %d | Failed: %d", downloaded, synced, skipped, failed)
+	if deferred > 0 {
+		counters += fmt.Sprintf(" | Deferred: %d", deferred)
+	}
+	if complete, total := stats.Subjects.Counts(); total > 0 {
+		counters += fmt.Sprintf(" | Subjects: %d/%d", complete, total)
+	}
+	if failed > 0 {
+		// Red failure counter
+		counters = strings.Replace(counters, fmt.Sprintf("Failed: %d", failed), fmt.Sprintf("\033[31mFailed: %d\033[0m", failed), 1)
+	}
+	lines = append(lines, counters, "")
+
+	for _, worker := range workers {
+		if worker.Current == "" {
+			lines = append(lines, fmt.Sprintf(" Worker %-3d idle", worker.WorkerID))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf(" Worker %-3d %s  %s", worker.WorkerID,
+			now.Sub(worker.Since).Round(time.Second), worker.Current))
+	}
+
+	// Remaining rows show the log tail
+	lines = append(lines, "", " Log "+strings.Repeat("-", max(0, width-6)))
+	t.mu.Lock()
+	logRows := height - len(lines) - 1
+	if logRows > len(t.logs) {
+		logRows = len(t.logs)
+	}
+	if logRows > 0 {
+		for _, line := range t.logs[len(t.logs)-logRows:] {
+			lines = append(lines, " "+line)
+		}
+	}
+	t.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, line := range lines {
+		// Leave the last row empty so the screen never scrolls
+		if i >= height-1 {
+			break
+		}
+		if !strings.Contains(line, "\033[") && len(line) > width {
+			line = line[:width]
+		}
+		b.WriteString(line)
+		b.WriteString("\033[K\r\n")
+	}
+	b.WriteString("\033[J")
+	fmt.Fprint(t.out, b.String())
+}
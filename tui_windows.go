@@ -0,0 +1,43 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+)
+
+// consoleScreenBufferInfo mirrors CONSOLE_SCREEN_BUFFER_INFO
+type consoleScreenBufferInfo struct {
+	SizeX, SizeY                                     int16
+	CursorX, CursorY                                 int16
+	Attributes                                       uint16
+	WindowLeft, WindowTop, WindowRight, WindowBottom int16
+	MaxSizeX, MaxSizeY                               int16
+}
+
+// terminalSize returns the columns and rows of the console attached to f
+func terminalSize(f *os.File) (int, int, bool) {
+	var info consoleScreenBufferInfo
+	ret, _, _ := procGetConsoleScreenBufferInfo.Call(f.Fd(), uintptr(unsafe.Pointer(&info)))
+	if ret == 0 {
+		return 0, 0, false
+	}
+	return int(info.WindowRight-info.WindowLeft) + 1, int(info.WindowBottom-info.WindowTop) + 1, true
+}
+
+// enableVirtualTerminal turns on ANSI escape sequence processing for the console
+func enableVirtualTerminal(f *os.File) {
+	const enableVirtualTerminalProcessing = 0x0004
+	var mode uint32
+	if err := syscall.GetConsoleMode(syscall.Handle(f.Fd()), &mode); err == nil {
+		procSetConsoleMode.Call(f.Fd(), uintptr(mode|enableVirtualTerminalProcessing))
+	}
+}
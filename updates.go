@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// listUpdatedSeries returns the series NBIA reports as added or changed since a date,
+// across all collections
+func listUpdatedSeries(httpClient *http.Client, authToken *Token, since time.Time) ([]*SeriesSummary, error) {
+	var series []*SeriesSummary
+	query := map[string]interface{}{"fromDate": since.Format("02/01/2006")}
+	if err := queryNBIA(httpClient, authToken, endpoints.Updated, query, &series); err != nil {
+		return nil, fmt.Errorf("failed to list updated series: %w", err)
+	}
+	return series, nil
+}
+
+// splitCollections reads a comma-separated --collection value
+func splitCollections(value string) []string {
+	var collections []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			collections = append(collections, name)
+		}
+	}
+	return collections
+}
+
+// filterCollections keeps the series of the given collections; none keeps all
+func filterCollections(series []*SeriesSummary, collections []string) []*SeriesSummary {
+	if len(collections) == 0 {
+		return series
+	}
+	var kept []*SeriesSummary
+	for _, s := range series {
+		for _, name := range collections {
+			if strings.EqualFold(s.Collection, name) {
+				kept = append(kept, s)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// runUpdates lists the series added or changed since --since in the collections of
+// --collection, or in all collections, and optionally writes them to a manifest
+// that downloads the changes
+func runUpdates(httpClient *http.Client, authToken *Token, options *Options) error {
+	if options.UpdatedSince.IsZero() {
+		return fmt.Errorf("updates requires --since")
+	}
+	series, err := listUpdatedSeries(httpClient, authToken, options.UpdatedSince)
+	if err != nil {
+		return err
+	}
+	series = filterCollections(series, splitCollections(options.Collection))
+	sort.SliceStable(series, func(i, j int) bool {
+		if series[i].Collection != series[j].Collection {
+			return series[i].Collection < series[j].Collection
+		}
+		if series[i].PatientID != series[j].PatientID {
+			return series[i].PatientID < series[j].PatientID
+		}
+		return series[i].SeriesUID < series[j].SeriesUID
+	})
+
+	if options.JSON {
+		content, err := json.MarshalIndent(series, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+	} else {
+		collections := make(map[string]bool)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "COLLECTION\tPATIENT\tSERIES\tMODALITY\tIMAGES\tSIZE\n")
+		for _, s := range series {
+			collections[s.Collection] = true
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", s.Collection, s.PatientID, s.SeriesUID, s.Modality, s.ImageCount, formatBytes(int64(s.FileSize)))
+		}
+		_ = w.Flush()
+		fmt.Printf("\n%d series in %d collections added or changed since %s\n",
+			len(series), len(collections), options.UpdatedSince.Format(updatedSinceLayout))
+	}
+
+	if options.SaveManifest == "" {
+		return nil
+	}
+	seriesUIDs := make([]string, 0, len(series))
+	for _, s := range series {
+		seriesUIDs = append(seriesUIDs, s.SeriesUID)
+	}
+	if err := writeTCIAManifest(options.SaveManifest, seriesUIDs); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d series to %s\n", len(seriesUIDs), options.SaveManifest)
+	return nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// usageFileName is the run history below the user cache directory
+const usageFileName = "usage.jsonl"
+
+// usageMaxRuns is the number of runs the history keeps; older ones are dropped once
+// it holds twice as many, so most runs only append a line
+const usageMaxRuns = 1000
+
+// UsageRun is the anonymous record of a download run kept in the local run history.
+// It holds counts and timings only: no paths, collections, series or user names, and
+// it never leaves the machine.
+type UsageRun struct {
+	Time        time.Time        `json:"time"`
+	Version     string           `json:"version"`
+	Platform    string           `json:"platform"`
+	Command     string           `json:"command"` // download, sync or apply
+	Workers     int              `json:"workers"`
+	MaxRetries  int              `json:"max_retries"`
+	Items       int32            `json:"items"`
+	Downloaded  int32            `json:"downloaded"`
+	Synced      int32            `json:"synced,omitempty"`
+	Skipped     int32            `json:"skipped"`
+	Failed      int32            `json:"failed"`
+	Deferred    int32            `json:"deferred,omitempty"`
+	Bytes       int64            `json:"bytes"`
+	Seconds     float64          `json:"seconds"`
+	Retries     int              `json:"retries"`
+	RetryCodes  map[string]int   `json:"retry_codes,omitempty"`  // failure codes of the retried attempts
+	FailedCodes map[string]int32 `json:"failed_codes,omitempty"` // failure codes of the failed items
+	ExitStatus  int              `json:"exit_status"`
+}
+
+// UsageRecorder counts the retries of a run for its UsageRun
+type UsageRecorder struct {
+	mu         sync.Mutex
+	retries    int
+	retryCodes map[string]int
+}
+
+// NewUsageRecorder returns a recorder counting the retrying events of bus
+func NewUsageRecorder(bus *ProgressBus) *UsageRecorder {
+	r := &UsageRecorder{retryCodes: make(map[string]int)}
+	bus.Subscribe(func(event ProgressEvent) {
+		if event.Kind != ProgressRetrying {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.retries++
+		if event.Code != "" {
+			r.retryCodes[event.Code]++
+		}
+	})
+	return r
+}
+
+// Run returns the record of a run whose downloads took elapsed
+func (r *UsageRecorder) Run(stats *DownloadStats, options *Options, elapsed time.Duration, exitStatus int) UsageRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	command := options.Command
+	if command == "" {
+		command = "download"
+	}
+	run := UsageRun{
+		Time:        stats.StartTime.UTC(),
+		Version:     version,
+		Platform:    runtime.GOOS + "/" + runtime.GOARCH,
+		Command:     command,
+		Workers:     options.Concurrent,
+		MaxRetries:  options.MaxRetries,
+		Items:       stats.Total,
+		Downloaded:  stats.Downloaded,
+		Synced:      stats.Synced,
+		Skipped:     stats.Skipped,
+		Failed:      stats.Failed,
+		Deferred:    stats.Deferred,
+		Bytes:       stats.BytesDownloaded,
+		Seconds:     elapsed.Seconds(),
+		Retries:     r.retries,
+		FailedCodes: countFailures(stats.Failures()),
+		ExitStatus:  exitStatus,
+	}
+	if len(r.retryCodes) > 0 {
+		run.RetryCodes = make(map[string]int, len(r.retryCodes))
+		for code, n := range r.retryCodes {
+			run.RetryCodes[code] = n
+		}
+	}
+	return run
+}
+
+// usagePath returns the run history of --usage-file, or the one in the user cache
+// directory
+func usagePath(options *Options) (string, error) {
+	if options.UsageFile != "" {
+		return options.UsageFile, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("no user cache directory, set --usage-file: %v", err)
+	}
+	return filepath.Join(dir, tokenCacheDirName, usageFileName), nil
+}
+
+// appendUsage adds a run to the history at path, dropping the oldest runs once it
+// holds more than twice usageMaxRuns
+func appendUsage(path string, run UsageRun) error {
+	line, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= 2*usageMaxRuns {
+		return nil
+	}
+	return writeFileAtomic(path, bytes.Join(lines[len(lines)-usageMaxRuns:], nil), 0644)
+}
+
+// recordUsage adds the run to the local run history unless --no-usage-stats is given
+func recordUsage(recorder *UsageRecorder, stats *DownloadStats, options *Options, elapsed time.Duration, exitStatus int) {
+	if options.NoUsageStats {
+		return
+	}
+	path, err := usagePath(options)
+	if err == nil {
+		err = appendUsage(path, recorder.Run(stats, options, elapsed, exitStatus))
+	}
+	if err != nil {
+		logger.Debugf("Failed to record the run statistics: %v", err)
+	}
+}
+
+// readUsage returns the runs of the history at path started at or after since.
+// Lines that do not parse, e.g. the last one of a run killed while writing it, are
+// left out.
+func readUsage(path string, since time.Time) ([]UsageRun, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var runs []UsageRun
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var run UsageRun
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			continue
+		}
+		if !run.Time.Before(since) {
+			runs = append(runs, run)
+		}
+	}
+	return runs, scanner.Err()
+}
+
+// UsageGroup sums the runs made with the same -p and --max-retries
+type UsageGroup struct {
+	Workers        int     `json:"workers"`
+	MaxRetries     int     `json:"max_retries"`
+	Runs           int     `json:"runs"`
+	Items          int64   `json:"items"`
+	Failed         int64   `json:"failed"`
+	Retries        int64   `json:"retries"`
+	Bytes          int64   `json:"bytes"`
+	Seconds        float64 `json:"seconds"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+// UsageCode counts a failure code over the runs
+type UsageCode struct {
+	Code     string `json:"code"`
+	Retries  int64  `json:"retries"`
+	Failures int64  `json:"failures"`
+}
+
+// UsageSummary is the result of the stats command, printed as JSON with --json
+type UsageSummary struct {
+	Path           string       `json:"path"`
+	Runs           int          `json:"runs"`
+	First          time.Time    `json:"first,omitempty"`
+	Last           time.Time    `json:"last,omitempty"`
+	Items          int64        `json:"items"`
+	Downloaded     int64        `json:"downloaded"`
+	Skipped        int64        `json:"skipped"`
+	Failed         int64        `json:"failed"`
+	Retries        int64        `json:"retries"`
+	Bytes          int64        `json:"bytes"`
+	Seconds        float64      `json:"seconds"`
+	BytesPerSecond float64      `json:"bytes_per_second"`
+	Groups         []UsageGroup `json:"groups"`
+	Codes          []UsageCode  `json:"codes,omitempty"`
+}
+
+// summarizeUsage sums runs in total, per -p and --max-retries, and per failure code
+func summarizeUsage(path string, runs []UsageRun) *UsageSummary {
+	summary := &UsageSummary{Path: path, Runs: len(runs)}
+	groups := make(map[[2]int]*UsageGroup)
+	codes := make(map[string]*UsageCode)
+	code := func(name string) *UsageCode {
+		if codes[name] == nil {
+			codes[name] = &UsageCode{Code: name}
+		}
+		return codes[name]
+	}
+	for _, run := range runs {
+		if summary.First.IsZero() || run.Time.Before(summary.First) {
+			summary.First = run.Time
+		}
+		if run.Time.After(summary.Last) {
+			summary.Last = run.Time
+		}
+		summary.Items += int64(run.Items)
+		summary.Downloaded += int64(run.Downloaded + run.Synced)
+		summary.Skipped += int64(run.Skipped)
+		summary.Failed += int64(run.Failed)
+		summary.Retries += int64(run.Retries)
+		summary.Bytes += run.Bytes
+		summary.Seconds += run.Seconds
+
+		key := [2]int{run.Workers, run.MaxRetries}
+		group := groups[key]
+		if group == nil {
+			group = &UsageGroup{Workers: run.Workers, MaxRetries: run.MaxRetries}
+			groups[key] = group
+		}
+		group.Runs++
+		group.Items += int64(run.Items)
+		group.Failed += int64(run.Failed)
+		group.Retries += int64(run.Retries)
+		group.Bytes += run.Bytes
+		group.Seconds += run.Seconds
+
+		for name, n := range run.RetryCodes {
+			code(name).Retries += int64(n)
+		}
+		for name, n := range run.FailedCodes {
+			code(name).Failures += int64(n)
+		}
+	}
+	if summary.Seconds > 0 {
+		summary.BytesPerSecond = float64(summary.Bytes) / summary.Seconds
+	}
+
+	for _, group := range groups {
+		if group.Seconds > 0 {
+			group.BytesPerSecond = float64(group.Bytes) / group.Seconds
+		}
+		summary.Groups = append(summary.Groups, *group)
+	}
+	sort.Slice(summary.Groups, func(i, j int) bool {
+		a, b := summary.Groups[i], summary.Groups[j]
+		if a.Workers != b.Workers {
+			return a.Workers < b.Workers
+		}
+		return a.MaxRetries < b.MaxRetries
+	})
+	for _, name := range sortedKeys(codes) {
+		summary.Codes = append(summary.Codes, *codes[name])
+	}
+	return summary
+}
+
+// perHundred returns n per 100 of total
+func perHundred(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// printUsageSummary prints the totals, the settings table and the failure codes
+func printUsageSummary(summary *UsageSummary) {
+	fmt.Printf("Runs:        %d, %s to %s\n", summary.Runs,
+		summary.First.Local().Format("2006-01-02 15:04"), summary.Last.Local().Format("2006-01-02 15:04"))
+	fmt.Printf("Items:       %d (%d downloaded, %d skipped, %d failed)\n",
+		summary.Items, summary.Downloaded, summary.Skipped, summary.Failed)
+	fmt.Printf("Transferred: %s in %s, %s/s\n", formatBytes(summary.Bytes),
+		(time.Duration(summary.Seconds) * time.Second).Round(time.Second), formatBytes(int64(summary.BytesPerSecond)))
+	fmt.Printf("Retries:     %d (%.1f per 100 items)\n", summary.Retries, perHundred(summary.Retries, summary.Items))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\nWORKERS\tMAX RETRIES\tRUNS\tITEMS\tRETRIES/100\tFAILED/100\tRATE\n")
+	for _, g := range summary.Groups {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%.1f\t%.1f\t%s/s\n", g.Workers, g.MaxRetries, g.Runs, g.Items,
+			perHundred(g.Retries, g.Items), perHundred(g.Failed, g.Items), formatBytes(int64(g.BytesPerSecond)))
+	}
+	if len(summary.Codes) > 0 {
+		fmt.Fprintf(w, "\nCODE\tRETRIES\tFAILURES\n")
+		for _, c := range summary.Codes {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", c.Code, c.Retries, c.Failures)
+		}
+	}
+	_ = w.Flush()
+
+	// The fastest setting among those that moved data, as a starting point for -p
+	best := -1
+	for i, g := range summary.Groups {
+		if g.Bytes > 0 && (best < 0 || g.BytesPerSecond > summary.Groups[best].BytesPerSecond) {
+			best = i
+		}
+	}
+	if best >= 0 && len(summary.Groups) > 1 {
+		g := summary.Groups[best]
+		fmt.Printf("\nFastest: -p %d --max-retries %d, %s/s over %d runs\n",
+			g.Workers, g.MaxRetries, formatBytes(int64(g.BytesPerSecond)), g.Runs)
+	}
+}
+
+// runUsageStats summarizes the local run history, since --since if given
+func runUsageStats(options *Options) error {
+	path, err := usagePath(options)
+	if err != nil {
+		return err
+	}
+	runs, err := readUsage(path, options.UpdatedSince)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no run statistics recorded yet in %s", path)
+	}
+	if err != nil {
+		return err
+	}
+	summary := summarizeUsage(path, runs)
+
+	if options.JSON {
+		content, err := json.MarshalIndent(summary, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+		return nil
+	}
+	if summary.Runs == 0 {
+		fmt.Printf("No runs recorded in %s", path)
+		if !options.UpdatedSince.IsZero() {
+			fmt.Printf(" since %s", options.UpdatedSince.Format(updatedSinceLayout))
+		}
+		fmt.Println()
+		return nil
+	}
+	fmt.Printf("Run statistics from %s\n\n", path)
+	printUsageSummary(summary)
+	return nil
+}
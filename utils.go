@@ -2,10 +2,10 @@ package main
 
 import (
 	"archive/tar"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -85,83 +85,206 @@ func ToJSON(files []*FileInfo, output string) {
 	}
 }
 
-// writeMetadataToCSV writes/appends a slice of FileInfo structs to a CSV file.
-func writeMetadataToCSV(filePath string, fileInfos []*FileInfo) error {
-	// Check if file exists to determine if we need to write a header
-	stat, err := os.Stat(filePath)
-	writeHeader := os.IsNotExist(err)
+// metadataCSVHeader is the header of the *-metadata.csv files in metadata/
+var metadataCSVHeader = []string{
+	"SeriesInstanceUID", "SubjectID", "Collection", "Modality",
+	"StudyInstanceUID", "StudyDate", "StudyDescription", "SeriesDescription", "SeriesNumber",
+	"Manufacturer", "NumberOfImages", "FileSize", "MD5Hash",
+	"OriginalS5cmdURI", "NBIAViewerURL", "IDCViewerURL",
+}
 
-	// Open with read/write/create permissions.
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return fmt.Errorf("could not open/create CSV file: %w", err)
-	}
-	defer file.Close()
-
-	// If the file is not new and not empty, check for a trailing newline.
-	if !writeHeader && stat.Size() > 0 {
-		buf := make([]byte, 1)
-		// Read the last byte.
-		if _, err := file.ReadAt(buf, stat.Size()-1); err == nil {
-			// If it's not a newline, we need to add one.
-			if buf[0] != '\n' {
-				// Seek to the end and write a newline.
-				if _, err := file.Seek(0, io.SeekEnd); err != nil {
-					return fmt.Errorf("could not seek to end of file to add newline: %w", err)
-				}
-				if _, err := file.WriteString("\n"); err != nil {
-					return fmt.Errorf("failed to write missing newline: %w", err)
-				}
+// metadataCSVRecord returns the row of a series in the order of metadataCSVHeader
+func metadataCSVRecord(info *FileInfo) []string {
+	return []string{
+		info.SeriesUID,
+		info.SubjectID,
+		info.Collection,
+		info.Modality,
+		info.StudyUID,
+		normalizeDate(info.StudyDate),
+		normalizeDescription(info.StudyDescription),
+		normalizeDescription(info.SeriesDescription),
+		info.SeriesNumber,
+		info.Manufacturer,
+		info.NumberOfImages,
+		info.FileSize,
+		info.MD5Hash,
+		info.OriginalS5cmdURI,
+		nbiaViewerURL(info.SeriesUID),
+		idcViewerURL(info.StudyUID, info.SeriesUID),
+	}
+}
+
+// metadataCSVRow returns the row of a series in the order of the given columns,
+// empty for columns unknown to metadataCSVHeader
+func metadataCSVRow(info *FileInfo, columns []string) []string {
+	values := metadataCSVRecord(info)
+	row := make([]string, len(columns))
+	for i, column := range columns {
+		for j, name := range metadataCSVHeader {
+			if column == name {
+				row[i] = values[j]
+				break
 			}
 		}
 	}
+	return row
+}
 
-	// Ensure we are at the end of the file before letting the CSV writer take over.
-	if _, err := file.Seek(0, io.SeekEnd); err != nil {
-		return fmt.Errorf("could not seek to end of file for writing: %w", err)
-	}
+// Values of --reflink
+const (
+	reflinkAuto   = "auto"
+	reflinkAlways = "always"
+	reflinkNever  = "never"
+)
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+// reflinkMode is --reflink: whether copies across volumes clone the blocks of the
+// source on copy-on-write file systems instead of copying them
+var reflinkMode = reflinkAuto
 
-	header := []string{
-		"SeriesInstanceUID", "SubjectID", "Collection", "Modality",
-		"StudyInstanceUID", "SeriesDescription", "SeriesNumber",
-		"Manufacturer", "NumberOfImages", "FileSize", "MD5Hash",
-		"OriginalS5cmdURI",
+// renameFile moves a file or directory like os.Rename. Across volumes or file
+// systems, e.g. from --temp-dir to a network share, it copies the source next to
+// the target, syncs and renames the copy into place once its files match the
+// source, and removes the source.
+func renameFile(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+	copyPath := to + ".copy.tmp"
+	os.RemoveAll(copyPath)
+	if err := copyTree(from, copyPath); err != nil {
+		os.RemoveAll(copyPath)
+		return fmt.Errorf("failed to copy %s across volumes: %w", from, err)
 	}
+	// The source was verified; make sure the copy is what is left of it
+	if err := verifyCopy(from, copyPath); err != nil {
+		os.RemoveAll(copyPath)
+		return fmt.Errorf("copy of %s across volumes is incomplete: %w", from, err)
+	}
+	if err := os.Rename(copyPath, to); err != nil {
+		os.RemoveAll(copyPath)
+		return err
+	}
+	return os.RemoveAll(from)
+}
 
-	if writeHeader {
-		if err := writer.Write(header); err != nil {
-			return fmt.Errorf("failed to write CSV header: %w", err)
+// replaceDir moves the directory from to to, replacing the one there, e.g. when
+// --force downloads a series again. The old directory is moved aside and only
+// removed once the new one is in place, so a failed move keeps it. Removing
+// unlinks its files rather than overwriting them, so their hard links elsewhere,
+// in .objects/ of --dedup or in snapshots made with cp -al, keep their content.
+func replaceDir(from, to string) error {
+	old := to + ".old.tmp"
+	if _, err := os.Lstat(to); err != nil {
+		old = ""
+	} else {
+		os.RemoveAll(old)
+		if err := os.Rename(to, old); err != nil {
+			return fmt.Errorf("failed to move %s aside: %w", to, err)
 		}
 	}
-
-	// Write rows
-	for _, info := range fileInfos {
-		record := []string{
-			info.SeriesUID,
-			info.SubjectID,
-			info.Collection,
-			info.Modality,
-			info.StudyUID,
-			info.SeriesDescription,
-			info.SeriesNumber,
-			info.Manufacturer,
-			info.NumberOfImages,
-			info.FileSize,
-			info.MD5Hash,
-			info.OriginalS5cmdURI,
+	if err := renameFile(from, to); err != nil {
+		if old != "" {
+			if restoreErr := os.Rename(old, to); restoreErr != nil {
+				return fmt.Errorf("%w; the previous copy is kept in %s", err, old)
+			}
 		}
-		if err := writer.Write(record); err != nil {
-			return fmt.Errorf("failed to write CSV record for series %s: %w", info.SeriesUID, err)
+		return err
+	}
+	if old != "" {
+		if err := os.RemoveAll(old); err != nil {
+			logger.Warnf("Failed to remove the previous copy %s: %v", old, err)
 		}
 	}
+	return nil
+}
+
+// verifyCopy checks that every file below from exists below to with the same size
+func verifyCopy(from, to string) error {
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		source, err := d.Info()
+		if err != nil {
+			return err
+		}
+		copied, err := os.Stat(filepath.Join(to, rel))
+		if err != nil {
+			return err
+		}
+		if copied.Size() != source.Size() {
+			return fmt.Errorf("%s has %d bytes, not %d", filepath.Join(to, rel), copied.Size(), source.Size())
+		}
+		return nil
+	})
+}
+
+// copyTree copies a file, or a directory with everything below it, keeping modes
+// and modification times
+func copyTree(from, to string) error {
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		if err := copyFile(path, target); err != nil {
+			return err
+		}
+		if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
 
+// writeFileAtomic writes data to path through a uniquely named temporary file, so
+// that invocations sharing the output directory never write into each other's
+// temporary files
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Chmod(tempPath, perm); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := renameFile(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
 	return nil
 }
 
-// copyFile copies a file from src to dst.
+// copyFile copies a file from src to dst. Unless --reflink is never, dst is a
+// copy-on-write clone of src where the file system supports it.
 func copyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
@@ -175,9 +298,22 @@ func copyFile(src, dst string) error {
 	}
 	defer out.Close()
 
+	if reflinkMode != reflinkNever {
+		err := cloneFile(in, out)
+		if err == nil {
+			return out.Close()
+		}
+		if reflinkMode == reflinkAlways {
+			return fmt.Errorf("failed to reflink %s (--reflink always): %w", src, err)
+		}
+	}
 	_, err = io.Copy(out, in)
 	if err != nil {
 		return err
 	}
+	// On disk before a move removes the source
+	if err := out.Sync(); err != nil {
+		return err
+	}
 	return out.Close()
 }
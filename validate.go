@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// dicomProblemsFile lists the problem files found by --validate-dicom, below metadata/
+const dicomProblemsFile = "dicom-problems.txt"
+
+// DicomProblem is a file that failed DICOM validation
+type DicomProblem struct {
+	SeriesUID string
+	Path      string
+	Problem   string
+}
+
+// validateSeries parses every DICOM file of an extracted series. It flags files that
+// cannot be parsed or are truncated, instances without or with a duplicate
+// SOPInstanceUID, and instances that belong to another series.
+func validateSeries(output string, info *FileInfo) (int, []DicomProblem, error) {
+	var problems []DicomProblem
+	flag := func(path, format string, args ...interface{}) {
+		problems = append(problems, DicomProblem{SeriesUID: info.SeriesUID, Path: path, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	// De-identification remaps the UIDs, so only the original series can be matched
+	_, deidentified := deidentifiedSize(output, info.SeriesUID)
+
+	checked := 0
+	seen := make(map[string]string) // SOPInstanceUID -> first file
+	err := filepath.WalkDir(info.seriesPath(output), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !isDicomFile(path) {
+			// License texts and checksum lists ship with the series
+			if strings.EqualFold(filepath.Ext(path), ".dcm") {
+				checked++
+				flag(path, "not a DICOM file (no DICM marker)")
+			}
+			return nil
+		}
+		checked++
+
+		dataset, err := dicom.ParseFile(path, nil, dicom.SkipProcessingPixelDataValue())
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				flag(path, "truncated: %v", err)
+			} else {
+				flag(path, "unreadable: %v", err)
+			}
+			return nil
+		}
+
+		sopUID, err := getElementValue(dataset, tag.SOPInstanceUID)
+		if err != nil || sopUID == "" {
+			flag(path, "missing SOPInstanceUID")
+		} else if first, ok := seen[sopUID]; ok {
+			flag(path, "duplicate SOPInstanceUID %s (also in %s)", sopUID, filepath.Base(first))
+		} else {
+			seen[sopUID] = path
+		}
+
+		if seriesUID, err := getElementValue(dataset, tag.SeriesInstanceUID); err == nil && !deidentified && seriesUID != info.SeriesUID {
+			flag(path, "belongs to series %s", seriesUID)
+		}
+		return nil
+	})
+	return checked, problems, err
+}
+
+// runDicomValidation validates the extracted series among files and reports the
+// problem files in the run summary and in metadata/dicom-problems.txt
+func runDicomValidation(files []*FileInfo, options *Options) {
+	series := extractedSeries(files, options.Output)
+	if len(series) == 0 {
+		return
+	}
+
+	fmt.Printf("\nValidating DICOM files of %d series...\n", len(series))
+	var mu sync.Mutex
+	var problems []DicomProblem
+	var checked, badSeries int32
+	forEachSeries(series, options.Concurrent, func(info *FileInfo) {
+		count, found, err := validateSeries(options.Output, info)
+		if err != nil {
+			found = append(found, DicomProblem{SeriesUID: info.SeriesUID, Path: info.seriesPath(options.Output), Problem: err.Error()})
+		}
+		atomic.AddInt32(&checked, int32(count))
+		if len(found) == 0 {
+			return
+		}
+		atomic.AddInt32(&badSeries, 1)
+		events.Record(Event{Action: "validate", SeriesUID: info.SeriesUID, Error: fmt.Sprintf("%d problem files", len(found))})
+		mu.Lock()
+		problems = append(problems, found...)
+		mu.Unlock()
+	})
+
+	fmt.Printf("DICOM validation: %d files in %d series checked, %d problems in %d series\n",
+		checked, len(series), len(problems), badSeries)
+	path := filepath.Join(options.Output, "metadata", dicomProblemsFile)
+	if len(problems) == 0 {
+		// Do not leave the list of an earlier run behind
+		os.Remove(path)
+		return
+	}
+
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	var b strings.Builder
+	for _, problem := range problems {
+		line := fmt.Sprintf("%s\t%s\t%s", problem.SeriesUID, problem.Path, problem.Problem)
+		fmt.Printf("  %s\n", line)
+		b.WriteString(line + "\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		logger.Warnf("Failed to write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// PlannedAction is what a download run would do with a single item
+type PlannedAction struct {
+	SeriesUID string `json:"series_uid"`
+	Action    string `json:"action"` // download, repair, sync or skip
+	Reason    string `json:"reason"`
+}
+
+// planAction mirrors the decisions of the download workers using only local state
+func planAction(info *FileInfo, options *Options) PlannedAction {
+	plan := PlannedAction{SeriesUID: info.SeriesUID}
+	isTCIA := info.DownloadURL == "" && info.DRSURI == "" && info.S5cmdManifestPath == ""
+
+	if isTCIA && info.SubjectID == "" && info.StudyUID == "" {
+		plan.Action, plan.Reason = "download", "metadata not cached, local copy cannot be located"
+		return plan
+	}
+
+	if info.IsSyncJob {
+		plan.Action, plan.Reason = "sync", "series already organized, s5cmd sync --size-only"
+		return plan
+	}
+	plan.Action, plan.Reason = info.existingAction(options)
+	return plan
+}
+
+// runWhatIf prints what a download run would do without transferring anything
+func runWhatIf(files []*FileInfo, options *Options) error {
+	plans := make([]PlannedAction, 0, len(files))
+	counts := make(map[string]int)
+	for _, info := range files {
+		plan := planAction(info, options)
+		plans = append(plans, plan)
+		counts[plan.Action]++
+	}
+	sort.SliceStable(plans, func(i, j int) bool {
+		return plans[i].Action < plans[j].Action
+	})
+
+	if options.JSON {
+		content, err := json.MarshalIndent(plans, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ACTION\tSERIES\tREASON\n")
+	for _, plan := range plans {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", plan.Action, plan.SeriesUID, plan.Reason)
+	}
+	_ = w.Flush()
+
+	fmt.Println("\n=== What-If Summary ===")
+	fmt.Printf("Download: %d\n", counts["download"])
+	fmt.Printf("Repair: %d\n", counts["repair"])
+	if counts["sync"] > 0 {
+		fmt.Printf("Sync: %d\n", counts["sync"])
+	}
+	fmt.Printf("Skip: %d\n", counts["skip"])
+	return nil
+}
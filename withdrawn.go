@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrSeriesNotFound is returned when the server reports a series as unknown
+var ErrSeriesNotFound = errors.New("not available on server")
+
+// UnavailableSeries is a requested series the server has no metadata for, typically
+// because it was withdrawn or retired after the manifest was created
+type UnavailableSeries struct {
+	SeriesUID string `json:"series_uid"`
+	LocalCopy string `json:"local_copy,omitempty"` // path of a copy from a previous run, if one exists
+}
+
+// unavailableSeriesRegistry collects unavailable series across metadata workers
+type unavailableSeriesRegistry struct {
+	mu     sync.Mutex
+	series []UnavailableSeries
+}
+
+// unavailableSeries holds the series of the current run that are not on the server
+var unavailableSeries = &unavailableSeriesRegistry{}
+
+// Add records an unavailable series, cross-checking the output directory for a copy
+// downloaded by a previous run (its metadata cache entry is kept in that case)
+func (r *unavailableSeriesRegistry) Add(output, seriesUID string) {
+	entry := UnavailableSeries{SeriesUID: seriesUID}
+	if info, err := loadMetadataFromCache(getMetadataCachePath(output, seriesUID)); err == nil && info.SubjectID != "" {
+		for _, path := range []string{info.seriesPath(output), info.seriesPath(output) + seriesArchiveExt} {
+			if _, err := os.Stat(path); err == nil {
+				entry.LocalCopy = path
+				break
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series = append(r.series, entry)
+}
+
+// List returns the unavailable series sorted by UID
+func (r *unavailableSeriesRegistry) List() []UnavailableSeries {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := append([]UnavailableSeries(nil), r.series...)
+	sort.Slice(list, func(i, j int) bool { return list[i].SeriesUID < list[j].SeriesUID })
+	return list
+}
+
+// reportUnavailableSeries prints the unavailable series and saves them to
+// unavailable-series.txt in the report directory for follow-up with the archive
+func reportUnavailableSeries(output string) {
+	list := unavailableSeries.List()
+	if len(list) == 0 {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Printf("\nNot available on server (withdrawn or retired): %d\n", len(list))
+	for _, series := range list {
+		line := series.SeriesUID
+		if series.LocalCopy != "" {
+			line += fmt.Sprintf("\tlocal copy: %s", series.LocalCopy)
+		}
+		fmt.Printf("  %s\n", line)
+		b.WriteString(line + "\n")
+		events.Record(Event{Action: "unavailable", SeriesUID: series.SeriesUID, Path: series.LocalCopy, Code: CodeNotFound})
+	}
+
+	path := filepath.Join(reportDir(output), "unavailable-series.txt")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Warnf("Failed to create %s: %v", filepath.Dir(path), err)
+	} else if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		logger.Warnf("Failed to write %s: %v", path, err)
+	}
+}